@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// entryRepo returns the repository path an entry is fetched from. Aliased
+// entries vendor a second copy of a repository under a suffixed path, e.g.
+// "github.com/pkg/errors.v1" with alias "github.com/pkg/errors", so two
+// versions of it can be used side by side. Subdirectory entries are fetched
+// from the monorepo containing them.
+func entryRepo(pkg string, entry *bpmEntry) string {
+	if entry.Alias != "" {
+		return entry.Alias
+	}
+	if entry.Subdir != "" {
+		return strings.TrimSuffix(pkg, "/"+entry.Subdir)
+	}
+	return pkg
+}
+
+// rewriteAliases points the imports an aliased copy makes of its own
+// repository at the suffixed path it is vendored under.
+func rewriteAliases(dir string, dependencies map[string]*bpmEntry) error {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if entry.Alias != "" && fileExists(pkgDir) {
+			files, err := getAliasSourceFiles(pkgDir)
+			if err != nil {
+				return err
+			}
+			count := 0
+			for _, fname := range files {
+				rewritten, err := rewriteFileImports(fname, func(path string) (string, bool) {
+					if path != entry.Alias && !strings.HasPrefix(path, entry.Alias+"/") {
+						return "", false
+					}
+					return pkg + strings.TrimPrefix(path, entry.Alias), true
+				})
+				if err != nil {
+					return err
+				}
+				if rewritten {
+					count++
+				}
+			}
+			log.Printf("Alias %s: rewrote imports of %s to %s in %d files", pkg, entry.Alias, pkg, count)
+		}
+		if err := rewriteAliases(pkgDir, entry.Dependencies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAliasSourceFiles lists the Go files of an aliased copy, leaving out its
+// own vendor folder.
+func getAliasSourceFiles(pkgDir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != pkgDir && (info.Name() == gitFolderName || info.Name() == vendorFolderName) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".go") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
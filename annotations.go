@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	annotationsGitHub = "github"
+	annotationsGitLab = "gitlab"
+	// gitLabReportFilename is the code quality report -annotations gitlab
+	// writes, to be declared as artifacts:reports:codequality of the job.
+	gitLabReportFilename = "gl-code-quality-report.json"
+)
+
+// annotationFormat is set by -annotations to also report failures as GitHub
+// Actions workflow commands or in a GitLab code quality report, so they show
+// inline on pull and merge requests.
+var annotationFormat = ""
+
+// gitLabIssue is an entry of a GitLab code quality report.
+type gitLabIssue struct {
+	Description string         `json:"description"`
+	CheckName   string         `json:"check_name"`
+	Fingerprint string         `json:"fingerprint"`
+	Severity    string         `json:"severity"`
+	Location    gitLabLocation `json:"location"`
+}
+
+type gitLabLocation struct {
+	Path  string `json:"path"`
+	Lines struct {
+		Begin int `json:"begin"`
+	} `json:"lines"`
+}
+
+var annotations = struct {
+	sync.Mutex
+	// manifest is the first bpm.json read, which failures point at.
+	manifest string
+	issues   []*gitLabIssue
+}{}
+
+// setAnnotationFormat checks the -annotations format, starting an empty
+// GitLab report so the job finds one even when nothing fails.
+func setAnnotationFormat(format string) error {
+	annotationFormat = format
+	switch format {
+	case "":
+	case annotationsGitHub:
+	case annotationsGitLab:
+		writeGitLabReport()
+	default:
+		annotationFormat = ""
+		return fmt.Errorf("unknown annotations format %q, expected %s or %s", format, annotationsGitHub, annotationsGitLab)
+	}
+	return nil
+}
+
+// noteManifest remembers the manifest of the project failures are annotated
+// on.
+func noteManifest(filename string) {
+	annotations.Lock()
+	defer annotations.Unlock()
+	if annotations.manifest == "" {
+		annotations.manifest = filename
+	}
+}
+
+// annotate reports a failure and each of its problems in the -annotations
+// format, at the line of bpm.json declaring the package a problem names.
+func annotate(message string, problems []string) {
+	if annotationFormat == "" {
+		return
+	}
+	annotations.Lock()
+	defer annotations.Unlock()
+	title := strings.TrimSuffix(message, ":")
+	if len(problems) == 0 {
+		problems = []string{title}
+	}
+	path, lines := annotations.manifest, []string(nil)
+	if path != "" {
+		if bytes, err := ioutil.ReadFile(path); err == nil {
+			lines = strings.Split(string(bytes), "\n")
+		}
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		path = filepath.ToSlash(path)
+	}
+	for _, problem := range problems {
+		line := findProblemLine(lines, problem)
+		switch annotationFormat {
+		case annotationsGitHub:
+			properties := "title=" + escapeWorkflowProperty(title)
+			if path != "" {
+				properties = fmt.Sprintf("file=%s,line=%d,%s", escapeWorkflowProperty(path), line, properties)
+			}
+			fmt.Printf("::error %s::%s\n", properties, escapeWorkflowData(problem))
+		case annotationsGitLab:
+			sum := sha256.Sum256([]byte(path + "\x00" + title + "\x00" + problem))
+			issue := &gitLabIssue{
+				Description: problem,
+				CheckName:   "bpm: " + title,
+				Fingerprint: hex.EncodeToString(sum[:16]),
+				Severity:    "major"}
+			issue.Location.Path = path
+			issue.Location.Lines.Begin = line
+			annotations.issues = append(annotations.issues, issue)
+		}
+	}
+	if annotationFormat == annotationsGitLab {
+		writeGitLabReport()
+	}
+}
+
+// findProblemLine returns the line of the manifest declaring the package a
+// problem starts with, like "github.com/a/b: ...", or 1.
+func findProblemLine(lines []string, problem string) int {
+	pkg := strings.SplitN(problem, ":", 2)[0]
+	if strings.ContainsAny(pkg, " \t") || !strings.Contains(pkg, "/") {
+		return 1
+	}
+	for i, line := range lines {
+		if strings.Contains(line, `"`+pkg+`"`) {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// writeGitLabReport rewrites the report with every issue so far, so it is
+// complete however the command stops.
+func writeGitLabReport() {
+	issues := annotations.issues
+	if issues == nil {
+		issues = make([]*gitLabIssue, 0)
+	}
+	bytes, err := jsonEncodeIndented(issues)
+	if err == nil {
+		err = writeFileAtomic(gitLabReportFilename, bytes)
+	}
+	if err != nil {
+		log.Printf("Could not write %s: %s", gitLabReportFilename, err)
+	}
+}
+
+// escapeWorkflowData escapes the message of a GitHub Actions workflow
+// command.
+func escapeWorkflowData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeWorkflowProperty escapes a property value of a workflow command.
+func escapeWorkflowProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+)
+
+// bpmChangeSet is a declarative set of dependency changes for bpm apply:
+//
+//	{
+//	  "add": {"github.com/pkg/errors": {"version": "^0.9"}},
+//	  "remove": ["github.com/old/lib"],
+//	  "pin": {"github.com/some/dep": {"tag": "v1.4.0"}}
+//	}
+//
+// Pins set exactly one of version, tag, commit or branch.
+type bpmChangeSet struct {
+	Add    map[string]*bpmEntry `json:"add,omitempty"`
+	Remove []string             `json:"remove,omitempty"`
+	Pin    map[string]*bpmEntry `json:"pin,omitempty"`
+}
+
+// doApply applies a change set to the direct dependencies. Every change is
+// checked before any is made, and when applying fails the project is
+// restored from the snapshot taken before.
+func doApply(dir string, changesFile string) (code int) {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if changesFile == "" {
+		output.Errorf("No change set given: bpm apply <changes.json>")
+		return 1
+	}
+	bytes, err := ioutil.ReadFile(changesFile)
+	if err != nil {
+		output.Errorf("Could not read change set: %s", err)
+		return 1
+	}
+	changes := &bpmChangeSet{}
+	if err := json.Unmarshal(bytes, changes); err != nil {
+		output.Errorf("Invalid change set %s: %s", changesFile, err)
+		return 1
+	}
+	if !checkVendorUnlocked(dir) {
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	problems := checkChangeSet(data.Dependencies, changes)
+	if len(problems) == 0 {
+		problems = checkChangeSetRemotes(data.Dependencies, changes)
+	}
+	if len(problems) > 0 {
+		output.Problems(fmt.Sprintf("Change set %s can't be applied:", changesFile), problems)
+		return 1
+	}
+
+	snapshotDir, err := takeSnapshot(dir, "apply")
+	if err != nil {
+		output.Errorf("Could not take a snapshot: %s", err)
+		return 1
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		output.Errorf("Applying %s failed: %s", changesFile, err)
+		for pkg := range changes.Add {
+			if err := removeDir(filepath.Join(dir, vendorFolderName, vendorPath(pkg))); err != nil {
+				log.Printf("Could not remove %s: %s", pkg, err)
+			}
+		}
+		if mismatches, err := restoreSnapshot(dir, snapshotDir); err != nil {
+			output.Errorf("Could not restore the previous state: %s", err)
+		} else if len(mismatches) > 0 {
+			output.Problems("Restored the previous state, except:", mismatches)
+		} else {
+			output.Println("Restored the previous state")
+		}
+		code = 1
+	}()
+	defer recoverError(&err)
+	if err = applyChangeSet(dir, data, changes); err != nil {
+		return 1
+	}
+	if err = postInstall(dir, data); err != nil {
+		return 1
+	}
+	if err = writeDataFile(depFile, data); err != nil {
+		return 1
+	}
+	output.Printf("Applied %s: %d added, %d removed, %d pinned\n", changesFile, len(changes.Add), len(changes.Remove), len(changes.Pin))
+	return 0
+}
+
+func checkChangeSet(dependencies map[string]*bpmEntry, changes *bpmChangeSet) []string {
+	problems := make([]string, 0)
+	for _, pkg := range changes.Remove {
+		if _, ok := dependencies[pkg]; !ok {
+			problems = append(problems, fmt.Sprintf("remove %s: not a direct dependency", pkg))
+		}
+	}
+	for _, pkg := range sortedKeys(changes.Add) {
+		if _, ok := dependencies[pkg]; ok && !containsString(changes.Remove, pkg) {
+			problems = append(problems, fmt.Sprintf("add %s: already a dependency", pkg))
+		}
+		if changes.Add[pkg] == nil {
+			changes.Add[pkg] = &bpmEntry{}
+		}
+	}
+	for _, pkg := range sortedKeys(changes.Pin) {
+		pin := changes.Pin[pkg]
+		if _, ok := dependencies[pkg]; !ok {
+			problems = append(problems, fmt.Sprintf("pin %s: not a direct dependency", pkg))
+		} else if containsString(changes.Remove, pkg) {
+			problems = append(problems, fmt.Sprintf("pin %s: also removed", pkg))
+		}
+		set := 0
+		for _, value := range []string{pin.Version, pin.Tag, pin.Commit, pin.Branch} {
+			if value != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			problems = append(problems, fmt.Sprintf("pin %s: set exactly one of version, tag, commit or branch", pkg))
+		} else if pin.Version != "" {
+			if _, err := parseVersionConstraint(pin.Version); err != nil {
+				problems = append(problems, fmt.Sprintf("pin %s: %s", pkg, err))
+			}
+		}
+	}
+	return problems
+}
+
+// checkChangeSetRemotes makes sure the remotes of added and pinned packages
+// can be listed and have the tags they ask for, as failures while pulling
+// can't be recovered from.
+func checkChangeSetRemotes(dependencies map[string]*bpmEntry, changes *bpmChangeSet) []string {
+	problems := make([]string, 0)
+	for _, pkg := range sortedKeys(changes.Add) {
+		if err := checkRemote(pkg, changes.Add[pkg]); err != nil {
+			problems = append(problems, fmt.Sprintf("add %s: %s", pkg, err))
+		}
+	}
+	for _, pkg := range sortedKeys(changes.Pin) {
+		pin := *dependencies[pkg]
+		pin.Version, pin.Tag = changes.Pin[pkg].Version, changes.Pin[pkg].Tag
+		if err := checkRemote(pkg, &pin); err != nil {
+			problems = append(problems, fmt.Sprintf("pin %s: %s", pkg, err))
+		}
+	}
+	return problems
+}
+
+func checkRemote(pkg string, entry *bpmEntry) (err error) {
+	switch {
+	case entry.Archive != "":
+	case entry.Version != "":
+		_, _, err = findVersion(pkg, entry)
+	case entry.Tag != "":
+		_, err = findTag(pkg, entry)
+	default:
+		_, err = lsRemote(getEntryURL(pkg, entry), "HEAD")
+	}
+	return err
+}
+
+func applyChangeSet(dir string, data *bpmPackage, changes *bpmChangeSet) error {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	for _, pkg := range changes.Remove {
+		log.Printf("Removing %s", pkg)
+		delete(data.Dependencies, pkg)
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if err := removeDir(pkgDir); err != nil {
+			return err
+		}
+		removeEmptyParents(filepath.Dir(pkgDir), vendorDir)
+	}
+	for pkg, entry := range changes.Add {
+		log.Printf("Adding %s", pkg)
+		data.Dependencies[pkg] = entry
+	}
+	for pkg, pin := range changes.Pin {
+		entry := data.Dependencies[pkg]
+		log.Printf("Pinning %s", pkg)
+		entry.Version, entry.Tag, entry.TagInfo, entry.Commit = pin.Version, pin.Tag, nil, pin.Commit
+		entry.BranchPattern, entry.Channel = "", ""
+		if pin.Branch != "" {
+			entry.Branch = pin.Branch
+		}
+		if pin.Commit != "" || pin.Branch != "" {
+			// Cloned again, the existing checkout may not have the commit or
+			// the latest state of the branch.
+			if err := removeDir(filepath.Join(vendorDir, vendorPath(pkg))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := pullPackages(data.Dependencies, dir); err != nil {
+		return err
+	}
+	if data.Layout == layoutFlat {
+		_, err := syncFlatVendor(dir, data)
+		return err
+	}
+	failed := &pullError{}
+	for _, changed := range []map[string]*bpmEntry{changes.Add, changes.Pin} {
+		for _, pkg := range sortedKeys(changed) {
+			entry := data.Dependencies[pkg]
+			reresolveNested(pkg, entry, filepath.Join(vendorDir, vendorPath(pkg)), []string{data.Package, pkg}, failed)
+		}
+	}
+	return failed.orNil()
+}
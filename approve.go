@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bpmApproval records why a new dependency was let in, when approval of new
+// dependencies is required.
+type bpmApproval struct {
+	Reason string `json:"reason"`
+	By     string `json:"by,omitempty"`
+	Date   string `json:"date"`
+}
+
+func doApprove(dir string, pkg string, reason string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if pkg == "" {
+		output.Errorf("No package given: bpm approve -reason <reason> <pkg>")
+		return 1
+	}
+	if strings.TrimSpace(reason) == "" {
+		output.Errorf("Approving a dependency needs a reason: bpm approve -reason <reason> <pkg>")
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if data.Approvals == nil {
+		data.Approvals = make(map[string]*bpmApproval)
+	}
+	data.Approvals[pkg] = &bpmApproval{
+		Reason: reason,
+		By:     getGitUser(dir),
+		Date:   time.Now().Format("2006-01-02")}
+	if err := writeDataFile(depFile, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	output.Printf("Approved %s: %s\n", pkg, reason)
+	return 0
+}
+
+// findUnapproved lists the dependencies that are neither in the committed
+// bpm.json nor explicitly approved.
+func findUnapproved(dir string, data *bpmPackage) []string {
+	known := getCommittedPackages(dir)
+	packages := make(map[string]bool)
+	collectPackages(data.Dependencies, packages)
+
+	unapproved := make([]string, 0)
+	for pkg := range packages {
+		if _, ok := data.Approvals[pkg]; !known[pkg] && !ok {
+			unapproved = append(unapproved, pkg)
+		}
+	}
+	sort.Strings(unapproved)
+	return unapproved
+}
+
+func reportUnapproved(unapproved []string) {
+	output.Problems("New dependencies need approval before they can be vendored:", unapproved)
+	output.Printf("Run bpm approve -reason <reason> <pkg> for each of them.\n")
+}
+
+// getCommittedPackages returns every package in the committed bpm.json.
+func getCommittedPackages(dir string) map[string]bool {
+	known := make(map[string]bool)
+	if committed := readCommittedDataFile(dir); committed != nil {
+		collectPackages(committed.Dependencies, known)
+	}
+	return known
+}
+
+// readCommittedDataFile reads bpm.json as of the last commit, or nil if it
+// isn't committed yet.
+func readCommittedDataFile(dir string) *bpmPackage {
+	cmd := gitCommand(&dir, "show", "HEAD:./"+dependencyFilename)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	data := &bpmPackage{}
+	if err := json.Unmarshal(out, data); err != nil {
+		return nil
+	}
+	data.mergeTestDependencies()
+	if out, err := gitCommand(&dir, "show", "HEAD:./"+lockFilename).Output(); err == nil {
+		lock := &bpmLock{}
+		if json.Unmarshal(out, lock) == nil && lock.Dependencies != nil {
+			data.Dependencies = mergeLock(data.Dependencies, lock.Dependencies)
+		}
+	}
+	return data
+}
+
+func getGitUser(dir string) string {
+	cmd := exec.CommandContext(runContext, "git", "config", "user.email")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
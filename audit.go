@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// auditItem is one vulnerability affecting one pinned package.
+type auditItem struct {
+	Package string `json:"package"`
+	Commit  string `json:"commit"`
+	ID      string `json:"id"`
+	Aliases string `json:"aliases,omitempty"`
+	Summary string `json:"summary"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// auditReport is the result of audit with -json.
+type auditReport struct {
+	Vulnerabilities []*auditItem `json:"vulnerabilities"`
+	Ignored         int          `json:"ignored,omitempty"`
+}
+
+// licenseItem groups the packages vendored under one license.
+type licenseItem struct {
+	License  string `json:"license"`
+	Count    int    `json:"count"`
+	Packages string `json:"packages"`
+}
+
+// doAudit lists the known vulnerabilities of every pinned dependency and
+// returns the exit code.
+func doAudit(dir string, report csvReport) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	vulnerable, err := findVulnerable(data.Dependencies)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	vulnerable, silenced := filterIgnored(vulnerable, data.IgnoreVulnerabilities, time.Now())
+
+	owners := resolveOwners(data)
+	items := make([]*auditItem, 0)
+	for _, v := range vulnerable {
+		for _, vuln := range v.Vulnerabilities {
+			items = append(items, &auditItem{
+				Package: v.Package,
+				Commit:  v.Commit,
+				ID:      vuln.ID,
+				Aliases: strings.Join(vuln.Aliases, " "),
+				Summary: vuln.Summary,
+				Owner:   owners[v.Package]})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Package < items[j].Package
+	})
+
+	if report.enabled {
+		if err := report.write(os.Stdout, items, []string{"Package", "Commit", "ID", "Summary"}); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	} else {
+		output.Result(&auditReport{Vulnerabilities: items, Ignored: silenced}, func() {
+			printAudit(items, owners, silenced)
+		})
+	}
+	if len(items) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printAudit(items []*auditItem, owners map[string]string, silenced int) {
+	if len(items) == 0 {
+		fmt.Println("No known vulnerabilities")
+	} else if len(owners) == 0 {
+		for _, item := range items {
+			fmt.Printf("%s %s: %s %s\n", item.Package, shortHash(item.Commit), item.ID, item.Summary)
+		}
+	} else {
+		packages := make([]string, len(items))
+		for i, item := range items {
+			packages[i] = item.Package
+		}
+		order, groups := groupByOwner(owners, packages)
+		for _, owner := range order {
+			fmt.Printf("%s:\n", owner)
+			for _, i := range groups[owner] {
+				item := items[i]
+				fmt.Printf("    %s %s: %s %s\n", item.Package, shortHash(item.Commit), item.ID, item.Summary)
+			}
+		}
+	}
+	if silenced > 0 {
+		fmt.Printf("%d ignored until their expiry in %s\n", silenced, dependencyFilename)
+	}
+}
+
+// doLicenses summarizes the licenses of all vendored packages.
+func doLicenses(dir string, report csvReport) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	byLicense := make(map[string][]string)
+	for _, item := range listDependencies(dir, data.Dependencies, 0) {
+		byLicense[item.License] = append(byLicense[item.License], item.Package)
+	}
+	items := make([]*licenseItem, 0, len(byLicense))
+	for _, license := range sortedStrings(byLicense) {
+		packages := byLicense[license]
+		sort.Strings(packages)
+		items = append(items, &licenseItem{License: license, Count: len(packages), Packages: strings.Join(packages, " ")})
+	}
+
+	if report.enabled {
+		if err := report.write(os.Stdout, items, []string{"License", "Count", "Packages"}); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		return 0
+	}
+	output.Result(items, func() {
+		for _, item := range items {
+			fmt.Printf("%-12s %3d  %s\n", item.License, item.Count, item.Packages)
+		}
+	})
+	return 0
+}
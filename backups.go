@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxBackups is how many versions of bpm.json and bpm.lock are kept, oldest
+// removed first.
+const maxBackups = 10
+
+var backupsFolder = filepath.Join(".bpm", "backups")
+
+// writeFileAtomic writes filename through a temporary file renamed over it,
+// so an interrupted write leaves the previous version in place.
+func writeFileAtomic(filename string, content []byte) error {
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeDataFiles writes the manifest and lock file of dir, first copying the
+// current ones to .bpm/backups when either changes, for bpm undo.
+func writeDataFiles(dir string, manifest []byte, lock []byte) error {
+	depFile := filepath.Join(dir, dependencyFilename)
+	lockFile := filepath.Join(dir, lockFilename)
+	if fileChanges(depFile, manifest) || fileChanges(lockFile, lock) {
+		if err := backupDataFiles(dir); err != nil {
+			return fmt.Errorf("could not back up %s: %s", dependencyFilename, err)
+		}
+	}
+	if err := writeFileAtomic(lockFile, lock); err != nil {
+		return err
+	}
+	return writeFileAtomic(depFile, manifest)
+}
+
+// fileChanges is whether filename exists with other content.
+func fileChanges(filename string, content []byte) bool {
+	current, err := ioutil.ReadFile(filename)
+	return err == nil && !bytes.Equal(current, content)
+}
+
+func backupDataFiles(dir string) error {
+	backupDir := filepath.Join(dir, backupsFolder, time.Now().Format("20060102-150405.000000000"))
+	if err := createDir(backupDir); err != nil {
+		return err
+	}
+	for _, filename := range []string{dependencyFilename, lockFilename} {
+		source := filepath.Join(dir, filename)
+		if fileExists(source) {
+			if err := copySnapshotFile(source, filepath.Join(backupDir, filename)); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Saved backup %s", backupDir)
+	backups := listBackups(dir)
+	for len(backups) > maxBackups {
+		if err := removeDir(backups[0]); err != nil {
+			log.Printf("Could not remove backup %s: %s", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// listBackups returns the backup folders of dir, oldest first.
+func listBackups(dir string) []string {
+	backupsDir := filepath.Join(dir, backupsFolder)
+	files, err := ioutil.ReadDir(backupsDir)
+	if err != nil {
+		return nil
+	}
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			result = append(result, filepath.Join(backupsDir, file.Name()))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// doUndo restores bpm.json and bpm.lock from the most recent backup and
+// removes it, so undoing again goes further back. Unlike rollback it leaves
+// vendor alone, for bpm install to bring in line.
+func doUndo(dir string) int {
+	backups := listBackups(dir)
+	if len(backups) == 0 {
+		output.Errorf("No backups in %s", filepath.Join(dir, backupsFolder))
+		return 1
+	}
+	backupDir := backups[len(backups)-1]
+	for _, filename := range []string{dependencyFilename, lockFilename} {
+		source := filepath.Join(backupDir, filename)
+		target := filepath.Join(dir, filename)
+		if !fileExists(source) {
+			if fileExists(target) {
+				if err := os.Remove(target); err != nil {
+					output.Errorf("%s", err)
+					return 1
+				}
+			}
+			continue
+		}
+		content, err := ioutil.ReadFile(source)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		if err := writeFileAtomic(target, content); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	}
+	if err := removeDir(backupDir); err != nil {
+		output.Errorf("Restored the backup, but could not remove it: %s", err)
+		return 1
+	}
+	output.Printf("Restored %s and %s from backup %s, run bpm install to vendor them\n", dependencyFilename, lockFilename, filepath.Base(backupDir))
+	return 0
+}
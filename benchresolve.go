@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultBenchRuns = 5
+
+// benchResult is the timing and allocations of one benchmark over its runs.
+type benchResult struct {
+	Name         string        `json:"name"`
+	Runs         int           `json:"runs"`
+	Min          time.Duration `json:"minNs"`
+	Median       time.Duration `json:"medianNs"`
+	Mean         time.Duration `json:"meanNs"`
+	AllocsPerRun uint64        `json:"allocsPerRun"`
+	BytesPerRun  uint64        `json:"bytesPerRun"`
+}
+
+// benchReport is the result of bench-resolve, comparable between bpm builds
+// run on the same fixture.
+type benchReport struct {
+	Fixture      string         `json:"fixture"`
+	Repositories int            `json:"repositories"`
+	Go           string         `json:"go"`
+	Results      []*benchResult `json:"results"`
+}
+
+// captureFixture records the project in dir as a fixture: the imports of its
+// sources and of every vendored package at its locked commit. It also returns
+// the folders scanned, for the scan benchmark.
+func captureFixture(dir string, data *bpmPackage) (*resolverFixture, []string, error) {
+	imports, err := gitSource{}.imports(dir, data.Package)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := &resolverFixture{
+		Package: data.Package,
+		Imports: imports,
+		Repos:   make(map[string]*fixtureRepo)}
+	dirs := []string{dir}
+	if err := captureRepos(f, dir, data.Dependencies, &dirs); err != nil {
+		return nil, nil, err
+	}
+	return f, dirs, nil
+}
+
+func captureRepos(f *resolverFixture, dir string, dependencies map[string]*bpmEntry, dirs *[]string) error {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if entry.Commit == "" || !fileExists(pkgDir) {
+			log.Printf("Leaving %s out of the fixture, it isn't vendored at a commit", pkg)
+			continue
+		}
+		repo, ok := f.Repos[pkg]
+		if !ok {
+			repo = &fixtureRepo{
+				URL:           getEntryURL(pkg, entry),
+				DefaultBranch: entry.Branch,
+				Branches:      map[string]string{entry.Branch: entry.Commit},
+				Commits:       make(map[string]*fixtureCommit)}
+			f.Repos[pkg] = repo
+		}
+		if entry.Tag != "" {
+			if repo.Tags == nil {
+				repo.Tags = make(map[string]string)
+			}
+			repo.Tags[entry.Tag] = entry.Commit
+		}
+		if _, ok := repo.Commits[entry.Commit]; !ok {
+			imports, err := gitSource{}.imports(pkgDir, pkg)
+			if err != nil {
+				return err
+			}
+			repo.Commits[entry.Commit] = &fixtureCommit{Imports: imports}
+			*dirs = append(*dirs, pkgDir)
+		}
+		if err := captureRepos(f, pkgDir, entry.Dependencies, dirs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBench runs fn the given number of times after a warm-up run.
+func runBench(name string, runs int, fn func()) *benchResult {
+	fn()
+	durations := make([]time.Duration, runs)
+	var total time.Duration
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := range durations {
+		start := time.Now()
+		fn()
+		durations[i] = time.Since(start)
+		total += durations[i]
+	}
+	runtime.ReadMemStats(&after)
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	return &benchResult{
+		Name:         name,
+		Runs:         runs,
+		Min:          durations[0],
+		Median:       durations[runs/2],
+		Mean:         total / time.Duration(runs),
+		AllocsPerRun: (after.Mallocs - before.Mallocs) / uint64(runs),
+		BytesPerRun:  (after.TotalAlloc - before.TotalAlloc) / uint64(runs)}
+}
+
+// withoutOutput runs fn with the log and standard output discarded, so the
+// benchmarks don't measure the terminal. Without a null device it runs fn as
+// is.
+func withoutOutput(fn func()) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		log.Printf("Could not open %s, timing with output: %s", os.DevNull, err)
+		fn()
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	log.SetOutput(ioutil.Discard)
+	defer func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+		devNull.Close()
+	}()
+	fn()
+}
+
+// doBenchResolve times resolving a fixture, nested and flat, and scanning the
+// imports of the project it was captured from. Without a fixture file, the
+// project in dir is captured first, and saved when capture is set, so later
+// bpm versions can be timed on the same input.
+func doBenchResolve(dir string, fixtureFile string, capture string, count string) int {
+	runs := defaultBenchRuns
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			output.Errorf("Invalid -count %q, expected a positive number", count)
+			return 1
+		}
+		runs = n
+	}
+
+	var f *resolverFixture
+	var dirs []string
+	if fixtureFile != "" {
+		var err error
+		if f, err = readFixture(fixtureFile); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	} else {
+		depFile := filepath.Join(dir, dependencyFilename)
+		if !fileExists(depFile) {
+			noDependencyFile(depFile)
+			return 1
+		}
+		data, err := readDataFile(depFile)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		log.Printf("Capturing a fixture of %s", dir)
+		withoutOutput(func() {
+			f, dirs, err = captureFixture(dir, data)
+		})
+		if err != nil {
+			output.Errorf("Could not capture a fixture of %s: %s", dir, err)
+			return 1
+		}
+		fixtureFile = dir
+		if capture != "" {
+			bytes, err := jsonEncodeIndented(f)
+			if err == nil {
+				err = ioutil.WriteFile(capture, bytes, 0644)
+			}
+			if err != nil {
+				output.Errorf("Could not save the fixture: %s", err)
+				return 1
+			}
+			output.Printf("Saved the fixture of %d repositories to %s\n", len(f.Repos), capture)
+		}
+	}
+
+	report := &benchReport{Fixture: fixtureFile, Repositories: len(f.Repos), Go: runtime.Version()}
+	withoutOutput(func() {
+		report.Results = append(report.Results, runBench("resolve", runs, func() {
+			fixtureResolver(f).resolve(".", f.Package)
+		}))
+		report.Results = append(report.Results, runBench("resolve-flat", runs, func() {
+			r := fixtureResolver(f)
+			r.flat = true
+			r.resolve(".", f.Package)
+		}))
+		if len(dirs) > 0 {
+			report.Results = append(report.Results, runBench("scan", runs, func() {
+				for _, scanDir := range dirs {
+					gitSource{}.imports(scanDir, "")
+				}
+			}))
+		}
+	})
+
+	output.Result(report, func() {
+		fmt.Printf("Fixture %s, %d repositories, %s\n", report.Fixture, report.Repositories, report.Go)
+		fmt.Printf("%-14s %5s %12s %12s %12s %12s %14s\n", "benchmark", "runs", "min", "median", "mean", "allocs/run", "bytes/run")
+		for _, r := range report.Results {
+			fmt.Printf("%-14s %5d %12s %12s %12s %12d %14d\n", r.Name, r.Runs, r.Min, r.Median, r.Mean, r.AllocsPerRun, r.BytesPerRun)
+		}
+	})
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxBudgetOffenders limits the breakdown printed when a budget is exceeded.
+const maxBudgetOffenders = 10
+
+// bpmBudget limits how much a project may vendor.
+type bpmBudget struct {
+	// MaxVendorSize like "200MiB" or "1GB", with binary units either way.
+	MaxVendorSize string `json:"maxVendorSize,omitempty"`
+	// MaxDependencies counts distinct packages, transitive ones included.
+	MaxDependencies int `json:"maxDependencies,omitempty"`
+}
+
+// budget is the budget of p, or the nearest base's when p has none.
+func (p *bpmPackage) budget() *bpmBudget {
+	if p.Budget != nil || p.base == nil {
+		return p.Budget
+	}
+	return p.base.budget()
+}
+
+// checkBudget projects the vendor size and dependency count after install,
+// measuring vendored packages and estimating missing ones, and prints the
+// biggest offenders of every exceeded budget. It returns false when any is.
+func checkBudget(dir string, budget *bpmBudget, dependencies map[string]*bpmEntry) bool {
+	withinBudget := true
+	flat := make(map[string][]*bpmEntry)
+	flattenDependencies(dependencies, flat)
+	if budget.MaxDependencies > 0 && len(flat) > budget.MaxDependencies {
+		withinBudget = false
+		message := fmt.Sprintf("%d dependencies exceed the budget of %d. Direct dependencies bringing in the most:",
+			len(flat), budget.MaxDependencies)
+		counts := make([]diskUsage, 0)
+		for _, pkg := range sortedKeys(dependencies) {
+			nested := make(map[string][]*bpmEntry)
+			flattenDependencies(dependencies[pkg].Dependencies, nested)
+			counts = append(counts, diskUsage{pkg: pkg, size: int64(len(nested) + 1)})
+		}
+		problems := make([]string, 0)
+		for _, count := range largestUsages(counts) {
+			problems = append(problems, fmt.Sprintf("%10d  %s", count.size, count.pkg))
+		}
+		output.Problems(message, problems)
+	}
+
+	if budget.MaxVendorSize == "" {
+		return withinBudget
+	}
+	// Checked by checkSettings.
+	maxSize, _ := parseSize(budget.MaxVendorSize)
+	usages := make([]diskUsage, 0)
+	unknown := make([]string, 0)
+	projectUsage(filepath.Join(dir, vendorFolderName), dependencies, &usages, &unknown)
+	var total int64
+	for _, usage := range usages {
+		total += usage.size
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Printf("No size estimate for: %s", strings.Join(unknown, ", "))
+	}
+	if total > maxSize {
+		withinBudget = false
+		message := fmt.Sprintf("Vendor size of about %s exceeds the budget of %s. Largest packages:",
+			formatSize(total), formatSize(maxSize))
+		problems := make([]string, 0)
+		for _, usage := range largestUsages(usages) {
+			problems = append(problems, fmt.Sprintf("%10s  %s", formatSize(usage.size), usage.pkg))
+		}
+		output.Problems(message, problems)
+	}
+	return withinBudget
+}
+
+// projectUsage collects the size of vendored packages, and the estimated size
+// of missing ones.
+func projectUsage(vendorDir string, dependencies map[string]*bpmEntry, usages *[]diskUsage, unknown *[]string) {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if fileExists(pkgDir) {
+			if size, err := getDirSize(pkgDir); err != nil {
+				log.Printf("Could not measure %s: %s", pkgDir, err)
+				*unknown = append(*unknown, pkg)
+			} else {
+				*usages = append(*usages, diskUsage{pkg: pkg, size: size})
+			}
+		} else if size, err := estimateSize(pkg, entry); err != nil || size < 0 {
+			*unknown = append(*unknown, pkg)
+		} else {
+			*usages = append(*usages, diskUsage{pkg: pkg, size: size})
+		}
+		projectUsage(filepath.Join(pkgDir, vendorFolderName), entry.Dependencies, usages, unknown)
+	}
+}
+
+func largestUsages(usages []diskUsage) []diskUsage {
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].size > usages[j].size
+	})
+	if len(usages) > maxBudgetOffenders {
+		usages = usages[:maxBudgetOffenders]
+	}
+	return usages
+}
+
+// parseSize parses sizes like "512KB", "200MiB" or "1.5G", counting in powers
+// of 1024 like formatSize.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		for exp := strings.IndexByte("KMGT", s[i]); exp >= 0; exp-- {
+			multiplier *= 1024
+		}
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 200MiB")
+	}
+	return int64(n * float64(multiplier)), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultCacheLockTimeout = 5 * time.Minute
+	cacheLockPollInterval   = 100 * time.Millisecond
+	// cacheLockStaleAfter is when a lock left by a crashed process is broken.
+	cacheLockStaleAfter = 30 * time.Minute
+)
+
+// lockCache takes the lock on one entry of the global cache, e.g. the clone
+// or remote listing of one repository, shared by every bpm process on the
+// machine. Locks are files created exclusively in the locks folder of the
+// cache; waiting for one gives up after the configured cacheLockTimeout.
+// It returns the function releasing the lock.
+func lockCache(kind string, key string) (func(), error) {
+	sum := sha256.Sum256([]byte(key))
+	filename := filepath.Join(getCacheDir("locks"), kind+"-"+hex.EncodeToString(sum[:8])+".lock")
+	// Checked by loadGlobalConfig.
+	timeout, _ := parseDurationSetting("cacheLockTimeout", getGlobalConfig().CacheLockTimeout, defaultCacheLockTimeout)
+	deadline := time.Now().Add(timeout)
+	logged := false
+	for {
+		f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() {
+				if err := os.Remove(filename); err != nil {
+					log.Printf("Could not release cache lock %s: %s", filename, err)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(filename); err == nil && time.Since(info.ModTime()) > cacheLockStaleAfter {
+			log.Printf("Breaking stale cache lock %s", filename)
+			os.Remove(filename)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for the cache lock on %s held by another bpm process (%s)", timeout, key, filename)
+		}
+		if !logged {
+			log.Printf("Waiting for another bpm process using the cache of %s", key)
+			logged = true
+		}
+		time.Sleep(cacheLockPollInterval)
+	}
+}
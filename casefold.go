@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// caseEscaped holds the packages whose path collides with another package's
+// on case-insensitive filesystems. They are vendored under the escaped path
+// Go modules use for the module cache, e.g. "github.com/!sirupsen/logrus",
+// so neither overwrites the other, and reported by addCaseCollisions.
+var (
+	caseEscapedMu sync.RWMutex
+	caseEscaped   = make(map[string]bool)
+)
+
+// vendorPath returns the folder, relative to a vendor folder, a package is
+// vendored in.
+func vendorPath(pkg string) string {
+	caseEscapedMu.RLock()
+	defer caseEscapedMu.RUnlock()
+	if caseEscaped[pkg] {
+		return filepath.FromSlash(escapeCase(pkg))
+	}
+	return filepath.FromSlash(pkg)
+}
+
+// escapeCase replaces every upper case letter with "!" and its lower case.
+func escapeCase(pkg string) string {
+	sb := strings.Builder{}
+	for _, r := range pkg {
+		if unicode.IsUpper(r) {
+			sb.WriteRune('!')
+			sb.WriteRune(unicode.ToLower(r))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// noteCaseCollisions escapes every package of a dependency tree whose path
+// differs from another one's only by case, returning the new collisions.
+func noteCaseCollisions(dependencies map[string]*bpmEntry) []string {
+	packages := make(map[string]bool)
+	collectPackages(dependencies, packages)
+	return escapeCollisions(packages)
+}
+
+func escapeCollisions(packages map[string]bool) []string {
+	folded := make(map[string][]string)
+	for pkg := range packages {
+		key := strings.ToLower(pkg)
+		folded[key] = append(folded[key], pkg)
+	}
+
+	caseEscapedMu.Lock()
+	defer caseEscapedMu.Unlock()
+	collisions := make([]string, 0)
+	for _, group := range folded {
+		if len(group) < 2 {
+			continue
+		}
+		for _, pkg := range group {
+			if !caseEscaped[pkg] {
+				caseEscaped[pkg] = true
+				collisions = append(collisions, pkg)
+			}
+		}
+	}
+	sort.Strings(collisions)
+	if len(collisions) > 0 {
+		log.Printf("Packages differing only by case, vendored under escaped paths: %s", strings.Join(collisions, ", "))
+	}
+	return collisions
+}
+
+// addCaseCollisions records the escaped packages of a dependency tree in
+// failed. Go rejects the "!" of escaped paths in imports, so their importers
+// can't be built from vendor until a single spelling is imported.
+func addCaseCollisions(dependencies map[string]*bpmEntry, failed *pullError) {
+	packages := make(map[string]bool)
+	collectPackages(dependencies, packages)
+	folded := make(map[string][]string)
+	for pkg := range packages {
+		key := strings.ToLower(pkg)
+		folded[key] = append(folded[key], pkg)
+	}
+	for _, group := range folded {
+		sort.Strings(group)
+		for _, pkg := range group {
+			if !isCaseEscaped(pkg) {
+				continue
+			}
+			others := make([]string, 0, len(group)-1)
+			for _, other := range group {
+				if other != pkg {
+					others = append(others, other)
+				}
+			}
+			failed.add(pkg, fmt.Errorf("differs only by case from %s and is vendored in %s, which Go can't import; import a single spelling of it",
+				strings.Join(others, ", "), filepath.Join(vendorFolderName, vendorPath(pkg))))
+		}
+	}
+}
+
+func isCaseEscaped(pkg string) bool {
+	caseEscapedMu.RLock()
+	defer caseEscapedMu.RUnlock()
+	return caseEscaped[pkg]
+}
+
+// moveEscaped moves a package fetched before its collision was known to its
+// escaped folder.
+func moveEscaped(vendorDir string, pkg string) error {
+	from := filepath.Join(vendorDir, filepath.FromSlash(pkg))
+	to := filepath.Join(vendorDir, vendorPath(pkg))
+	if from == to || !fileExists(from) {
+		return nil
+	}
+	if err := createDir(filepath.Dir(to)); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// warnFileCaseCollisions reports tracked files of a repository whose paths
+// differ only by case, which overwrite each other on macOS and Windows.
+func warnFileCaseCollisions(pkg string, pkgDir string) {
+	out, err := runCmdOutput(&pkgDir, "git", "ls-files")
+	if err != nil {
+		log.Printf("Could not list the files of %s: %s", pkg, err)
+		return
+	}
+	folded := make(map[string][]string)
+	for _, file := range strings.Split(out, "\n") {
+		if file != "" {
+			key := strings.ToLower(file)
+			folded[key] = append(folded[key], file)
+		}
+	}
+	for _, files := range folded {
+		if len(files) > 1 {
+			sort.Strings(files)
+			log.Printf("Warning: %s has files differing only by case, which collide on case-insensitive filesystems: %s",
+				pkg, strings.Join(files, ", "))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+// defaultChannels are the branch patterns release channels map to on hosts
+// without a convention in the channels setting of the global config.
+var defaultChannels = map[string]string{
+	"stable": "release-*",
+}
+
+// trackedBranch returns the branch an entry follows: the highest-sorting
+// remote branch matching its branch pattern or channel, or else its fixed
+// branch.
+func trackedBranch(pkg string, entry *bpmEntry) (string, error) {
+	pattern := entry.BranchPattern
+	repoURL := getEntryURL(pkg, entry)
+	if entry.Channel != "" {
+		var err error
+		if pattern, err = getChannelPattern(getURLHost(repoURL), entry.Channel); err != nil {
+			return "", err
+		}
+	}
+	if pattern == "" {
+		return entry.Branch, nil
+	}
+
+	refs, err := lsRemote(repoURL, "refs/heads/*")
+	if err != nil {
+		return "", err
+	}
+	best := ""
+	for ref := range refs {
+		name := strings.TrimPrefix(ref, "refs/heads/")
+		if matched, _ := path.Match(pattern, name); matched && (best == "" || compareNatural(name, best) > 0) {
+			best = name
+		}
+	}
+	if best == "" {
+		log.Printf("No branch of %s matches %s, staying on %s", pkg, pattern, entry.Branch)
+		return entry.Branch, nil
+	}
+	return best, nil
+}
+
+// getChannelPattern maps a channel to the branch pattern used for it on a
+// host. The global config can set conventions per host, with "*" applying to
+// every host:
+//
+//	"channels": {"gitlab.corp.example": {"stable": "stable-*"}, "*": {"lts": "lts/*"}}
+func getChannelPattern(host string, channel string) (string, error) {
+	channels := getGlobalConfig().Channels
+	for _, key := range []string{host, "*"} {
+		if pattern, ok := channels[key][channel]; ok {
+			return pattern, nil
+		}
+	}
+	if pattern, ok := defaultChannels[channel]; ok {
+		return pattern, nil
+	}
+	return "", fmt.Errorf("unknown channel %q for %s, configure it in %s", channel, host, getConfigFile())
+}
+
+// compareNatural compares strings with runs of digits compared as numbers,
+// so "release-1.10" sorts after "release-1.9".
+func compareNatural(a string, b string) int {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return compareInts(len(na), len(nb))
+			}
+			if na != nb {
+				return strings.Compare(na, nb)
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const (
+	checkExitCurrent          = 0
+	checkExitError            = 1
+	checkExitUpdatesAvailable = 3
+	checkExitVulnerable       = 4
+)
+
+type checkReport struct {
+	Package         string             `json:"package"`
+	CheckedAt       time.Time          `json:"checkedAt"`
+	Status          string             `json:"status"`
+	Outdated        []*outdatedEntry   `json:"outdated"`
+	Vulnerabilities []*vulnerableEntry `json:"vulnerabilities"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// doCheckUpdates checks for outdated and vulnerable dependencies, writes the
+// report file and returns the process exit code.
+func doCheckUpdates(dir string, quietIfCurrent bool, reportFile string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return checkExitError
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return checkExitError
+	}
+
+	report := &checkReport{
+		Package:   data.Package,
+		CheckedAt: time.Now().UTC()}
+
+	code := checkExitCurrent
+	report.Status = "current"
+	report.Outdated, err = findUpdates(dir, data.Dependencies)
+	if err != nil {
+		code = checkExitError
+		report.Status = "error"
+		report.Error = err.Error()
+	} else if len(report.Outdated) > 0 {
+		code = checkExitUpdatesAvailable
+		report.Status = "updates available"
+	}
+	vulnerable, err := findVulnerable(data.Dependencies)
+	vulnerable, _ = filterIgnored(vulnerable, data.IgnoreVulnerabilities, time.Now())
+	if err != nil {
+		code = checkExitError
+		report.Status = "error"
+		report.Error = err.Error()
+	} else if len(vulnerable) > 0 && code != checkExitError {
+		code = checkExitVulnerable
+		report.Status = "vulnerabilities found"
+	}
+	report.Vulnerabilities = vulnerable
+
+	if reportFile != "" {
+		if !filepath.IsAbs(reportFile) {
+			reportFile = filepath.Join(dir, reportFile)
+		}
+		bytes, _ := json.MarshalIndent(report, "", "  ")
+		if err := ioutil.WriteFile(reportFile, bytes, 0644); err != nil {
+			output.Errorf("Could not write report %s: %s", reportFile, err)
+			return checkExitError
+		}
+	}
+
+	if code == checkExitCurrent && quietIfCurrent {
+		return code
+	}
+	output.Result(report, func() {
+		printCheckReport(report)
+	})
+	return code
+}
+
+func printCheckReport(report *checkReport) {
+	fmt.Printf("%s: %s\n", report.Package, report.Status)
+	if report.Error != "" {
+		fmt.Printf("    error: %s\n", report.Error)
+	}
+	for _, o := range report.Outdated {
+		if o.Upstream != "" {
+			fmt.Printf("    behind upstream: %s (%s %s) %s\n", o.Package, o.Upstream, o.Branch, describeBehind(o))
+			continue
+		}
+		fmt.Printf("    outdated: %s %s\n", o.Package, describeUpdate(o))
+	}
+	for _, v := range report.Vulnerabilities {
+		for _, vuln := range v.Vulnerabilities {
+			fmt.Printf("    vulnerable: %s@%s %s %s\n", v.Package, shortHash(v.Commit), vuln.ID, vuln.Summary)
+		}
+	}
+}
+
+func describeBehind(o *outdatedEntry) string {
+	if o.Behind > 0 {
+		return fmt.Sprintf("%d commits behind %s", o.Behind, shortHash(o.Latest))
+	}
+	return fmt.Sprintf("%s -> %s", shortHash(o.Commit), shortHash(o.Latest))
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -5,64 +5,203 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// Handler runs a command with the positional arguments left after its flags.
+type Handler func(args []string)
+
+// CmdItem is a command with its own flag set. Flags registered on Commands
+// are added to every command that doesn't define a flag of the same name.
 type CmdItem struct {
+	parent  *Commands
 	cmd     *flag.FlagSet
-	handler func()
+	handler Handler
 	desc    string
+	args    []*ArgItem
 }
 
 type ArgItem struct {
-	pVal *string
-	desc string
+	name  string
+	pVal  *string
+	pBool *bool
+	def   string
+	desc  string
 }
 
 type Commands struct {
 	Name        string
 	MainCommand string
+	// Before is called with the command name before its handler runs.
+	Before      func(name string)
 	commands    map[string]*CmdItem
-	args        map[string]*ArgItem
+	args        []*ArgItem
+	groups      []*cmdGroup
 	nameMaxSize int
 }
 
+// cmdGroup is a heading of the help, listing its commands in order.
+type cmdGroup struct {
+	title string
+	names []string
+}
+
+// CommandInfo describes a command, its flags and its group.
+type CommandInfo struct {
+	Name        string      `json:"name"`
+	Group       string      `json:"group,omitempty"`
+	Description string      `json:"description"`
+	Flags       []*FlagInfo `json:"flags,omitempty"`
+}
+
+// FlagInfo describes a flag, of type "string" or "bool".
+type FlagInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+}
+
+// Description is every command and the flags they all accept, for wrappers,
+// documentation and completion scripts.
+type Description struct {
+	Name     string         `json:"name"`
+	Command  string         `json:"command"`
+	Commands []*CommandInfo `json:"commands"`
+	Flags    []*FlagInfo    `json:"flags"`
+}
+
 func (c *Commands) updateMaxSize(name string) {
 	if l := len(name); c.nameMaxSize < l {
 		c.nameMaxSize = l
 	}
 }
 
-func (c *Commands) NewCommand(name string, handler func(), desc string) {
-	cmd := flag.NewFlagSet(name, flag.ContinueOnError)
-	c.AddCommand(cmd, handler, desc)
+func (c *Commands) NewCommand(name string, handler Handler, desc string) *CmdItem {
+	cmd := flag.NewFlagSet(name, flag.ExitOnError)
+	return c.AddCommand(cmd, handler, desc)
 }
 
-func (c *Commands) AddCommand(flag *flag.FlagSet, handler func(), desc string) {
+func (c *Commands) AddCommand(flag *flag.FlagSet, handler Handler, desc string) *CmdItem {
 	if c.commands == nil {
 		c.commands = make(map[string]*CmdItem)
-		c.args = make(map[string]*ArgItem)
 	}
-	c.commands[flag.Name()] = &CmdItem{
+	item := &CmdItem{
+		parent:  c,
 		cmd:     flag,
 		handler: handler,
 		desc:    desc}
+	c.commands[flag.Name()] = item
 	c.updateMaxSize(flag.Name())
+	return item
+}
+
+// Group lists commands under a heading of the help, in the given order.
+// Commands in no group are listed last.
+func (c *Commands) Group(title string, names ...string) {
+	c.groups = append(c.groups, &cmdGroup{title: title, names: names})
 }
 
+// NewArg registers a string flag every command accepts.
 func (c *Commands) NewArg(name string, pVal *string, def string, desc string) {
-	if c.commands == nil {
-		c.commands = make(map[string]*CmdItem)
-		c.args = make(map[string]*ArgItem)
-	}
-	flag.StringVar(pVal, name, def, desc)
-	c.args[name] = &ArgItem{
-		pVal: pVal,
-		desc: desc}
+	c.args = append(c.args, &ArgItem{name: name, pVal: pVal, def: def, desc: desc})
 	c.updateMaxSize(name)
 }
 
+// NewBoolArg registers a bool flag every command accepts.
+func (c *Commands) NewBoolArg(name string, pVal *bool, desc string) {
+	c.args = append(c.args, &ArgItem{name: name, pBool: pVal, desc: desc})
+	c.updateMaxSize(name)
+}
+
+// NewArg registers a string flag of the command.
+func (item *CmdItem) NewArg(name string, pVal *string, def string, desc string) *CmdItem {
+	arg := &ArgItem{name: name, pVal: pVal, def: def, desc: desc}
+	arg.define(item.cmd)
+	item.args = append(item.args, arg)
+	item.parent.updateMaxSize(arg.name)
+	return item
+}
+
+// NewBoolArg registers a bool flag of the command.
+func (item *CmdItem) NewBoolArg(name string, pVal *bool, desc string) *CmdItem {
+	arg := &ArgItem{name: name, pBool: pVal, desc: desc}
+	arg.define(item.cmd)
+	item.args = append(item.args, arg)
+	item.parent.updateMaxSize(arg.name)
+	return item
+}
+
+func (arg *ArgItem) define(set *flag.FlagSet) {
+	name := strings.TrimLeft(arg.name, "-")
+	if arg.pBool != nil {
+		set.BoolVar(arg.pBool, name, false, arg.desc)
+	} else {
+		set.StringVar(arg.pVal, name, arg.def, arg.desc)
+	}
+}
+
+func (arg *ArgItem) info() *FlagInfo {
+	if arg.pBool != nil {
+		return &FlagInfo{Name: arg.name, Type: "bool", Description: arg.desc}
+	}
+	return &FlagInfo{Name: arg.name, Type: "string", Default: arg.def, Description: arg.desc}
+}
+
+// Describe returns every command, grouped like the help.
+func (c *Commands) Describe() *Description {
+	d := &Description{Name: c.Name, Command: c.MainCommand}
+	for _, group := range c.listGroups() {
+		for _, name := range group.names {
+			item := c.commands[name]
+			info := &CommandInfo{Name: name, Group: group.title, Description: item.desc}
+			for _, arg := range item.args {
+				info.Flags = append(info.Flags, arg.info())
+			}
+			d.Commands = append(d.Commands, info)
+		}
+	}
+	for _, arg := range c.args {
+		d.Flags = append(d.Flags, arg.info())
+	}
+	return d
+}
+
+// listGroups returns the groups with registered commands, and the other
+// commands by name in a last group, untitled when there are no groups.
+func (c *Commands) listGroups() []*cmdGroup {
+	grouped := make(map[string]bool)
+	groups := make([]*cmdGroup, 0, len(c.groups)+1)
+	for _, group := range c.groups {
+		listed := &cmdGroup{title: group.title}
+		for _, name := range group.names {
+			if _, ok := c.commands[name]; ok && !grouped[name] {
+				listed.names = append(listed.names, name)
+				grouped[name] = true
+			}
+		}
+		if len(listed.names) > 0 {
+			groups = append(groups, listed)
+		}
+	}
+	other := &cmdGroup{}
+	if len(groups) > 0 {
+		other.title = "Other commands"
+	}
+	for name := range c.commands {
+		if !grouped[name] {
+			other.names = append(other.names, name)
+		}
+	}
+	sort.Strings(other.names)
+	if len(other.names) > 0 {
+		groups = append(groups, other)
+	}
+	return groups
+}
+
 func showHelp(c *Commands) {
 	sb := strings.Builder{}
 	sb.WriteString(c.Name)
@@ -86,7 +225,6 @@ func showHelp(c *Commands) {
 func HandleArgs(c *Commands) {
 	if c.commands == nil {
 		c.commands = make(map[string]*CmdItem)
-		c.args = make(map[string]*ArgItem)
 	}
 	if len(os.Args) <= 1 {
 		showHelp(c)
@@ -94,45 +232,58 @@ func HandleArgs(c *Commands) {
 	}
 
 	cmd := os.Args[1]
-	var pItem *CmdItem
-
-	for name, item := range c.commands {
-		if name == cmd {
-			pItem = item
-			break
-		}
-	}
-
-	if pItem == nil {
+	pItem, ok := c.commands[cmd]
+	if !ok {
 		showHelp(c)
 		return
 	}
 
-	flag.Parse()
+	for _, arg := range c.args {
+		if pItem.cmd.Lookup(strings.TrimLeft(arg.name, "-")) == nil {
+			arg.define(pItem.cmd)
+		}
+	}
+	pItem.cmd.Parse(os.Args[2:])
 
-	pItem.handler()
+	if c.Before != nil {
+		c.Before(cmd)
+	}
+	pItem.handler(pItem.cmd.Args())
 }
 
 func (c *Commands) WriteWholeUsage(w io.Writer) {
 	indent := "    "
 	if len(c.commands) > 0 {
-		io.WriteString(w, "Commands:\n")
-
-		for name, item := range c.commands {
-			io.WriteString(w, indent)
-			io.WriteString(w, fmt.Sprintf("%-"+strconv.Itoa(c.nameMaxSize)+"s", name))
-			io.WriteString(w, indent)
-			io.WriteString(w, item.desc)
+		for _, group := range c.listGroups() {
+			if group.title == "" {
+				io.WriteString(w, "Commands:\n")
+			} else {
+				io.WriteString(w, group.title+":\n")
+			}
+			for _, name := range group.names {
+				item := c.commands[name]
+				io.WriteString(w, indent)
+				io.WriteString(w, fmt.Sprintf("%-"+strconv.Itoa(c.nameMaxSize)+"s", name))
+				io.WriteString(w, indent)
+				io.WriteString(w, item.desc)
+				io.WriteString(w, "\n")
+				for _, arg := range item.args {
+					io.WriteString(w, indent+indent)
+					io.WriteString(w, fmt.Sprintf("%-"+strconv.Itoa(c.nameMaxSize-len(indent))+"s", arg.name))
+					io.WriteString(w, indent)
+					io.WriteString(w, arg.desc)
+					io.WriteString(w, "\n")
+				}
+			}
 			io.WriteString(w, "\n")
 		}
-		io.WriteString(w, "\n")
 	}
 
 	if len(c.args) > 0 {
 		io.WriteString(w, "Args:\n")
-		for name, item := range c.args {
+		for _, item := range c.args {
 			io.WriteString(w, indent)
-			io.WriteString(w, fmt.Sprintf("%-"+strconv.Itoa(c.nameMaxSize)+"s", name))
+			io.WriteString(w, fmt.Sprintf("%-"+strconv.Itoa(c.nameMaxSize)+"s", item.name))
 			io.WriteString(w, indent)
 			io.WriteString(w, item.desc)
 			io.WriteString(w, "\n")
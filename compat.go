@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var compileErrorPattern = regexp.MustCompile(`^(.+\.go):(\d+)(?::\d+)?: (.*)$`)
+
+type compileError struct {
+	file    string
+	line    int
+	message string
+}
+
+// doCompat builds (or vets) the project against the installed vendor tree and
+// attributes compile errors to the dependency most likely behind them. When
+// changed is given, errors in project code are blamed on those packages
+// first. It returns the exit code.
+func doCompat(dir string, vet bool, changed []string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	tool := "build"
+	if vet {
+		tool = "vet"
+	}
+	cmd := exec.CommandContext(runContext, "go", tool, "./...")
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), toolchainEnv()...), projectEnv(dir, data.Dependencies)...)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		output.Printf("go %s succeeded\n", tool)
+		return 0
+	}
+
+	errors := parseCompileErrors(dir, out)
+	if len(errors) == 0 {
+		output.Errorf("go %s failed: %s\n%s", tool, err, out)
+		return 1
+	}
+
+	packages := make(map[string]bool)
+	collectPackages(data.Dependencies, packages)
+	blamed := make(map[string][]*compileError)
+	for _, e := range errors {
+		pkg := blameCompileError(dir, e, packages, changed)
+		blamed[pkg] = append(blamed[pkg], e)
+	}
+
+	output.Errorf("go %s failed with %d errors:", tool, len(errors))
+	blamedPackages := make([]string, 0, len(blamed))
+	for pkg := range blamed {
+		blamedPackages = append(blamedPackages, pkg)
+	}
+	sort.Strings(blamedPackages)
+	for _, pkg := range blamedPackages {
+		message := fmt.Sprintf("Likely caused by %s:", pkg)
+		if pkg == "" {
+			message = "Project code, no dependency identified:"
+		}
+		problems := make([]string, 0, len(blamed[pkg]))
+		for _, e := range blamed[pkg] {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s", e.file, e.line, e.message))
+		}
+		output.Problems(message, problems)
+	}
+	return 1
+}
+
+func parseCompileErrors(dir string, out []byte) []*compileError {
+	errors := make([]*compileError, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		match := compileErrorPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if match == nil {
+			continue
+		}
+		file := match[1]
+		if filepath.IsAbs(file) {
+			if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+				file = rel
+			}
+		}
+		line, _ := strconv.Atoi(match[2])
+		errors = append(errors, &compileError{file: filepath.ToSlash(filepath.Clean(file)), line: line, message: match[3]})
+	}
+	return errors
+}
+
+// blameCompileError picks the dependency behind an error: the vendored
+// package the file belongs to, or else an imported package the message
+// refers to by name, preferring recently changed packages.
+func blameCompileError(dir string, e *compileError, packages map[string]bool, changed []string) string {
+	if i := strings.LastIndex(e.file, vendorFolderName+"/"); i >= 0 {
+		vendored := e.file[i+len(vendorFolderName)+1:]
+		best := ""
+		for pkg := range packages {
+			if strings.HasPrefix(vendored, pkg+"/") && len(pkg) > len(best) {
+				best = pkg
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+
+	candidates := make([]string, 0)
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, filepath.Join(dir, filepath.FromSlash(e.file)), nil, parser.ImportsOnly)
+	if err != nil {
+		return ""
+	}
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil || !isVendoredImport(importPath, packages) {
+			continue
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if strings.Contains(e.message, name+".") {
+			candidates = append(candidates, importPath)
+		}
+	}
+	for _, candidate := range candidates {
+		for _, pkg := range changed {
+			if candidate == pkg || strings.HasPrefix(candidate, pkg+"/") {
+				return pkg
+			}
+		}
+	}
+	for _, candidate := range candidates {
+		for pkg := range packages {
+			if candidate == pkg || strings.HasPrefix(candidate, pkg+"/") {
+				return pkg
+			}
+		}
+	}
+	if len(changed) == 1 {
+		return changed[0]
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const configFilename = "config.json"
+
+// globalConfig holds per-user settings from $XDG_CONFIG_HOME/bpm/config.json
+// (or the platform equivalent).
+type globalConfig struct {
+	RemoteCacheTTL string `json:"remoteCacheTTL,omitempty"`
+	// CacheLockTimeout bounds the wait for other processes using the same
+	// cache entry, like "5m".
+	CacheLockTimeout string                       `json:"cacheLockTimeout,omitempty"`
+	PartialClone     bool                         `json:"partialClone,omitempty"`
+	Stats            bool                         `json:"stats,omitempty"`
+	SharedCache      string                       `json:"sharedCache,omitempty"`
+	Credentials      []*credentialConfig          `json:"credentials,omitempty"`
+	Jobs             int                          `json:"jobs,omitempty"`
+	JobsPerHost      int                          `json:"jobsPerHost,omitempty"`
+	HostJobs         map[string]int               `json:"hostJobs,omitempty"`
+	Channels         map[string]map[string]string `json:"channels,omitempty"`
+	Git              *gitConfig                   `json:"git,omitempty"`
+	HostAPIs         map[string]*hostAPIConfig    `json:"hostAPIs,omitempty"`
+	// Templates are the project templates of init -template, replacing the
+	// built-in ones of the same name.
+	Templates map[string]*bpmPackage `json:"templates,omitempty"`
+	// TraceEndpoint is the OTLP/HTTP collector install -trace sends spans
+	// to, like "http://otel-collector:4318", when the OTEL_EXPORTER_OTLP_*
+	// variables don't name one.
+	TraceEndpoint string `json:"traceEndpoint,omitempty"`
+	// Hosts set how repository roots are found in import paths per host,
+	// overriding the built-in rules and go-import meta tag discovery.
+	Hosts map[string]*hostRootConfig `json:"hosts,omitempty"`
+}
+
+var (
+	globalConfigOnce  sync.Once
+	globalConfigValue *globalConfig
+	globalConfigErr   error
+)
+
+// loadGlobalConfig reads and checks the global config, once. Commands call it
+// before running, so the settings read later are known to be valid.
+func loadGlobalConfig() error {
+	globalConfigOnce.Do(func() {
+		globalConfigValue = &globalConfig{}
+		filename := getConfigFile()
+		if filename == "" || !fileExists(filename) {
+			return
+		}
+		bytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			globalConfigErr = err
+			return
+		}
+		config := &globalConfig{}
+		if err = json.Unmarshal(bytes, config); err == nil {
+			err = config.check()
+		}
+		if err != nil {
+			globalConfigErr = fmt.Errorf("invalid %s: %s", filename, err)
+			return
+		}
+		globalConfigValue = config
+	})
+	return globalConfigErr
+}
+
+// getGlobalConfig returns the global config, empty when loadGlobalConfig
+// couldn't read it.
+func getGlobalConfig() *globalConfig {
+	loadGlobalConfig()
+	return globalConfigValue
+}
+
+// check rejects the settings bpm can't act on.
+func (c *globalConfig) check() error {
+	if _, err := parseDurationSetting("remoteCacheTTL", c.RemoteCacheTTL, defaultRemoteCacheTTL); err != nil {
+		return err
+	}
+	if _, err := parseDurationSetting("cacheLockTimeout", c.CacheLockTimeout, defaultCacheLockTimeout); err != nil {
+		return err
+	}
+	for _, cred := range c.Credentials {
+		if _, err := parseDurationSetting("ttl", cred.TTL, defaultCredentialTTL); err != nil {
+			return fmt.Errorf("credentials of %s: %s", cred.Host, err)
+		}
+	}
+	for host, rule := range c.Hosts {
+		if rule.Pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid host pattern %q of %s: %s", rule.Pattern, host, err)
+		}
+	}
+	return nil
+}
+
+func getConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bpm", configFilename)
+}
+
+// getCacheDir returns a folder in the per-user bpm cache, creating it.
+func getCacheDir(parts ...string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	dir = filepath.Join(append([]string{dir, "bpm"}, parts...)...)
+	// Reading or writing below it reports the error.
+	if err := createDir(dir); err != nil {
+		log.Printf("Could not create %s: %s", dir, err)
+	}
+	return dir
+}
+
+// parseDurationSetting parses a duration setting, returning def when it is
+// empty or, along with the error, invalid.
+func parseDurationSetting(name string, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return def, fmt.Errorf("invalid %s %q: %s", name, value, err)
+	}
+	return d, nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ways of settling a package required at different commits, set by conflicts
+// in the policy. Without one the commit resolved first is kept and the
+// conflict reported.
+const (
+	conflictsNewest           = "newest"
+	conflictsManifestOverride = "manifest-override"
+	conflictsFail             = "fail"
+)
+
+// conflictStrategy is how the policy of p settles conflicting commits.
+func (p *bpmPackage) conflictStrategy() string {
+	if policy := p.policy(); policy != nil {
+		return policy.Conflicts
+	}
+	return ""
+}
+
+// conflictCopy is a checkout of a package in one of the vendor folders, and
+// in a nested layout the chain of packages leading to it.
+type conflictCopy struct {
+	dir   string
+	entry *bpmEntry
+	chain []string
+}
+
+// diamondConflict is a package two dependencies require at different
+// commits: in a nested layout its copies differ, in a flat one the single
+// copy differs from the commit another dependency pins.
+type diamondConflict struct {
+	pkg     string
+	copies  []*conflictCopy
+	commits []string
+	// wantedBy lists who requires each commit.
+	wantedBy map[string][]string
+}
+
+func (c *diamondConflict) want(commit string, by string) {
+	if !containsString(c.commits, commit) {
+		c.commits = append(c.commits, commit)
+	}
+	if !containsString(c.wantedBy[commit], by) {
+		c.wantedBy[commit] = append(c.wantedBy[commit], by)
+	}
+}
+
+func (c *diamondConflict) describe() string {
+	return fmt.Sprintf("%s is required at %s", c.pkg, c.wanted())
+}
+
+// wanted lists the commits of the conflict with who requires them.
+func (c *diamondConflict) wanted() string {
+	wanted := make([]string, 0, len(c.commits))
+	for _, commit := range c.commits {
+		wanted = append(wanted, fmt.Sprintf("%s by %s", shortHash(commit), strings.Join(c.wantedBy[commit], ", ")))
+	}
+	return strings.Join(wanted, "; ")
+}
+
+// findDiamonds lists the packages of the resolved tree required at more than
+// one commit.
+func (r *resolver) findDiamonds(dir string, pkg string, dependencies map[string]*bpmEntry) []*diamondConflict {
+	byPackage := make(map[string]*diamondConflict)
+	conflict := func(name string) *diamondConflict {
+		c, ok := byPackage[name]
+		if !ok {
+			c = &diamondConflict{pkg: name, wantedBy: make(map[string][]string)}
+			byPackage[name] = c
+		}
+		return c
+	}
+	if r.flat {
+		for _, name := range sortedKeys(dependencies) {
+			entry := dependencies[name]
+			if entry.Commit == "" || len(r.flatPins[name]) == 0 {
+				continue
+			}
+			c := conflict(name)
+			c.copies = append(c.copies, &conflictCopy{dir: filepath.Join(dir, vendorFolderName, vendorPath(name)), entry: entry})
+			for _, by := range entry.RequiredBy {
+				c.want(entry.Commit, by)
+			}
+			for _, pin := range r.flatPins[name] {
+				c.want(pin.commit, pin.by)
+			}
+		}
+	} else {
+		var walk func(dir string, chain []string, dependencies map[string]*bpmEntry)
+		walk = func(dir string, chain []string, dependencies map[string]*bpmEntry) {
+			for _, name := range sortedKeys(dependencies) {
+				entry := dependencies[name]
+				pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(name))
+				if entry.Commit != "" && !isAssets(entry) {
+					c := conflict(name)
+					c.copies = append(c.copies, &conflictCopy{dir: pkgDir, entry: entry, chain: chain})
+					c.want(entry.Commit, chain[len(chain)-1])
+				}
+				walk(pkgDir, append(chain[:len(chain):len(chain)], name), entry.Dependencies)
+			}
+		}
+		walk(dir, []string{pkg}, dependencies)
+	}
+
+	diamonds := make([]*diamondConflict, 0)
+	for _, c := range byPackage {
+		if len(c.commits) > 1 {
+			diamonds = append(diamonds, c)
+		}
+	}
+	sort.Slice(diamonds, func(i, j int) bool {
+		return diamonds[i].pkg < diamonds[j].pkg
+	})
+	return diamonds
+}
+
+// settleConflicts applies the conflict strategy to the resolved tree: newest
+// checks every copy out at the most recent of the commits required, and
+// manifest-override at the commit bpm.json has, failing for packages it
+// doesn't declare. With fail any conflict stops the resolution. Conflicts
+// left unsettled are recorded in r.failed. In a nested layout the
+// dependencies of a copy moved to another commit are resolved again, as its
+// imports may have changed, so the tree is searched again after each
+// conflict; a package is settled only once.
+func (r *resolver) settleConflicts(dir string, pkg string, dependencies map[string]*bpmEntry) {
+	if r.conflicts == "" {
+		return
+	}
+	handled := make(map[string]bool)
+	for {
+		var c *diamondConflict
+		for _, diamond := range r.findDiamonds(dir, pkg, dependencies) {
+			if !handled[diamond.pkg] {
+				c = diamond
+				break
+			}
+		}
+		if c == nil {
+			return
+		}
+		handled[c.pkg] = true
+		target := ""
+		switch r.conflicts {
+		case conflictsNewest:
+			target = newestCommit(c)
+		case conflictsManifestOverride:
+			if declared, ok := dependencies[c.pkg]; ok && !declared.Indirect && declared.Commit != "" {
+				target = declared.Commit
+			}
+		}
+		if target == "" {
+			r.failed.add(c.pkg, unsettledConflict(r.conflicts, c))
+			continue
+		}
+		settled := true
+		for _, vendored := range c.copies {
+			if vendored.entry.Commit == target {
+				continue
+			}
+			if err := checkoutConflictCopy(vendored, target); err != nil {
+				r.failed.add(c.pkg, err)
+				settled = false
+				continue
+			}
+			if !r.flat {
+				r.resolveCopy(c.pkg, vendored)
+			}
+		}
+		if !settled {
+			continue
+		}
+		output.Printf("Conflict settled (%s): %s, using %s\n", r.conflicts, c.describe(), shortHash(target))
+	}
+}
+
+// unsettledConflict is the failure recorded for a conflict the strategy
+// couldn't settle.
+func unsettledConflict(strategy string, c *diamondConflict) error {
+	switch strategy {
+	case conflictsNewest:
+		return fmt.Errorf("required at %s, none of which is in %s", c.wanted(), c.copies[0].dir)
+	case conflictsManifestOverride:
+		return fmt.Errorf("required at %s, declare it in %s at the commit to use", c.wanted(), dependencyFilename)
+	}
+	return fmt.Errorf("required at %s, and the policy is to fail on conflicts", c.wanted())
+}
+
+// newestCommit returns the most recent of the commits a conflict requires, as
+// the first copy has them, or "" when it has none of them. A commit that
+// descends from all the others is the newest; when the history diverged the
+// one committed last is taken, which may drop changes only the other
+// branches have.
+func newestCommit(c *diamondConflict) string {
+	pkgDir := c.copies[0].dir
+	available := make([]string, 0, len(c.commits))
+	for _, commit := range c.commits {
+		if err := deepenTo(pkgDir, commit); err != nil {
+			log.Printf("Could not fetch %s into %s: %s", shortHash(commit), pkgDir, err)
+			continue
+		}
+		available = append(available, commit)
+	}
+	for _, commit := range available {
+		descendant := true
+		for _, other := range available {
+			if other != commit && !isAncestor(pkgDir, other, commit) {
+				descendant = false
+				break
+			}
+		}
+		if descendant {
+			return commit
+		}
+	}
+	newest, newestTime := "", int64(0)
+	for _, commit := range available {
+		if seconds := commitTime(pkgDir, commit); newest == "" || seconds > newestTime {
+			newest, newestTime = commit, seconds
+		}
+	}
+	if newest != "" {
+		log.Printf("%s diverged, required at %s, using the commit made last", c.pkg, c.wanted())
+	}
+	return newest
+}
+
+// resolveCopy resolves the dependencies of a copy checked out at another
+// commit again, replacing those vendored for the commit it had.
+func (r *resolver) resolveCopy(pkg string, vendored *conflictCopy) {
+	if err := os.RemoveAll(filepath.Join(vendored.dir, vendorFolderName)); err != nil {
+		r.failed.add(pkg, err)
+		return
+	}
+	chain := append(vendored.chain[:len(vendored.chain):len(vendored.chain)], pkg)
+	vendored.entry.Dependencies = r.resolveNested(vendored.dir, entryRepo(pkg, vendored.entry), chain)
+}
+
+// checkoutConflictCopy moves a vendored copy to the settled commit. Its tag
+// no longer names the commit and is dropped.
+func checkoutConflictCopy(vendored *conflictCopy, commit string) error {
+	log.Printf("Checking out %s at %s", vendored.dir, shortHash(commit))
+	if vendored.entry.Branch != "" {
+		if err := checkoutCommit(vendored.dir, vendored.entry.Branch, commit); err != nil {
+			return err
+		}
+	} else {
+		if err := deepenTo(vendored.dir, commit); err != nil {
+			return err
+		}
+		if _, err := runCmd(&vendored.dir, false, "git", "checkout", "--quiet", commit); err != nil {
+			return err
+		}
+	}
+	vendored.entry.Commit = commit
+	vendored.entry.Tag = ""
+	return nil
+}
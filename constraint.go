@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type versionComparator struct {
+	op string
+	v  *semver
+}
+
+// versionConstraint is a semver constraint: alternatives separated by "||",
+// each a list of comparators separated by commas or spaces that all have to
+// match. Comparators are "^1.2.0", "~1.2", ">=2.1", "<3", "=1.4.2", a partial
+// version like "1.2" meaning "~1.2", or "*".
+type versionConstraint struct {
+	alternatives [][]versionComparator
+	// prerelease is whether any comparator names a pre-release, the only
+	// case where pre-release tags are considered.
+	prerelease bool
+}
+
+func parseVersionConstraint(s string) (*versionConstraint, error) {
+	c := &versionConstraint{}
+	for _, alternative := range strings.Split(s, "||") {
+		comparators := make([]versionComparator, 0)
+		for _, term := range strings.Fields(strings.Replace(alternative, ",", " ", -1)) {
+			parsed, err := parseComparator(term)
+			if err != nil {
+				return nil, err
+			}
+			for _, comparator := range parsed {
+				if comparator.v.pre != "" {
+					c.prerelease = true
+				}
+			}
+			comparators = append(comparators, parsed...)
+		}
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("empty constraint in %q", s)
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+	return c, nil
+}
+
+func parseComparator(term string) ([]versionComparator, error) {
+	if term == "*" || term == "x" {
+		return []versionComparator{{">=", &semver{}}}, nil
+	}
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, parts, err := parsePartialVersion(strings.TrimPrefix(term, op))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case op == "^":
+		upper := &semver{major: v.major + 1}
+		if v.major == 0 && parts > 1 {
+			upper = &semver{minor: v.minor + 1}
+			if v.minor == 0 && parts > 2 {
+				upper = &semver{patch: v.patch + 1}
+			}
+		}
+		return []versionComparator{{">=", v}, {"<", upper}}, nil
+	case op == "~" || (op == "" && parts < 3):
+		upper := &semver{major: v.major + 1}
+		if parts > 1 {
+			upper = &semver{major: v.major, minor: v.minor + 1}
+		}
+		return []versionComparator{{">=", v}, {"<", upper}}, nil
+	case op == "":
+		op = "="
+	}
+	return []versionComparator{{op, v}}, nil
+}
+
+// parsePartialVersion parses "1", "1.2" or "1.2.3", with an optional "v"
+// prefix and pre-release, and returns how many parts were given.
+func parsePartialVersion(s string) (*semver, int, error) {
+	v := &semver{}
+	version := strings.TrimPrefix(s, "v")
+	if i := strings.Index(version, "-"); i >= 0 {
+		v.pre = version[i+1:]
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 || (v.pre != "" && len(parts) != 3) {
+		return nil, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid version %q", s)
+		}
+		switch i {
+		case 0:
+			v.major = n
+		case 1:
+			v.minor = n
+		case 2:
+			v.patch = n
+		}
+	}
+	return v, len(parts), nil
+}
+
+func (c *versionConstraint) allows(v *semver) bool {
+	if v.pre != "" && !c.prerelease {
+		return false
+	}
+	for _, comparators := range c.alternatives {
+		matches := true
+		for _, comparator := range comparators {
+			if !comparator.allows(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (comparator versionComparator) allows(v *semver) bool {
+	d := v.compare(comparator.v)
+	switch comparator.op {
+	case ">=":
+		return d >= 0
+	case ">":
+		return d > 0
+	case "<=":
+		return d <= 0
+	case "<":
+		return d < 0
+	}
+	return d == 0
+}
+
+// stableOnly is set by update -stable-only to skip pre-release tags even
+// where entries allow them.
+var stableOnly = false
+
+// allowsPrerelease is whether the tags an entry moves to may be pre-releases.
+func allowsPrerelease(entry *bpmEntry) bool {
+	return entry.AllowPrerelease && !stableOnly
+}
+
+// findVersion returns the highest tag of the remote matching the version
+// constraint of an entry and the commit it points at.
+func findVersion(pkg string, entry *bpmEntry) (string, string, error) {
+	if _, err := parseVersionConstraint(entry.Version); err != nil {
+		return "", "", fmt.Errorf("invalid version constraint of %s: %s", pkg, err)
+	}
+	refs, err := lsRemote(getEntryURL(pkg, entry), "refs/tags/*")
+	if err != nil {
+		return "", "", err
+	}
+	return matchVersion(pkg, entry, tagCommits(refs))
+}
+
+// matchVersion returns the highest of tags, with the commits they point at,
+// matching the version constraint of an entry and its commit.
+func matchVersion(pkg string, entry *bpmEntry, tags map[string]string) (string, string, error) {
+	constraint, err := parseVersionConstraint(entry.Version)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid version constraint of %s: %s", pkg, err)
+	}
+	constraint.prerelease = (constraint.prerelease || entry.AllowPrerelease) && !stableOnly
+	names := sortSemverTags(sortedStringKeys(tags))
+	for i := len(names) - 1; i >= 0; i-- {
+		if v, _ := parseSemver(names[i]); constraint.allows(v) {
+			return names[i], tags[names[i]], nil
+		}
+	}
+	return "", "", fmt.Errorf("no tag of %s satisfies %s", pkg, entry.Version)
+}
+
+// tagCommits maps the tags among remote refs to the commits they point at.
+func tagCommits(refs map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for ref, commit := range refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			tags[strings.TrimPrefix(ref, "refs/tags/")] = commit
+		}
+	}
+	return tags
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allowed    []string
+		denied     []string
+	}{
+		{"^1.2.0", []string{"v1.2.0", "v1.9.9"}, []string{"v1.1.9", "v2.0.0", "v1.3.0-rc.1"}},
+		{"^0.2.3", []string{"v0.2.3", "v0.2.9"}, []string{"v0.3.0", "v0.2.2"}},
+		{"^0.0.3", []string{"v0.0.3"}, []string{"v0.0.4"}},
+		{"^0", []string{"v0.0.1", "v0.9.0"}, []string{"v1.0.0"}},
+		{"~1.2.3", []string{"v1.2.3", "v1.2.9"}, []string{"v1.3.0", "v1.2.2"}},
+		{"~1", []string{"v1.0.0", "v1.9.0"}, []string{"v2.0.0"}},
+		{"1.2", []string{"v1.2.0", "v1.2.5"}, []string{"v1.3.0"}},
+		{"1.2.3", []string{"v1.2.3"}, []string{"v1.2.4"}},
+		{"=1.2.3", []string{"v1.2.3"}, []string{"v1.2.4"}},
+		{">=2.1, <3", []string{"v2.1.0", "v2.9.9"}, []string{"v2.0.9", "v3.0.0"}},
+		{">2 <=3", []string{"v2.0.1", "v3.0.0"}, []string{"v2.0.0", "v3.0.1"}},
+		{"<1 || >=2", []string{"v0.5.0", "v2.0.0"}, []string{"v1.5.0"}},
+		{"*", []string{"v0.0.0", "v9.9.9"}, []string{"v1.0.0-beta"}},
+		{"~1.3.0-0", []string{"v1.3.0-beta", "v1.3.0"}, []string{"v1.4.0", "v1.2.9"}},
+	}
+	for _, test := range tests {
+		c, err := parseVersionConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("%q: %s", test.constraint, err)
+			continue
+		}
+		for _, tag := range test.allowed {
+			if v, _ := parseSemver(tag); !c.allows(v) {
+				t.Errorf("%q doesn't allow %s", test.constraint, tag)
+			}
+		}
+		for _, tag := range test.denied {
+			if v, _ := parseSemver(tag); c.allows(v) {
+				t.Errorf("%q allows %s", test.constraint, tag)
+			}
+		}
+	}
+}
+
+func TestInvalidVersionConstraint(t *testing.T) {
+	for _, constraint := range []string{"", "||", ">=1 ||", "1.2.3.4", "^x", "~1.2-rc", ">=-1"} {
+		if _, err := parseVersionConstraint(constraint); err == nil {
+			t.Errorf("%q was accepted", constraint)
+		}
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	tags := map[string]string{
+		"v1.1.0": "c1", "v1.2.0": "c2", "v1.2.1": "c3", "v1.3.0-beta": "c4", "v2.0.0": "c5", "stable": "c5",
+	}
+	tests := []struct {
+		entry      *bpmEntry
+		stableOnly bool
+		tag        string
+		commit     string
+	}{
+		{entry: &bpmEntry{Version: "^1.2"}, tag: "v1.2.1", commit: "c3"},
+		{entry: &bpmEntry{Version: "^1"}, tag: "v1.2.1", commit: "c3"},
+		{entry: &bpmEntry{Version: "^1", AllowPrerelease: true}, tag: "v1.3.0-beta", commit: "c4"},
+		{entry: &bpmEntry{Version: "^1", AllowPrerelease: true}, stableOnly: true, tag: "v1.2.1", commit: "c3"},
+		{entry: &bpmEntry{Version: "*"}, tag: "v2.0.0", commit: "c5"},
+		{entry: &bpmEntry{Version: ">=3"}},
+		{entry: &bpmEntry{Version: "^"}},
+	}
+	defer func() { stableOnly = false }()
+	for _, test := range tests {
+		stableOnly = test.stableOnly
+		tag, commit, err := matchVersion("github.com/lib/c", test.entry, tags)
+		if test.tag == "" {
+			if err == nil {
+				t.Errorf("%q matched %s", test.entry.Version, tag)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.entry.Version, err)
+			continue
+		}
+		if tag != test.tag || commit != test.commit {
+			t.Errorf("%q matched %s at %s, expected %s at %s", test.entry.Version, tag, commit, test.tag, test.commit)
+		}
+	}
+}
+
+func TestTagCommits(t *testing.T) {
+	refs := map[string]string{"refs/tags/v1.0.0": "c1", "refs/heads/master": "c2", "HEAD": "c2", "refs/tags/nightly": "c3"}
+	tags := tagCommits(refs)
+	if len(tags) != 2 || tags["v1.0.0"] != "c1" || tags["nightly"] != "c3" {
+		t.Errorf("tags are %v", tags)
+	}
+}
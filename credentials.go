@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCredentialTTL = 5 * time.Minute
+
+// credentialConfig obtains short-lived credentials for a proxy or mirror,
+// either from a token helper command or by exchanging a CI OIDC token.
+//
+//	"credentials": [
+//	  {"host": "git.corp.example", "helper": "corp-token --audience bpm"},
+//	  {"host": "mirror.example", "oidc": {"tokenURL": "https://sts.example/token", "audience": "mirror"}}
+//	]
+type credentialConfig struct {
+	Host     string      `json:"host"`
+	Username string      `json:"username,omitempty"`
+	Helper   string      `json:"helper,omitempty"`
+	TTL      string      `json:"ttl,omitempty"`
+	OIDC     *oidcConfig `json:"oidc,omitempty"`
+}
+
+// oidcConfig exchanges the CI job's identity token at TokenURL (RFC 8693).
+// The identity token comes from the GitHub Actions token endpoint, or from
+// the environment variable named by TokenEnv.
+type oidcConfig struct {
+	TokenURL string `json:"tokenURL"`
+	Audience string `json:"audience,omitempty"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+var (
+	credentialsMu sync.Mutex
+	tokens        = make(map[string]*cachedToken)
+)
+
+// getToken returns a valid token for the credential config, refreshing it
+// shortly before it expires so long installs keep working.
+func getToken(cred *credentialConfig) (string, error) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	if cached, ok := tokens[cred.Host]; ok && time.Until(cached.expires) > 30*time.Second {
+		return cached.token, nil
+	}
+
+	var (
+		cached *cachedToken
+		err    error
+	)
+	switch {
+	case cred.Helper != "":
+		cached, err = runTokenHelper(cred)
+	case cred.OIDC != nil:
+		cached, err = exchangeOIDCToken(cred.OIDC)
+	default:
+		return "", fmt.Errorf("credentials for %s need a helper or oidc setting", cred.Host)
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Obtained credentials for %s, valid until %s", cred.Host, cached.expires.Format(time.Kitchen))
+	tokens[cred.Host] = cached
+	return cached.token, nil
+}
+
+// runTokenHelper runs the helper command, which prints either a bare token or
+// JSON with "token" and "expiresAt" (or "access_token" and "expires_in").
+func runTokenHelper(cred *credentialConfig) (*cachedToken, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(runContext, shell, flag, cred.Helper)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("token helper for %s failed: %s", cred.Host, err)
+	}
+	output := strings.TrimSpace(string(out))
+	// Checked by loadGlobalConfig.
+	ttl, _ := parseDurationSetting("ttl", cred.TTL, defaultCredentialTTL)
+	if !strings.HasPrefix(output, "{") {
+		return &cachedToken{token: output, expires: time.Now().Add(ttl)}, nil
+	}
+	var response struct {
+		Token       string    `json:"token"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return nil, fmt.Errorf("token helper for %s printed invalid JSON: %s", cred.Host, err)
+	}
+	return newCachedToken(response.Token+response.AccessToken, response.ExpiresAt, response.ExpiresIn, ttl), nil
+}
+
+func exchangeOIDCToken(oidc *oidcConfig) (*cachedToken, error) {
+	idToken, err := getCIIdentityToken(oidc)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":         {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token":      {idToken},
+		"subject_token_type": {"urn:ietf:params:oauth:token-type:jwt"},
+	}
+	if oidc.Audience != "" {
+		form.Set("audience", oidc.Audience)
+	}
+	resp, err := httpClient.PostForm(oidc.TokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token exchange at %s failed: %s", oidc.TokenURL, resp.Status)
+	}
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return newCachedToken(response.AccessToken, time.Time{}, response.ExpiresIn, defaultCredentialTTL), nil
+}
+
+func getCIIdentityToken(oidc *oidcConfig) (string, error) {
+	if oidc.TokenEnv != "" {
+		if token := os.Getenv(oidc.TokenEnv); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("%s is not set", oidc.TokenEnv)
+	}
+	requestURL, requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"), os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf("no CI identity token available, set oidc.tokenEnv")
+	}
+	if oidc.Audience != "" {
+		requestURL += "&audience=" + url.QueryEscape(oidc.Audience)
+	}
+	var response struct {
+		Value string `json:"value"`
+	}
+	if _, err := getJSON(requestURL, "Authorization", "Bearer", requestToken, &response); err != nil {
+		return "", err
+	}
+	return response.Value, nil
+}
+
+func newCachedToken(token string, expiresAt time.Time, expiresIn int, ttl time.Duration) *cachedToken {
+	switch {
+	case !expiresAt.IsZero():
+	case expiresIn > 0:
+		expiresAt = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	default:
+		expiresAt = time.Now().Add(ttl)
+	}
+	return &cachedToken{token: token, expires: expiresAt}
+}
+
+// findCredentials returns the credential config for a URL's host.
+func findCredentials(rawURL string) *credentialConfig {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	for _, cred := range getGlobalConfig().Credentials {
+		if cred.Host == u.Host || cred.Host == u.Hostname() {
+			return cred
+		}
+	}
+	return nil
+}
+
+// authorizeRequest adds a bearer token to requests for configured hosts.
+func authorizeRequest(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	if cred := findCredentials(req.URL.String()); cred != nil {
+		token, err := getToken(cred)
+		if err != nil {
+			log.Printf("No credentials for %s: %s", cred.Host, err)
+			return
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// withCredentials passes tokens for every configured host to a git command as
+// extra HTTP headers. They go through the environment rather than arguments,
+// keeping them out of process listings.
+func withCredentials(cmd *exec.Cmd) {
+	creds := getGlobalConfig().Credentials
+	if len(creds) == 0 {
+		return
+	}
+	env := os.Environ()
+	count := 0
+	for _, cred := range creds {
+		token, err := getToken(cred)
+		if err != nil {
+			log.Printf("No credentials for %s: %s", cred.Host, err)
+			continue
+		}
+		header := "Authorization: Bearer " + token
+		if cred.Username != "" {
+			header = "Authorization: Basic " + basicAuth(cred.Username, token)
+		}
+		env = append(env,
+			"GIT_CONFIG_KEY_"+strconv.Itoa(count)+"=http.https://"+cred.Host+"/.extraHeader",
+			"GIT_CONFIG_VALUE_"+strconv.Itoa(count)+"="+header)
+		count++
+	}
+	cmd.Env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count))
+}
+
+func basicAuth(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// csvReport holds the -csv and -columns options shared by the reporting
+// commands.
+type csvReport struct {
+	enabled bool
+	columns string
+}
+
+// write prints items, a slice of struct pointers, as CSV with a header row.
+// Columns are field names, matched case-insensitively, defaulting to
+// defaults.
+func (r csvReport) write(w io.Writer, items interface{}, defaults []string) error {
+	columns := defaults
+	if r.columns != "" {
+		columns = strings.Split(r.columns, ",")
+	}
+
+	slice := reflect.ValueOf(items)
+	elemType := slice.Type().Elem().Elem()
+	fields := make([]int, len(columns))
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		column = strings.TrimSpace(column)
+		field, ok := elemType.FieldByNameFunc(func(name string) bool {
+			return strings.EqualFold(name, column)
+		})
+		if !ok || field.PkgPath != "" || field.Type.Kind() == reflect.Slice {
+			return fmt.Errorf("unknown column %q, expected one of: %s", column, strings.Join(csvColumns(elemType), ", "))
+		}
+		fields[i] = field.Index[0]
+		header[i] = field.Name
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < slice.Len(); i++ {
+		item := slice.Index(i).Elem()
+		row := make([]string, len(fields))
+		for j, field := range fields {
+			row[j] = fmt.Sprint(item.Field(field).Interface())
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func csvColumns(t reflect.Type) []string {
+	columns := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.PkgPath == "" && field.Type.Kind() != reflect.Slice {
+			columns = append(columns, field.Name)
+		}
+	}
+	return columns
+}
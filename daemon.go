@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type daemonRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Dir string `json:"dir"`
+	} `json:"params"`
+}
+
+// daemonEvent is one line of the NDJSON response stream: any number of
+// progress events followed by a single result or error.
+type daemonEvent struct {
+	Progress string      `json:"progress,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+type daemon struct {
+	// mu serializes operations, since they share the process-wide log and
+	// standard output that progress is captured from.
+	mu sync.Mutex
+	// platforms, shallowClone and remoteCacheDisabled are the settings of the
+	// command line the daemon was started with, which every request starts
+	// from.
+	platforms           string
+	shallowClone        bool
+	remoteCacheDisabled bool
+}
+
+var daemonMethods = map[string]func(dir string) (interface{}, error){
+	"resolve": daemonResolve,
+	"list":    daemonList,
+	"verify":  daemonVerify,
+	"install": daemonInstall,
+}
+
+func defaultDaemonAddress() string {
+	if runtime.GOOS == "windows" {
+		return "tcp:127.0.0.1:7878"
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = os.TempDir()
+	}
+	return "unix:" + filepath.Join(cacheDir, "bpm", "daemon.sock")
+}
+
+// doDaemon serves bpm operations over HTTP on a local socket:
+//
+//	POST /rpc {"method": "list", "params": {"dir": "/path/to/project"}}
+//
+// The response is a stream of JSON lines with progress output followed by the
+// result, so editor plugins can show what is happening.
+func doDaemon(address string) int {
+	if address == "" {
+		address = defaultDaemonAddress()
+	}
+	network, addr := "tcp", address
+	if i := strings.Index(address, ":"); i > 0 && (address[:i] == "unix" || address[:i] == "tcp") {
+		network, addr = address[:i], address[i+1:]
+	}
+	if network == "unix" {
+		if err := createDir(filepath.Dir(addr)); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	log.Printf("bpm daemon listening on %s:%s", network, addr)
+
+	d := &daemon{platforms: platforms, shallowClone: shallowClone, remoteCacheDisabled: remoteCacheDisabled}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rpc", d.handleRPC)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	go func() {
+		<-runContext.Done()
+		listener.Close()
+	}()
+	err = http.Serve(listener, mux)
+	if interrupted() != nil {
+		return exitInterrupted
+	}
+	output.Errorf("%s", err)
+	return 1
+}
+
+func (d *daemon) handleRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a JSON request", http.StatusMethodNotAllowed)
+		return
+	}
+	req := daemonRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method, ok := daemonMethods[req.Method]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown method %q", req.Method), http.StatusNotFound)
+		return
+	}
+	if req.Params.Dir == "" {
+		http.Error(w, "params.dir is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	send := func(event *daemonEvent) {
+		encoder.Encode(event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resetSettings()
+
+	var result interface{}
+	var err error
+	if captureErr := captureOutput(func(line string) {
+		send(&daemonEvent{Progress: line})
+	}, func() {
+		defer recoverError(&err)
+		result, err = method(req.Params.Dir)
+	}); captureErr != nil {
+		err = captureErr
+	}
+
+	if err != nil {
+		send(&daemonEvent{Error: err.Error()})
+		return
+	}
+	send(&daemonEvent{Result: result})
+}
+
+// resetSettings forgets the settings of the manifests read and the packages
+// escaped by earlier requests, like mirrors, replacements, source roots,
+// target platforms and stable-only resolution, so they don't apply to other
+// projects.
+func (d *daemon) resetSettings() {
+	mirrors = make(map[string]string)
+	replacements = make(map[string]string)
+	sourceRoots = make(map[string][]string)
+	platforms = d.platforms
+	buildTags = nil
+	shallowClone = d.shallowClone
+	remoteCacheDisabled = d.remoteCacheDisabled
+	stableOnly = false
+	caseEscapedMu.Lock()
+	caseEscaped = make(map[string]bool)
+	caseEscapedMu.Unlock()
+}
+
+// captureOutput runs fn with the log and standard streams redirected to
+// progress, one line at a time. It fails without running fn when the streams
+// can't be redirected.
+func captureOutput(progress func(line string), fn func()) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	log.SetOutput(w)
+
+	done := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			progress(scanner.Text())
+		}
+		close(done)
+	}()
+
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		log.SetOutput(stderr)
+		w.Close()
+		<-done
+		r.Close()
+	}()
+	fn()
+	return nil
+}
+
+func readDaemonManifest(dir string) (*bpmPackage, error) {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		return nil, fmt.Errorf("%s does not exist: %s", dependencyFilename, depFile)
+	}
+	return readDataFile(depFile)
+}
+
+// daemonResolve reports the repositories the project imports and which of
+// them are missing from bpm.json, without fetching any. Vanity import paths
+// are still looked up over HTTP.
+func daemonResolve(dir string) (interface{}, error) {
+	data, err := readDaemonManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := gitSource{}.imports(dir, data.Package)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(imports)
+	missing := make([]string, 0)
+	for _, pkg := range imports {
+		if _, ok := data.Dependencies[pkg]; !ok {
+			missing = append(missing, pkg)
+		}
+	}
+	return map[string][]string{"imports": imports, "missing": missing}, nil
+}
+
+func daemonList(dir string) (interface{}, error) {
+	data, err := readDaemonManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return listDependencies(dir, data.Dependencies, 0), nil
+}
+
+func daemonVerify(dir string) (interface{}, error) {
+	data, err := readDaemonManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	problems := checkFrozen(data.Dependencies)
+	problems = append(problems, verifyVendor(dir, dir, data.Dependencies)...)
+	if data.VendorMarkers {
+		problems = append(problems, checkVendorMarkers(dir, data.Dependencies)...)
+	}
+	return map[string]interface{}{"ok": len(problems) == 0, "problems": problems}, nil
+}
+
+func daemonInstall(dir string) (interface{}, error) {
+	return map[string]int{"exitCode": doInstall(dir, installOptions{})}, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusCurrent    = "current"
+	statusOutdated   = "outdated"
+	statusDirty      = "dirty"
+	statusVulnerable = "vulnerable"
+)
+
+var statusColors = map[string]string{
+	statusCurrent:    "32",
+	statusOutdated:   "33",
+	statusDirty:      "35",
+	statusVulnerable: "31",
+}
+
+// dashboardItem is a dependency with everything the dashboard shows about it.
+type dashboardItem struct {
+	*listItem
+	statuses []string
+	update   *outdatedEntry
+	vulns    []*osvVuln
+	changes  []string
+}
+
+// dashboard is an interactive terminal view of the dependencies of a project,
+// driven by commands typed at its prompt.
+type dashboard struct {
+	dir   string
+	in    *bufio.Reader
+	color bool
+	items []*dashboardItem
+}
+
+func doDashboard(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	d := &dashboard{dir: dir, in: bufio.NewReader(os.Stdin), color: isTerminal(os.Stdout)}
+	if err := d.refresh(false); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	for {
+		d.showList()
+		command, arg := d.prompt("Number for details, u <number> to update, v to verify, r to refresh, q to quit")
+		switch {
+		case command == "q":
+			return 0
+		case command == "r":
+			if err := d.refresh(true); err != nil {
+				output.Errorf("%s", err)
+			}
+		case command == "v":
+			doCheck(dir, false)
+			d.pause()
+		case command == "u":
+			if item := d.find(arg); item != nil {
+				d.updatePackage(item)
+			}
+		default:
+			if item := d.find(command); item != nil {
+				d.showDetails(item)
+			}
+		}
+	}
+}
+
+// refresh collects the status of every dependency, querying OSV for
+// vulnerabilities and remotes for updates, unless the last outdated scan is
+// recent and force isn't set. The list stays as it was when bpm.json can't be
+// read.
+func (d *dashboard) refresh(force bool) error {
+	output.Println("Collecting dependency status...")
+	data, err := readDataFile(filepath.Join(d.dir, dependencyFilename))
+	if err != nil {
+		return err
+	}
+	updates := make(map[string]*outdatedEntry)
+	for _, update := range findUpdatesSafely(d.dir, data.Dependencies, force) {
+		if _, ok := updates[update.Package]; !ok {
+			updates[update.Package] = update
+		}
+	}
+	vulns := make(map[string][]*osvVuln)
+	if vulnerable, err := findVulnerable(data.Dependencies); err != nil {
+		log.Printf("Vulnerability status unavailable: %s", err)
+	} else {
+		vulnerable, _ = filterIgnored(vulnerable, data.IgnoreVulnerabilities, time.Now())
+		for _, v := range vulnerable {
+			vulns[v.Package] = v.Vulnerabilities
+		}
+	}
+
+	flat := make(map[string][]*bpmEntry)
+	flattenDependencies(data.Dependencies, flat)
+
+	d.items = make([]*dashboardItem, 0)
+	for _, item := range listDependencies(d.dir, data.Dependencies, 0) {
+		di := &dashboardItem{listItem: item, update: updates[item.Package], vulns: vulns[item.Package]}
+		if isGitRepo(item.Dir) && !isPatched(d.dir, item.Package, item.Dir) {
+			changes, err := getLocalChanges(item.Dir)
+			if err != nil {
+				log.Printf("Could not list the changes in %s: %s", item.Dir, err)
+			}
+			di.changes = changes
+			if flat[item.Package][0].Alias != "" || hasImportRewrites(d.dir) {
+				di.changes = withoutSourceFiles(di.changes)
+			}
+		}
+		if len(di.vulns) > 0 {
+			di.statuses = append(di.statuses, statusVulnerable)
+		}
+		if len(di.changes) > 0 {
+			di.statuses = append(di.statuses, statusDirty)
+		}
+		if di.update != nil {
+			di.statuses = append(di.statuses, statusOutdated)
+		}
+		if len(di.statuses) == 0 {
+			di.statuses = append(di.statuses, statusCurrent)
+		}
+		d.items = append(d.items, di)
+	}
+	return nil
+}
+
+// findUpdatesSafely is scanOutdated logging unreachable remotes instead of
+// failing, so the dashboard still comes up offline.
+func findUpdatesSafely(dir string, dependencies map[string]*bpmEntry, refresh bool) []*outdatedEntry {
+	scan, err := scanOutdated(dir, dependencies, refresh, defaultOutdatedMaxAge)
+	if err != nil {
+		log.Printf("Update status unavailable: %s", err)
+		return nil
+	}
+	return scan.Updates
+}
+
+func (d *dashboard) showList() {
+	d.clear()
+	output.Printf("bpm dashboard: %s\n\n", d.dir)
+	for i, item := range d.items {
+		statuses := make([]string, 0, len(item.statuses))
+		for _, status := range item.statuses {
+			statuses = append(statuses, d.paint(status, status))
+		}
+		output.Printf("%3d  %s%s %s  %s\n", i+1, item.Indent, item.Package, shortHash(item.Commit), strings.Join(statuses, ", "))
+	}
+	output.Println()
+}
+
+func (d *dashboard) showDetails(item *dashboardItem) {
+	for {
+		d.clear()
+		output.Printf("%s\n\n", item.Package)
+		output.Printf("    URL:      %s\n", item.URL)
+		if item.Upstream != "" {
+			output.Printf("    Upstream: %s\n", item.Upstream)
+		}
+		output.Printf("    Branch:   %s\n", item.Branch)
+		output.Printf("    Commit:   %s\n", item.Commit)
+		if item.Kind != "" {
+			output.Printf("    Kind:     %s\n", item.Kind)
+		}
+		output.Printf("    License:  %s\n", item.License)
+		output.Printf("    Dir:      %s\n", item.Dir)
+		if item.update != nil {
+			output.Printf("    Update:   %s %s\n", item.update.Severity, describeUpdate(item.update))
+		}
+		for _, vuln := range item.vulns {
+			output.Printf("    %s %s\n", d.paint(statusVulnerable, vuln.ID), vuln.Summary)
+		}
+		if len(item.changes) > 0 {
+			output.Printf("    %s %s\n", d.paint(statusDirty, "Modified:"), strings.Join(item.changes, ", "))
+		}
+		output.Println()
+
+		command, _ := d.prompt("l for log, d for diff, u to update, b to go back")
+		switch command {
+		case "l":
+			d.runInPackage(item, "git", "log", "--oneline", "-20")
+		case "d":
+			d.runInPackage(item, "git", "diff")
+		case "u":
+			d.updatePackage(item)
+			return
+		case "b", "q":
+			return
+		}
+	}
+}
+
+func (d *dashboard) runInPackage(item *dashboardItem, name string, args ...string) {
+	if !isGitRepo(item.Dir) {
+		output.Errorf("%s is not a git checkout", item.Dir)
+	} else {
+		out, err := runCmd(&item.Dir, true, name, args...)
+		if err != nil {
+			output.Errorf("%s", err)
+		}
+		output.Printf("%s", out)
+	}
+	d.pause()
+}
+
+func (d *dashboard) updatePackage(item *dashboardItem) {
+	doUpdate(d.dir, item.Package, updateOptions{})
+	d.pause()
+	if err := d.refresh(false); err != nil {
+		output.Errorf("%s", err)
+	}
+}
+
+func (d *dashboard) find(number string) *dashboardItem {
+	i, err := strconv.Atoi(number)
+	if err != nil || i < 1 || i > len(d.items) {
+		return nil
+	}
+	return d.items[i-1]
+}
+
+// prompt reads a command and its argument. End of input quits.
+func (d *dashboard) prompt(help string) (string, string) {
+	output.Printf("%s: ", help)
+	line, err := d.in.ReadString('\n')
+	if err != nil && line == "" {
+		return "q", ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+	return fields[0], fields[1]
+}
+
+func (d *dashboard) pause() {
+	output.Printf("Press enter to continue")
+	d.in.ReadString('\n')
+}
+
+func (d *dashboard) clear() {
+	if d.color {
+		output.Printf("\x1b[H\x1b[2J")
+	}
+}
+
+func (d *dashboard) paint(status string, text string) string {
+	if !d.color {
+		return text
+	}
+	return "\x1b[" + statusColors[status] + "m" + text + "\x1b[0m"
+}
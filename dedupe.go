@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// visibleCommits returns the packages that code inside any of the given
+// dependencies resolves from enclosing vendor folders, the closest one winning.
+func visibleCommits(visible map[string]string, dependencies map[string]*bpmEntry) map[string]string {
+	result := make(map[string]string, len(visible)+len(dependencies))
+	for pkg, commit := range visible {
+		result[pkg] = commit
+	}
+	for pkg, entry := range dependencies {
+		result[pkg] = entry.Commit
+	}
+	return result
+}
+
+// isRedundantCopy reports whether a nested copy can be dropped because the
+// compiler would find an identical package in an enclosing vendor folder.
+func isRedundantCopy(pkg string, entry *bpmEntry, visible map[string]string) bool {
+	return entry.Commit != "" && visible[pkg] == entry.Commit
+}
+
+type vendoredCopy struct {
+	pkg    string
+	commit string
+	dir    string
+}
+
+// dedupeVendor removes nested copies shadowing an identical package from an
+// enclosing vendor folder, and hardlinks the files of the remaining copies
+// that share a package and commit.
+func dedupeVendor(dir string, dependencies map[string]*bpmEntry) {
+	copies := make(map[string][]*vendoredCopy)
+	removed := collectVendoredCopies(dir, dependencies, map[string]string{}, copies)
+
+	linked := 0
+	for _, group := range copies {
+		for _, other := range group[1:] {
+			linked += hardlinkIdenticalFiles(group[0].dir, other.dir)
+		}
+	}
+	if removed > 0 || linked > 0 {
+		log.Printf("Deduplicated vendor: removed %d nested copies, hardlinked %d files", removed, linked)
+	}
+}
+
+func collectVendoredCopies(dir string, dependencies map[string]*bpmEntry, visible map[string]string, copies map[string][]*vendoredCopy) int {
+	removed := 0
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	childVisible := visibleCommits(visible, dependencies)
+
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if isRedundantCopy(pkg, entry, visible) {
+			if fileExists(pkgDir) {
+				log.Printf("Removing redundant nested copy: %s", pkgDir)
+				if err := removeDir(pkgDir); err != nil {
+					log.Printf("Could not remove %s: %s", pkgDir, err)
+					continue
+				}
+				removed++
+			}
+			continue
+		}
+		if !fileExists(pkgDir) || entry.Commit == "" {
+			continue
+		}
+		key := pkg + "@" + entry.Commit
+		copies[key] = append(copies[key], &vendoredCopy{pkg: pkg, commit: entry.Commit, dir: pkgDir})
+		removed += collectVendoredCopies(pkgDir, entry.Dependencies, childVisible, copies)
+	}
+	return removed
+}
+
+// hardlinkIdenticalFiles replaces the files of target that are byte-identical
+// to the same file in source with hardlinks, skipping git metadata and nested
+// vendor folders. It returns the number of linked files.
+func hardlinkIdenticalFiles(source string, target string) int {
+	linked := 0
+	filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != target && (info.Name() == gitFolderName || info.Name() == vendorFolderName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(target, path)
+		sourcePath := filepath.Join(source, rel)
+		sourceInfo, err := os.Stat(sourcePath)
+		if err != nil || os.SameFile(info, sourceInfo) || sourceInfo.Size() != info.Size() {
+			return nil
+		}
+		if !sameContent(sourcePath, path) {
+			return nil
+		}
+		tmp := path + ".bpm-link"
+		if err := os.Link(sourcePath, tmp); err != nil {
+			return nil
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return nil
+		}
+		linked++
+		return nil
+	})
+	return linked
+}
+
+func sameContent(a string, b string) bool {
+	aBytes, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	bBytes, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bpmMarkerFiles are written into vendored packages by bpm and never come
+// from upstream.
+var bpmMarkerFiles = map[string]bool{
+	vendorMarkerFilename:  true,
+	subdirMarkerFilename:  true,
+	archiveMarkerFilename: true,
+}
+
+// deepVerify fetches every pinned commit again, straight from its repository
+// without mirrors or caches, into a temporary folder and compares the files
+// byte by byte with the vendored copy. Patches are applied to the fresh copy
+// first; Go files of aliased packages, and of projects rewriting imports, are
+// skipped as bpm changes them. This catches mirrors serving other contents
+// and corrupted caches, not only local changes.
+func deepVerify(root string, dir string, dependencies map[string]*bpmEntry, skipSource bool) []string {
+	problems := make([]string, 0)
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if fileExists(pkgDir) && !isAssets(entry) {
+			if problem := deepVerifyPackage(root, pkg, entry, pkgDir, skipSource); problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+		problems = append(problems, deepVerify(root, pkgDir, entry.Dependencies, skipSource)...)
+	}
+	return problems
+}
+
+func deepVerifyPackage(root string, pkg string, entry *bpmEntry, pkgDir string, skipSource bool) string {
+	tmp, err := ioutil.TempDir("", "bpm-deep-")
+	if err != nil {
+		return fmt.Sprintf("%s: %s", pkg, err)
+	}
+	defer removeDir(tmp)
+
+	upstreamDir := filepath.Join(tmp, "upstream")
+	if err := createDir(upstreamDir); err != nil {
+		return fmt.Sprintf("%s: %s", pkg, err)
+	}
+	if entry.Archive != "" {
+		url := archiveURL(entry)
+		log.Printf("Downloading %s from %s", pkg, url)
+		archive, sum, err := downloadArchive(url)
+		if err != nil {
+			return fmt.Sprintf("%s: could not download %s: %s", pkg, url, err)
+		}
+		defer os.Remove(archive)
+		if !strings.EqualFold(sum, entry.SHA256) {
+			return fmt.Sprintf("%s: %s has sha256 %s, expected %s", pkg, url, sum, entry.SHA256)
+		}
+		if err := extractArchive(archive, url, upstreamDir); err != nil {
+			return fmt.Sprintf("%s: could not extract %s: %s", pkg, url, err)
+		}
+	} else {
+		if entry.Commit == "" {
+			return fmt.Sprintf("%s: no commit pinned", pkg)
+		}
+		url := getEntryURL(pkg, entry)
+		log.Printf("Fetching %s of %s from %s", shortHash(entry.Commit), pkg, url)
+		if err := fetchCommit(url, entry.Commit, upstreamDir); err != nil {
+			return fmt.Sprintf("%s: %s is not available from %s: %s", pkg, shortHash(entry.Commit), url, err)
+		}
+		if entry.Subdir != "" {
+			subdir := filepath.Join(tmp, "subdir")
+			if err := copyTree(filepath.Join(upstreamDir, filepath.FromSlash(entry.Subdir)), subdir); err != nil {
+				return fmt.Sprintf("%s: %s has no folder %s at %s", pkg, url, entry.Subdir, shortHash(entry.Commit))
+			}
+			upstreamDir = subdir
+		}
+		if len(getPatches(root, pkg)) > 0 {
+			if err := applyPatches(root, pkg, upstreamDir); err != nil {
+				return fmt.Sprintf("%s: %s", pkg, err)
+			}
+		}
+	}
+
+	differences, err := compareTrees(upstreamDir, pkgDir, skipSource || entry.Alias != "")
+	if err != nil {
+		return fmt.Sprintf("%s: could not compare with upstream: %s", pkg, err)
+	}
+	if len(differences) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s: vendored copy differs from upstream: %s", pkg, strings.Join(differences, ", "))
+}
+
+// fetchCommit checks out a single commit of url in dir. Servers that refuse
+// to send an unadvertised commit get a full fetch instead.
+func fetchCommit(url string, commit string, dir string) error {
+	if err := runGit(&dir, "init", "--quiet"); err != nil {
+		return err
+	}
+	if err := runGit(&dir, "fetch", "--quiet", "--depth", "1", url, commit); err != nil {
+		log.Printf("Shallow fetch of %s failed, fetching all branches and tags", shortHash(commit))
+		if err := runGit(&dir, "fetch", "--quiet", url, "+refs/heads/*:refs/upstream/heads/*", "+refs/tags/*:refs/upstream/tags/*"); err != nil {
+			return err
+		}
+	}
+	return runGit(&dir, append(longPathArgs(), "checkout", "--quiet", commit)...)
+}
+
+// compareTrees lists the files that differ between the upstream and vendored
+// copy of a package, ignoring git metadata, nested vendor folders and the
+// markers bpm writes.
+func compareTrees(upstream string, vendored string, skipSource bool) ([]string, error) {
+	upstreamFiles, err := listTreeFiles(upstream, skipSource)
+	if err != nil {
+		return nil, err
+	}
+	vendoredFiles, err := listTreeFiles(vendored, skipSource)
+	if err != nil {
+		return nil, err
+	}
+	differences := make([]string, 0)
+	for _, rel := range sortedStringKeys(upstreamFiles) {
+		content, ok := vendoredFiles[rel]
+		switch {
+		case !ok:
+			differences = append(differences, rel+" missing")
+		case content != upstreamFiles[rel]:
+			differences = append(differences, rel+" changed")
+		}
+	}
+	for _, rel := range sortedStringKeys(vendoredFiles) {
+		if _, ok := upstreamFiles[rel]; !ok {
+			differences = append(differences, rel+" added")
+		}
+	}
+	sort.Strings(differences)
+	return differences, nil
+}
+
+// listTreeFiles reads every file below dir, keyed by slash separated path.
+// Symlinks are read as their target.
+func listTreeFiles(dir string, skipSource bool) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel == gitFolderName || rel == vendorFolderName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == gitFolderName || bpmMarkerFiles[rel] || (skipSource && strings.HasSuffix(rel, ".go")) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			files[rel] = "-> " + target
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		files[rel] = string(content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// packageDirVariable can be used in the env values of an entry to refer to
+// the vendor folder of its package, e.g. "CGO_CFLAGS": "-I${BPM_PACKAGE_DIR}/include".
+const packageDirVariable = "BPM_PACKAGE_DIR"
+
+// dependencyEnv returns the env variables a dependency declares as
+// KEY=value pairs, sorted by key, with ${VAR} references expanded.
+func dependencyEnv(dir string, pkg string, entry *bpmEntry) []string {
+	pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+	env := make([]string, 0, len(entry.Env))
+	for _, key := range sortedStringKeys(entry.Env) {
+		value := os.Expand(entry.Env[key], func(name string) string {
+			if name == packageDirVariable {
+				return pkgDir
+			}
+			return os.Getenv(name)
+		})
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// projectEnv merges the env variables of the direct dependencies, for hooks
+// and builds of the project. Conflicting values are reported and the one of
+// the last package in sorted order wins.
+func projectEnv(dir string, dependencies map[string]*bpmEntry) []string {
+	values := make(map[string]string)
+	owners := make(map[string]string)
+	for _, pkg := range sortedKeys(dependencies) {
+		for _, variable := range dependencyEnv(dir, pkg, dependencies[pkg]) {
+			key, value := splitEnv(variable)
+			if owner, ok := owners[key]; ok && values[key] != value {
+				log.Printf("%s is set by %s and %s, using the value of %s", key, owner, pkg, pkg)
+			}
+			values[key] = value
+			owners[key] = pkg
+		}
+	}
+	env := make([]string, 0, len(values))
+	for _, key := range sortedStringKeys(values) {
+		env = append(env, key+"="+values[key])
+	}
+	return env
+}
+
+func splitEnv(variable string) (string, string) {
+	parts := strings.SplitN(variable, "=", 2)
+	return parts[0], parts[1]
+}
+
+// shellCommand prepares a command run by the platform's shell with the given
+// env variables added to the current environment.
+func shellCommand(dir string, command string, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(runContext, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(runContext, "cmd", "/C", command)
+	}
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), toolchainEnv()...), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// doRun runs a command with the env variables of the dependencies, in the
+// project dir, or with -p in the vendor folder of that package with only its
+// own variables. It returns the exit code of the command.
+func doRun(dir string, pkg string, args []string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if len(args) == 0 {
+		output.Errorf("No command given: bpm run [-p <pkg>] <command>")
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	runDir, env := dir, projectEnv(dir, data.Dependencies)
+	if pkg != "" {
+		entry, entryDir := findDependency(dir, data.Dependencies, pkg)
+		if entry == nil {
+			output.Errorf("%s is not a dependency", pkg)
+			return 1
+		}
+		runDir = filepath.Join(entryDir, vendorFolderName, vendorPath(pkg))
+		env = dependencyEnv(entryDir, pkg, entry)
+	}
+	err = shellCommand(runDir, strings.Join(args, " "), env).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		output.Errorf("Could not run %s: %s", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// findDependency returns the entry of pkg in a dependency tree, direct
+// dependencies first, and the dir whose vendor folder holds it.
+func findDependency(dir string, dependencies map[string]*bpmEntry, pkg string) (*bpmEntry, string) {
+	if entry, ok := dependencies[pkg]; ok {
+		return entry, dir
+	}
+	for _, name := range sortedKeys(dependencies) {
+		nestedDir := filepath.Join(dir, vendorFolderName, vendorPath(name))
+		if entry, entryDir := findDependency(nestedDir, dependencies[name].Dependencies, pkg); entry != nil {
+			return entry, entryDir
+		}
+	}
+	return nil, ""
+}
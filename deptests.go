@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// runDependencyTests runs go test ./... inside every updated package listed
+// as critical in bpm.json. It returns the exit code.
+func runDependencyTests(dir string, data *bpmPackage, updated []string) int {
+	code := 0
+	for _, pkg := range updated {
+		if !containsString(data.Critical, pkg) {
+			continue
+		}
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		output.Printf("Testing %s...\n", pkg)
+		cmd := exec.CommandContext(runContext, "go", "test", "./...")
+		cmd.Dir = pkgDir
+		if entry, entryDir := findDependency(dir, data.Dependencies, pkg); entry != nil {
+			cmd.Env = append(append(os.Environ(), toolchainEnv()...), dependencyEnv(entryDir, pkg, entry)...)
+		}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			output.Errorf("Tests of %s failed: %s\n%s", pkg, err, out)
+			code = 1
+			continue
+		}
+		output.Printf("Tests of %s passed\n", pkg)
+	}
+	return code
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// checkDiskSpace estimates the size of the dependencies that still need to be
+// fetched and warns when the filesystem holding the vendor folder is short on
+// room. Sizes come from the hosting API and are doubled to account for the
+// checked out files next to the git objects.
+func checkDiskSpace(dir string, dependencies map[string]*bpmEntry) {
+	var required int64
+	unknown := make([]string, 0)
+	estimateMissing(filepath.Join(dir, vendorFolderName), dependencies, &required, &unknown)
+
+	free, err := getFreeSpace(dir)
+	if err != nil {
+		log.Printf("Could not determine free disk space: %s", err)
+		return
+	}
+	log.Printf("Estimated space needed: %s, available: %s", formatSize(required), formatSize(free))
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Printf("No size estimate for: %s", strings.Join(unknown, ", "))
+	}
+	if required > free {
+		output.Printf("Warning: install needs about %s but only %s is free on the filesystem of %s\n",
+			formatSize(required), formatSize(free), dir)
+	}
+}
+
+func estimateMissing(vendorDir string, dependencies map[string]*bpmEntry, required *int64, unknown *[]string) {
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			size, err := estimateSize(pkg, entry)
+			if err != nil || size < 0 {
+				*unknown = append(*unknown, pkg)
+			} else {
+				*required += size
+			}
+		}
+		estimateMissing(filepath.Join(pkgDir, vendorFolderName), entry.Dependencies, required, unknown)
+	}
+}
+
+// estimateSize returns the expected on-disk size of a dependency, or -1 when
+// its host can't tell.
+func estimateSize(pkg string, entry *bpmEntry) (int64, error) {
+	if entry.Archive != "" {
+		resp, err := httpClient.Head(archiveURL(entry))
+		if err != nil {
+			return -1, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			return -1, nil
+		}
+		return resp.ContentLength * 2, nil
+	}
+
+	api, path := getHostAPI(getEntryURL(pkg, entry))
+	if api == nil {
+		return -1, nil
+	}
+	repo, err := api.repository(path)
+	if repo == nil || err != nil || repo.Size == 0 {
+		return -1, err
+	}
+	return repo.Size * 2, nil
+}
+
+type diskUsage struct {
+	pkg  string
+	size int64
+}
+
+// diskUsageReport is the result of bpm du, sizes in bytes.
+type diskUsageReport struct {
+	Packages []*diskUsageItem `json:"packages"`
+	Total    int64            `json:"total"`
+}
+
+type diskUsageItem struct {
+	Package string `json:"package"`
+	Size    int64  `json:"size"`
+}
+
+// doDiskUsage prints the on-disk size of every vendored package, excluding
+// its nested vendor folder, largest first.
+func doDiskUsage(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	usages := make([]diskUsage, 0)
+	if err := collectDiskUsage(filepath.Join(dir, vendorFolderName), data.Dependencies, &usages); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].size > usages[j].size
+	})
+
+	report := &diskUsageReport{Packages: make([]*diskUsageItem, 0, len(usages))}
+	for _, usage := range usages {
+		report.Total += usage.size
+		report.Packages = append(report.Packages, &diskUsageItem{Package: usage.pkg, Size: usage.size})
+	}
+	output.Result(report, func() {
+		for _, item := range report.Packages {
+			fmt.Printf("%10s  %s\n", formatSize(item.Size), item.Package)
+		}
+		fmt.Printf("%10s  total\n", formatSize(report.Total))
+	})
+	return 0
+}
+
+func collectDiskUsage(vendorDir string, dependencies map[string]*bpmEntry, usages *[]diskUsage) error {
+	for _, pkg := range sortedKeys(dependencies) {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if fileExists(pkgDir) {
+			size, err := getDirSize(pkgDir)
+			if err != nil {
+				return err
+			}
+			*usages = append(*usages, diskUsage{pkg: pkg, size: size})
+		}
+		if err := collectDiskUsage(filepath.Join(pkgDir, vendorFolderName), dependencies[pkg].Dependencies, usages); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == filepath.Join(dir, vendorFolderName) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
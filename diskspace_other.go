@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package main
+
+import "errors"
+
+func getFreeSpace(dir string) (int64, error) {
+	return 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const doctorTimeout = 5 * time.Second
+
+// failureCauses maps the error output of git to the likely cause of a failed
+// clone or fetch, in order of precedence.
+var failureCauses = []struct {
+	cause string
+	hint  string
+	signs []string
+}{
+	{"rate limited", "the host throttles requests, lower jobsPerHost or authenticate",
+		[]string{"429", "rate limit", "too many requests", "secondary rate", "try again later"}},
+	{"DNS", "the host name doesn't resolve, check the URL and your DNS or VPN, bpm doctor tests this",
+		[]string{"could not resolve host", "name or service not known", "temporary failure in name resolution", "nodename nor servname", "no such host"}},
+	{"TLS", "the TLS handshake failed, check the system certificates or a proxy intercepting HTTPS",
+		[]string{"ssl", "certificate", "x509", "tls", "gnutls"}},
+	{"authentication", "the host refused the credentials, check tokens, SSH keys or the credentials config",
+		[]string{"authentication failed", "could not read username", "permission denied", "terminal prompts disabled", "401", "403"}},
+	{"not found", "the repository doesn't exist or is private, check the package path or url in bpm.json",
+		[]string{"repository not found", "404", "not found", "does not appear to be a git repository"}},
+	{"network", "the host is unreachable, check the connection or proxy, bpm doctor tests this",
+		[]string{"connection refused", "timed out", "network is unreachable", "connection reset", "couldn't connect", "failed to connect"}},
+}
+
+// classifyGitError returns the likely cause of a failed git network operation
+// and a hint on fixing it.
+func classifyGitError(err error) (string, string) {
+	gerr, ok := err.(*gitError)
+	if !ok {
+		return "unknown", err.Error()
+	}
+	stderr := strings.ToLower(gerr.stderr)
+	for _, cause := range failureCauses {
+		for _, sign := range cause.signs {
+			if strings.Contains(stderr, sign) {
+				return cause.cause, cause.hint
+			}
+		}
+	}
+	return "unknown", strings.TrimSpace(gerr.stderr)
+}
+
+// doDoctor checks the tools bpm needs and the connectivity to every host the
+// project's dependencies and the global config refer to.
+func doDoctor(dir string) int {
+	code := 0
+	if out, err := gitCommand(nil, "--version").Output(); err != nil {
+		output.Errorf("git:   not found: %s", err)
+		code = 1
+	} else {
+		output.Printf("git:   %s\n", strings.TrimSpace(string(out)))
+	}
+	doctorToolchain(dir)
+
+	hosts, err := getDoctorHosts(dir)
+	if err != nil {
+		output.Errorf("%s: %s", dependencyFilename, err)
+		code = 1
+	}
+	for _, host := range hosts {
+		problems := checkHost(host)
+		failed := false
+		for _, problem := range problems {
+			if strings.HasPrefix(problem, "FAIL") {
+				failed = true
+			}
+		}
+		if failed {
+			output.Problems(host+":", problems)
+			code = 1
+			continue
+		}
+		output.Printf("%s:\n", host)
+		for _, problem := range problems {
+			output.Printf("    %s\n", problem)
+		}
+	}
+	return code
+}
+
+// getDoctorHosts returns the hosts of the global config and the project's
+// dependencies, along with why the latter couldn't be read.
+func getDoctorHosts(dir string) ([]string, error) {
+	hosts := make(map[string]bool)
+	depFile := filepath.Join(dir, dependencyFilename)
+	var err error
+	if fileExists(depFile) {
+		var data *bpmPackage
+		if data, err = readDataFile(depFile); err != nil {
+			data = &bpmPackage{}
+		}
+		flat := make(map[string][]*bpmEntry)
+		flattenDependencies(data.Dependencies, flat)
+		for pkg, entries := range flat {
+			for _, entry := range entries {
+				repoURL := getEntryURL(pkg, entry)
+				if entry.Archive != "" {
+					repoURL = archiveURL(entry)
+				}
+				if host := getURLHost(repoURL); host != "" {
+					hosts[host] = true
+				}
+			}
+		}
+	}
+	for _, cred := range getGlobalConfig().Credentials {
+		hosts[cred.Host] = true
+	}
+	result := make([]string, 0, len(hosts))
+	for host := range hosts {
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result, err
+}
+
+// checkHost resolves a host and tries TCP over IPv4 and IPv6 and a TLS
+// handshake on port 443.
+func checkHost(host string) []string {
+	results := make([]string, 0)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	ips, err := net.LookupIP(hostname)
+	if err != nil {
+		return append(results, fmt.Sprintf("FAIL dns: %s", err))
+	}
+	v4, v6 := 0, 0
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	results = append(results, fmt.Sprintf("ok   dns: %d IPv4, %d IPv6 addresses", v4, v6))
+
+	address := net.JoinHostPort(hostname, "443")
+	reachable := false
+	for _, network := range []string{"tcp4", "tcp6"} {
+		if (network == "tcp4" && v4 == 0) || (network == "tcp6" && v6 == 0) {
+			continue
+		}
+		start := time.Now()
+		conn, err := net.DialTimeout(network, address, doctorTimeout)
+		if err != nil {
+			results = append(results, fmt.Sprintf("warn %s: %s", network, err))
+			continue
+		}
+		conn.Close()
+		reachable = true
+		results = append(results, fmt.Sprintf("ok   %s: connected in %s", network, time.Since(start).Round(time.Millisecond)))
+	}
+	if !reachable {
+		return append(results, "FAIL no address reachable on port 443")
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: doctorTimeout}, "tcp", address, &tls.Config{ServerName: hostname})
+	if err != nil {
+		return append(results, fmt.Sprintf("FAIL tls: %s", err))
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	expires := state.PeerCertificates[0].NotAfter
+	return append(results, fmt.Sprintf("ok   tls: certificate for %s valid until %s", hostname, expires.Format("2006-01-02")))
+}
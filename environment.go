@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// bpmVersion is set at build time with -ldflags "-X main.bpmVersion=...".
+var bpmVersion = "dev"
+
+const (
+	resolutionBranchHeads = "branch-heads"
+	resolutionKeepPins    = "keep-pins"
+	resolutionWorkspace   = "workspace"
+)
+
+// bpmEnvironment records the inputs dependencies were resolved with, so a
+// later install in a different environment can warn about it.
+type bpmEnvironment struct {
+	BpmVersion string `json:"bpmVersion"`
+	Mode       string `json:"mode"`
+	Platform   string `json:"platform"`
+	Proxy      string `json:"proxy,omitempty"`
+}
+
+func captureEnvironment(dir string, mode string) *bpmEnvironment {
+	return &bpmEnvironment{
+		BpmVersion: bpmVersion,
+		Mode:       mode,
+		Platform:   getTargetPlatform(),
+		Proxy:      getProxy(dir)}
+}
+
+// getTargetPlatform returns GOOS/GOARCH, honoring the environment variables
+// used for cross compilation.
+func getTargetPlatform() string {
+	goos, goarch := os.Getenv("GOOS"), os.Getenv("GOARCH")
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	return goos + "/" + goarch
+}
+
+// getProxy returns the proxy git uses for fetches, from its configuration or
+// the environment.
+func getProxy(dir string) string {
+	cmd := gitCommand(&dir, "config", "--get", "http.proxy")
+	if out, err := cmd.Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+		return strings.TrimSpace(string(out))
+	}
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "ALL_PROXY", "all_proxy"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// recordEnvironment stores the resolution environment in dir's bpm.lock,
+// keeping any locked dependencies in it.
+func recordEnvironment(dir string, mode string) error {
+	lockFile := filepath.Join(dir, lockFilename)
+	lock := &bpmLock{}
+	if fileExists(lockFile) {
+		var err error
+		if lock, err = readLockFile(lockFile); err != nil {
+			return err
+		}
+	}
+	lock.Environment = captureEnvironment(dir, mode)
+	return writeLockFile(lockFile, lock)
+}
+
+// warnEnvironmentChanges compares the environment recorded in dir's bpm.lock
+// with the current one.
+func warnEnvironmentChanges(dir string) {
+	lockFile := filepath.Join(dir, lockFilename)
+	if !fileExists(lockFile) {
+		return
+	}
+	lock, err := readLockFile(lockFile)
+	if err != nil {
+		output.Printf("Warning: %s\n", err)
+		return
+	}
+	recorded := lock.Environment
+	if recorded == nil {
+		return
+	}
+	current := captureEnvironment(dir, recorded.Mode)
+	changes := make([]string, 0)
+	if recorded.BpmVersion != current.BpmVersion {
+		changes = append(changes, fmt.Sprintf("bpm version %s, now %s", recorded.BpmVersion, current.BpmVersion))
+	}
+	if recorded.Platform != current.Platform {
+		changes = append(changes, fmt.Sprintf("platform %s, now %s", recorded.Platform, current.Platform))
+	}
+	if recorded.Proxy != current.Proxy {
+		changes = append(changes, fmt.Sprintf("proxy %q, now %q", recorded.Proxy, current.Proxy))
+	}
+	if len(changes) == 0 {
+		return
+	}
+	output.Printf("Warning: dependencies in %s were resolved in a different environment (%s mode):\n", lockFile, recorded.Mode)
+	for _, change := range changes {
+		output.Printf("    %s\n", change)
+	}
+}
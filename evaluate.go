@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// evaluateReport is the result of evaluate with -json.
+type evaluateReport struct {
+	Package         string              `json:"package"`
+	Branch          string              `json:"branch"`
+	Commit          string              `json:"commit"`
+	License         string              `json:"license"`
+	Size            int64               `json:"size"`
+	TreeSize        int64               `json:"treeSize"`
+	LastCommit      string              `json:"lastCommit"`
+	LastRelease     string              `json:"lastRelease,omitempty"`
+	LastReleaseDate string              `json:"lastReleaseDate,omitempty"`
+	Transitive      int                 `json:"transitive"`
+	Licenses        map[string][]string `json:"licenses"`
+	Vulnerable      []*vulnerableEntry  `json:"vulnerable"`
+	VulnerableError string              `json:"vulnerableError,omitempty"`
+}
+
+// doEvaluate fetches a package and everything it imports into a temporary
+// folder and reports what vendoring it would bring in.
+func doEvaluate(importPath string) int {
+	if importPath == "" {
+		output.Errorf("Usage: bpm evaluate <import-path>")
+		return 1
+	}
+	pkg := importRoot(importPath)
+	if pkg == "" {
+		output.Errorf("%s is not a valid package path", importPath)
+		return 1
+	}
+	tmp, err := ioutil.TempDir("", "bpm-evaluate-")
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+
+	r := newGitResolver()
+	pkgDir := filepath.Join(tmp, vendorFolderName, vendorPath(pkg))
+	entry, err := r.src.fetch(pkg, "", pkgDir)
+	if err != nil {
+		output.Errorf("Could not fetch %s: %s", pkg, err)
+		return 1
+	}
+	entry.Dependencies = r.resolveNested(pkgDir, pkg, []string{pkg})
+	if err := r.failed.orNil(); err != nil {
+		return reportPullError(err)
+	}
+
+	transitive := make(map[string]bool)
+	collectPackages(entry.Dependencies, transitive)
+	size, err := getDirSize(pkgDir)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	report := &evaluateReport{
+		Package:    pkg,
+		Branch:     entry.Branch,
+		Commit:     entry.Commit,
+		License:    detectLicense(pkgDir),
+		Size:       size,
+		TreeSize:   getTreeSize(pkgDir),
+		LastCommit: getCommitDate(pkgDir, entry.Commit),
+		Transitive: len(transitive),
+		Licenses:   make(map[string][]string)}
+	report.LastRelease, report.LastReleaseDate = getLastRelease(pkgDir)
+	for _, item := range listDependencies(pkgDir, entry.Dependencies, 0) {
+		report.Licenses[item.License] = append(report.Licenses[item.License], item.Package)
+	}
+	for _, packages := range report.Licenses {
+		sort.Strings(packages)
+	}
+	if report.Vulnerable, err = findVulnerable(map[string]*bpmEntry{pkg: entry}); err != nil {
+		report.VulnerableError = err.Error()
+	}
+	output.Result(report, func() {
+		printEvaluation(report)
+	})
+	return 0
+}
+
+func printEvaluation(report *evaluateReport) {
+	fmt.Printf("Package:      %s\n", report.Package)
+	fmt.Printf("Revision:     %s %s\n", report.Branch, shortHash(report.Commit))
+	fmt.Printf("License:      %s\n", report.License)
+	fmt.Printf("Size:         %s, %s with dependencies\n", formatSize(report.Size), formatSize(report.TreeSize))
+	fmt.Printf("Last commit:  %s\n", report.LastCommit)
+	if report.LastRelease != "" {
+		fmt.Printf("Last release: %s (%s)\n", report.LastRelease, report.LastReleaseDate)
+	} else {
+		fmt.Println("Last release: none tagged")
+	}
+	fmt.Printf("Dependencies: %d transitive\n", report.Transitive)
+	for _, license := range sortedStrings(report.Licenses) {
+		fmt.Printf("    %-12s %s\n", license, strings.Join(report.Licenses[license], ", "))
+	}
+
+	switch {
+	case report.VulnerableError != "":
+		fmt.Printf("Vulnerabilities: unknown, %s\n", report.VulnerableError)
+	case len(report.Vulnerable) == 0:
+		fmt.Println("Vulnerabilities: none known")
+	default:
+		fmt.Println("Vulnerabilities:")
+		for _, v := range report.Vulnerable {
+			for _, vuln := range v.Vulnerabilities {
+				fmt.Printf("    %s %s: %s %s\n", v.Package, shortHash(v.Commit), vuln.ID, vuln.Summary)
+			}
+		}
+	}
+}
+
+// getTreeSize returns the size of a folder including nested vendor folders.
+func getTreeSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func getCommitDate(dir string, commit string) string {
+	date, err := runCmdOutput(&dir, "git", "log", "-1", "--format=%cs", commit)
+	if err != nil {
+		log.Printf("Could not read the date of %s: %s", commit, err)
+	}
+	return date
+}
+
+// getLastRelease returns the highest semver tag and the date it points at.
+func getLastRelease(dir string) (string, string) {
+	all, err := getTags(dir)
+	if err != nil {
+		log.Printf("Could not list the tags of %s: %s", dir, err)
+	}
+	tags := sortSemverTags(all)
+	if len(tags) == 0 {
+		return "", ""
+	}
+	tag := tags[len(tags)-1]
+	return tag, getCommitDate(dir, tag)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const exportFormatGoMod = "gomod"
+
+// goModule is a dependency as go.mod requires it, replaced by the folder it
+// is vendored in.
+type goModule struct {
+	path    string
+	version string
+	dir     string
+	direct  bool
+}
+
+// doExport writes the locked dependencies in the format of another tool.
+// With gomod, go.mod requires every vendored package at its pinned version
+// and replaces it with its vendor folder, so the go command builds against
+// exactly what bpm vendored. Modules replaced by folders aren't checked
+// against go.sum, so there is no go.sum to write.
+func doExport(dir string, format string, force bool) int {
+	if format != exportFormatGoMod {
+		output.Errorf("Unknown format %q, expected %s", format, exportFormatGoMod)
+		return 1
+	}
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	goModFile := filepath.Join(dir, "go.mod")
+	if fileExists(goModFile) && !force {
+		output.Errorf("%s already exists, run export with -force to overwrite it", goModFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	modules, conflicts := collectGoModules(dir, data.Dependencies)
+	for _, conflict := range conflicts {
+		output.Printf("%s\n", conflict)
+	}
+	if err := ioutil.WriteFile(goModFile, []byte(formatGoMod(data.Package, modules)), 0644); err != nil {
+		output.Errorf("Could not write %s: %s", goModFile, err)
+		return 1
+	}
+	for _, module := range modules {
+		if !fileExists(filepath.Join(dir, module.dir, "go.mod")) {
+			output.Printf("%s has no go.mod, add one in %s or the go command won't accept the replacement\n", module.path, module.dir)
+		}
+	}
+	output.Printf("Wrote %s with %d modules. Build with -mod=mod, as the vendor folder has no modules.txt.\n", goModFile, len(modules))
+	return 0
+}
+
+// collectGoModules lists the vendored packages breadth first, so that of a
+// package vendored at several commits the one closest to the project is
+// required, and the others are reported.
+func collectGoModules(dir string, dependencies map[string]*bpmEntry) ([]*goModule, []string) {
+	type level struct {
+		dir          string
+		rel          string
+		dependencies map[string]*bpmEntry
+	}
+	modules := make([]*goModule, 0)
+	byPath := make(map[string]*goModule)
+	conflicts := make([]string, 0)
+	queue := []level{{dir, "", dependencies}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, pkg := range sortedKeys(current.dependencies) {
+			entry := current.dependencies[pkg]
+			pkgDir := filepath.Join(current.dir, vendorFolderName, vendorPath(pkg))
+			if isAssets(entry) {
+				continue
+			}
+			version := goModVersion(pkg, entry, pkgDir)
+			if existing, ok := byPath[pkg]; ok {
+				if existing.version != version {
+					conflicts = append(conflicts, fmt.Sprintf("%s is vendored at %s and %s, requiring %s", pkg, existing.version, version, existing.version))
+				}
+				continue
+			}
+			rel := filepath.Join(current.rel, vendorFolderName, vendorPath(pkg))
+			module := &goModule{path: pkg, version: version, dir: "./" + filepath.ToSlash(rel), direct: current.dir == dir && !entry.Indirect}
+			byPath[pkg] = module
+			modules = append(modules, module)
+			queue = append(queue, level{pkgDir, rel, entry.Dependencies})
+		}
+	}
+	return modules, conflicts
+}
+
+// goModVersion is the version go.mod requires a package at: its tag when it
+// is a semantic version, else a pseudo-version of its commit.
+func goModVersion(pkg string, entry *bpmEntry, pkgDir string) string {
+	if v, ok := parseSemver(entry.Tag); ok && strings.HasPrefix(entry.Tag, "v") {
+		if v.major >= 2 && !majorSuffixPattern.MatchString(pkg) && !strings.HasPrefix(pkg, "gopkg.in/") {
+			return entry.Tag + "+incompatible"
+		}
+		return entry.Tag
+	}
+	commit := entry.Commit
+	if commit == "" {
+		commit = entry.SHA256
+	}
+	stamp := "00010101000000"
+	if isGitRepo(pkgDir) && entry.Commit != "" {
+		if seconds := commitTime(pkgDir, entry.Commit); seconds > 0 {
+			stamp = time.Unix(seconds, 0).UTC().Format("20060102150405")
+		}
+	}
+	if len(commit) < 12 {
+		commit = "000000000000"
+	}
+	return "v0.0.0-" + stamp + "-" + commit[:12]
+}
+
+func formatGoMod(pkg string, modules []*goModule) string {
+	sb := strings.Builder{}
+	sb.WriteString("// Generated by bpm export from bpm.lock.\n")
+	sb.WriteString("module " + pkg + "\n")
+	if t := detectToolchain(); t != nil && t.minor < 1<<10 {
+		sb.WriteString(fmt.Sprintf("\ngo 1.%d\n", t.minor))
+	}
+	if len(modules) == 0 {
+		return sb.String()
+	}
+	sb.WriteString("\nrequire (\n")
+	for _, module := range modules {
+		sb.WriteString("\t" + module.path + " " + module.version)
+		if !module.direct {
+			sb.WriteString(" // indirect")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(")\n\nreplace (\n")
+	for _, module := range modules {
+		sb.WriteString("\t" + module.path + " => " + module.dir + "\n")
+	}
+	sb.WriteString(")\n")
+	return sb.String()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sourcePackage is a package of the project with the imports of its
+// non-test files.
+type sourcePackage struct {
+	name    string
+	imports []string
+}
+
+// doExportDeps writes a manifest of only the dependencies the public packages
+// of the project need, pinned at their commits, for projects consumed by
+// others through bpm. Public packages are those outside internal folders
+// that aren't commands; the internal packages they import count, tests
+// don't. Settings like replace and mirrors stay behind.
+func doExportDeps(dir string, out string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	imports, err := publicImports(dir, data.Package)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	needed := make(map[string]*bpmEntry)
+	left := make([]string, 0)
+	for _, pkg := range sortedKeys(data.Dependencies) {
+		entry := data.Dependencies[pkg]
+		if entry.Indirect {
+			continue
+		}
+		if !entry.Test && isImported(imports, pkg) {
+			needed[pkg] = entry
+		} else {
+			left = append(left, pkg)
+		}
+	}
+	if len(left) > 0 {
+		log.Printf("Left out %d dependencies the public packages don't import: %s", len(left), strings.Join(left, ", "))
+	}
+
+	exported := declaredDependencies(needed)
+	for pkg, entry := range exported {
+		entry.Commit = needed[pkg].Commit
+		if needed[pkg].Archive != "" {
+			entry.SHA256 = needed[pkg].SHA256
+		}
+	}
+	manifest, err := jsonEncodeIndented(&bpmPackage{Package: data.Package, Dependencies: exported})
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if out == "" {
+		os.Stdout.Write(manifest)
+		return 0
+	}
+	if err := ioutil.WriteFile(out, manifest, 0644); err != nil {
+		output.Errorf("Could not write %s: %s", out, err)
+		return 1
+	}
+	output.Printf("Wrote %d of %d dependencies to %s\n", len(exported), len(exported)+len(left), out)
+	return 0
+}
+
+// publicImports returns the imports of the public packages of the project in
+// dir and of every package of the project they import.
+func publicImports(dir string, pkg string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	contexts, err := platformContexts()
+	if err != nil {
+		return nil, err
+	}
+	roots, ok := projectSourceRoots(dir)
+	if !ok {
+		roots = []string{dir}
+	}
+	packages := make(map[string]*sourcePackage)
+	for _, root := range roots {
+		if fileExists(root) {
+			if err := readSourcePackages(dir, root, pkg, contexts, packages); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	queue := make([]string, 0)
+	for path, p := range packages {
+		if p.name != "main" && !isInternalPath(path) {
+			queue = append(queue, path)
+		}
+	}
+	sort.Strings(queue)
+	visited := make(map[string]bool)
+	imports := make([]string, 0)
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		if visited[path] {
+			continue
+		}
+		visited[path] = true
+		for _, imported := range packages[path].imports {
+			if _, own := packages[imported]; own {
+				queue = append(queue, imported)
+			} else {
+				imports = append(imports, imported)
+			}
+		}
+	}
+	return imports, nil
+}
+
+// readSourcePackages reads the package name and non-test imports of every
+// folder below dir, outside of vendor, testdata and folders Go ignores.
+func readSourcePackages(root string, dir string, pkg string, contexts []*build.Context, packages map[string]*sourcePackage) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var p *sourcePackage
+	for _, f := range files {
+		name := f.Name()
+		fullName := filepath.Join(dir, name)
+		if f.IsDir() {
+			if name != vendorFolderName && name != "testdata" && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "_") {
+				if err := readSourcePackages(root, fullName, pkg, contexts, packages); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || !matchesPlatforms(contexts, fullName) {
+			continue
+		}
+		parsed, err := parser.ParseFile(token.NewFileSet(), fullName, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			p = &sourcePackage{name: parsed.Name.Name}
+			rel, _ := filepath.Rel(root, dir)
+			path := pkg
+			if rel != "." {
+				path = pkg + "/" + filepath.ToSlash(rel)
+			}
+			packages[path] = p
+		}
+		for _, spec := range parsed.Imports {
+			if imported, err := strconv.Unquote(spec.Path.Value); err == nil {
+				p.imports = append(p.imports, imported)
+			}
+		}
+	}
+	return nil
+}
+
+// isInternalPath is whether an import path has an internal element, making
+// it importable only from within the project.
+func isInternalPath(path string) bool {
+	for _, element := range strings.Split(path, "/") {
+		if element == "internal" {
+			return true
+		}
+	}
+	return false
+}
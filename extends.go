@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// maxExtendsDepth bounds chains of base manifests, catching cycles between
+// them.
+const maxExtendsDepth = 8
+
+// mirrors map repository URL prefixes to the prefixes they are cloned from
+// instead, and replacements package paths to the repository used in their
+// place. Both are collected from the manifest last read and its bases.
+var (
+	mirrors      = make(map[string]string)
+	replacements = make(map[string]string)
+)
+
+// loadBase reads the base manifest data extends, from a path relative to dir
+// or an http(s) URL, along with its own bases.
+func loadBase(data *bpmPackage, dir string, depth int) error {
+	if data.Extends == "" {
+		return nil
+	}
+	if depth >= maxExtendsDepth {
+		return fmt.Errorf("base manifests extend each other more than %d levels deep at %s", maxExtendsDepth, data.Extends)
+	}
+	location := data.Extends
+	var bytes []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		bytes, err = downloadManifest(location)
+	} else {
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(dir, location)
+		}
+		bytes, err = ioutil.ReadFile(location)
+		dir = filepath.Dir(location)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read base manifest %s: %s", data.Extends, err)
+	}
+	base := &bpmPackage{}
+	if err := json.Unmarshal(bytes, base); err != nil {
+		return fmt.Errorf("could not parse base manifest %s: %s", data.Extends, err)
+	}
+	if err := loadBase(base, dir, depth+1); err != nil {
+		return err
+	}
+	data.base = base
+	return nil
+}
+
+func downloadManifest(manifestURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	authorizeRequest(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// applyBase registers the mirrors, replacements, shallow clones and target
+// platforms of data and its bases, replacing the mirrors and replacements
+// of manifests read before, and pins dependencies left unpinned in data at
+// the versions its bases pin. Settings of data win over those of its bases.
+func applyBase(data *bpmPackage) {
+	mirrors = make(map[string]string)
+	replacements = make(map[string]string)
+	for _, p := range data.chain() {
+		if p.Shallow {
+			shallowClone = true
+		}
+		applyPlatforms(p)
+		for prefix, mirror := range p.Mirrors {
+			mirrors[prefix] = mirror
+		}
+		for pkg, url := range p.Replace {
+			replacements[pkg] = url
+		}
+	}
+	pins := data.basePins()
+	for pkg, entry := range data.Dependencies {
+		if pinned, ok := pins[pkg]; ok && entry.Commit == "" && entry.Archive == "" {
+			entry.Branch = pinned.Branch
+			entry.Commit = pinned.Commit
+			if entry.URL == "" {
+				entry.URL = pinned.URL
+			}
+		}
+	}
+}
+
+// chain lists the bases of p, the furthest first, followed by p itself.
+func (p *bpmPackage) chain() []*bpmPackage {
+	if p.base == nil {
+		return []*bpmPackage{p}
+	}
+	return append(p.base.chain(), p)
+}
+
+// basePins are the top level dependencies pinned by the bases of p, the
+// nearer base winning.
+func (p *bpmPackage) basePins() map[string]*bpmEntry {
+	pins := make(map[string]*bpmEntry)
+	chain := p.chain()
+	for _, base := range chain[:len(chain)-1] {
+		for pkg, entry := range base.Dependencies {
+			if entry.Commit != "" {
+				pins[pkg] = entry
+			}
+		}
+	}
+	return pins
+}
+
+// policy is the staging policy of p, or the nearest base's when p has none.
+func (p *bpmPackage) policy() *bpmPolicy {
+	if p.Policy != nil || p.base == nil {
+		return p.Policy
+	}
+	return p.base.policy()
+}
+
+// mirrorURL rewrites a repository URL to its configured mirror.
+func mirrorURL(url string) string {
+	longest := ""
+	for prefix := range mirrors {
+		if strings.HasPrefix(url, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return url
+	}
+	return mirrors[longest] + strings.TrimPrefix(url, longest)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// resolverFixture declares a project and a set of fake repositories, so
+// resolution can be reproduced without network or disk access. Dependencies
+// are declared as in bpm.json, with tags, versions or aliases; archives,
+// subdirectories and refs aren't served from fixtures.
+//
+//	{
+//	  "package": "github.com/me/app",
+//	  "imports": ["github.com/lib/a", "github.com/lib/c"],
+//	  "pins": {"github.com/lib/a": "a1"},
+//	  "dependencies": {"github.com/lib/c": {"version": "^1.2"}},
+//	  "repos": {
+//	    "github.com/lib/a": {
+//	      "defaultBranch": "master",
+//	      "branches": {"master": "a2"},
+//	      "tags": {"v1.0.0": "a1"},
+//	      "commits": {"a1": {"imports": []}, "a2": {"imports": ["github.com/lib/b/sub"]}}
+//	    }
+//	  }
+//	}
+type resolverFixture struct {
+	Package string            `json:"package"`
+	Imports []string          `json:"imports"`
+	Pins    map[string]string `json:"pins,omitempty"`
+	// Dependencies are the declared entries, kept as with install.
+	Dependencies map[string]*bpmEntry    `json:"dependencies,omitempty"`
+	Repos        map[string]*fixtureRepo `json:"repos"`
+}
+
+type fixtureRepo struct {
+	URL           string                    `json:"url,omitempty"`
+	DefaultBranch string                    `json:"defaultBranch,omitempty"`
+	Branches      map[string]string         `json:"branches"`
+	Tags          map[string]string         `json:"tags,omitempty"`
+	Commits       map[string]*fixtureCommit `json:"commits"`
+}
+
+type fixtureCommit struct {
+	Imports []string `json:"imports"`
+}
+
+func readFixture(filename string) (*resolverFixture, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f := resolverFixture{}
+	if err = json.Unmarshal(bytes, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %s", filename, err)
+	}
+	return &f, nil
+}
+
+// memSource serves packages from a fixture, remembering which commit was
+// "checked out" into each virtual vendor folder.
+type memSource struct {
+	fixture   *resolverFixture
+	mu        sync.Mutex
+	checkouts map[string]string
+}
+
+func newMemSource(f *resolverFixture) *memSource {
+	return &memSource{fixture: f, checkouts: make(map[string]string)}
+}
+
+func (s *memSource) imports(dir string, pkg string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := s.fixture.Imports
+	if commit, ok := s.checkouts[dir]; ok {
+		repo := s.fixture.Repos[pkg]
+		if c, ok := repo.Commits[commit]; ok {
+			paths = c.Imports
+		} else {
+			paths = nil
+		}
+	}
+
+	roots := make(map[string]bool)
+	for _, path := range paths {
+		if root := s.repoRoot(path); root != "" && root != pkg {
+			roots[root] = true
+		}
+	}
+	result := make([]string, 0, len(roots))
+	for root := range roots {
+		result = append(result, root)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (s *memSource) repoRoot(path string) string {
+	if path == s.fixture.Package || strings.HasPrefix(path, s.fixture.Package+"/") {
+		return s.fixture.Package
+	}
+	for root := range s.fixture.Repos {
+		if path == root || strings.HasPrefix(path, root+"/") {
+			return root
+		}
+	}
+	return builtinRepoRoot(path)
+}
+
+func (s *memSource) fetch(pkg string, url string, pkgDir string) (*bpmEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, ok := s.fixture.Repos[pkg]
+	if !ok {
+		return nil, fmt.Errorf("fixture has no repository %s", pkg)
+	}
+	branch := repo.DefaultBranch
+	if branch == "" {
+		branch = "master"
+	}
+	commit, ok := repo.Branches[branch]
+	if !ok {
+		return nil, fmt.Errorf("fixture repository %s has no branch %s", pkg, branch)
+	}
+	s.checkouts[pkgDir] = commit
+
+	if url == "" {
+		url = repo.URL
+	}
+	if url == "" {
+		url = "https://" + pkg
+	}
+	return &bpmEntry{URL: url, Branch: branch, Commit: commit}, nil
+}
+
+func (s *memSource) checkout(pkg string, pkgDir string, pinned *bpmEntry) *bpmEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, ok := s.fixture.Repos[pkg]
+	if !ok {
+		return nil
+	}
+	if _, ok := repo.Commits[pinned.Commit]; !ok {
+		return nil
+	}
+	s.checkouts[pkgDir] = pinned.Commit
+
+	url := repo.URL
+	if url == "" {
+		url = "https://" + pkg
+	}
+	branch := pinned.Branch
+	if branch == "" {
+		branch = repo.DefaultBranch
+	}
+	if branch == "" {
+		branch = "master"
+	}
+	return &bpmEntry{URL: url, Branch: branch, Tag: pinned.Tag, Commit: pinned.Commit}
+}
+
+func (s *memSource) tags(pkg string, url string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repo, ok := s.fixture.Repos[pkg]
+	if !ok {
+		return nil, fmt.Errorf("fixture has no repository %s", pkg)
+	}
+	tags := make(map[string]string, len(repo.Tags))
+	for tag, commit := range repo.Tags {
+		tags[tag] = commit
+	}
+	return tags, nil
+}
+
+// escape moves the virtual checkout of pkg to its escaped folder.
+func (s *memSource) escape(vendorDir string, pkg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := filepath.Join(vendorDir, filepath.FromSlash(pkg))
+	to := filepath.Join(vendorDir, vendorPath(pkg))
+	if commit, ok := s.checkouts[from]; ok && from != to {
+		delete(s.checkouts, from)
+		s.checkouts[to] = commit
+	}
+	return nil
+}
+
+// doFixture resolves a fixture in memory and prints the manifest it produces.
+func doFixture(filename string) int {
+	if filename == "" {
+		output.Errorf("Usage: bpm fixture <file>")
+		return 1
+	}
+	f, err := readFixture(filename)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	dependencies, err := fixtureResolver(f).resolve(".", f.Package)
+	if err != nil {
+		return reportPullError(err)
+	}
+	data := &bpmPackage{
+		Package:      f.Package,
+		Dependencies: dependencies}
+	output.writeJSON(data)
+	return 0
+}
+
+// fixtureResolver returns a resolver over the repositories of f, keeping its
+// declared dependencies and pins.
+func fixtureResolver(f *resolverFixture) *resolver {
+	r := &resolver{src: newMemSource(f)}
+	r.keepDeclared(f.Dependencies)
+	if len(f.Pins) > 0 {
+		r.pins = make(map[string]*bpmEntry)
+		for pkg, commit := range f.Pins {
+			r.pins[pkg] = &bpmEntry{Commit: commit}
+		}
+	}
+	return r
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+// layoutFlat vendors every dependency once, in the vendor folder of the
+// project, instead of nesting the dependencies of each package in its own.
+const layoutFlat = "flat"
+
+// flatPin is a commit a dependency pins one of its own dependencies at in
+// its bpm.lock or bpm.json.
+type flatPin struct {
+	by     string
+	commit string
+}
+
+// resolveFlat fetches every package reachable from the imports of dir into
+// its vendor folder, breadth first, so each package is vendored once at the
+// commit found first. Entries of existing that are still reachable, or
+// declared directly, are kept as they are. Nested vendor folders of the
+// packages are removed, as they would shadow the shared copies.
+func (r *resolver) resolveFlat(dir string, pkg string, existing map[string]*bpmEntry) map[string]*bpmEntry {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	dependencies := make(map[string]*bpmEntry)
+	requiredBy := make(map[string][]string)
+	direct := make(map[string]bool)
+	queue := make([]string, 0)
+	for _, imported := range r.expandSubdirs(r.importsOf(dir, pkg)) {
+		direct[imported] = true
+		requiredBy[imported] = append(requiredBy[imported], pkg)
+		queue = append(queue, imported)
+	}
+	for name, entry := range existing {
+		if !entry.Indirect && !direct[name] {
+			direct[name] = true
+			queue = append(queue, name)
+		}
+	}
+
+	for len(queue) > 0 {
+		sort.Strings(queue)
+		round := make([]string, 0)
+		missing := make([]string, 0)
+		for _, name := range queue {
+			if _, ok := dependencies[name]; ok || name == pkg || containsString(round, name) {
+				continue
+			}
+			round = append(round, name)
+			if entry, ok := existing[name]; ok && (isAssets(entry) || fileExists(filepath.Join(vendorDir, vendorPath(name)))) {
+				dependencies[name] = entry
+			} else {
+				missing = append(missing, name)
+			}
+		}
+		r.noteCollisions(missing)
+		for name, entry := range r.fetchAll(missing, dir) {
+			r.locations[name] = append(r.locations[name], vendorDir)
+			dependencies[name] = entry
+		}
+
+		queue = make([]string, 0)
+		for _, name := range round {
+			entry, ok := dependencies[name]
+			if !ok {
+				continue
+			}
+			entry.Indirect = !direct[name]
+			entry.Dependencies = nil
+			if isAssets(entry) {
+				continue
+			}
+			pkgDir := filepath.Join(vendorDir, vendorPath(name))
+			if err := removeNestedVendor(pkgDir); err != nil {
+				r.failed.add(name, err)
+				continue
+			}
+			r.replaces = r.noteReplaces(name, pkgDir, r.replaces)
+			r.noteFlatPins(name, pkgDir)
+			for _, imported := range r.expandSubdirs(r.importsOf(pkgDir, entryRepo(name, entry))) {
+				if !containsString(requiredBy[imported], name) {
+					requiredBy[imported] = append(requiredBy[imported], name)
+				}
+				queue = append(queue, imported)
+			}
+		}
+	}
+
+	for name, entry := range dependencies {
+		entry.RequiredBy = requiredBy[name]
+		sort.Strings(entry.RequiredBy)
+	}
+	return dependencies
+}
+
+func removeNestedVendor(pkgDir string) error {
+	nested := filepath.Join(pkgDir, vendorFolderName)
+	if !fileExists(nested) {
+		return nil
+	}
+	log.Printf("Removing nested vendor folder %s", nested)
+	return removeDir(nested)
+}
+
+// noteFlatPins remembers the commits a package pins its own dependencies at,
+// to report the ones the flat vendor folder has at a different commit.
+func (r *resolver) noteFlatPins(pkg string, pkgDir string) {
+	dependencies := readPinnedDependencies(pkgDir)
+	if len(dependencies) == 0 {
+		return
+	}
+	if r.flatPins == nil {
+		r.flatPins = make(map[string][]flatPin)
+	}
+	for name, entry := range dependencies {
+		if entry.Commit != "" {
+			r.flatPins[name] = append(r.flatPins[name], flatPin{by: pkg, commit: entry.Commit})
+		}
+	}
+}
+
+// readPinnedDependencies reads the direct dependencies a package pins in its
+// own bpm.lock or, without one, its bpm.json.
+func readPinnedDependencies(pkgDir string) map[string]*bpmEntry {
+	for _, filename := range []string{lockFilename, dependencyFilename} {
+		bytes, err := ioutil.ReadFile(filepath.Join(pkgDir, filename))
+		if err != nil {
+			continue
+		}
+		data := &bpmLock{}
+		if err := json.Unmarshal(bytes, data); err != nil {
+			log.Printf("Could not read %s of %s: %s", filename, pkgDir, err)
+			continue
+		}
+		return data.Dependencies
+	}
+	return nil
+}
+
+// reportFlatConflicts prints the packages vendored at another commit than a
+// dependency pins them at.
+func (r *resolver) reportFlatConflicts(dependencies map[string]*bpmEntry) {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		for _, pin := range r.flatPins[pkg] {
+			if pin.commit != entry.Commit {
+				output.Printf("Version conflict: %s is vendored at %s, %s pins %s\n", pkg, shortHash(entry.Commit), pin.by, shortHash(pin.commit))
+			}
+		}
+	}
+}
+
+// syncFlatVendor brings a flat vendor folder in line with the imports after
+// dependencies were added, removed or updated: newly imported packages are
+// fetched and packages nothing imports anymore are removed, unless declared
+// directly. It returns the removed packages, and the packages failing to be
+// fetched as a *pullError.
+func syncFlatVendor(dir string, data *bpmPackage) ([]string, error) {
+	r := newGitResolver()
+	r.keepDeclared(data.Dependencies)
+	r.keepPins(data.basePins())
+	r.replaceMode = data.transitiveReplaces()
+	r.conflicts = data.conflictStrategy()
+	r.flat = true
+	dependencies := r.resolveFlat(dir, data.Package, data.Dependencies)
+	r.settleConflicts(dir, data.Package, dependencies)
+	r.reportProblems(dependencies)
+	if err := r.failed.orNil(); err != nil {
+		return nil, err
+	}
+
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	removed := make([]string, 0)
+	for _, pkg := range sortedKeys(data.Dependencies) {
+		if _, ok := dependencies[pkg]; !ok {
+			pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+			if err := removeDir(pkgDir); err != nil {
+				return nil, err
+			}
+			removeEmptyParents(filepath.Dir(pkgDir), vendorDir)
+			removed = append(removed, pkg)
+		}
+	}
+	data.Dependencies = dependencies
+	return removed, nil
+}
+
+// removeNestedVendors removes the vendor folders that packages of a flat
+// layout ship in their repositories.
+func removeNestedVendors(dir string, dependencies map[string]*bpmEntry) error {
+	for pkg, entry := range dependencies {
+		if isAssets(entry) {
+			continue
+		}
+		if err := removeNestedVendor(filepath.Join(dir, vendorFolderName, vendorPath(pkg))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectFlatEdges returns an edge to every package of a flat layout from
+// each package importing it.
+func collectFlatEdges(root string, project string, dependencies map[string]*bpmEntry, whyEdges bool) ([]*graphEdge, error) {
+	edges := make([]*graphEdge, 0)
+	files := make(map[string][]string)
+	for _, pkg := range sortedKeys(dependencies) {
+		for _, from := range dependencies[pkg].RequiredBy {
+			edge := &graphEdge{from: from, to: pkg}
+			if whyEdges {
+				fromDir := root
+				if from != project {
+					fromDir = filepath.Join(root, vendorFolderName, vendorPath(from))
+				}
+				if _, ok := files[from]; !ok {
+					sources, err := getAllSourceFiles(fromDir)
+					if err != nil {
+						return nil, err
+					}
+					files[from] = *sources
+				}
+				edge.sites = findImportSites(root, files[from], pkg)
+			}
+			edges = append(edges, edge)
+		}
+	}
+	return edges, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// bpmFreshness is how far dependencies may fall behind upstream.
+type bpmFreshness struct {
+	// MaxAge like "12mo", "90d", "6w" or "1y" is how much older a pinned
+	// commit may be than the newest release, or branch tip without releases.
+	MaxAge string `json:"maxAge,omitempty"`
+}
+
+// freshness is the freshness policy of p, or the nearest base's when p has
+// none.
+func (p *bpmPackage) freshness() *bpmFreshness {
+	if p.Freshness != nil || p.base == nil {
+		return p.Freshness
+	}
+	return p.base.freshness()
+}
+
+// freshnessItem is how far a vendored package is behind upstream.
+type freshnessItem struct {
+	Package   string `json:"package"`
+	Commit    string `json:"commit"`
+	Latest    string `json:"latest,omitempty"`
+	LatestTag string `json:"latestTag,omitempty"`
+	// BehindDays is how much older the pinned commit is than the latest.
+	BehindDays int  `json:"behindDays"`
+	Violation  bool `json:"violation,omitempty"`
+}
+
+var agePattern = regexp.MustCompile(`^(\d+)\s*(d|w|mo|y)$`)
+
+// parseAge parses ages in days, weeks, months of 30 days or years of 365.
+func parseAge(s string) (time.Duration, error) {
+	match := agePattern.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("expected a number of days, weeks, months or years like 90d, 6w, 12mo or 1y")
+	}
+	n, _ := strconv.Atoi(match[1])
+	days := map[string]int{"d": 1, "w": 7, "mo": 30, "y": 365}[match[2]]
+	return time.Duration(n*days) * 24 * time.Hour, nil
+}
+
+// doStatus shows how far every dependency is behind upstream. With sla it
+// only reports the dependencies breaking the freshness policy of bpm.json,
+// exiting 1 when there are any, for CI.
+func doStatus(dir string, sla bool) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	var maxAge time.Duration
+	if freshness := data.freshness(); freshness != nil && freshness.MaxAge != "" {
+		var err error
+		if maxAge, err = parseAge(freshness.MaxAge); err != nil {
+			output.Errorf("Invalid freshness maxAge %q: %s", freshness.MaxAge, err)
+			return 1
+		}
+	} else if sla {
+		output.Errorf("%s sets no freshness policy, add \"freshness\": {\"maxAge\": \"12mo\"}", depFile)
+		return 1
+	}
+
+	items, err := findFreshness(dir, data.Dependencies)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	violations := make([]*freshnessItem, 0)
+	for _, item := range items {
+		if maxAge > 0 && time.Duration(item.BehindDays)*24*time.Hour > maxAge {
+			item.Violation = true
+			violations = append(violations, item)
+		}
+	}
+
+	if sla {
+		output.Result(violations, func() {
+			if len(violations) == 0 {
+				fmt.Printf("Every dependency is within %s of upstream\n", data.freshness().MaxAge)
+				return
+			}
+			fmt.Printf("%d dependencies are more than %s behind upstream:\n", len(violations), data.freshness().MaxAge)
+			for _, item := range violations {
+				fmt.Printf("    %s: %d days behind %s\n", item.Package, item.BehindDays, describeLatest(item))
+			}
+		})
+		if len(violations) > 0 {
+			return 1
+		}
+		return 0
+	}
+	output.Result(items, func() {
+		for _, item := range items {
+			marker := " "
+			if item.Violation {
+				marker = "!"
+			}
+			if item.Latest == "" {
+				fmt.Printf("%s %-50s %s  current\n", marker, item.Package, shortHash(item.Commit))
+			} else {
+				fmt.Printf("%s %-50s %s  %d days behind %s\n", marker, item.Package, shortHash(item.Commit), item.BehindDays, describeLatest(item))
+			}
+		}
+	})
+	return 0
+}
+
+func describeLatest(item *freshnessItem) string {
+	if item.LatestTag != "" {
+		return item.LatestTag
+	}
+	return shortHash(item.Latest)
+}
+
+// findFreshness compares every vendored checkout with the newest release or
+// branch tip of its remote, fetching it to learn its commit time.
+func findFreshness(dir string, dependencies map[string]*bpmEntry) ([]*freshnessItem, error) {
+	result := make([]*freshnessItem, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if entry.Commit == "" || !isGitRepo(pkgDir) {
+			continue
+		}
+		item := &freshnessItem{Package: pkg, Commit: entry.Commit}
+		update, err := findUpdate(pkg, entry, pkgDir)
+		if err != nil {
+			return nil, err
+		}
+		if update != nil {
+			item.Latest, item.LatestTag = update.Latest, update.LatestTag
+			latest := update.Latest
+			refspec := "+refs/heads/" + update.Branch + ":refs/remotes/origin/" + update.Branch
+			if update.LatestTag != "" {
+				latest = update.LatestTag
+				refspec = "+refs/tags/" + update.LatestTag + ":refs/tags/" + update.LatestTag
+			}
+			if err := runGit(&pkgDir, "fetch", "--quiet", "origin", refspec); err != nil {
+				log.Printf("Could not fetch %s of %s: %s", latest, pkg, err)
+			} else if behind := commitTime(pkgDir, latest) - commitTime(pkgDir, entry.Commit); behind > 0 {
+				item.BehindDays = int(behind / (24 * 60 * 60))
+			}
+		}
+		nested, err := findFreshness(pkgDir, entry.Dependencies)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+		result = append(result, nested...)
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// gitConfig selects the git binary and the options every git command gets,
+// from the git setting of the global config:
+//
+//	"git": {
+//	  "path": "/opt/git/bin/git",
+//	  "args": ["-c", "http.sslCAInfo=/etc/ssl/corp.pem"],
+//	  "hosts": {"git.corp.example": {"sshCommand": "ssh -i ~/.ssh/corp"}}
+//	}
+type gitConfig struct {
+	Path string `json:"path,omitempty"`
+	gitOptions
+	// Hosts add options for commands talking to, or run in clones of, a host.
+	Hosts map[string]*gitOptions `json:"hosts,omitempty"`
+}
+
+type gitOptions struct {
+	Args       []string `json:"args,omitempty"`
+	SSHCommand string   `json:"sshCommand,omitempty"`
+}
+
+func (o *gitOptions) appendTo(args []string) []string {
+	args = append(args, o.Args...)
+	if o.SSHCommand != "" {
+		args = append(args, "-c", "core.sshCommand="+o.SSHCommand)
+	}
+	return args
+}
+
+func getGitExecutable() string {
+	if git := getGlobalConfig().Git; git != nil && git.Path != "" {
+		return git.Path
+	}
+	return "git"
+}
+
+// gitCommand prepares a git command with the configured binary, options and
+// credentials. Host options apply when an argument is a URL of the host or
+// dir is a clone of it.
+func gitCommand(dir *string, args ...string) *exec.Cmd {
+	options := make([]string, 0)
+	if git := getGlobalConfig().Git; git != nil {
+		options = git.appendTo(options)
+		if len(git.Hosts) > 0 {
+			if hostOptions, ok := git.Hosts[getCommandHost(dir, args)]; ok {
+				options = hostOptions.appendTo(options)
+			}
+		}
+	}
+	cmd := exec.CommandContext(runContext, getGitExecutable(), append(options, args...)...)
+	if dir != nil {
+		cmd.Dir = *dir
+	}
+	withCredentials(cmd)
+	return cmd
+}
+
+var (
+	originHostsMutex sync.Mutex
+	originHosts      = make(map[string]string)
+)
+
+func getCommandHost(dir *string, args []string) string {
+	for _, arg := range args {
+		if strings.Contains(arg, "://") || (strings.Contains(arg, "@") && !strings.HasPrefix(arg, "-")) {
+			if host := getURLHost(arg); host != "" {
+				return host
+			}
+		}
+	}
+	if dir == nil {
+		return ""
+	}
+	originHostsMutex.Lock()
+	defer originHostsMutex.Unlock()
+	host, ok := originHosts[*dir]
+	if !ok {
+		cmd := exec.CommandContext(runContext, getGitExecutable(), "config", "--get", "remote.origin.url")
+		cmd.Dir = *dir
+		if out, err := cmd.Output(); err == nil {
+			host = getURLHost(strings.TrimSpace(string(out)))
+		}
+		originHosts[*dir] = host
+	}
+	return host
+}
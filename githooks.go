@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const gitHookMarker = "# Installed by bpm hooks install"
+
+var gitHooks = map[string]string{
+	"pre-commit": "check",
+	"post-merge": "install --frozen"}
+
+func doGitHooks(dir string, action string, force bool) int {
+	if action != "install" {
+		output.Errorf("Unknown hooks action %q, expected install", action)
+		return 1
+	}
+
+	toplevel, err := runCmdOutput(&dir, "git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	hooksDir, err := runCmdOutput(&dir, "git", "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	if err := createDir(hooksDir); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	projectDir := `"$(git rev-parse --show-toplevel)"`
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(toplevel, absDir); err == nil && rel != "." {
+			projectDir = `"$(git rev-parse --show-toplevel)/` + filepath.ToSlash(rel) + `"`
+		}
+	}
+
+	for name, command := range gitHooks {
+		hookFile := filepath.Join(hooksDir, name)
+		if fileExists(hookFile) && !force {
+			existing, err := ioutil.ReadFile(hookFile)
+			if err != nil {
+				output.Errorf("%s", err)
+				return 1
+			}
+			if !strings.Contains(string(existing), gitHookMarker) {
+				output.Printf("Skipping %s, a hook not created by bpm exists (use -force to replace it)\n", hookFile)
+				continue
+			}
+		}
+		parts := strings.SplitN(command, " ", 2)
+		script := fmt.Sprintf("#!/bin/sh\n%s\nexec bpm %s -d %s", gitHookMarker, parts[0], projectDir)
+		if len(parts) > 1 {
+			script += " " + parts[1]
+		}
+		if err := ioutil.WriteFile(hookFile, []byte(script+"\n"), 0755); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		output.Printf("Installed %s hook: bpm %s\n", name, command)
+	}
+	return 0
+}
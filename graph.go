@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type graphEdge struct {
+	from  string
+	to    string
+	sites []string
+}
+
+// graphNode is a package of the graph with the versions it is vendored at.
+// It is a duplicate when vendored more than once, a conflict when at
+// different versions.
+type graphNode struct {
+	Package   string   `json:"package"`
+	Versions  []string `json:"versions,omitempty"`
+	Copies    int      `json:"copies"`
+	Duplicate bool     `json:"duplicate,omitempty"`
+	Conflict  bool     `json:"conflict,omitempty"`
+}
+
+type graphJSONEdge struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Sites []string `json:"sites,omitempty"`
+}
+
+type graphJSON struct {
+	Nodes []*graphNode     `json:"nodes"`
+	Edges []*graphJSONEdge `json:"edges"`
+}
+
+// doGraph prints the dependency graph as "from -> to" lines, or with format
+// as Graphviz dot, Mermaid or JSON, labeling packages with their versions
+// and highlighting duplicates and conflicts. With whyEdges, every edge lists
+// the file:line positions of the imports that create it. With internal, the
+// project is split into its own packages, with the edges between them and
+// from each of them to the dependencies it imports.
+func doGraph(dir string, format string, whyEdges bool, internal bool) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	switch format {
+	case "", "dot", "mermaid", "json":
+	default:
+		output.Errorf("Unknown format %q, expected dot, mermaid or json", format)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	var edges []*graphEdge
+	if data.Layout == layoutFlat {
+		edges, err = collectFlatEdges(dir, data.Package, data.Dependencies, whyEdges)
+	} else {
+		edges, err = collectEdges(dir, dir, data.Package, data.Dependencies, whyEdges)
+	}
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if internal {
+		external := edges
+		if edges, err = collectInternalEdges(dir, data.Package, data.Dependencies, whyEdges); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		for _, edge := range external {
+			if edge.from != data.Package {
+				edges = append(edges, edge)
+			}
+		}
+	}
+	nodes := collectGraphNodes(data.Package, data.Dependencies, edges)
+
+	graph := newGraphJSON(nodes, edges)
+	if format == "json" {
+		output.writeJSON(graph)
+		return 0
+	}
+	output.Result(graph, func() {
+		switch format {
+		case "dot":
+			printDotGraph(dir, nodes, edges)
+		case "mermaid":
+			printMermaidGraph(nodes, edges)
+		default:
+			printEdges(edges)
+		}
+	})
+	return 0
+}
+
+func printEdges(edges []*graphEdge) {
+	for _, edge := range edges {
+		fmt.Printf("%s -> %s\n", edge.from, edge.to)
+		for _, site := range edge.sites {
+			fmt.Printf("    %s\n", site)
+		}
+	}
+}
+
+// collectGraphNodes returns a node for every package of the edges, with the
+// versions of every copy vendored.
+func collectGraphNodes(project string, dependencies map[string]*bpmEntry, edges []*graphEdge) []*graphNode {
+	byPackage := make(map[string]*graphNode)
+	var walk func(dependencies map[string]*bpmEntry)
+	walk = func(dependencies map[string]*bpmEntry) {
+		for _, pkg := range sortedKeys(dependencies) {
+			entry := dependencies[pkg]
+			node, ok := byPackage[pkg]
+			if !ok {
+				node = &graphNode{Package: pkg}
+				byPackage[pkg] = node
+			}
+			node.Copies++
+			if version := graphVersion(entry); !containsString(node.Versions, version) {
+				node.Versions = append(node.Versions, version)
+			}
+			walk(entry.Dependencies)
+		}
+	}
+	walk(dependencies)
+
+	nodes := make([]*graphNode, 0)
+	added := make(map[string]bool)
+	add := func(pkg string) {
+		if added[pkg] {
+			return
+		}
+		added[pkg] = true
+		node, ok := byPackage[pkg]
+		if !ok {
+			node = &graphNode{Package: pkg}
+		}
+		node.Duplicate = node.Copies > 1
+		node.Conflict = len(node.Versions) > 1
+		nodes = append(nodes, node)
+	}
+	add(project)
+	for _, edge := range edges {
+		add(edge.from)
+		add(edge.to)
+	}
+	return nodes
+}
+
+// graphVersion labels an entry with its tag, else its branch and commit.
+func graphVersion(entry *bpmEntry) string {
+	switch {
+	case entry.Tag != "":
+		return entry.Tag
+	case entry.Commit != "" && entry.Branch != "":
+		return entry.Branch + "@" + shortHash(entry.Commit)
+	case entry.Commit != "":
+		return shortHash(entry.Commit)
+	case entry.SHA256 != "":
+		return "sha256:" + shortHash(entry.SHA256)
+	}
+	return "unpinned"
+}
+
+func collectEdges(root string, dir string, from string, dependencies map[string]*bpmEntry, whyEdges bool) ([]*graphEdge, error) {
+	edges := make([]*graphEdge, 0)
+	var files []string
+	if whyEdges {
+		sources, err := getAllSourceFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		files = *sources
+	}
+	for _, pkg := range sortedKeys(dependencies) {
+		edge := &graphEdge{from: from, to: pkg}
+		if whyEdges {
+			edge.sites = findImportSites(root, files, pkg)
+		}
+		edges = append(edges, edge)
+	}
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		if isAssets(entry) {
+			continue
+		}
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if fileExists(pkgDir) {
+			nested, err := collectEdges(root, pkgDir, pkg, entry.Dependencies, whyEdges)
+			if err != nil {
+				return nil, err
+			}
+			edges = append(edges, nested...)
+		}
+	}
+	return edges, nil
+}
+
+// collectInternalEdges scans the project's own packages for imports of each
+// other and of the dependencies.
+func collectInternalEdges(dir string, project string, dependencies map[string]*bpmEntry, whyEdges bool) ([]*graphEdge, error) {
+	files, err := getAllSourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*graphEdge)
+	keys := make([]string, 0)
+	for _, fname := range *files {
+		fs := token.NewFileSet()
+		f, err := parser.ParseFile(fs, fname, nil, parser.ImportsOnly)
+		if err != nil {
+			log.Printf("Skipping %s: %s", fname, err)
+			continue
+		}
+		from := project
+		if rel, err := filepath.Rel(dir, filepath.Dir(fname)); err == nil && rel != "." {
+			from = project + "/" + filepath.ToSlash(rel)
+		}
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			to := ""
+			if path == project || strings.HasPrefix(path, project+"/") {
+				to = path
+			} else {
+				for pkg := range dependencies {
+					if (path == pkg || strings.HasPrefix(path, pkg+"/")) && len(pkg) > len(to) {
+						to = pkg
+					}
+				}
+			}
+			if to == "" || to == from {
+				continue
+			}
+			key := from + " -> " + to
+			edge, ok := byKey[key]
+			if !ok {
+				edge = &graphEdge{from: from, to: to}
+				byKey[key] = edge
+				keys = append(keys, key)
+			}
+			if whyEdges {
+				rel, err := filepath.Rel(dir, fname)
+				if err != nil {
+					rel = fname
+				}
+				edge.sites = append(edge.sites, fmt.Sprintf("%s:%d", filepath.ToSlash(rel), fs.Position(spec.Pos()).Line))
+			}
+		}
+	}
+	sort.Strings(keys)
+	edges := make([]*graphEdge, 0, len(keys))
+	for _, key := range keys {
+		edges = append(edges, byKey[key])
+	}
+	return edges, nil
+}
+
+// findImportSites returns the file:line positions, relative to root, of the
+// imports of pkg or its subpackages in files.
+func findImportSites(root string, files []string, pkg string) []string {
+	sites := make([]string, 0)
+	for _, fname := range files {
+		src, err := ioutil.ReadFile(fname)
+		if err != nil {
+			log.Printf("Skipping %s: %s", fname, err)
+			continue
+		}
+		fs := token.NewFileSet()
+		f, err := parser.ParseFile(fs, fname, src, parser.ImportsOnly)
+		if err != nil {
+			log.Printf("Skipping %s: %s", fname, err)
+			continue
+		}
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || (path != pkg && !strings.HasPrefix(path, pkg+"/")) {
+				continue
+			}
+			rel, err := filepath.Rel(root, fname)
+			if err != nil {
+				rel = fname
+			}
+			sites = append(sites, fmt.Sprintf("%s:%d", filepath.ToSlash(rel), fs.Position(spec.Pos()).Line))
+		}
+	}
+	return sites
+}
+
+func printDotGraph(root string, nodes []*graphNode, edges []*graphEdge) {
+	fmt.Println("digraph bpm {")
+	for _, node := range nodes {
+		attrs := fmt.Sprintf("label=%s", strconv.Quote(graphLabel(node, "\n")))
+		switch {
+		case node.Conflict:
+			attrs += ", color=red, style=filled, fillcolor=mistyrose"
+		case node.Duplicate:
+			attrs += ", color=orange, style=filled, fillcolor=lightyellow"
+		}
+		fmt.Printf("  %s [%s];\n", strconv.Quote(node.Package), attrs)
+	}
+	for _, edge := range edges {
+		attrs := ""
+		if len(edge.sites) > 0 {
+			first := strings.SplitN(edge.sites[0], ":", 2)[0]
+			attrs = fmt.Sprintf(" [tooltip=%s, URL=%s]",
+				strconv.Quote(strings.Join(edge.sites, "\n")),
+				strconv.Quote("file://"+filepath.ToSlash(filepath.Join(root, first))))
+		}
+		fmt.Printf("  %s -> %s%s;\n", strconv.Quote(edge.from), strconv.Quote(edge.to), attrs)
+	}
+	fmt.Println("}")
+}
+
+// printMermaidGraph prints a Mermaid flowchart. Mermaid ids can't contain
+// the slashes and dots of package paths, so nodes are numbered.
+func printMermaidGraph(nodes []*graphNode, edges []*graphEdge) {
+	fmt.Println("graph LR")
+	ids := make(map[string]string)
+	for i, node := range nodes {
+		ids[node.Package] = fmt.Sprintf("n%d", i)
+		label := strings.Replace(graphLabel(node, "<br/>"), `"`, "#quot;", -1)
+		fmt.Printf("  %s[\"%s\"]\n", ids[node.Package], label)
+	}
+	for _, edge := range edges {
+		fmt.Printf("  %s --> %s\n", ids[edge.from], ids[edge.to])
+	}
+	fmt.Println("  classDef conflict fill:#fdd,stroke:#c00")
+	fmt.Println("  classDef duplicate fill:#ffd,stroke:#e90")
+	for _, node := range nodes {
+		switch {
+		case node.Conflict:
+			fmt.Printf("  class %s conflict\n", ids[node.Package])
+		case node.Duplicate:
+			fmt.Printf("  class %s duplicate\n", ids[node.Package])
+		}
+	}
+}
+
+func newGraphJSON(nodes []*graphNode, edges []*graphEdge) *graphJSON {
+	graph := &graphJSON{Nodes: nodes, Edges: make([]*graphJSONEdge, 0, len(edges))}
+	for _, edge := range edges {
+		graph.Edges = append(graph.Edges, &graphJSONEdge{From: edge.from, To: edge.to, Sites: edge.sites})
+	}
+	return graph
+}
+
+// graphLabel is the package of a node over its versions.
+func graphLabel(node *graphNode, separator string) string {
+	if len(node.Versions) == 0 {
+		return node.Package
+	}
+	return node.Package + separator + strings.Join(node.Versions, ", ")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const prePublishHook = "prePublish"
+
+// runHooks runs the commands of a hook with the env variables of the
+// dependencies, stopping at the first one failing.
+func runHooks(dir string, data *bpmPackage, name string) error {
+	env := projectEnv(dir, data.Dependencies)
+	for _, command := range data.Hooks[name] {
+		log.Printf("Running %s hook: %s", name, command)
+		if err := shellCommand(dir, command, env).Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %s", name, command, err)
+		}
+	}
+	return nil
+}
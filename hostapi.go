@@ -0,0 +1,257 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hostAPIConfig selects the API of a git host in the global config, for
+// self-hosted servers whose name doesn't tell, e.g.
+//
+//	"hostAPIs": {"git.corp.example": {"type": "gitea", "tokenEnv": "CORP_GITEA_TOKEN"}}
+//
+// Type is github, gitlab, gitea or gogs. URL defaults to the API root of the
+// type on the host, and TokenEnv to GITHUB_TOKEN, GITLAB_TOKEN, GITEA_TOKEN or
+// GOGS_TOKEN.
+type hostAPIConfig struct {
+	Type     string `json:"type"`
+	URL      string `json:"url,omitempty"`
+	TokenEnv string `json:"tokenEnv,omitempty"`
+}
+
+// hostRepository is the metadata a host keeps about a repository.
+type hostRepository struct {
+	Description string
+	Archived    bool
+	// Size is the size of the repository in bytes, 0 when unknown.
+	Size int64
+}
+
+// hostAPI reads repository metadata from the API of a git host. Both methods
+// return nil without an error when the host doesn't have the resource.
+type hostAPI interface {
+	repository(path string) (*hostRepository, error)
+	release(path string, tag string) (*releaseNotes, error)
+}
+
+// getHostAPI returns the API adapter of the host serving repoURL and the
+// owner/name path of the repository, or nil for hosts without a known API.
+func getHostAPI(repoURL string) (hostAPI, string) {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil, ""
+	}
+	path := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	config, ok := getGlobalConfig().HostAPIs[u.Host]
+	if !ok {
+		config, ok = getGlobalConfig().HostAPIs[u.Hostname()]
+	}
+	if !ok {
+		config = &hostAPIConfig{Type: guessHostType(u.Hostname())}
+	}
+	base := "https://" + u.Host
+	switch config.Type {
+	case "github":
+		if u.Hostname() == "github.com" {
+			base = "https://api.github.com"
+		} else {
+			base += "/api/v3"
+		}
+		return &githubAPI{base: apiBase(config, base), token: apiToken(config, "GITHUB_TOKEN")}, path
+	case "gitlab":
+		return &gitlabAPI{base: apiBase(config, base+"/api/v4"), token: apiToken(config, "GITLAB_TOKEN")}, path
+	case "gitea":
+		return &giteaAPI{base: apiBase(config, base+"/api/v1"), token: apiToken(config, "GITEA_TOKEN")}, path
+	case "gogs":
+		return &giteaAPI{base: apiBase(config, base+"/api/v1"), token: apiToken(config, "GOGS_TOKEN"), gogs: true}, path
+	}
+	return nil, ""
+}
+
+// guessHostType recognizes the public hosts and servers named after their
+// software.
+func guessHostType(hostname string) string {
+	switch {
+	case hostname == "github.com":
+		return "github"
+	case strings.Contains(hostname, "gitlab"):
+		return "gitlab"
+	case hostname == "codeberg.org" || strings.Contains(hostname, "gitea"):
+		return "gitea"
+	case strings.Contains(hostname, "gogs"):
+		return "gogs"
+	}
+	return ""
+}
+
+func apiBase(config *hostAPIConfig, def string) string {
+	if config.URL != "" {
+		return strings.TrimSuffix(config.URL, "/")
+	}
+	return def
+}
+
+func apiToken(config *hostAPIConfig, def string) string {
+	if config.TokenEnv != "" {
+		return os.Getenv(config.TokenEnv)
+	}
+	return os.Getenv(def)
+}
+
+// getHostRepository reads the host metadata of a git dependency, logging
+// failures. It returns nil for archives and hosts without a known API.
+func getHostRepository(pkg string, entry *bpmEntry) *hostRepository {
+	if entry.Archive != "" {
+		return nil
+	}
+	api, path := getHostAPI(getEntryURL(pkg, entry))
+	if api == nil {
+		return nil
+	}
+	repo, err := api.repository(path)
+	if err != nil {
+		log.Printf("Could not read %s from its host: %s", pkg, err)
+	}
+	return repo
+}
+
+// findArchived lists the direct dependencies archived on their host.
+func findArchived(dependencies map[string]*bpmEntry) []string {
+	archived := make([]string, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		if repo := getHostRepository(pkg, dependencies[pkg]); repo != nil && repo.Archived {
+			archived = append(archived, pkg)
+		}
+	}
+	return archived
+}
+
+type githubAPI struct {
+	base  string
+	token string
+}
+
+func (a *githubAPI) repository(path string) (*hostRepository, error) {
+	var repo struct {
+		Description string `json:"description"`
+		Archived    bool   `json:"archived"`
+		Size        int64  `json:"size"`
+	}
+	found, err := getJSON(a.base+"/repos/"+path, "Authorization", "token", a.token, &repo)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &hostRepository{Description: repo.Description, Archived: repo.Archived, Size: repo.Size * 1024}, nil
+}
+
+func (a *githubAPI) release(path string, tag string) (*releaseNotes, error) {
+	var release struct {
+		Name    string `json:"name"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	}
+	found, err := getJSON(a.base+"/repos/"+path+"/releases/tags/"+url.PathEscape(tag), "Authorization", "token", a.token, &release)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &releaseNotes{Tag: tag, Name: release.Name, Body: release.Body, URL: release.HTMLURL}, nil
+}
+
+type gitlabAPI struct {
+	base  string
+	token string
+}
+
+func (a *gitlabAPI) repository(path string) (*hostRepository, error) {
+	var project struct {
+		Description string `json:"description"`
+		Archived    bool   `json:"archived"`
+		Statistics  *struct {
+			RepositorySize int64 `json:"repository_size"`
+		} `json:"statistics"`
+	}
+	found, err := getJSON(a.base+"/projects/"+url.PathEscape(path)+"?statistics=true", "PRIVATE-TOKEN", "", a.token, &project)
+	if !found || err != nil {
+		return nil, err
+	}
+	repo := &hostRepository{Description: project.Description, Archived: project.Archived}
+	if project.Statistics != nil {
+		repo.Size = project.Statistics.RepositorySize
+	}
+	return repo, nil
+}
+
+func (a *gitlabAPI) release(path string, tag string) (*releaseNotes, error) {
+	var release struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Links       struct {
+			Self string `json:"self"`
+		} `json:"_links"`
+	}
+	found, err := getJSON(a.base+"/projects/"+url.PathEscape(path)+"/releases/"+url.PathEscape(tag), "PRIVATE-TOKEN", "", a.token, &release)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &releaseNotes{Tag: tag, Name: release.Name, Body: release.Description, URL: release.Links.Self}, nil
+}
+
+// giteaAPI covers Gitea and Gogs, which share the API Gitea was forked with.
+// Gogs reports sizes in bytes, has no archived repositories and only lists
+// releases.
+type giteaAPI struct {
+	base  string
+	token string
+	gogs  bool
+}
+
+type giteaRelease struct {
+	TagName string `json:"tag_name"`
+	Name    string `json:"name"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+}
+
+func (a *giteaAPI) repository(path string) (*hostRepository, error) {
+	var repo struct {
+		Description string `json:"description"`
+		Archived    bool   `json:"archived"`
+		Size        int64  `json:"size"`
+	}
+	found, err := getJSON(a.base+"/repos/"+path, "Authorization", "token", a.token, &repo)
+	if !found || err != nil {
+		return nil, err
+	}
+	size := repo.Size * 1024
+	if a.gogs {
+		size = repo.Size
+	}
+	return &hostRepository{Description: repo.Description, Archived: repo.Archived, Size: size}, nil
+}
+
+func (a *giteaAPI) release(path string, tag string) (*releaseNotes, error) {
+	var release giteaRelease
+	if a.gogs {
+		var releases []giteaRelease
+		found, err := getJSON(a.base+"/repos/"+path+"/releases", "Authorization", "token", a.token, &releases)
+		if !found || err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			if r.TagName == tag {
+				release = r
+			}
+		}
+		if release.TagName == "" {
+			return nil, nil
+		}
+	} else {
+		found, err := getJSON(a.base+"/repos/"+path+"/releases/tags/"+url.PathEscape(tag), "Authorization", "token", a.token, &release)
+		if !found || err != nil {
+			return nil, err
+		}
+	}
+	return &releaseNotes{Tag: tag, Name: release.Name, Body: release.Body, URL: release.HTMLURL}, nil
+}
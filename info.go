@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// infoReport is the result of info with -json.
+type infoReport struct {
+	Package string `json:"package"`
+	URL     string `json:"url"`
+	*bpmEntry
+	Owner     string `json:"owner,omitempty"`
+	Vendored  string `json:"vendored"`
+	Nested    int    `json:"nested"`
+	About     string `json:"about,omitempty"`
+	Archived  bool   `json:"archived,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// doInfo prints everything recorded about a dependency, including the
+// provenance of the tag it is pinned to.
+func doInfo(dir string, pkg string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if pkg == "" {
+		output.Errorf("No package given: bpm info -p <pkg>")
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	entry, entryDir := findDependency(dir, data.Dependencies, pkg)
+	if entry == nil {
+		output.Errorf("%s is not a dependency", pkg)
+		return 1
+	}
+	pkgDir := filepath.Join(entryDir, vendorFolderName, vendorPath(pkg))
+
+	fields := *entry
+	fields.Dependencies = nil
+	info := &infoReport{
+		Package:  pkg,
+		URL:      getEntryURL(pkg, entry),
+		bpmEntry: &fields,
+		Owner:    resolveOwners(data)[pkg],
+		Vendored: pkgDir,
+		Nested:   len(entry.Dependencies)}
+	if repo := getHostRepository(pkg, entry); repo != nil {
+		info.About, info.Archived = repo.Description, repo.Archived
+	}
+	if entry.TagInfo != nil && entry.TagInfo.Signature != "" && isGitRepo(pkgDir) {
+		info.Signature = "unverified"
+		if gitCommand(&pkgDir, "verify-tag", entry.Tag).Run() == nil {
+			info.Signature = "verified"
+		}
+	}
+	output.Result(info, func() {
+		printInfo(info)
+	})
+	return 0
+}
+
+func printInfo(info *infoReport) {
+	entry := info.bpmEntry
+	fmt.Printf("Package:   %s\n", info.Package)
+	printInfoField("URL", info.URL)
+	printInfoField("Upstream", entry.Upstream)
+	printInfoField("Branch", entry.Branch)
+	printInfoField("Version", entry.Version)
+	printInfoField("Tag", entry.Tag)
+	printInfoField("Commit", entry.Commit)
+	printInfoField("Kind", entry.Kind)
+	printInfoField("Owner", info.Owner)
+	printInfoField("Alias", entry.Alias)
+	printInfoField("Subdir", entry.Subdir)
+	printInfoField("Archive", entry.Archive)
+	printInfoField("SHA256", entry.SHA256)
+	for _, variable := range sortedStringKeys(entry.Env) {
+		printInfoField("Env", variable+"="+entry.Env[variable])
+	}
+	printInfoField("Vendored", info.Vendored)
+	fmt.Printf("Nested:    %d dependencies\n", info.Nested)
+	printInfoField("About", info.About)
+	if info.Archived {
+		printInfoField("Archived", "yes, the repository is read-only on its host")
+	}
+
+	if tag := entry.TagInfo; tag != nil {
+		fmt.Printf("\nTag %s, %s\n", entry.Tag, tag)
+		if info.Signature != "" {
+			fmt.Printf("Signature %s\n", info.Signature)
+		}
+		if tag.Message != "" {
+			fmt.Printf("\n    %s\n", strings.Replace(tag.Message, "\n", "\n    ", -1))
+		}
+	} else if entry.Tag != "" {
+		fmt.Printf("\nTag %s is a lightweight tag without metadata\n", entry.Tag)
+	}
+}
+
+func printInfoField(name string, value string) {
+	if value != "" {
+		fmt.Printf("%-10s %s\n", name+":", value)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// exitInterrupted is returned when Ctrl+C or SIGTERM stopped a command, as
+// shells do for SIGINT.
+const exitInterrupted = 130
+
+// incompleteMarkerSuffix names the file next to a vendored package that marks
+// it while a pull changes it. A pull that doesn't finish leaves it behind,
+// and the next pull clones the package again rather than trusting a
+// checkout stopped halfway. It sits beside the folder so a clone into the
+// folder still finds it empty.
+const incompleteMarkerSuffix = ".bpm-incomplete"
+
+var errInterrupted = errors.New("interrupted")
+
+// runContext is cancelled on Ctrl+C or SIGTERM. Every command bpm runs,
+// git above all, is killed with it.
+var runContext, cancelRun = context.WithCancel(context.Background())
+
+// handleInterrupts cancels runContext on the first Ctrl+C or SIGTERM, so
+// running pulls stop and clean up after themselves, and exits on the second.
+func handleInterrupts() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, stopping. Press Ctrl+C again to quit at once", sig)
+		cancelRun()
+		<-signals
+		log.Printf("Quitting without cleaning up")
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// interrupted returns errInterrupted once the command was interrupted.
+func interrupted() error {
+	if runContext.Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
+
+func markIncomplete(pkgDir string) error {
+	if err := createDir(filepath.Dir(pkgDir)); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pkgDir+incompleteMarkerSuffix, nil, 0644)
+}
+
+func isIncomplete(pkgDir string) bool {
+	return fileExists(pkgDir + incompleteMarkerSuffix)
+}
+
+// clearIncomplete removes the marker of markIncomplete. A marker left behind
+// only makes the next install pull the package again, so failing is logged.
+func clearIncomplete(pkgDir string) {
+	if err := os.Remove(pkgDir + incompleteMarkerSuffix); err != nil && !os.IsNotExist(err) {
+		log.Printf("Could not clear the incomplete marker of %s: %s", pkgDir, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var licenseFilePattern = regexp.MustCompile(`(?i)^(licen[cs]e|copying)(\.(md|txt|rst))?$`)
+
+type licenseRule struct {
+	name   string
+	checks []string
+}
+
+// licenseRules are tried in order against the normalized license text; more
+// specific licenses come before the ones they contain.
+var licenseRules = []licenseRule{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"MIT", []string{"mit license"}},
+	{"Unlicense", []string{"this is free and unencumbered software"}},
+}
+
+// detectLicense identifies the license of a vendored package from its
+// license file. It returns "None" without a license file and "Unknown" when
+// the text isn't recognized.
+func detectLicense(pkgDir string) string {
+	files, err := ioutil.ReadDir(pkgDir)
+	if err != nil {
+		return "None"
+	}
+	for _, f := range files {
+		if f.IsDir() || !licenseFilePattern.MatchString(f.Name()) {
+			continue
+		}
+		text, err := ioutil.ReadFile(filepath.Join(pkgDir, f.Name()))
+		if err != nil {
+			continue
+		}
+		return classifyLicense(string(text))
+	}
+	return "None"
+}
+
+func classifyLicense(text string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	for _, rule := range licenseRules {
+		matched := true
+		for _, check := range rule.checks {
+			if !strings.Contains(normalized, check) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule.name
+		}
+	}
+	return "Unknown"
+}
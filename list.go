@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const defaultListFormat = "{{.Indent}}{{.Package}} {{.Branch}} {{short .Commit}}"
+
+// listItem is the data available to list -format templates.
+type listItem struct {
+	Package  string `json:"package"`
+	URL      string `json:"url"`
+	Upstream string `json:"upstream,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	License  string `json:"license"`
+	Depth    int    `json:"depth"`
+	Indent   string `json:"-"`
+	Dir      string `json:"dir"`
+}
+
+const licenseListFormat = "{{.Indent}}{{.Package}} {{.License}}"
+
+func doList(dir string, format string, licenses bool, report csvReport) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	items := listDependencies(dir, data.Dependencies, 0)
+
+	if report.enabled {
+		columns := []string{"Package", "Branch", "Commit"}
+		if licenses {
+			columns = []string{"Package", "License", "URL"}
+		}
+		if err := report.write(os.Stdout, items, columns); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		return 0
+	}
+	if output.mode != outputPlain {
+		output.Result(items, nil)
+		return 0
+	}
+	if format == "" {
+		format = defaultListFormat
+		if licenses {
+			format = licenseListFormat
+		}
+	}
+	tmpl, err := template.New("list").Funcs(template.FuncMap{
+		"short": shortHash,
+		"join":  strings.Join,
+	}).Parse(format + "\n")
+	if err != nil {
+		output.Errorf("Invalid format: %s", err)
+		return 1
+	}
+
+	for _, item := range items {
+		if err := tmpl.Execute(os.Stdout, item); err != nil {
+			output.Errorf("Invalid format: %s", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// listDependencies walks the dependency tree depth first in package order.
+func listDependencies(dir string, dependencies map[string]*bpmEntry, depth int) []*listItem {
+	result := make([]*listItem, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		result = append(result, &listItem{
+			Package:  pkg,
+			URL:      getEntryURL(pkg, entry),
+			Upstream: entry.Upstream,
+			Branch:   entry.Branch,
+			Commit:   entry.Commit,
+			Kind:     entry.Kind,
+			License:  detectLicense(pkgDir),
+			Depth:    depth,
+			Indent:   strings.Repeat("  ", depth),
+			Dir:      pkgDir})
+		result = append(result, listDependencies(pkgDir, entry.Dependencies, depth+1)...)
+	}
+	return result
+}
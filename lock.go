@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+const lockFilename = "bpm.lock"
+
+// bpmLock holds what resolving wrote next to a manifest: the environment
+// resolution ran in, the exact dependency tree of the project, and at a
+// workspace root the pins shared by every manifest below it, by package.
+type bpmLock struct {
+	Environment  *bpmEnvironment      `json:"environment,omitempty"`
+	Dependencies map[string]*bpmEntry `json:"dependencies,omitempty"`
+	Workspace    map[string]*bpmEntry `json:"workspace,omitempty"`
+}
+
+func writeLockFile(filename string, lock *bpmLock) error {
+	bytes, err := jsonEncodeIndented(lock)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, bytes)
+}
+
+func readLockFile(filename string) (*bpmLock, error) {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lock := bpmLock{}
+	if err = json.Unmarshal(bytes, &lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", filename, err)
+	}
+	return &lock, nil
+}
+
+// mergeLock returns the locked dependency tree for the dependencies a
+// manifest declares. Declared settings win over locked ones, and a locked pin
+// is dropped when the declaration it was resolved for changed. A pin in the
+// manifest itself, as written before lock files, wins over the lock. The
+// indirect dependencies of a flat layout come from the lock alone.
+func mergeLock(declared map[string]*bpmEntry, locked map[string]*bpmEntry) map[string]*bpmEntry {
+	result := make(map[string]*bpmEntry, len(declared))
+	for pkg, entry := range declared {
+		lockedEntry, ok := locked[pkg]
+		if !ok || entry.Commit != "" || (entry.SHA256 != "" && !isArtifact(entry)) || !lockMatches(entry, lockedEntry) {
+			result[pkg] = entry
+			continue
+		}
+		merged := *lockedEntry
+		if entry.Branch != "" && hasFixedBranch(entry) {
+			merged.Branch = entry.Branch
+		}
+		if entry.URL != "" {
+			merged.URL = entry.URL
+		}
+		merged.BranchPattern = entry.BranchPattern
+		merged.Channel = entry.Channel
+		merged.Version = entry.Version
+		merged.Ref = entry.Ref
+		merged.AllowPrerelease = entry.AllowPrerelease
+		merged.Test = entry.Test
+		if entry.Version == "" {
+			merged.Tag = entry.Tag
+		}
+		merged.Env = entry.Env
+		merged.Owner = entry.Owner
+		merged.Kind = entry.Kind
+		merged.Alias = entry.Alias
+		merged.Archive = entry.Archive
+		merged.Subdir = entry.Subdir
+		if entry.MergeConflict != "" {
+			merged.MergeConflict = entry.MergeConflict
+		}
+		result[pkg] = &merged
+	}
+	for pkg, entry := range locked {
+		if _, ok := declared[pkg]; !ok && entry.Indirect {
+			result[pkg] = entry
+		}
+	}
+	return result
+}
+
+func lockMatches(entry *bpmEntry, locked *bpmEntry) bool {
+	if entry.URL != "" && entry.URL != locked.URL {
+		return false
+	}
+	if entry.Branch != "" && hasFixedBranch(entry) && entry.Branch != locked.Branch {
+		return false
+	}
+	if entry.Tag != "" && entry.Version == "" && entry.Tag != locked.Tag {
+		return false
+	}
+	if isArtifact(entry) && entry.SHA256 != locked.SHA256 {
+		return false
+	}
+	if entry.Ref != locked.Ref {
+		return false
+	}
+	return entry.Version == locked.Version && entry.Alias == locked.Alias && entry.Archive == locked.Archive && entry.Subdir == locked.Subdir
+}
+
+// hasFixedBranch is whether an entry follows its branch, rather than a branch
+// pattern, channel, tag or version constraint.
+func hasFixedBranch(entry *bpmEntry) bool {
+	return entry.BranchPattern == "" && entry.Channel == "" && entry.Version == "" && entry.Tag == "" && entry.Ref == ""
+}
+
+// declaredDependencies strips a dependency tree down to what the manifest
+// declares: the direct dependencies and their constraints, without the
+// commits, tags, checksums, recorded upstreams, default URLs and indirect
+// dependencies that go into the lock file. Artifacts keep their checksum,
+// declared with their version.
+func declaredDependencies(dependencies map[string]*bpmEntry) map[string]*bpmEntry {
+	result := make(map[string]*bpmEntry, len(dependencies))
+	for pkg, entry := range dependencies {
+		if entry.Indirect {
+			continue
+		}
+		declared := &bpmEntry{
+			URL:           entry.URL,
+			Branch:        entry.Branch,
+			BranchPattern: entry.BranchPattern,
+			Channel:       entry.Channel,
+			Version:       entry.Version,
+			Ref:           entry.Ref,
+			Kind:          entry.Kind,
+			Alias:         entry.Alias,
+			Archive:       entry.Archive,
+			Subdir:        entry.Subdir,
+			Env:           entry.Env,
+			Owner:         entry.Owner,
+			MergeConflict: entry.MergeConflict}
+		if declared.URL == getEntryURL(pkg, &bpmEntry{Alias: entry.Alias, Subdir: entry.Subdir}) {
+			declared.URL = ""
+		}
+		if entry.Version == "" {
+			declared.Tag = entry.Tag
+		}
+		declared.AllowPrerelease = entry.AllowPrerelease
+		if isArtifact(entry) {
+			declared.SHA256 = entry.SHA256
+		}
+		if !hasFixedBranch(declared) {
+			declared.Branch = ""
+		}
+		result[pkg] = declared
+	}
+	return result
+}
+
+// flattenDependencies collects every entry of a dependency tree by package.
+func flattenDependencies(dependencies map[string]*bpmEntry, into map[string][]*bpmEntry) {
+	for pkg, entry := range dependencies {
+		into[pkg] = append(into[pkg], entry)
+		flattenDependencies(entry.Dependencies, into)
+	}
+}
+
+func sortedKeys(dependencies map[string]*bpmEntry) []string {
+	keys := make([]string, 0, len(dependencies))
+	for pkg := range dependencies {
+		keys = append(keys, pkg)
+	}
+	sort.Strings(keys)
+	return keys
+}
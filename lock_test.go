@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeLock(t *testing.T) {
+	const pkg = "github.com/lib/a"
+	tests := []struct {
+		name     string
+		declared *bpmEntry
+		locked   *bpmEntry
+		// expected is the merged entry as branch, commit and tag, or ""
+		// when the declared entry is taken as it is.
+		expected string
+	}{
+		{
+			name:     "locked commit",
+			declared: &bpmEntry{},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1"},
+			expected: "master a1 ",
+		},
+		{
+			name:     "declared commit",
+			declared: &bpmEntry{Commit: "a2"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1"},
+		},
+		{
+			name:     "not locked",
+			declared: &bpmEntry{Branch: "dev"},
+		},
+		{
+			name:     "branch changed",
+			declared: &bpmEntry{Branch: "dev"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1"},
+		},
+		{
+			name:     "url changed",
+			declared: &bpmEntry{URL: "https://example.com/fork.git"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1", URL: "https://example.com/a.git"},
+		},
+		{
+			name:     "tag changed",
+			declared: &bpmEntry{Tag: "v1.1.0"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1", Tag: "v1.0.0"},
+		},
+		{
+			name:     "same tag",
+			declared: &bpmEntry{Tag: "v1.0.0"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1", Tag: "v1.0.0"},
+			expected: "master a1 v1.0.0",
+		},
+		{
+			name:     "version keeps the locked tag",
+			declared: &bpmEntry{Version: "^1.0"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1", Tag: "v1.2.0", Version: "^1.0"},
+			expected: "master a1 v1.2.0",
+		},
+		{
+			name:     "version changed",
+			declared: &bpmEntry{Version: "^2.0"},
+			locked:   &bpmEntry{Branch: "master", Commit: "a1", Tag: "v1.2.0", Version: "^1.0"},
+		},
+		{
+			name:     "ref changed",
+			declared: &bpmEntry{Ref: "refs/pull/1/head"},
+			locked:   &bpmEntry{Commit: "a1"},
+		},
+		{
+			name:     "artifact version changed",
+			declared: &bpmEntry{Archive: "https://example.com/a-{version}.tar.gz", Version: "1.1", SHA256: "s2"},
+			locked:   &bpmEntry{Archive: "https://example.com/a-{version}.tar.gz", Version: "1.0", SHA256: "s1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			locked := make(map[string]*bpmEntry)
+			if test.locked != nil {
+				locked[pkg] = test.locked
+			}
+			merged := mergeLock(map[string]*bpmEntry{pkg: test.declared}, locked)[pkg]
+			if test.expected == "" {
+				if merged != test.declared {
+					t.Errorf("merged %+v, expected the declared entry", merged)
+				}
+				return
+			}
+			if got := strings.Join([]string{merged.Branch, merged.Commit, merged.Tag}, " "); got != test.expected {
+				t.Errorf("merged %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestMergeLockIndirect(t *testing.T) {
+	declared := map[string]*bpmEntry{"github.com/lib/a": {}}
+	locked := map[string]*bpmEntry{
+		"github.com/lib/a":       {Commit: "a1"},
+		"github.com/lib/b":       {Commit: "b1", Indirect: true},
+		"github.com/lib/removed": {Commit: "r1"},
+	}
+	merged := mergeLock(declared, locked)
+	if got := strings.Join(sortedKeys(merged), ","); got != "github.com/lib/a,github.com/lib/b" {
+		t.Errorf("merged packages are %s, expected github.com/lib/a and the indirect github.com/lib/b", got)
+	}
+}
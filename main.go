@@ -14,7 +14,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/borislav-rangelov/bpm/commands"
 )
@@ -23,158 +25,818 @@ const dependencyFilename = "bpm.json"
 const vendorFolderName = "vendor"
 const gitFolderName = ".git"
 
+// partialClone is set by -partial-clone; the partialClone setting of the
+// global config enables it permanently.
+var partialClone = false
+
 func main() {
 
 	var (
-		c   = &commands.Commands{}
-		dir = ""
-		pkg = ""
+		c              = &commands.Commands{}
+		dir            = ""
+		chdir          = ""
+		pkg            = ""
+		quietIfCurrent = false
+		reportFile     = ""
+		force          = false
+		format         = ""
+		severity       = ""
+		onlyMajor      = false
+		listen         = ""
+		reason         = ""
+		whyEdges       = false
+		internal       = false
+		keepPins       = false
+		flat           = false
+		install        = installOptions{}
+		update         = updateOptions{}
+		outdated       = outdatedOptions{}
+		vet            = false
+		deep           = false
+		vendorDiff     = false
+		report         = csvReport{}
+		licenses       = false
+		jsonOutput     = false
+		quietOutput    = false
+		capture        = ""
+		benchCount     = ""
+		template       = ""
+		migrateFrom    = ""
+		exportFormat   = ""
+		exportDepsOut  = ""
+		sla            = false
 	)
 	c.Name = "Basic Package Manager"
 	c.MainCommand = "bpm"
-	c.NewCommand("init", func() {
-		doInit(getCurrentDir())
-	}, "Creates a bpm.json file in the current directory and gets all dependencies.")
-	c.NewCommand("install", func() {
-		doInstall(getDir(&dir))
-	}, "Pulls configured packages and version.")
-	c.NewCommand("update", func() {
-		doUpdate(getDir(&dir), pkg)
-	}, "Updates all or a specific package by pulling the latest commit on the specified branch.")
-	c.NewCommand("rebuild", func() {
-		doRebuild(getDir(&dir))
-	}, "Forgets all dependency data and pulls latest package versions.")
-	c.NewArg("-d", &dir, getCurrentDir(), "Root dir of project. Would pull all dependencies in $dir/vendor.")
-	c.NewArg("-p", &pkg, "", "Execute the specified command for a specific dependency package.")
-
+	withCSV := func(item *commands.CmdItem) *commands.CmdItem {
+		return item.
+			NewBoolArg("-csv", &report.enabled, "Print the report as CSV.").
+			NewArg("-columns", &report.columns, "", "Comma separated columns of the CSV report, e.g. Package,License.")
+	}
+	c.NewCommand("init", func(args []string) {
+		exit(doInit(getNewProjectDir(dir), flat, template))
+	}, "Creates a bpm.json file in the current directory and gets all dependencies.").
+		NewBoolArg("-flat", &flat, "Vendor every dependency once in the top level vendor folder, recorded as the flat layout in bpm.json.").
+		NewArg("-template", &template, "", "Seed bpm.json with the settings and dependencies of a template: service, library, cli or one from the global config.")
+	c.NewCommand("migrate", func(args []string) {
+		exit(doMigrate(getNewProjectDir(dir), migrateFrom))
+	}, "Creates bpm.json and bpm.lock from go.mod, Gopkg.lock, glide.lock or vendor/vendor.json, keeping their pinned versions.").
+		NewArg("-from", &migrateFrom, "", "Only migrate from this source: gomod, dep, glide or govendor.")
+	c.NewCommand("export", func(args []string) {
+		exit(doExport(getDir(&dir), exportFormat, force))
+	}, "Writes the locked dependencies for other tools: with -format gomod, a go.mod requiring them and replacing each with its vendor folder.").
+		NewArg("-format", &exportFormat, exportFormatGoMod, "Export format, gomod.").
+		NewBoolArg("-force", &force, "Overwrite an existing go.mod.")
+	c.NewCommand("export-deps", func(args []string) {
+		exit(doExportDeps(getDir(&dir), exportDepsOut))
+	}, "Prints a manifest of only the dependencies the public packages need, for projects others vendor with bpm.").
+		NewArg("-o", &exportDepsOut, "", "Write the manifest to this file instead.")
+	c.NewCommand("install", func(args []string) {
+		exit(doInstall(getDir(&dir), install))
+	}, "Pulls configured packages and version.").
+		NewBoolArg("-frozen", &install.frozen, "Fail if bpm.json isn't fully pinned, and never rewrite it.").
+		NewBoolArg("-check-space", &install.checkSpace, "Estimate the size of missing dependencies first and warn when the disk lacks room.").
+		NewBoolArg("-allow-over-budget", &install.overBudget, "Install even when the vendor size or dependency count exceeds the budget in bpm.json.").
+		NewBoolArg("-verify-signatures-report", &install.signatures, "List which vendored commits and tags are signed and by whom, without enforcing anything.").
+		NewBoolArg("-vendor-read-only", &install.readOnly, "Make all vendor files read-only, until bpm unlock.").
+		NewBoolArg("-force", &install.force, "Install over vendored files that changed since they were locked.").
+		NewBoolArg("-production", &install.production, "Skip the testDependencies of bpm.json, removing them from vendor, for deployment builds.").
+		NewBoolArg("-prune-orphans", &install.pruneOrphans, "Remove the vendor folders no dependency of bpm.json is vendored in, which install otherwise lists.").
+		NewBoolArg("-trace", &install.trace, "Send OpenTelemetry spans of the resolution, clone and checkout steps to the OTLP endpoint of OTEL_EXPORTER_OTLP_ENDPOINT.")
+	c.NewCommand("update", func(args []string) {
+		exit(doUpdate(getDir(&dir), pkg, update))
+	}, "Updates all or a specific package by pulling the latest commit on the specified branch.").
+		NewArg("-p", &pkg, "", "Only update this package.").
+		NewBoolArg("-verify-build", &update.verifyBuild, "Run compat afterwards, blaming compile errors on the updated packages first.").
+		NewBoolArg("-only-security", &update.onlySecurity, "Only update dependencies affected by known vulnerabilities, to the lowest fixed version.").
+		NewBoolArg("-run-dep-tests", &update.runDepTests, "Run go test inside the updated packages listed as critical in bpm.json.").
+		NewBoolArg("-stable-only", &update.stableOnly, "Never move to pre-release tags, even for dependencies with allowPrerelease.").
+		NewBoolArg("-preview", &update.preview, "List the moves update would make, with the release notes of the tags crossed, without changing anything.")
+	c.NewCommand("rebuild", func(args []string) {
+		exit(doRebuild(getDir(&dir), keepPins, flat))
+	}, "Forgets all dependency data and pulls latest package versions. With -keep-pins, packages still needed keep their commits.").
+		NewBoolArg("-keep-pins", &keepPins, "Keep the pinned commits of packages still needed, resolving only new packages fresh.").
+		NewBoolArg("-flat", &flat, "Vendor every dependency once in the top level vendor folder, recorded as the flat layout in bpm.json.")
+	c.NewCommand("unlock", func(args []string) {
+		exit(doUnlock(getDir(&dir)))
+	}, "Makes the vendor folder writable again after install -vendor-read-only.")
+	c.NewCommand("apply", func(args []string) {
+		exit(doApply(getDir(&dir), firstArg(args)))
+	}, "Adds, removes and pins dependencies from a change set file in one step, restoring the previous state if any change fails: bpm apply <changes.json>")
+	c.NewCommand("remove", func(args []string) {
+		exit(doRemove(getDir(&dir), firstArg(args), force))
+	}, "Removes a dependency from bpm.json and vendor, with its nested dependencies no other package uses: bpm remove <pkg>").
+		NewBoolArg("-force", &force, "Remove the dependency even though the project still imports it.")
+	c.NewCommand("info", func(args []string) {
+		exit(doInfo(getDir(&dir), pkg))
+	}, "Shows everything recorded about a dependency, with the tagger, message and signature of its annotated tag: bpm info -p <pkg>").
+		NewArg("-p", &pkg, "", "The dependency to show.")
+	c.NewCommand("run", func(args []string) {
+		exit(doRun(getDir(&dir), pkg, args))
+	}, "Runs a command with the env variables declared by the dependencies, or with -p in that package's folder with its own: bpm run [-p <pkg>] <command>").
+		NewArg("-p", &pkg, "", "Run in the folder of this dependency, with only its env variables.")
+	c.NewCommand("rollback", func(args []string) {
+		exit(doRollback(getDir(&dir), firstArg(args)))
+	}, "Restores bpm.json, bpm.lock and vendor to the snapshot taken before the last install, update or rebuild: bpm rollback [list]")
+	c.NewCommand("undo", func(args []string) {
+		exit(doUndo(getDir(&dir)))
+	}, "Restores bpm.json and bpm.lock from the backup taken before they were last rewritten, leaving vendor to bpm install.")
+	c.NewCommand("rewrite", func(args []string) {
+		exit(doRewrite(getDir(&dir)))
+	}, "Rewrites vendored import paths to the prefix configured in bpm.json.")
+	c.NewCommand("publish", func(args []string) {
+		exit(doPublish(getDir(&dir), firstArg(args)))
+	}, "Verifies the project and vendor state, then tags and pushes a release: bpm publish vX.Y.Z")
+	c.NewCommand("notes", func(args []string) {
+		exit(doNotes(getDir(&dir), pkg, firstArg(args)))
+	}, "Shows release notes between the pinned tag of -p and the latest (or given) tag: bpm notes -p <pkg> [tag]").
+		NewArg("-p", &pkg, "", "The dependency to show release notes of.")
+	c.NewCommand("check-updates", func(args []string) {
+		exit(doCheckUpdates(getDir(&dir), quietIfCurrent, reportFile))
+	}, "Checks for outdated or vulnerable dependencies. Exits 0 when current, 3 on updates, 4 on vulnerabilities.").
+		NewArg("-report", &reportFile, "bpm-check.json", "Report file, relative to the project dir.").
+		NewBoolArg("-quiet-if-current", &quietIfCurrent, "Print nothing when everything is current.")
+	c.NewCommand("workspace", func(args []string) {
+		exit(doWorkspace(getDir(&dir), firstArg(args)))
+	}, "Manages all bpm.json files below the project dir with a shared bpm.lock: bpm workspace [list|lock|install]")
+	c.NewCommand("vendor-check", func(args []string) {
+		exit(doVendorCheck(getDir(&dir)))
+	}, "Fails if vendored files changed since install without going through patches. Meant for pre-commit hooks.")
+	c.NewCommand("verify", func(args []string) {
+		exit(doVerify(getDir(&dir)))
+	}, "Compares every vendored package with the content hash recorded in bpm.lock, detecting tampering and incomplete clones.")
+	c.NewCommand("check", func(args []string) {
+		exit(doCheck(getDir(&dir), deep))
+	}, "Verifies bpm.json is frozen and vendor matches it. Meant for pre-commit hooks. With -deep, compares vendor with a fresh fetch of every pinned commit.").
+		NewBoolArg("-deep", &deep, "Fetch every pinned commit again from its repository and compare it byte by byte with vendor, bypassing mirrors and caches.")
+	c.NewCommand("merge-driver", func(args []string) {
+		exit(doMergeDriver(getDir(&dir), args))
+	}, "Merges bpm.json and bpm.lock entry by entry for git, keeping the newest pin of entries changed on both sides: bpm merge-driver install")
+	c.NewCommand("hooks", func(args []string) {
+		exit(doGitHooks(getDir(&dir), firstArg(args), force))
+	}, "Installs git hooks running check before commits and install -frozen after merges: bpm hooks install").
+		NewBoolArg("-force", &force, "Overwrite existing hooks not created by bpm.")
+	c.NewCommand("fixture", func(args []string) {
+		exit(doFixture(firstArg(args)))
+	}, "Resolves a declarative fixture of fake repositories in memory and prints the result: bpm fixture <file>")
+	c.NewCommand("bench-resolve", func(args []string) {
+		exit(doBenchResolve(getDir(&dir), firstArg(args), capture, benchCount))
+	}, "Times resolving a fixture and scanning imports, with allocations, to compare bpm versions: bpm bench-resolve [<fixture>]. Without a fixture, the project is captured as one.").
+		NewArg("-capture", &capture, "", "Save the fixture captured from the project to this file.").
+		NewArg("-count", &benchCount, "", "Runs of each benchmark (default 5).")
+	withCSV(c.NewCommand("list", func(args []string) {
+		exit(doList(getDir(&dir), format, licenses, report))
+	}, "Lists all dependencies, optionally using a Go template: bpm list -format '{{.Package}} {{.Commit}} {{.License}}'")).
+		NewArg("-format", &format, "", "Go template for each line, with fields Package, URL, Upstream, Branch, Commit, Kind, License, Depth, Indent and Dir.").
+		NewBoolArg("-licenses", &licenses, "Show the license of every package.")
+	withCSV(c.NewCommand("outdated", func(args []string) {
+		if onlyMajor {
+			severity = severityMajor
+		}
+		exit(doOutdated(getDir(&dir), severity, report, outdated))
+	}, "Lists dependencies with newer tags or commits, classified as major, minor, patch or commits. With -json, prints them as JSON for CI. Reuses the last scan for an hour unless -refresh is given.")).
+		NewArg("-severity", &severity, "", "Minimum severity reported: major, minor, patch or commits.").
+		NewBoolArg("-only-major", &onlyMajor, "Only report major updates.").
+		NewBoolArg("-refresh", &outdated.refresh, "Query every remote again instead of reusing the last scan.").
+		NewArg("-max-age", &outdated.maxAge, "", "How old a saved scan may be to reuse it, like 30m (default 1h).")
+	c.NewCommand("owners", func(args []string) {
+		exit(doOwners(getDir(&dir), args))
+	}, "Lists the dependencies per owning team, or the owners of the given packages one per line, e.g. to request reviews: bpm owners [<pkg>...]")
+	c.NewCommand("approve", func(args []string) {
+		exit(doApprove(getDir(&dir), firstArg(args), reason))
+	}, "Approves a new dependency when requireApproval is set in bpm.json: bpm approve -reason <reason> <pkg>").
+		NewArg("-reason", &reason, "", "Why the dependency is approved, recorded in bpm.json.")
+	c.NewCommand("graph", func(args []string) {
+		exit(doGraph(getDir(&dir), format, whyEdges, internal))
+	}, "Prints the dependency graph, with -format dot, mermaid or json for other tools, -why-edges for the imports behind each edge and -internal for the project's own packages.").
+		NewArg("-format", &format, "", "Output format: dot for Graphviz, mermaid or json, labeling versions and highlighting duplicate and conflicting packages.").
+		NewBoolArg("-internal", &internal, "Include the edges between the project's own packages.").
+		NewBoolArg("-why-edges", &whyEdges, "List the file:line of the imports behind each edge.")
+	c.NewCommand("compat", func(args []string) {
+		exit(doCompat(getDir(&dir), vet, nil))
+	}, "Builds the project against the vendor tree and maps compile errors to the dependencies likely causing them.").
+		NewBoolArg("-vet", &vet, "Use go vet instead of go build.")
+	c.NewCommand("doctor", func(args []string) {
+		exit(doDoctor(getDir(&dir)))
+	}, "Checks git and tests DNS, IPv4/IPv6 and TLS connectivity to every host bpm uses.")
+	c.NewCommand("du", func(args []string) {
+		exit(doDiskUsage(getDir(&dir)))
+	}, "Shows the disk usage of every vendored package, largest first.")
+	c.NewCommand("stats", func(args []string) {
+		exit(doStats())
+	}, "Shows the local usage statistics recorded when \"stats\" is enabled in the global config.")
+	c.NewCommand("evaluate", func(args []string) {
+		exit(doEvaluate(firstArg(args)))
+	}, "Reports license, size, dependencies, releases and vulnerabilities of a package before adding it: bpm evaluate <import-path>")
+	withCSV(c.NewCommand("licenses", func(args []string) {
+		exit(doLicenses(getDir(&dir), report))
+	}, "Summarizes the licenses of all vendored packages."))
+	withCSV(c.NewCommand("audit", func(args []string) {
+		exit(doAudit(getDir(&dir), report))
+	}, "Lists known vulnerabilities of the pinned dependencies. Exits 1 when there are any."))
+	c.NewCommand("status", func(args []string) {
+		exit(doStatus(getDir(&dir), sla))
+	}, "Shows how many days every dependency is behind its newest release or branch tip upstream.").
+		NewBoolArg("-sla", &sla, "Only report dependencies older than the maxAge of the freshness policy in bpm.json. Exits 1 when there are any.")
+	c.NewCommand("unused", func(args []string) {
+		exit(doUnused(getDir(&dir)))
+	}, "Reports dependencies in bpm.json that no import reaches, without removing them. Exits 1 when there are any.")
+	c.NewCommand("diff", func(args []string) {
+		exit(doDiff(getDir(&dir), vendorDiff))
+	}, "Compares the vendored commits with bpm.json, or with -vendor prints a patch of local modifications to vendored files.").
+		NewBoolArg("-vendor", &vendorDiff, "Print a patch of vendored files against their pinned commits.")
+	c.NewCommand("dashboard", func(args []string) {
+		exit(doDashboard(getDir(&dir)))
+	}, "Shows all dependencies with their status, drilling down into log, diff and metadata, and runs update and verify.")
+	c.NewCommand("daemon", func(args []string) {
+		exit(doDaemon(listen))
+	}, "Serves resolve, list, verify and install over a local socket for editor integrations.").
+		NewArg("-listen", &listen, "", "Daemon address, unix:<socket path> or tcp:<host:port>. Defaults to a socket in the user cache dir.")
+	c.NewCommand("commands", func(args []string) {
+		description := c.Describe()
+		output.Result(description, func() {
+			for _, command := range description.Commands {
+				fmt.Println(command.Name)
+			}
+		})
+	}, "Lists all commands, with -json their groups, flags and descriptions for wrappers and completion scripts.")
+	c.Group("Managing dependencies", "init", "migrate", "install", "update", "rebuild", "remove", "rollback", "undo", "apply", "rewrite", "workspace", "unlock")
+	c.Group("Inspecting dependencies", "list", "info", "graph", "outdated", "check-updates", "status", "diff", "du", "stats", "unused", "owners", "dashboard")
+	c.Group("Verifying and auditing", "verify", "vendor-check", "check", "audit", "licenses", "evaluate", "approve", "compat", "doctor")
+	c.Group("Publishing", "publish", "notes", "export-deps")
+	c.Group("Integrations", "run", "hooks", "merge-driver", "daemon", "export", "fixture", "bench-resolve", "commands")
+	c.NewArg("-d", &dir, "", "Root dir of project, by default the nearest folder with a bpm.json from the working directory up. Would pull all dependencies in $dir/vendor.")
+	c.NewArg("-chdir", &chdir, "", "Change to this folder before running the command, like git -C.")
+	c.NewBoolArg("-no-remote-cache", &remoteCacheDisabled, "Always query remotes instead of using cached branch and tag listings.")
+	c.NewArg("-record-refs", &recordRefsFile, "", "Save the branch and tag listings of every remote consulted to this file, for -refs-snapshot.")
+	c.NewArg("-refs-snapshot", &refsSnapshotFile, "", "Resolve against the branch and tag listings saved by -record-refs instead of the remotes.")
+	c.NewBoolArg("-partial-clone", &partialClone, "Clone dependencies without blobs, fetching file contents lazily on checkout.")
+	c.NewBoolArg("-shallow", &shallowClone, "Clone only the tip of each dependency, deepening clones when a pinned commit isn't in them.")
+	c.NewArg("-j", &jobs, "", "Maximum packages cloned, pulled or resolved at once, overriding jobs of the global config (default the number of CPUs).")
+	c.NewArg("-jobs-per-host", &jobsPerHost, "", "Maximum concurrent clones per host, overriding jobsPerHost of the global config (default 4).")
+	c.NewArg("-platforms", &platforms, "", "Comma separated GOOS/GOARCH pairs to scan imports for, like linux/amd64,darwin/arm64, overriding platforms of bpm.json.")
+	c.NewBoolArg("-json", &jsonOutput, "Print results and errors as JSON.")
+	c.NewBoolArg("-quiet", &quietOutput, "Only print errors.")
+	c.NewArg("-annotations", &annotationFormat, "", "Also report failures as github Actions annotations, or in a gitlab code quality report written to "+gitLabReportFilename+".")
+
+	c.Before = func(name string) {
+		setOutputMode(jsonOutput, quietOutput)
+		if chdir != "" {
+			if err := os.Chdir(chdir); err != nil {
+				output.Errorf("Could not change to %s: %s", chdir, err)
+				exit(1)
+			}
+		}
+		if err := setAnnotationFormat(annotationFormat); err != nil {
+			output.Errorf("Invalid -annotations: %s", err)
+			exit(1)
+		}
+		if err := loadGlobalConfig(); err != nil {
+			output.Errorf("%s", err)
+			exit(1)
+		}
+		if err := checkJobFlags(); err != nil {
+			output.Errorf("%s", err)
+			exit(1)
+		}
+		startStats(name)
+	}
+	defer exitOnPanic()
+	handleInterrupts()
 	commands.HandleArgs(c)
+	recordStats(0)
 }
 
+// getCurrentDir returns the working directory, or "." when it can't be
+// named, e.g. after it was removed.
 func getCurrentDir() string {
-	ex, _ := os.Executable()
-	return filepath.Dir(ex)
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Printf("Could not get the working directory: %s", err)
+		return "."
+	}
+	return dir
 }
 
+// firstArg returns the first positional argument of a command, or "".
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// getDir returns the project folder: dir when -d set it, else the nearest
+// folder with a bpm.json from the working directory up, else the working
+// directory, where commands report the missing bpm.json.
 func getDir(dir *string) string {
-	if dir != nil {
+	if dir != nil && *dir != "" {
 		return *dir
 	}
-	dir = findPackageFile(getCurrentDir())
-	if dir == nil {
-		log.Panicf("No git repository found in folder or parent folders.\n")
+	cwd := getCurrentDir()
+	found := findPackageFile(cwd)
+	if found == nil {
+		return cwd
+	}
+	if *found != cwd {
+		log.Printf("Using %s in %s", dependencyFilename, *found)
 	}
-	return *dir
+	return *found
+}
+
+// getNewProjectDir returns the folder a new bpm.json is created in: dir when
+// -d set it, else the working directory, never a parent project.
+func getNewProjectDir(dir string) string {
+	if dir != "" {
+		return dir
+	}
+	return getCurrentDir()
 }
 
 func findPackageFile(dir string) *string {
-	for dir != "." {
-		println(dir)
+	for {
 		if fileExists(filepath.Join(dir, dependencyFilename)) {
 			return &dir
 		}
-		nextDir, _ := filepath.Abs(dir + "/..")
-		if dir == nextDir {
-			break
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
 		}
-		dir = nextDir
+		dir = parent
 	}
-	return nil
 }
 
-func doInit(dir string) {
+// doInit creates the manifest of the project in dir from its imports. A
+// template adds its settings, and its dependencies whether imported yet or
+// not.
+func doInit(dir string, flat bool, template string) int {
 	depFile := filepath.Join(dir, dependencyFilename)
 	if fileExists(depFile) {
-		fmt.Printf("%s already exists: %s", dependencyFilename, depFile)
-		return
+		output.Errorf("%s already exists: %s", dependencyFilename, depFile)
+		return 1
+	}
+	data := &bpmPackage{}
+	if template != "" {
+		var err error
+		if data, err = getProjectTemplate(template); err != nil {
+			output.Errorf("Invalid template %q: %s", template, err)
+			return 1
+		} else if data == nil {
+			output.Errorf("Unknown template %q, expected one of %s", template, projectTemplateNames())
+			return 1
+		}
 	}
 	pkg := getCurrentPackage(dir)
 	if pkg == "" {
-		return
+		return 1
 	}
+	data.Package = pkg
+	if flat {
+		data.Layout = layoutFlat
+	}
+	data.mergeTestDependencies()
+	if err := loadBase(data, dir, 0); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	applyBase(data)
+	setSourceRoots(dir, data.SourceRoots)
+
+	declared := data.Dependencies
+	r := newGitResolver()
+	r.keepDeclared(declared)
+	r.keepPins(data.basePins())
+	r.replaceMode = data.transitiveReplaces()
+	r.conflicts = data.conflictStrategy()
+	r.flat = data.Layout == layoutFlat
+	resolved, err := r.resolve(dir, pkg)
+	if err != nil {
+		return reportPullError(err)
+	}
+	data.Dependencies = resolved
 
-	dependencies := resolveDependencies(dir, pkg)
-
-	data := &bpmPackage{
-		Package:      pkg,
-		Dependencies: dependencies}
-	writeDataFile(data)
+	unimported := make(map[string]*bpmEntry)
+	for pkg, entry := range declared {
+		if _, ok := data.Dependencies[pkg]; !ok {
+			unimported[pkg] = entry
+		}
+	}
+	if err := pullPackages(unimported, dir); err != nil {
+		return reportPullError(err)
+	}
+	for pkg, entry := range unimported {
+		data.Dependencies[pkg] = entry
+	}
+	if err := markTestDependencies(dir, pkg, data.Dependencies); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if err := postInstall(dir, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if err := writeDataFile(depFile, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if err := recordEnvironment(dir, resolutionBranchHeads); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	return 0
 }
 
-func resolveDependencies(dir string, pkg string) map[string]*bpmEntry {
-	files := getAllSourceFiles(dir)
-	log.Printf("Found files: %d", len(*files))
-	imports := getAllImports(files)
-	packages := getImports(imports, pkg)
-	dependencies := installPackages(packages, dir)
+type installOptions struct {
+	frozen     bool
+	checkSpace bool
+	readOnly   bool
+	overBudget bool
+	signatures bool
+	force      bool
+	trace      bool
+	production bool
+	// pruneOrphans removes the vendor folders of no dependency, which
+	// install only lists otherwise.
+	pruneOrphans bool
+}
 
-	for pkg, entry := range dependencies {
-		pkgDir := filepath.Join(dir, vendorFolderName, pkg)
-		log.Printf("Subpackage: %s", pkgDir)
-		entry.Dependencies = resolveDependencies(pkgDir, pkg)
+func doInstall(dir string, opts installOptions) (code int) {
+	if opts.trace {
+		root := startTracing("install", "bpm.dir", dir)
+		root.own(dir)
+		defer func() {
+			var err error
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%v", r)
+				defer panic(r)
+			} else if code != 0 {
+				err = fmt.Errorf("exit code %d", code)
+			}
+			finishTracing(err)
+		}()
+	}
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if !checkVendorUnlocked(dir) {
+		return 1
+	}
+	if _, err := takeSnapshot(dir, "install"); err != nil {
+		output.Errorf("Could not take a snapshot: %s", err)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	warnEnvironmentChanges(dir)
+	// installed is data without its test dependencies for -production,
+	// sharing the entries so their new pins are written with the rest.
+	installed := data
+	if opts.production {
+		production, _ := splitTestDependencies(data.Dependencies)
+		withoutTests := *data
+		withoutTests.Dependencies = production
+		installed = &withoutTests
+	}
+	if data.RequireApproval {
+		if unapproved := findUnapproved(dir, data); len(unapproved) > 0 {
+			reportUnapproved(unapproved)
+			return 1
+		}
+	}
+	if opts.frozen {
+		if problems := checkFrozen(installed.Dependencies); len(problems) > 0 {
+			output.Problems(fmt.Sprintf("%s is not frozen:", depFile), problems)
+			return 1
+		}
+	}
+	if opts.checkSpace {
+		checkDiskSpace(dir, installed.Dependencies)
+	}
+	if budget := data.budget(); budget != nil && !checkBudget(dir, budget, installed.Dependencies) && !opts.overBudget {
+		output.Errorf("Install stopped, run it with -allow-over-budget to exceed the budget")
+		return 1
+	}
+	if problems := checkHashes(dir, installed.Dependencies, true); len(problems) > 0 && !opts.force {
+		output.Problems("Vendored files changed since they were locked:", problems)
+		output.Errorf("Install stopped, run it with -force to accept the changes")
+		return 1
+	}
+	if data.Staging && !stageNewDependencies(dir, installed) {
+		return 1
+	}
+	tx := beginTransaction(dir)
+	committed := false
+	defer func() {
+		tx.end(committed)
+	}()
+	if err := pullPackages(installed.Dependencies, dir); err != nil {
+		return reportPullError(err)
+	}
+	if err := tx.commit(); err != nil {
+		output.Errorf("Could not move the new packages into %s: %s", vendorFolderName, err)
+		return 1
+	}
+	if err := postInstall(dir, installed); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if problems := checkPolicyPlugins(dir, installed); len(problems) > 0 {
+		output.Problems("Policy plugins vetoed the install:", problems)
+		return 1
+	}
+	committed = true
+	if opts.production {
+		_, tests := splitTestDependencies(data.Dependencies)
+		if err := removeTestDependencies(dir, tests); err != nil {
+			output.Errorf("Could not remove the test dependencies: %s", err)
+			return 1
+		}
+	}
+	if !opts.frozen {
+		if err := writeDataFile(depFile, data); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
 	}
+	reportOrphans(dir, data.Dependencies, opts.pruneOrphans)
+	if opts.signatures {
+		reportSignatures(dir, installed.Dependencies)
+	}
+	if opts.readOnly {
+		if err := lockVendor(dir); err != nil {
+			output.Errorf("Could not make %s read-only: %s", filepath.Join(dir, vendorFolderName), err)
+			return 1
+		}
+	}
+	return 0
+}
 
-	return dependencies
+type updateOptions struct {
+	verifyBuild  bool
+	runDepTests  bool
+	onlySecurity bool
+	stableOnly   bool
+	preview      bool
 }
 
-func doInstall(dir string) {
+func doUpdate(dir string, pkg string, opts updateOptions) int {
 	depFile := filepath.Join(dir, dependencyFilename)
 	if !fileExists(depFile) {
-		fmt.Printf("%s does not exist: %s", dependencyFilename, depFile)
-		return
+		noDependencyFile(depFile)
+		return 1
 	}
-	data := readDataFile(depFile)
-	pullPackages(data.Dependencies, dir)
-	writeDataFile(data)
-}
+	if opts.preview {
+		data, err := readDataFile(depFile)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		stableOnly = opts.stableOnly
+		return doUpdatePreview(dir, data, pkg)
+	}
+	if !checkVendorUnlocked(dir) {
+		return 1
+	}
+	if _, err := takeSnapshot(dir, "update"); err != nil {
+		output.Errorf("Could not take a snapshot: %s", err)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	updated := make([]string, 0)
+	stableOnly = opts.stableOnly
 
-func doUpdate(dir string, pkg string) {
+	if opts.onlySecurity {
+		if updated, err = updateVulnerable(dir, data.Dependencies); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		if len(updated) == 0 {
+			output.Println("No dependency needs a security update")
+			return 0
+		}
+		if data.Layout == layoutFlat {
+			if _, err := syncFlatVendor(dir, data); err != nil {
+				return reportPullError(err)
+			}
+		}
+		if err := postInstall(dir, data); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		if err := writeDataFile(depFile, data); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	} else {
+		flat := make(map[string][]*bpmEntry)
+		flattenDependencies(data.Dependencies, flat)
+		if _, ok := flat[pkg]; pkg != "" && !ok {
+			output.Errorf("%s is not a dependency", pkg)
+			return 1
+		}
+		if err := pullPackages(data.Dependencies, dir); err != nil {
+			return reportPullError(err)
+		}
+		isFlat := data.Layout == layoutFlat
+		failed := &pullError{}
+		updated = updateDependencies(dir, data.Dependencies, pkg, []string{data.Package}, isFlat, failed)
+		if err := failed.orNil(); err != nil {
+			return reportPullError(err)
+		}
+		if len(updated) == 0 {
+			output.Println("All dependencies are up to date")
+		} else if isFlat {
+			if _, err := syncFlatVendor(dir, data); err != nil {
+				return reportPullError(err)
+			}
+		}
+		if err := postInstall(dir, data); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		if err := writeDataFile(depFile, data); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	}
 
+	if opts.runDepTests {
+		if code := runDependencyTests(dir, data, updated); code != 0 {
+			return code
+		}
+	}
+	if opts.verifyBuild {
+		return doCompat(dir, false, updated)
+	}
+	return 0
 }
 
-func doRebuild(dir string) {
-	fmt.Printf("Working dir: %s\n", dir)
+func doRebuild(dir string, keepPins bool, flat bool) int {
+	output.Printf("Working dir: %s\n", dir)
 	pkg := getCurrentPackage(dir)
-	if pkg == "" {
-		return
+	if pkg == "" || !checkVendorUnlocked(dir) {
+		return 1
+	}
+	var prev *bpmPackage
+	depFile := filepath.Join(dir, dependencyFilename)
+	if _, err := takeSnapshot(dir, "rebuild"); err != nil {
+		output.Errorf("Could not take a snapshot: %s", err)
+		return 1
+	}
+	if fileExists(depFile) {
+		var err error
+		if prev, err = readDataFile(depFile); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
 	}
 	vendorDir := filepath.Join(dir, vendorFolderName)
-	removeDir(vendorDir)
+	if err := removeDir(vendorDir); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
 
-	dependencies := resolveDependencies(dir, pkg)
+	r := newGitResolver()
+	if prev != nil {
+		r.keepDeclared(prev.Dependencies)
+		r.keepAssets(prev.Dependencies)
+		if keepPins {
+			r.keepPins(prev.Dependencies)
+		}
+		r.keepPins(prev.basePins())
+		r.replaceMode = prev.transitiveReplaces()
+		r.conflicts = prev.conflictStrategy()
+		r.flat = prev.Layout == layoutFlat
+	}
+	if flat {
+		r.flat = true
+	}
+	dependencies, err := r.resolve(dir, pkg)
+	if err != nil {
+		return reportPullError(err)
+	}
+	if err := markTestDependencies(dir, pkg, dependencies); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
 	data := &bpmPackage{
 		Package:      pkg,
 		Dependencies: dependencies}
-	writeDataFile(data)
+	if prev != nil {
+		data.inheritSettings(prev)
+	}
+	if flat {
+		data.Layout = layoutFlat
+	}
+	if data.RequireApproval {
+		if unapproved := findUnapproved(dir, data); len(unapproved) > 0 {
+			reportUnapproved(unapproved)
+			return 1
+		}
+	}
+	if err := postInstall(dir, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if err := writeDataFile(depFile, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	mode := resolutionBranchHeads
+	if keepPins {
+		mode = resolutionKeepPins
+	}
+	if err := recordEnvironment(dir, mode); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	return 0
 }
 
-func getAllImports(files *[]string) map[string][]*ast.ImportSpec {
+// postInstall runs the passes that follow any change to the vendor tree.
+func postInstall(dir string, data *bpmPackage) error {
+	recordUpstreams(dir, data.Dependencies)
+	if data.Layout == layoutFlat {
+		if err := removeNestedVendors(dir, data.Dependencies); err != nil {
+			return err
+		}
+	}
+	if err := applyAllPatches(dir, dir, data.Dependencies); err != nil {
+		return err
+	}
+	if err := rewriteAliases(dir, data.Dependencies); err != nil {
+		return err
+	}
+	dedupeVendor(dir, data.Dependencies)
+	if data.Rewrite != nil {
+		if err := rewriteImports(dir, data); err != nil {
+			return err
+		}
+	}
+	if data.VendorMarkers {
+		if err := writeVendorMarkers(dir, data.Dependencies); err != nil {
+			return err
+		}
+	}
+	checkPathLengths(dir)
+	checkToolchain(dir, data)
+	return recordHashes(dir, data.Dependencies)
+}
+
+func getAllImports(files *[]string) (map[string][]*ast.ImportSpec, error) {
 	var (
 		bytes   []byte
 		err     error
 		f       *ast.File
 		imports = make(map[string][]*ast.ImportSpec)
 	)
+	contexts, err := platformContexts()
+	if err != nil {
+		return nil, err
+	}
 	for _, fname := range *files {
+		if !matchesPlatforms(contexts, fname) {
+			log.Printf("Skipping %s, not built for %s", fname, platforms)
+			continue
+		}
 		if bytes, err = ioutil.ReadFile(fname); err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		fs := token.NewFileSet()
-		if f, err = parser.ParseFile(fs, "", string(bytes), parser.ImportsOnly); err != nil {
-			log.Panic(err)
+		if f, err = parser.ParseFile(fs, fname, string(bytes), parser.ImportsOnly); err != nil {
+			return nil, err
 		}
 
 		imports[fname] = f.Imports
 	}
-	return imports
+	return imports, nil
 }
 
-func getAllSourceFiles(dir string) *[]string {
+// getAllSourceFiles returns the .go files below dir outside of vendor
+// folders, or below the source roots when dir is a project listing them.
+func getAllSourceFiles(dir string) (*[]string, error) {
+	if roots, ok := projectSourceRoots(dir); ok {
+		return getSourceRootFiles(roots)
+	}
+	return walkSourceFiles(dir)
+}
+
+func walkSourceFiles(dir string) (*[]string, error) {
 	result := make([]string, 0)
 
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	for _, f := range files {
@@ -184,7 +846,10 @@ func getAllSourceFiles(dir string) *[]string {
 				log.Printf("Skipping vendor folder: %s\n", fullName)
 				continue
 			}
-			sources := getAllSourceFiles(fullName)
+			sources, err := walkSourceFiles(fullName)
+			if err != nil {
+				return nil, err
+			}
 			if len(*sources) > 0 {
 				result = append(result, *sources...)
 			}
@@ -195,28 +860,19 @@ func getAllSourceFiles(dir string) *[]string {
 			result = append(result, fullName)
 		}
 	}
-	return &result
-}
-
-func getPackagePattern() *regexp.Regexp {
-	pattern, err := regexp.Compile("^([^/]+\\.[^.]{1,6}/[^/]+/[^/]+)")
-	if err != nil {
-		log.Panic(err)
-	}
-	return pattern
+	return &result, nil
 }
 
 func getImports(importMap map[string][]*ast.ImportSpec, currentPkg string) *[]string {
 
-	pattern := getPackagePattern()
 	imports := make(map[string]*interface{}, 0)
 
 	for fname, arr := range importMap {
 		for _, i := range arr {
 			val := (*i.Path).Value
 			val = strings.Trim(val, `"`)
-			if pattern.MatchString(val) {
-				val = pattern.FindString(val)
+			if root := importRoot(val); root != "" {
+				val = root
 				if _, ok := imports[val]; !ok {
 					log.Printf("Found package: %s in file %s", val, fname)
 					imports[val] = nil
@@ -236,139 +892,362 @@ func getImports(importMap map[string][]*ast.ImportSpec, currentPkg string) *[]st
 }
 
 type bpmPackage struct {
-	Package      string               `json:"package"`
-	Dependencies map[string]*bpmEntry `json:"dependencies"`
+	Package string `json:"package"`
+	// Extends is the path or URL of a base manifest whose policy, mirrors,
+	// replacements and pinned versions apply where this one sets none.
+	Extends string `json:"extends,omitempty"`
+	// Mirrors map repository URL prefixes to the prefixes to clone from.
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// Replace maps package paths to the repositories used in their place.
+	Replace       map[string]string   `json:"replace,omitempty"`
+	Rewrite       *bpmRewrite         `json:"rewrite,omitempty"`
+	Hooks         map[string][]string `json:"hooks,omitempty"`
+	VendorMarkers bool                `json:"vendorMarkers,omitempty"`
+	// RequireApproval makes dependencies missing from the committed bpm.json
+	// fail install until they are listed in Approvals.
+	RequireApproval bool                    `json:"requireApproval,omitempty"`
+	Approvals       map[string]*bpmApproval `json:"approvals,omitempty"`
+	// Staging installs new dependencies into .bpm/staging until they pass
+	// the license, vulnerability and Policy checks.
+	Staging bool       `json:"staging,omitempty"`
+	Policy  *bpmPolicy `json:"policy,omitempty"`
+	Budget  *bpmBudget `json:"budget,omitempty"`
+	// Freshness fails status -sla for dependencies too far behind upstream.
+	Freshness *bpmFreshness `json:"freshness,omitempty"`
+	// Critical packages have their own tests run by update -run-dep-tests.
+	Critical []string `json:"critical,omitempty"`
+	// Layout is flat to vendor every dependency once at the top level.
+	Layout string `json:"layout,omitempty"`
+	// Shallow clones dependencies with only the tip of their default branch,
+	// deepening a clone when a pinned commit isn't in it.
+	Shallow bool `json:"shallow,omitempty"`
+	// Platforms are the GOOS/GOARCH pairs the project is built for. Imports
+	// of files built only for other platforms aren't vendored.
+	Platforms []string `json:"platforms,omitempty"`
+	// BuildTags are the tags files are built with on every platform.
+	BuildTags []string `json:"buildTags,omitempty"`
+	// SourceRoots are the folders imports are scanned in, relative to
+	// bpm.json, instead of the whole project folder.
+	SourceRoots []string `json:"sourceRoots,omitempty"`
+	// Owners map package patterns to the teams owning them, for entries
+	// without an owner of their own.
+	Owners map[string]string `json:"owners,omitempty"`
+	// IgnoreVulnerabilities silence known vulnerabilities until they expire.
+	IgnoreVulnerabilities []*bpmVulnIgnore     `json:"ignoreVulnerabilities,omitempty"`
+	Dependencies          map[string]*bpmEntry `json:"dependencies"`
+	// TestDependencies are only imported by _test.go files of the project.
+	// They are read in with Dependencies, marked Test.
+	TestDependencies map[string]*bpmEntry `json:"testDependencies,omitempty"`
+
+	base *bpmPackage
 }
 
-type bpmEntry struct {
-	URL          string               `json:"url,omitempty"`
-	Branch       string               `json:"branch,omitempty"`
-	Commit       string               `json:"commit,omitempty"`
-	Dependencies map[string]*bpmEntry `json:"dependencies"`
+// inheritSettings carries user configuration over from a previous manifest
+// when the dependency data itself is being regenerated.
+func (p *bpmPackage) inheritSettings(prev *bpmPackage) {
+	p.Extends = prev.Extends
+	p.Mirrors = prev.Mirrors
+	p.Replace = prev.Replace
+	p.base = prev.base
+	p.Rewrite = prev.Rewrite
+	p.Hooks = prev.Hooks
+	p.VendorMarkers = prev.VendorMarkers
+	p.RequireApproval = prev.RequireApproval
+	p.Approvals = prev.Approvals
+	p.Staging = prev.Staging
+	p.Policy = prev.Policy
+	p.Budget = prev.Budget
+	p.Freshness = prev.Freshness
+	p.Critical = prev.Critical
+	p.Layout = prev.Layout
+	p.Shallow = prev.Shallow
+	p.Platforms = prev.Platforms
+	p.BuildTags = prev.BuildTags
+	p.SourceRoots = prev.SourceRoots
+	p.Owners = prev.Owners
+	p.IgnoreVulnerabilities = prev.IgnoreVulnerabilities
 }
 
-type channelResult struct {
-	pkg   string
-	entry *bpmEntry
+type bpmEntry struct {
+	URL      string `json:"url,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	// BranchPattern or Channel make the entry follow a release series, the
+	// highest-sorting matching branch, instead of a fixed branch.
+	BranchPattern string `json:"branchPattern,omitempty"`
+	Channel       string `json:"channel,omitempty"`
+	// Version is a semver constraint like "^1.2.0" or ">=2.1,<3", pinning the
+	// highest matching tag, recorded in Tag. For an artifact, an archive with
+	// {version} in its URL, it is the exact version downloaded.
+	Version string `json:"version,omitempty"`
+	// Tag pins the entry to a tag, or is the tag its version resolved to.
+	// TagInfo is the metadata of the tag when it is annotated.
+	Tag     string      `json:"tag,omitempty"`
+	TagInfo *bpmTagInfo `json:"tagInfo,omitempty"`
+	Commit  string      `json:"commit,omitempty"`
+	Kind    string      `json:"kind,omitempty"`
+	Alias   string      `json:"alias,omitempty"`
+	// AllowPrerelease lets version constraints and updates pick pre-release
+	// tags like v2.0.0-rc.1, which are skipped otherwise.
+	AllowPrerelease bool `json:"allowPrerelease,omitempty"`
+	// Ref pins the entry to a ref outside its branches and tags, like
+	// refs/pull/42/head or refs/changes/34/1234/2, to test a change before
+	// it is merged.
+	Ref string `json:"ref,omitempty"`
+	// Subdir vendors only this folder of the repository, at its own commit.
+	Subdir string `json:"subdir,omitempty"`
+	// Env are variables exported to hooks, builds and bpm run, like cgo
+	// flags the package needs. ${BPM_PACKAGE_DIR} is its vendor folder.
+	Env map[string]string `json:"env,omitempty"`
+	// Owner is the team responsible for the dependency, grouping reports.
+	Owner   string `json:"owner,omitempty"`
+	Archive string `json:"archive,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	// Hash is the content hash of the vendored files, recorded in the lock
+	// file by install and checked by verify.
+	Hash string `json:"hash,omitempty"`
+	// Indirect entries of a flat layout are only imported by other
+	// dependencies, listed in RequiredBy.
+	Indirect   bool     `json:"indirect,omitempty"`
+	RequiredBy []string `json:"requiredBy,omitempty"`
+	// Test entries are only needed by the tests of the project, declared in
+	// testDependencies.
+	Test bool `json:"test,omitempty"`
+	// MergeConflict is left by the merge driver on an entry changed on
+	// both sides of a merge, failing check until it is removed.
+	MergeConflict string               `json:"mergeConflict,omitempty"`
+	Dependencies  map[string]*bpmEntry `json:"dependencies,omitempty"`
 }
 
-func installPackages(packages *[]string, dir string) map[string]*bpmEntry {
-	vendorDir := filepath.Join(dir, vendorFolderName)
-	createDir(vendorDir)
-
-	dependencies := make(map[string]*bpmEntry, len(*packages))
-
-	channelList := []chan channelResult{}
-
-	for _, filename := range *packages {
+// entryKindAssets marks repositories without Go code, such as protobuf or
+// JSON schema collections. They are pinned like other dependencies but not
+// scanned for imports, and kept even though no import refers to them.
+const entryKindAssets = "assets"
 
-		pkgDir := filepath.Join(vendorDir, filepath.FromSlash(filename))
-		createDir(pkgDir)
-
-		c := make(chan channelResult, 1)
-		go clonePackage(c, filename, pkgDir)
-		channelList = append(channelList, c)
-	}
-
-	for _, c := range channelList {
-		result, ok := <-c
-		if ok && result.entry != nil {
-			log.Printf("Dependency pulled: %s", result.pkg)
-			dependencies[result.pkg] = result.entry
-		}
-	}
+func isAssets(entry *bpmEntry) bool {
+	return entry.Kind == entryKindAssets
+}
 
-	return dependencies
+// entryKindTool marks commands the project runs, e.g. with go run from a
+// script, rather than imports. unused doesn't report them.
+const entryKindTool = "tool"
+
+// pullPackages pulls the dependency tree into dir. A failing dependency
+// doesn't stop the others; the failures are returned as a *pullError.
+func pullPackages(dependencies map[string]*bpmEntry, dir string) error {
+	failed := &pullError{}
+	pullNestedPackages(dir, dependencies, dir, map[string]string{}, failed)
+	addCaseCollisions(dependencies, failed)
+	return failed.orNil()
 }
 
-func pullPackages(dependencies map[string]*bpmEntry, dir string) {
+func pullNestedPackages(root string, dependencies map[string]*bpmEntry, dir string, visible map[string]string, failed *pullError) {
 
 	if dependencies == nil || len(dependencies) == 0 {
 		return
 	}
 
 	vendorDir := filepath.Join(dir, vendorFolderName)
-	createDir(vendorDir)
+	if err := createDir(vendorDir); err != nil {
+		for pkg := range dependencies {
+			failed.add(pkg, err)
+		}
+		return
+	}
 
 	channelMap := make(map[string]chan error, 0)
 
 	for pkg, data := range dependencies {
-		pkgDir := filepath.Join(vendorDir, pkg)
+		if isRedundantCopy(pkg, data, visible) {
+			log.Printf("Skipping %s, an enclosing vendor folder has the same commit", pkg)
+			continue
+		}
+		pkgDir := transaction.stage(filepath.Join(vendorDir, vendorPath(pkg)))
 
 		c := make(chan error, 1)
-		go pullPackage(c, pkg, data, pkgDir)
+		pkg, data := pkg, data
+		getWorkerPool().submit(func() {
+			pullPackage(c, root, pkg, data, pkgDir)
+		})
 		channelMap[pkg] = c
 	}
 
-	for pkg, c := range channelMap {
-		err, ok := <-c
-		if ok {
-			if err != nil {
-				log.Panic(err)
-			}
-			log.Printf("Dependency pulled: %s", pkg)
-			data := dependencies[pkg]
-			pkgDir := filepath.Join(vendorDir, pkg)
-			pullPackages(data.Dependencies, pkgDir)
+	childVisible := visibleCommits(visible, dependencies)
+	for _, pkg := range sortedKeys(dependencies) {
+		c, ok := channelMap[pkg]
+		if !ok {
+			continue
 		}
+		if err := <-c; err != nil {
+			log.Printf("Dependency failed: %s: %s", pkg, err)
+			failed.add(pkg, err)
+			continue
+		}
+		log.Printf("Dependency pulled: %s", pkg)
+		data := dependencies[pkg]
+		pkgDir := transaction.stage(filepath.Join(vendorDir, vendorPath(pkg)))
+		pullNestedPackages(root, data.Dependencies, pkgDir, childVisible, failed)
 	}
 }
 
-func pullPackage(c chan error, pkg string, entry *bpmEntry, pkgDir string) {
-
-	if !fileExists(pkgDir) {
-		createDir(pkgDir)
+// pullPackage sends nil on c once pkg is pulled, or the error it failed
+// with. A package that wasn't vendored before is removed again on failure,
+// rather than left half written; one that was is marked incomplete.
+func pullPackage(c chan error, root string, pkg string, entry *bpmEntry, pkgDir string) {
+	var err error
+	if err = interrupted(); err != nil {
+		c <- err
+		return
 	}
-
-	if !isGitRepo(pkgDir) {
-		cloneRepo(entry.URL, pkgDir)
+	if isIncomplete(pkgDir) {
+		log.Printf("%s was left incomplete by an earlier pull, pulling it again", pkgDir)
+		if err = removeDir(pkgDir); err != nil {
+			c <- err
+			return
+		}
 	}
-
-	pullRepo(entry, pkgDir)
-
-	c <- nil
-}
-
-func clonePackage(c chan channelResult, pkg string, pkgDir string) {
+	existed := fileExists(pkgDir)
+	span := startSpan("pull "+pkg, pkgDir, "bpm.package", pkg)
+	span.own(pkgDir)
 	defer func() {
-		if r := recover(); r != nil {
-			fmt.Errorf("Couldn't clone package %s doe to error: %s", pkg, r)
+		if err != nil && !existed {
+			if err := removeDir(pkgDir); err != nil {
+				log.Printf("Could not remove %s: %s", pkgDir, err)
+			}
+			clearIncomplete(pkgDir)
+		} else if err == nil {
+			clearIncomplete(pkgDir)
 		}
-		c <- channelResult{
-			pkg:   pkg,
-			entry: nil}
+		span.set("bpm.commit", entry.Commit)
+		span.finish(err)
+		c <- err
 	}()
+	defer recoverError(&err)
 
-	cloneURL := "https://" + pkg
+	if existed {
+		if err = transaction.save(pkg, entry, pkgDir); err != nil {
+			return
+		}
+	}
+	if err = markIncomplete(pkgDir); err != nil {
+		return
+	}
+	if !existed {
+		if err = createDir(pkgDir); err != nil {
+			return
+		}
+	}
 
-	cloneRepo(cloneURL, pkgDir)
+	if entry.Archive != "" {
+		if err = revertPatches(root, pkg, pkgDir); err != nil {
+			return
+		}
+		err = installArchive(pkg, entry, pkgDir)
+		return
+	}
+	if entry.Subdir != "" {
+		if err = revertPatches(root, pkg, pkgDir); err != nil {
+			return
+		}
+		if entry.Commit == "" && entry.Version != "" {
+			if entry.Tag, entry.Commit, err = findVersion(pkg, entry); err != nil {
+				return
+			}
+		} else if entry.Commit == "" && entry.Tag != "" {
+			if entry.Commit, err = findTag(pkg, entry); err != nil {
+				return
+			}
+		}
+		err = installSubdir(pkg, entry, pkgDir)
+		return
+	}
 
-	branch := getCurrentBranch(pkgDir)
-	hash := getCurrentCommitHash(pkgDir)
+	fresh := false
+	if !isGitRepo(pkgDir) {
+		url := getEntryURL(pkg, entry)
+		if !cloneFromSharedCache(url, entry, pkgDir) {
+			if err = cloneRepo(url, pkgDir); err != nil {
+				return
+			}
+			fresh = true
+		}
+	} else {
+		if err = revertPatches(root, pkg, pkgDir); err != nil {
+			return
+		}
+		if entry.Alias != "" || hasImportRewrites(root) {
+			if err = revertImportRewrites(pkgDir); err != nil {
+				return
+			}
+		}
+	}
 
-	c <- channelResult{
-		pkg: pkg,
-		entry: &bpmEntry{
-			URL:    cloneURL,
-			Branch: branch,
-			Commit: hash}}
+	if entry.Commit == "" {
+		if err = resolveCommit(pkg, entry, pkgDir, !fresh); err != nil {
+			return
+		}
+	}
+	if err = pullRepo(entry, pkgDir); err != nil {
+		return
+	}
+	if entry.Tag != "" && entry.TagInfo == nil {
+		entry.TagInfo = readTagInfo(pkgDir, entry.Tag)
+	}
+	if fresh {
+		warnFileCaseCollisions(pkg, pkgDir)
+		uploadToSharedCache(getEntryURL(pkg, entry), entry, pkgDir)
+	}
 }
 
-func removeDir(dir string) {
-	if fileExists(dir) {
-		if err := os.RemoveAll(dir); err != nil {
-			log.Fatal(err)
+// resolveCommit pins an entry declared without a commit to its ref, tag or
+// version, or else moves it to the branch it tracks.
+func resolveCommit(pkg string, entry *bpmEntry, pkgDir string, fetch bool) error {
+	span := startSpan("resolve", pkgDir, "bpm.version", entry.Version, "bpm.tag", entry.Tag, "bpm.ref", entry.Ref)
+	defer span.done()
+	if entry.Ref != "" {
+		commit, err := findRef(pkg, entry)
+		if err != nil {
+			return err
 		}
+		entry.Commit = commit
+		span.set("bpm.commit", entry.Commit)
+	} else if entry.Version != "" || entry.Tag != "" {
+		if err := pinTag(pkg, entry, pkgDir, fetch); err != nil {
+			return err
+		}
+		span.set("bpm.tag", entry.Tag)
+		span.set("bpm.commit", entry.Commit)
+	} else {
+		branch, err := trackedBranch(pkg, entry)
+		if err != nil {
+			return err
+		}
+		entry.Branch = branch
+	}
+	span.set("bpm.branch", entry.Branch)
+	return nil
+}
+
+func removeDir(dir string) error {
+	if fileExists(dir) {
+		return os.RemoveAll(dir)
 	}
+	return nil
 }
 
-func createDir(dir string) {
+func createDir(dir string) error {
 	if !fileExists(dir) {
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
-		}
+		return os.MkdirAll(dir, os.ModePerm)
 	}
+	return nil
+}
+
+// resetDir empties dir, e.g. after a failed clone into it.
+func resetDir(dir string) error {
+	if err := removeDir(dir); err != nil {
+		return err
+	}
+	return createDir(dir)
 }
 
 func fileExists(filename string) bool {
@@ -376,106 +1255,248 @@ func fileExists(filename string) bool {
 	return !os.IsNotExist(err)
 }
 
-func runCmd(dir *string, getOutput bool, command string, args ...string) []byte {
-	var (
-		out []byte
-		err error
-	)
-	cmd := exec.Command(command, args...)
+// runCmd runs a command in dir, with its output passed through unless
+// getOutput is set, in which case it returns what the command printed.
+func runCmd(dir *string, getOutput bool, command string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(runContext, command, args...)
 	log.Printf("Command: %s %s", command, strings.Join(args, " "))
-	if dir != nil {
+	if command == "git" {
+		cmd = gitCommand(dir, args...)
+	} else if dir != nil {
 		cmd.Dir = *dir
 	}
 	if !getOutput {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			log.Panic(err)
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s %s: %s", command, strings.Join(args, " "), err)
 		}
-		return make([]byte, 0)
+		return make([]byte, 0), nil
 	}
 
-	if out, err = cmd.CombinedOutput(); err != nil {
-		log.Panic(err)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %s: %s", command, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
 	}
-	return out
+	return out, nil
+}
+
+// runCmdOutput runs a command in dir and returns its output without the
+// surrounding whitespace.
+func runCmdOutput(dir *string, command string, args ...string) (string, error) {
+	out, err := runCmd(dir, true, command, args...)
+	return strings.TrimSpace(string(out)), err
 }
 
-func pullRepo(entry *bpmEntry, pkgDir string) {
+func pullRepo(entry *bpmEntry, pkgDir string) error {
 
 	log.Printf("Pulling package %s in %s", entry.URL, pkgDir)
 
-	branch := getCurrentBranch(pkgDir)
+	branch, err := getCurrentBranch(pkgDir)
+	if err != nil {
+		return err
+	}
 	if entry.Branch == "" {
 		entry.Branch = branch
 	}
 	if branch != entry.Branch {
-		checkoutBranch(pkgDir, entry.Branch)
+		if err := checkoutBranch(pkgDir, entry.Branch); err != nil {
+			return err
+		}
+	}
+	commit, err := getCurrentCommitHash(pkgDir)
+	if err != nil {
+		return err
 	}
-	commit := getCurrentCommitHash(pkgDir)
 	if entry.Commit == "" {
 		entry.Commit = commit
 	}
 	if commit != entry.Commit {
-		checkoutCommit(pkgDir, entry.Commit)
+		if entry.Ref != "" {
+			if err := fetchRef(pkgDir, entry.Ref, entry.Commit); err != nil {
+				return err
+			}
+		}
+		return checkoutCommit(pkgDir, entry.Branch, entry.Commit)
 	}
+	return nil
 }
 
-func checkoutBranch(pkgDir string, branch string) {
-	runCmd(&pkgDir, false, "git", "checkout", branch)
+func checkoutBranch(pkgDir string, branch string) error {
+	defer startSpan("checkout", pkgDir, "bpm.branch", branch).done()
+	if err := fetchShallowBranch(pkgDir, branch); err != nil {
+		return err
+	}
+	_, err := runCmd(&pkgDir, false, "git", "checkout", branch)
+	return err
 }
 
-func checkoutCommit(pkgDir string, commit string) {
-	runCmd(&pkgDir, false, "git", "checkout", commit, ".")
+func checkoutCommit(pkgDir string, branch string, commit string) error {
+	defer startSpan("checkout", pkgDir, "bpm.branch", branch, "bpm.commit", commit).done()
+	if err := deepenTo(pkgDir, commit); err != nil {
+		return err
+	}
+	_, err := runCmd(&pkgDir, false, "git", "checkout", "-B", branch, commit)
+	return err
 }
 
-func cloneRepo(url string, dir string) {
+func cloneRepo(url string, dir string) error {
+	span := startSpan("clone", dir, "bpm.url", url)
+	defer span.done()
+	throttle := getHostThrottle(url)
+	for attempt := 1; ; attempt++ {
+		span.set("bpm.attempts", strconv.Itoa(attempt))
+		throttle.acquire()
+		start := time.Now()
+		err := cloneOnce(url, dir)
+		throttle.release()
+		if err == nil {
+			countClone(time.Since(start))
+			return nil
+		}
+		if err := resetDir(dir); err != nil {
+			return err
+		}
+		if !isThrottled(err) || attempt == maxThrottledAttempts {
+			cause, hint := classifyGitError(err)
+			return fmt.Errorf("could not clone %s, %s: %s", url, cause, hint)
+		}
+		throttle.slowDown()
+	}
+}
+
+// cloneOnce clones without blobs when partial clones are enabled, which are
+// then fetched lazily on checkout. Servers without filter support send a
+// full clone instead; a git too old to know the option fails, and a regular
+// clone follows. Shallow clones only get the tip of the default branch.
+func cloneOnce(url string, dir string) error {
+	url = mirrorURL(url)
+	clone := append([]string{"clone"}, shallowCloneArgs()...)
+	if partialClone || getGlobalConfig().PartialClone {
+		log.Printf("Cloning package %s in %s without blobs...", url, dir)
+		err := runGit(nil, append(append(clone, "--filter=blob:none"), append(longPathArgs(), url, dir)...)...)
+		if err == nil || isThrottled(err) {
+			return err
+		}
+		log.Printf("Partial clone of %s failed, cloning in full: %s", url, err)
+		if err := resetDir(dir); err != nil {
+			return err
+		}
+	}
 	log.Printf("Cloning package %s in %s...", url, dir)
-	runCmd(nil, false, "git", "clone", url, dir)
+	return runGit(nil, append(clone, append(longPathArgs(), url, dir)...)...)
 }
 
-func getCurrentBranch(dir string) string {
-	out := runCmd(&dir, true, "git", "branch")
+func getCurrentBranch(dir string) (string, error) {
+	out, err := runCmd(&dir, true, "git", "branch")
+	if err != nil {
+		return "", err
+	}
 	branch := string(regexp.MustCompile("\\* ([^\n]+)\n").Find(out))
 	branch = strings.TrimLeft(branch, "* ")
 	branch = strings.TrimRight(branch, "\n ")
-	return branch
+	return branch, nil
 }
 
-func getCurrentCommitHash(dir string) string {
-	hash := string(runCmd(&dir, true, "git", "rev-parse", "HEAD"))
-	hash = strings.TrimRight(hash, "\n ")
-	return hash
+func getCurrentCommitHash(dir string) (string, error) {
+	return runCmdOutput(&dir, "git", "rev-parse", "HEAD")
 }
 
-func jsonEncodeIndented(deps *bpmPackage) []byte {
+func jsonEncodeIndented(deps interface{}) ([]byte, error) {
 	buffer := bytes.Buffer{}
 	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
 	encoder.SetIndent("", "  ")
 	if err := encoder.Encode(deps); err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return buffer.Bytes()
+	return buffer.Bytes(), nil
 }
 
-func writeDataFile(data *bpmPackage) {
-	if err := ioutil.WriteFile(dependencyFilename, jsonEncodeIndented(data), os.ModeExclusive); err != nil {
-		log.Panic(err)
+// writeDataFile writes the declared dependencies to the manifest and the
+// resolved tree to the lock file next to it.
+func writeDataFile(filename string, data *bpmPackage) error {
+	lockFile := filepath.Join(filepath.Dir(filename), lockFilename)
+	lock := &bpmLock{}
+	if fileExists(lockFile) {
+		var err error
+		if lock, err = readLockFile(lockFile); err != nil {
+			return err
+		}
 	}
+	lock.Dependencies = data.Dependencies
+
+	manifest := *data
+	production, tests := splitTestDependencies(data.Dependencies)
+	manifest.Dependencies = declaredDependencies(production)
+	manifest.TestDependencies = declaredDependencies(tests)
+	manifestBytes, err := jsonEncodeIndented(&manifest)
+	if err != nil {
+		return err
+	}
+	lockBytes, err := jsonEncodeIndented(lock)
+	if err != nil {
+		return err
+	}
+	return writeDataFiles(filepath.Dir(filename), manifestBytes, lockBytes)
 }
 
-func readDataFile(filename string) *bpmPackage {
+// readDataFile reads a manifest with the dependency tree from its lock file.
+func readDataFile(filename string) (*bpmPackage, error) {
 	bytes, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 	data := bpmPackage{}
-	err = json.Unmarshal(bytes, &data)
-	if err != nil {
-		log.Panic(err)
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", filename, err)
+	}
+	data.mergeTestDependencies()
+	lockFile := filepath.Join(filepath.Dir(filename), lockFilename)
+	if fileExists(lockFile) {
+		lock, err := readLockFile(lockFile)
+		if err != nil {
+			return nil, err
+		}
+		if lock.Dependencies != nil {
+			data.Dependencies = mergeLock(data.Dependencies, lock.Dependencies)
+		}
+	}
+	noteManifest(filename)
+	if err := loadBase(&data, filepath.Dir(filename), 0); err != nil {
+		return nil, err
+	}
+	if err := data.checkSettings(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", filename, err)
 	}
-	return &data
+	applyBase(&data)
+	setSourceRoots(filepath.Dir(filename), data.SourceRoots)
+	noteCaseCollisions(data.Dependencies)
+	return &data, nil
+}
+
+// checkSettings rejects settings of p and its bases bpm can't act on, so they
+// fail as the manifest is read rather than midway through a command.
+func (p *bpmPackage) checkSettings() error {
+	if policy := p.policy(); policy != nil {
+		switch policy.TransitiveReplaces {
+		case "", replacesIgnore, replacesHonor, replacesConfirm:
+		default:
+			return fmt.Errorf("invalid transitiveReplaces %q, expected ignore, honor or confirm", policy.TransitiveReplaces)
+		}
+		switch policy.Conflicts {
+		case "", conflictsNewest, conflictsManifestOverride, conflictsFail:
+		default:
+			return fmt.Errorf("invalid conflicts %q, expected newest, manifest-override or fail", policy.Conflicts)
+		}
+	}
+	if budget := p.budget(); budget != nil && budget.MaxVendorSize != "" {
+		if _, err := parseSize(budget.MaxVendorSize); err != nil {
+			return fmt.Errorf("invalid maxVendorSize %q: %s", budget.MaxVendorSize, err)
+		}
+	}
+	return nil
 }
 
 func isGitRepo(dir string) bool {
@@ -483,21 +1504,28 @@ func isGitRepo(dir string) bool {
 }
 
 func getCurrentPackage(dir string) string {
-	result := string(runCmd(&dir, true, "git", "remote", "get-url", "origin"))
-	u, err := url.Parse(result)
+	result, err := runCmdOutput(&dir, "git", "remote", "get-url", "origin")
 	if err != nil {
-		fmt.Println("Could not resolve current repo origin: ", err.Error())
+		output.Errorf("Could not read the origin of the current repo: %s", err)
 		return ""
 	}
-	pkg := u.Hostname() + u.RawPath
-	pkg = strings.TrimSpace(pkg)
-	if strings.HasSuffix(pkg, ".git") {
-		pkg = pkg[:len(pkg)-4]
+	u, err := url.Parse(result)
+	if err != nil {
+		output.Errorf("Could not resolve current repo origin: %s", err)
+		return ""
 	}
-	pattern := getPackagePattern()
-	if pattern.MatchString(pkg) {
-		return pattern.FindString(pkg)
+	pkg := u.Hostname() + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+	// The origin is the repository itself, however deep its path, unless a
+	// host rule says otherwise.
+	if host := importHost(pkg); host != "" && strings.Contains(pkg, "/") {
+		if rule := hostRoot(host); rule != nil {
+			if root := rule.root(pkg); root != "" {
+				return root
+			}
+		} else {
+			return pkg
+		}
 	}
-	fmt.Println("Repo origin is not a valid package: " + pkg)
+	output.Errorf("Repo origin is not a valid package: %s", pkg)
 	return ""
 }
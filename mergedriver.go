@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeDriverName is the merge driver bpm.json and bpm.lock are attributed
+// to in .gitattributes.
+const mergeDriverName = "bpm"
+
+// entryMapKeys are the top level keys of bpm.json and bpm.lock holding
+// dependency maps, merged entry by entry.
+var entryMapKeys = map[string]bool{"dependencies": true, "testDependencies": true, "workspace": true}
+
+// regeneratedKeys are recorded by every install, so ours is kept on both
+// sides changing them.
+var regeneratedKeys = map[string]bool{"environment": true}
+
+// doMergeDriver registers the merge driver with "install", or merges the
+// files git passes it as %O %A %B %P, writing the result to ours.
+func doMergeDriver(dir string, args []string) int {
+	if len(args) == 1 && args[0] == "install" {
+		return installMergeDriver(dir)
+	}
+	if len(args) < 3 {
+		output.Errorf("Expected bpm merge-driver install, or bpm merge-driver <base> <ours> <theirs> [path] as run by git")
+		return 1
+	}
+	path := ""
+	if len(args) > 3 {
+		path = args[3]
+	}
+	return mergeDataFiles(args[0], args[1], args[2], path)
+}
+
+// installMergeDriver configures the driver in the git config of the
+// repository and attributes bpm.json and bpm.lock in dir to it.
+func installMergeDriver(dir string) int {
+	section := "merge." + mergeDriverName
+	if _, err := runCmd(&dir, true, "git", "config", section+".name", "bpm dependency merge"); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if _, err := runCmd(&dir, true, "git", "config", section+".driver", "bpm merge-driver %O %A %B %P"); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	attributesFile := filepath.Join(dir, ".gitattributes")
+	var lines []string
+	if fileExists(attributesFile) {
+		bytes, err := ioutil.ReadFile(attributesFile)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		lines = strings.Split(strings.TrimRight(string(bytes), "\n"), "\n")
+	}
+	added := false
+	for _, filename := range []string{dependencyFilename, lockFilename} {
+		line := filename + " merge=" + mergeDriverName
+		if !containsString(lines, line) {
+			lines = append(lines, line)
+			added = true
+		}
+	}
+	if added {
+		if err := ioutil.WriteFile(attributesFile, []byte(strings.TrimLeft(strings.Join(lines, "\n"), "\n")+"\n"), 0644); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	}
+	output.Printf("Installed the %s merge driver for %s and %s\n", mergeDriverName, dependencyFilename, lockFilename)
+	return 0
+}
+
+// dataFileMerge is a three-way merge of bpm.json or bpm.lock. Dependencies
+// are merged one by one: entries added on either side are all kept, and an
+// entry pinned differently on both sides gets the newest pin, marked with
+// mergeConflict until someone reviews it. Other settings changed on both
+// sides are real conflicts.
+type dataFileMerge struct {
+	resolved  []string
+	conflicts []string
+}
+
+func mergeDataFiles(baseFile string, oursFile string, theirsFile string, path string) int {
+	base, ours, theirs := readRawObject(baseFile), readRawObject(oursFile), readRawObject(theirsFile)
+	if ours == nil || theirs == nil {
+		return 1
+	}
+	if base == nil {
+		base = make(map[string]json.RawMessage)
+	}
+	dir := "."
+	if path != "" {
+		dir = filepath.Dir(path)
+	}
+
+	m := &dataFileMerge{}
+	merged := make(map[string]json.RawMessage)
+	for _, key := range rawKeys(base, ours, theirs) {
+		if entryMapKeys[key] {
+			entries := make([]map[string]*bpmEntry, 0, 3)
+			for _, raw := range []json.RawMessage{base[key], ours[key], theirs[key]} {
+				decoded, err := decodeEntries(raw)
+				if err != nil {
+					output.Errorf("Could not merge %s: %s", path, err)
+					return 1
+				}
+				entries = append(entries, decoded)
+			}
+			deps := m.mergeEntries(entries[0], entries[1], entries[2], dir, "")
+			if len(deps) > 0 {
+				encoded, err := jsonEncodeIndented(deps)
+				if err != nil {
+					output.Errorf("Could not merge %s: %s", path, err)
+					return 1
+				}
+				merged[key] = json.RawMessage(encoded)
+			}
+			continue
+		}
+		b, o, t := base[key], ours[key], theirs[key]
+		switch {
+		case sameJSON(o, t) || sameJSON(b, t):
+			if o != nil {
+				merged[key] = o
+			}
+		case sameJSON(b, o):
+			if t != nil {
+				merged[key] = t
+			}
+		default:
+			if o != nil {
+				merged[key] = o
+			}
+			if !regeneratedKeys[key] {
+				m.conflicts = append(m.conflicts, key)
+			}
+		}
+	}
+
+	var result interface{} = &bpmLock{}
+	if _, ok := merged["package"]; ok {
+		result = &bpmPackage{}
+	}
+	encoded, err := jsonEncodeIndented(merged)
+	if err == nil {
+		err = json.Unmarshal(encoded, result)
+	}
+	if err != nil {
+		output.Errorf("Could not merge %s: %s", path, err)
+		return 1
+	}
+	if encoded, err = jsonEncodeIndented(result); err == nil {
+		err = ioutil.WriteFile(oursFile, encoded, 0644)
+	}
+	if err != nil {
+		output.Errorf("Could not write %s: %s", oursFile, err)
+		return 1
+	}
+
+	for _, pkg := range m.resolved {
+		output.Printf("%s: %s changed on both sides, kept the newest pin marked with mergeConflict\n", path, pkg)
+	}
+	if len(m.conflicts) > 0 {
+		output.Problems(fmt.Sprintf("%s: changed on both sides, keeping ours:", path), m.conflicts)
+		return 1
+	}
+	return 0
+}
+
+// readRawObject reads the top level of a JSON file, nil for an empty one,
+// as git passes for a file without common ancestor.
+func readRawObject(filename string) map[string]json.RawMessage {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		output.Errorf("Could not read %s: %s", filename, err)
+		return nil
+	}
+	if strings.TrimSpace(string(bytes)) == "" {
+		return nil
+	}
+	object := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(bytes, &object); err != nil {
+		output.Errorf("Invalid %s: %s", filename, err)
+		return nil
+	}
+	return object
+}
+
+func rawKeys(objects ...map[string]json.RawMessage) []string {
+	set := make(map[string]bool)
+	for _, object := range objects {
+		for key := range object {
+			set[key] = true
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sameJSON(a json.RawMessage, b json.RawMessage) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func decodeEntries(raw json.RawMessage) (map[string]*bpmEntry, error) {
+	entries := make(map[string]*bpmEntry)
+	if raw != nil {
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// mergeEntries merges the dependencies vendored in dir, nested ones below
+// the vendor folders of their parents.
+func (m *dataFileMerge) mergeEntries(base, ours, theirs map[string]*bpmEntry, dir string, parent string) map[string]*bpmEntry {
+	packages := make(map[string]*bpmEntry)
+	for _, entries := range []map[string]*bpmEntry{base, ours, theirs} {
+		for pkg, entry := range entries {
+			packages[pkg] = entry
+		}
+	}
+	result := make(map[string]*bpmEntry)
+	for _, pkg := range sortedKeys(packages) {
+		b, o, t := base[pkg], ours[pkg], theirs[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		name := pkg
+		if parent != "" {
+			name = parent + " > " + pkg
+		}
+		switch {
+		case o == nil && t == nil:
+		case o == nil || t == nil:
+			kept := o
+			if kept == nil {
+				kept = t
+			}
+			if b == nil {
+				result[pkg] = kept
+			} else if !sameEntry(b, kept) {
+				// Removed on one side, changed on the other.
+				marked := *kept
+				marked.MergeConflict = "kept, though removed on the other side"
+				result[pkg] = &marked
+				m.resolved = append(m.resolved, name)
+			}
+		default:
+			result[pkg] = m.mergeEntry(name, b, o, t, pkgDir)
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func (m *dataFileMerge) mergeEntry(name string, base, ours, theirs *bpmEntry, pkgDir string) *bpmEntry {
+	var merged bpmEntry
+	switch {
+	case sameEntry(ours, theirs) || (base != nil && sameEntry(base, theirs)):
+		merged = *ours
+	case base != nil && sameEntry(base, ours):
+		merged = *theirs
+	default:
+		winner, loser := ours, theirs
+		if newerPin(ours, theirs, pkgDir) {
+			winner, loser = theirs, ours
+		}
+		merged = *winner
+		merged.MergeConflict = fmt.Sprintf("kept %s over %s", describePin(winner), describePin(loser))
+		m.resolved = append(m.resolved, name)
+	}
+	var baseDeps map[string]*bpmEntry
+	if base != nil {
+		baseDeps = base.Dependencies
+	}
+	merged.Dependencies = m.mergeEntries(baseDeps, ours.Dependencies, theirs.Dependencies, pkgDir, name)
+	return &merged
+}
+
+// sameEntry compares entries without their nested dependencies.
+func sameEntry(a *bpmEntry, b *bpmEntry) bool {
+	ca, cb := *a, *b
+	ca.Dependencies, cb.Dependencies = nil, nil
+	return reflect.DeepEqual(ca, cb)
+}
+
+// newerPin is whether theirs pins a newer release than ours: a higher tag
+// or version, else a commit descending from or younger than ours in the
+// vendored checkout. Without a way to tell, ours is kept.
+func newerPin(ours *bpmEntry, theirs *bpmEntry, pkgDir string) bool {
+	for _, pair := range [][2]string{{ours.Tag, theirs.Tag}, {ours.Version, theirs.Version}} {
+		a, okA := parseSemver(strings.TrimLeft(pair[0], "^~=<> "))
+		b, okB := parseSemver(strings.TrimLeft(pair[1], "^~=<> "))
+		if okA && okB && a.compare(b) != 0 {
+			return a.compare(b) < 0
+		}
+	}
+	if ours.Commit == "" || theirs.Commit == "" || ours.Commit == theirs.Commit || !isGitRepo(pkgDir) ||
+		!hasCommit(pkgDir, ours.Commit) || !hasCommit(pkgDir, theirs.Commit) {
+		return false
+	}
+	if gitCommand(&pkgDir, "merge-base", "--is-ancestor", ours.Commit, theirs.Commit).Run() == nil {
+		return true
+	}
+	if gitCommand(&pkgDir, "merge-base", "--is-ancestor", theirs.Commit, ours.Commit).Run() == nil {
+		return false
+	}
+	return commitTime(pkgDir, theirs.Commit) > commitTime(pkgDir, ours.Commit)
+}
+
+func commitTime(pkgDir string, commit string) int64 {
+	out, err := gitCommand(&pkgDir, "log", "-1", "--format=%ct", commit).Output()
+	if err != nil {
+		return 0
+	}
+	seconds, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return seconds
+}
+
+func describePin(entry *bpmEntry) string {
+	switch {
+	case entry.Tag != "":
+		return "tag " + entry.Tag
+	case entry.Version != "":
+		return "version " + entry.Version
+	case entry.Ref != "":
+		return "ref " + entry.Ref
+	case entry.Commit != "":
+		return "commit " + shortHash(entry.Commit)
+	case entry.SHA256 != "":
+		return "sha256 " + shortHash(entry.SHA256)
+	case entry.Branch != "":
+		return "branch " + entry.Branch
+	}
+	return "the entry of one side"
+}
+
+// findMergeConflicts lists the entries the merge driver left a mergeConflict
+// marker on, for check to fail until they are reviewed.
+func findMergeConflicts(dependencies map[string]*bpmEntry) []string {
+	problems := make([]string, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		if entry.MergeConflict != "" {
+			problems = append(problems, fmt.Sprintf("%s: merge conflict resolved automatically (%s), review it and remove mergeConflict", pkg, entry.MergeConflict))
+		}
+		problems = append(problems, findMergeConflicts(entry.Dependencies)...)
+	}
+	return problems
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeDataFiles(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		code               int
+		// expected are the merged entries by package, nested ones as
+		// "parent > package", as pin and merge conflict marker.
+		expected map[string]string
+	}{
+		{
+			name:     "added on both sides",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}}}`,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}, "github.com/lib/b": {"commit": "b1"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}, "github.com/lib/c": {"commit": "c1"}}}`,
+			expected: map[string]string{"github.com/lib/a": "commit a1", "github.com/lib/b": "commit b1", "github.com/lib/c": "commit c1"},
+		},
+		{
+			name:     "changed on one side",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}}}`,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a2"}}}`,
+			expected: map[string]string{"github.com/lib/a": "commit a2"},
+		},
+		{
+			name:     "newer tag on their side",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"tag": "v1.1.0"}}}`,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"tag": "v1.2.0"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"tag": "v1.3.0"}}}`,
+			expected: map[string]string{"github.com/lib/a": "tag v1.3.0 (kept tag v1.3.0 over tag v1.2.0)"},
+		},
+		{
+			name:     "newer version on our side",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"version": "^1.1"}}}`,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"version": "^2.0"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"version": "^1.2"}}}`,
+			expected: map[string]string{"github.com/lib/a": "version ^2.0 (kept version ^2.0 over version ^1.2)"},
+		},
+		{
+			name:     "removed on one side and changed on the other",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}}}`,
+			ours:     `{"package": "github.com/me/app"}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a2"}}}`,
+			expected: map[string]string{"github.com/lib/a": "commit a2 (kept, though removed on the other side)"},
+		},
+		{
+			name:     "removed on one side",
+			base:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}, "github.com/lib/b": {"commit": "b1"}}}`,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/b": {"commit": "b1"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}, "github.com/lib/b": {"commit": "b1"}}}`,
+			expected: map[string]string{"github.com/lib/b": "commit b1"},
+		},
+		{
+			name: "nested dependency changed",
+			base: `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1",
+				"dependencies": {"github.com/lib/b": {"commit": "b1"}}}}}`,
+			ours: `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1",
+				"dependencies": {"github.com/lib/b": {"commit": "b1"}}}}}`,
+			theirs: `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1",
+				"dependencies": {"github.com/lib/b": {"commit": "b2"}}}}}`,
+			expected: map[string]string{"github.com/lib/a": "commit a1", "github.com/lib/a > github.com/lib/b": "commit b2"},
+		},
+		{
+			name:     "no common ancestor",
+			base:     ``,
+			ours:     `{"package": "github.com/me/app", "dependencies": {"github.com/lib/a": {"commit": "a1"}}}`,
+			theirs:   `{"package": "github.com/me/app", "dependencies": {"github.com/lib/b": {"commit": "b1"}}}`,
+			expected: map[string]string{"github.com/lib/a": "commit a1", "github.com/lib/b": "commit b1"},
+		},
+		{
+			name:     "setting changed on both sides",
+			base:     `{"package": "github.com/me/app"}`,
+			ours:     `{"package": "github.com/me/ours"}`,
+			theirs:   `{"package": "github.com/me/theirs"}`,
+			code:     1,
+			expected: map[string]string{},
+		},
+	}
+	dir, err := ioutil.TempDir("", "bpm-merge-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := make([]string, 0, 3)
+			for i, content := range []string{test.base, test.ours, test.theirs} {
+				filename := filepath.Join(dir, []string{"base", "ours", "theirs"}[i])
+				if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = append(files, filename)
+			}
+			if code := mergeDataFiles(files[0], files[1], files[2], dependencyFilename); code != test.code {
+				t.Errorf("merge exited with %d, expected %d", code, test.code)
+			}
+			bytes, err := ioutil.ReadFile(files[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			merged := &bpmPackage{}
+			if err := json.Unmarshal(bytes, merged); err != nil {
+				t.Fatalf("merged file is invalid: %s", err)
+			}
+			got := make(map[string]string)
+			describeMerged(merged.Dependencies, "", got)
+			if len(got) != len(test.expected) {
+				t.Errorf("merged entries are %v, expected %v", got, test.expected)
+			}
+			for pkg, expected := range test.expected {
+				if got[pkg] != expected {
+					t.Errorf("%s merged as %q, expected %q", pkg, got[pkg], expected)
+				}
+			}
+		})
+	}
+}
+
+func describeMerged(dependencies map[string]*bpmEntry, parent string, into map[string]string) {
+	for pkg, entry := range dependencies {
+		name := pkg
+		if parent != "" {
+			name = parent + " > " + pkg
+		}
+		into[name] = describePin(entry)
+		if entry.MergeConflict != "" {
+			into[name] += " (" + entry.MergeConflict + ")"
+		}
+		describeMerged(entry.Dependencies, name, into)
+	}
+}
+
+func TestFindMergeConflicts(t *testing.T) {
+	dependencies := map[string]*bpmEntry{
+		"github.com/lib/a": {Commit: "a1", Dependencies: map[string]*bpmEntry{
+			"github.com/lib/b": {Commit: "b1", MergeConflict: "kept commit b1 over commit b2"}}},
+		"github.com/lib/c": {Commit: "c1"},
+	}
+	problems := findMergeConflicts(dependencies)
+	if len(problems) != 1 {
+		t.Fatalf("problems are %q, expected one for github.com/lib/b", problems)
+	}
+	expected := "github.com/lib/b: merge conflict resolved automatically (kept commit b1 over commit b2), review it and remove mergeConflict"
+	if problems[0] != expected {
+		t.Errorf("problem is %q, expected %q", problems[0], expected)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// migrationSource is a dependency manager bpm migrate reads the pins of.
+// read returns the package of the project, "" when the files don't name it,
+// and its dependencies pinned as they were.
+type migrationSource struct {
+	name  string
+	files []string
+	read  func(dir string) (string, map[string]*bpmEntry, error)
+}
+
+// migrationSources are looked for in this order without -from.
+var migrationSources = []*migrationSource{
+	{"gomod", []string{"go.mod"}, readGoMod},
+	{"dep", []string{"Gopkg.lock", "Gopkg.toml"}, readDep},
+	{"glide", []string{"glide.lock", "glide.yaml"}, readGlide},
+	{"govendor", []string{filepath.Join(vendorFolderName, "vendor.json")}, readGovendor},
+}
+
+var fullCommitPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// pseudoVersionPattern matches go module pseudo-versions, capturing the
+// abbreviated commit they name.
+var pseudoVersionPattern = regexp.MustCompile(`-(?:0\.)?\d{14}-([0-9a-f]{12})$`)
+
+var majorSuffixPattern = regexp.MustCompile(`/v\d+$`)
+
+// doMigrate writes bpm.json and bpm.lock from the files of another
+// dependency manager, so a project keeps the exact versions it vendored.
+// These tools all vendor flat, so the project gets the flat layout;
+// dependencies the source only lists as transitive are locked as indirect.
+func doMigrate(dir string, from string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if fileExists(depFile) {
+		output.Errorf("%s already exists: %s", dependencyFilename, depFile)
+		return 1
+	}
+	source := findMigrationSource(dir, from)
+	if source == nil {
+		if from != "" && getMigrationSource(from) == nil {
+			output.Errorf("Unknown source %q, expected one of %s", from, migrationSourceNames())
+		} else {
+			output.Errorf("Nothing to migrate from in %s, expected one of %s", dir, migrationFileNames(from))
+		}
+		return 1
+	}
+	pkg, dependencies, err := source.read(dir)
+	if err != nil {
+		output.Errorf("Could not read the %s files: %s", source.name, err)
+		return 1
+	}
+	if pkg == "" {
+		if pkg = getCurrentPackage(dir); pkg == "" {
+			return 1
+		}
+	}
+	data := &bpmPackage{Package: pkg, Layout: layoutFlat, Dependencies: dependencies}
+	if err := writeDataFile(depFile, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	direct := 0
+	for _, entry := range dependencies {
+		if !entry.Indirect {
+			direct++
+		}
+	}
+	output.Printf("Migrated %d dependencies, %d of them direct, from %s. Run bpm install to vendor them.\n", len(dependencies), direct, source.name)
+	return 0
+}
+
+func findMigrationSource(dir string, from string) *migrationSource {
+	for _, source := range migrationSources {
+		if from != "" && source.name != from {
+			continue
+		}
+		for _, file := range source.files {
+			if fileExists(filepath.Join(dir, file)) {
+				return source
+			}
+		}
+	}
+	return nil
+}
+
+func getMigrationSource(name string) *migrationSource {
+	for _, source := range migrationSources {
+		if source.name == name {
+			return source
+		}
+	}
+	return nil
+}
+
+func migrationSourceNames() string {
+	names := make([]string, 0, len(migrationSources))
+	for _, source := range migrationSources {
+		names = append(names, source.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func migrationFileNames(from string) string {
+	files := make([]string, 0)
+	for _, source := range migrationSources {
+		if from == "" || source.name == from {
+			files = append(files, source.files...)
+		}
+	}
+	return strings.Join(files, ", ")
+}
+
+// migratedEntry pins an entry to what a dependency manager recorded for it:
+// a full commit, a tag, a semver constraint, else a branch.
+func migratedEntry(version string, revision string) *bpmEntry {
+	entry := &bpmEntry{}
+	if fullCommitPattern.MatchString(revision) {
+		entry.Commit = revision
+	}
+	switch {
+	case version == "":
+	case fullCommitPattern.MatchString(version):
+		entry.Commit = version
+	case isTagName(version):
+		entry.Tag = version
+	case isVersionConstraint(version):
+		entry.Version = version
+	default:
+		entry.Branch = version
+	}
+	return entry
+}
+
+// isTagName is whether version names a single release, like v1.2.3.
+func isTagName(version string) bool {
+	_, ok := parseSemver(version)
+	return ok
+}
+
+func isVersionConstraint(version string) bool {
+	if !strings.ContainsAny(version, "0123456789*") {
+		return false
+	}
+	_, err := parseVersionConstraint(version)
+	return err == nil
+}
+
+// migratedURL is the url of an entry fetched from source rather than from
+// the path of its package.
+func migratedURL(pkg string, source string) string {
+	if source == "" {
+		return ""
+	}
+	if !strings.Contains(source, "://") && !strings.Contains(source, "@") {
+		source = "https://" + source
+	}
+	if source == getEntryURL(pkg, &bpmEntry{}) {
+		return ""
+	}
+	return source
+}
+
+// readGoMod reads the requirements of go.mod. Tagged versions are pinned to
+// their tag, pseudo-versions to the abbreviated commit they name. The
+// checksums of go.sum cover module zips, not the repositories bpm vendors,
+// so there is nothing to carry over from it.
+func readGoMod(dir string) (string, map[string]*bpmEntry, error) {
+	lines, err := readLines(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", nil, err
+	}
+	module := ""
+	dependencies := make(map[string]*bpmEntry)
+	replaced := make(map[string][2]string)
+	block := ""
+	for _, line := range lines {
+		indirect := strings.HasSuffix(line, "// indirect")
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			fields = append([]string{block}, fields...)
+		} else if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		switch {
+		case fields[0] == "module" && len(fields) == 2:
+			module = strings.Trim(fields[1], `"`)
+		case fields[0] == "require" && len(fields) == 3:
+			dependencies[fields[1]] = goModEntry(fields[1], fields[2], indirect)
+		case fields[0] == "replace":
+			// old [version] => new [version]
+			arrow := indexOf(fields, "=>")
+			if arrow < 0 || arrow+1 >= len(fields) {
+				return "", nil, fmt.Errorf("invalid replace: %s", strings.TrimSpace(line))
+			}
+			version := ""
+			if arrow+2 < len(fields) {
+				version = fields[arrow+2]
+			}
+			replaced[fields[1]] = [2]string{fields[arrow+1], version}
+		}
+	}
+	for pkg, replacement := range replaced {
+		entry, ok := dependencies[pkg]
+		if !ok {
+			continue
+		}
+		if replacement[1] == "" {
+			output.Printf("%s is replaced by the local folder %s, vendoring it from its own repository\n", pkg, replacement[0])
+			continue
+		}
+		replacing := goModEntry(replacement[0], replacement[1], entry.Indirect)
+		if replacing.URL == "" {
+			replacing.URL = migratedURL(pkg, replacement[0])
+		}
+		dependencies[pkg] = replacing
+	}
+	return module, dependencies, nil
+}
+
+func goModEntry(pkg string, version string, indirect bool) *bpmEntry {
+	entry := &bpmEntry{Indirect: indirect}
+	version = strings.TrimSuffix(version, "+incompatible")
+	if match := pseudoVersionPattern.FindStringSubmatch(version); match != nil {
+		entry.Commit = match[1]
+	} else {
+		entry.Tag = version
+	}
+	if major := majorSuffixPattern.FindString(pkg); major != "" && !strings.HasPrefix(pkg, "gopkg.in/") {
+		// Major version suffixes name a module, not a repository.
+		entry.URL = "https://" + strings.TrimSuffix(pkg, major)
+	}
+	return entry
+}
+
+// readDep reads the projects of Gopkg.lock, direct when Gopkg.toml
+// constrains them, or the constraints of Gopkg.toml without a lock.
+func readDep(dir string) (string, map[string]*bpmEntry, error) {
+	constraints, err := readTOMLTables(filepath.Join(dir, "Gopkg.toml"), "constraint")
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	direct := make(map[string]map[string]string)
+	for _, constraint := range constraints {
+		direct[constraint["name"]] = constraint
+	}
+	dependencies := make(map[string]*bpmEntry)
+	projects, err := readTOMLTables(filepath.Join(dir, "Gopkg.lock"), "projects")
+	if os.IsNotExist(err) {
+		for name, constraint := range direct {
+			version := constraint["version"]
+			if version != "" && !strings.ContainsAny(version[:1], "^~=<>*") {
+				// dep reads a bare version as a caret range.
+				version = "^" + strings.TrimPrefix(version, "v")
+			}
+			if version == "" {
+				version = constraint["branch"]
+			}
+			entry := migratedEntry(version, constraint["revision"])
+			entry.URL = migratedURL(name, constraint["source"])
+			dependencies[name] = entry
+		}
+		return "", dependencies, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	for _, project := range projects {
+		name := project["name"]
+		entry := migratedEntry("", project["revision"])
+		entry.Tag, entry.Branch = project["version"], project["branch"]
+		entry.URL = migratedURL(name, project["source"])
+		_, isDirect := direct[name]
+		entry.Indirect = !isDirect && len(direct) > 0
+		dependencies[name] = entry
+	}
+	return "", dependencies, nil
+}
+
+// readGlide reads the imports of glide.lock, direct when glide.yaml imports
+// them, or the imports of glide.yaml without a lock.
+func readGlide(dir string) (string, map[string]*bpmEntry, error) {
+	pkg := ""
+	imports, err := readYAMLList(filepath.Join(dir, "glide.yaml"), "import")
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	if lines, err := readLines(filepath.Join(dir, "glide.yaml")); err == nil {
+		for _, line := range lines {
+			if strings.HasPrefix(line, "package:") {
+				pkg = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "package:")), `"'`)
+			}
+		}
+	}
+	direct := make(map[string]map[string]string)
+	for _, imported := range imports {
+		direct[imported["package"]] = imported
+	}
+	dependencies := make(map[string]*bpmEntry)
+	locked, err := readYAMLList(filepath.Join(dir, "glide.lock"), "imports")
+	if os.IsNotExist(err) {
+		for name, imported := range direct {
+			entry := migratedEntry(imported["version"], "")
+			entry.URL = migratedURL(name, imported["repo"])
+			dependencies[name] = entry
+		}
+		return pkg, dependencies, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	testImports, err := readYAMLList(filepath.Join(dir, "glide.lock"), "testImports")
+	if err != nil {
+		return "", nil, err
+	}
+	for _, imported := range append(locked, testImports...) {
+		name := imported["name"]
+		entry := migratedEntry("", imported["version"])
+		if declared, ok := direct[name]; ok {
+			version := migratedEntry(declared["version"], "")
+			entry.Branch, entry.Version, entry.Tag = version.Branch, version.Version, version.Tag
+		} else {
+			entry.Indirect = len(direct) > 0
+		}
+		entry.URL = migratedURL(name, imported["repo"])
+		dependencies[name] = entry
+	}
+	return pkg, dependencies, nil
+}
+
+type govendorFile struct {
+	RootPath string `json:"rootPath"`
+	Package  []struct {
+		Path         string `json:"path"`
+		Revision     string `json:"revision"`
+		Version      string `json:"version"`
+		VersionExact string `json:"versionExact"`
+	} `json:"package"`
+}
+
+// readGovendor reads vendor/vendor.json. It lists packages rather than
+// repositories, so packages are grouped by repository, taking the pin of the
+// shortest path.
+func readGovendor(dir string) (string, map[string]*bpmEntry, error) {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, vendorFolderName, "vendor.json"))
+	if err != nil {
+		return "", nil, err
+	}
+	file := govendorFile{}
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return "", nil, err
+	}
+	sort.Slice(file.Package, func(i, j int) bool { return file.Package[i].Path < file.Package[j].Path })
+	dependencies := make(map[string]*bpmEntry)
+	for _, p := range file.Package {
+		repo := govendorRepo(p.Path)
+		for pkg := range dependencies {
+			if strings.HasPrefix(p.Path, pkg+"/") {
+				repo = pkg
+			}
+		}
+		if _, ok := dependencies[repo]; ok {
+			continue
+		}
+		entry := migratedEntry(p.Version, p.Revision)
+		if p.VersionExact != "" {
+			entry.Tag, entry.Version = p.VersionExact, ""
+		}
+		dependencies[repo] = entry
+	}
+	return file.RootPath, dependencies, nil
+}
+
+// govendorRepo guesses the repository of a package path as host/owner/repo,
+// or host/repo.vN on gopkg.in.
+func govendorRepo(path string) string {
+	parts := strings.Split(path, "/")
+	n := 3
+	if parts[0] == "gopkg.in" && len(parts) > 1 && strings.Contains(parts[1], ".v") {
+		n = 2
+	}
+	if len(parts) < n {
+		return path
+	}
+	return strings.Join(parts[:n], "/")
+}
+
+func readLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// readTOMLTables reads the string keys of the [[name]] tables of a TOML file,
+// all that Gopkg.toml and Gopkg.lock need. Arrays are skipped.
+func readTOMLTables(filename string, name string) ([]map[string]string, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]map[string]string, 0)
+	var table map[string]string
+	inArray := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if inArray {
+			inArray = !strings.HasSuffix(line, "]")
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table = nil
+			if line == "[["+name+"]]" {
+				table = make(map[string]string)
+				tables = append(tables, table)
+			}
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || table == nil {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		if strings.HasPrefix(value, "[") {
+			inArray = !strings.HasSuffix(value, "]")
+			continue
+		}
+		table[strings.TrimSpace(parts[0])] = strings.Trim(value, `"`)
+	}
+	return tables, nil
+}
+
+// readYAMLList reads the scalar keys of the items of the top level list key
+// of a YAML file, as glide writes them. Nested lists are skipped.
+func readYAMLList(filename string, key string) ([]map[string]string, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]map[string]string, 0)
+	var item map[string]string
+	inList, itemIndent := false, -1
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == 0 && !strings.HasPrefix(trimmed, "- ") {
+			inList = trimmed == key+":"
+			item, itemIndent = nil, -1
+			continue
+		}
+		if !inList {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") && (itemIndent < 0 || indent == itemIndent) {
+			itemIndent = indent
+			item = make(map[string]string)
+			items = append(items, item)
+			trimmed = strings.TrimPrefix(trimmed, "- ")
+		} else if indent != itemIndent+2 || item == nil {
+			continue
+		}
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) == 2 {
+			item[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		}
+	}
+	return items, nil
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type releaseNotes struct {
+	Tag  string `json:"tag"`
+	Name string `json:"name,omitempty"`
+	Body string `json:"body,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// tagNotes is a tag of the notes command with its release notes, or why
+// there are none. It is the result of notes with -json.
+type tagNotes struct {
+	Tag   string        `json:"tag"`
+	Notes *releaseNotes `json:"notes,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+func doNotes(dir string, pkg string, toTag string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	entry, ok := data.Dependencies[pkg]
+	if !ok {
+		output.Errorf("Package %s is not a direct dependency", pkg)
+		return 1
+	}
+	pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+	if !isGitRepo(pkgDir) {
+		output.Errorf("Package %s is not installed, run install first", pkg)
+		return 1
+	}
+
+	if _, err := runCmd(&pkgDir, true, "git", "fetch", "--tags", "--quiet"); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	fromTag, err := getTagAtCommit(pkgDir, entry.Commit)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	tags, err := getTags(pkgDir)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if toTag == "" {
+		semverTags := sortSemverTags(tags)
+		if len(semverTags) == 0 {
+			output.Errorf("Package %s has no semver tags", pkg)
+			return 1
+		}
+		toTag = semverTags[len(semverTags)-1]
+	}
+	if fromTag == toTag {
+		output.Printf("Package %s is already at %s\n", pkg, toTag)
+		return 0
+	}
+	notes := collectReleaseNotes(entry.URL, getTagsBetween(tags, fromTag, toTag))
+	output.Result(notes, func() {
+		printReleaseNotes(notes)
+	})
+	return 0
+}
+
+func getTags(dir string) ([]string, error) {
+	out, err := runCmdOutput(&dir, "git", "tag", "--list")
+	if err != nil || out == "" {
+		return []string{}, err
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// getTagAtCommit returns the highest semver tag pointing at the commit, or an
+// empty string if it isn't tagged.
+func getTagAtCommit(dir string, commit string) (string, error) {
+	out, err := runCmdOutput(&dir, "git", "tag", "--points-at", commit)
+	if err != nil || out == "" {
+		return "", err
+	}
+	tags := sortSemverTags(strings.Split(out, "\n"))
+	if len(tags) == 0 {
+		return "", nil
+	}
+	return tags[len(tags)-1], nil
+}
+
+// getTagsBetween returns the semver tags in (from, to], in ascending order.
+// An empty or non-semver from includes everything up to to.
+func getTagsBetween(tags []string, from string, to string) []string {
+	result := make([]string, 0)
+	fromVer, hasFrom := parseSemver(from)
+	toVer, ok := parseSemver(to)
+	if !ok {
+		return result
+	}
+	for _, tag := range sortSemverTags(tags) {
+		v, _ := parseSemver(tag)
+		if hasFrom && v.compare(fromVer) <= 0 {
+			continue
+		}
+		if v.compare(toVer) > 0 {
+			break
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
+func collectReleaseNotes(repoURL string, tags []string) []*tagNotes {
+	result := make([]*tagNotes, 0, len(tags))
+	for _, tag := range tags {
+		item := &tagNotes{Tag: tag}
+		notes, err := fetchReleaseNotes(repoURL, tag)
+		if err != nil {
+			item.Error = err.Error()
+		}
+		item.Notes = notes
+		result = append(result, item)
+	}
+	return result
+}
+
+func printReleaseNotes(tags []*tagNotes) {
+	for _, item := range tags {
+		notes := item.Notes
+		if item.Error != "" {
+			output.Printf("== %s (release notes unavailable: %s)\n\n", item.Tag, item.Error)
+			continue
+		}
+		if notes == nil {
+			output.Printf("== %s (no release notes published)\n\n", item.Tag)
+			continue
+		}
+		title := notes.Tag
+		if notes.Name != "" && notes.Name != notes.Tag {
+			title += " - " + notes.Name
+		}
+		output.Printf("== %s\n%s\n", title, notes.URL)
+		if body := strings.TrimSpace(notes.Body); body != "" {
+			output.Printf("\n%s\n", body)
+		}
+		output.Println()
+	}
+}
+
+// fetchReleaseNotes looks up the release published for a tag through the API
+// of the repository's host. It returns nil without an error when the host has
+// no release for the tag or no known API.
+func fetchReleaseNotes(repoURL string, tag string) (*releaseNotes, error) {
+	api, path := getHostAPI(repoURL)
+	if api == nil {
+		return nil, nil
+	}
+	return api.release(path, tag)
+}
+
+// getJSON decodes the response of a GET request into v. It reports false
+// without an error when the resource doesn't exist.
+func getJSON(apiURL string, authHeader string, authScheme string, token string, v interface{}) (bool, error) {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		if authScheme != "" {
+			token = authScheme + " " + token
+		}
+		req.Header.Set(authHeader, token)
+	}
+	authorizeRequest(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return true, json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// findOrphans returns the folders of the vendor folder of dir that no
+// dependency is vendored in, like those of packages since renamed or
+// removed from bpm.json by hand. Unlike unused, it goes by the manifest, not
+// the imports. Nested vendor folders belong to the checkouts of their
+// packages and are left alone.
+func findOrphans(dir string, dependencies map[string]*bpmEntry) []string {
+	paths := make(map[string]bool)
+	for pkg := range dependencies {
+		paths[filepath.ToSlash(vendorPath(pkg))] = true
+	}
+	orphans := make([]string, 0)
+	collectOrphans(filepath.Join(dir, vendorFolderName), "", paths, &orphans)
+	return orphans
+}
+
+func collectOrphans(vendorDir string, rel string, paths map[string]bool, orphans *[]string) {
+	files, err := ioutil.ReadDir(filepath.Join(vendorDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		path := f.Name()
+		if rel != "" {
+			path = rel + "/" + f.Name()
+		}
+		if paths[path] {
+			continue
+		}
+		if hasPathBelow(paths, path) {
+			collectOrphans(vendorDir, path, paths, orphans)
+			continue
+		}
+		*orphans = append(*orphans, path)
+	}
+}
+
+// hasPathBelow is whether any of paths is inside the folder path.
+func hasPathBelow(paths map[string]bool, path string) bool {
+	for p := range paths {
+		if strings.HasPrefix(p, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// reportOrphans lists the orphaned vendor folders of dir, removing them with
+// prune.
+func reportOrphans(dir string, dependencies map[string]*bpmEntry, prune bool) {
+	orphans := findOrphans(dir, dependencies)
+	if len(orphans) == 0 {
+		return
+	}
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	if !prune {
+		output.Printf("%d vendor folders belong to no dependency, run install -prune-orphans to remove them:\n", len(orphans))
+		for _, orphan := range orphans {
+			output.Printf("    %s\n", filepath.Join(vendorFolderName, filepath.FromSlash(orphan)))
+		}
+		return
+	}
+	removed := 0
+	for _, orphan := range orphans {
+		orphanDir := filepath.Join(vendorDir, filepath.FromSlash(orphan))
+		log.Printf("Removing orphaned %s", orphanDir)
+		if err := removeDir(orphanDir); err != nil {
+			output.Errorf("Could not remove %s: %s", orphanDir, err)
+			continue
+		}
+		removeEmptyParents(filepath.Dir(orphanDir), vendorDir)
+		removed++
+	}
+	output.Printf("Removed %d orphaned vendor folders\n", removed)
+}
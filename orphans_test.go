@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindOrphans(t *testing.T) {
+	tests := []struct {
+		name         string
+		folders      []string
+		dependencies []string
+		expected     []string
+	}{
+		{
+			name:         "none",
+			folders:      []string{"github.com/lib/a", "github.com/lib/b"},
+			dependencies: []string{"github.com/lib/a", "github.com/lib/b"},
+			expected:     []string{},
+		},
+		{
+			name:         "removed package",
+			folders:      []string{"github.com/lib/a", "github.com/lib/old"},
+			dependencies: []string{"github.com/lib/a"},
+			expected:     []string{"github.com/lib/old"},
+		},
+		{
+			name:         "whole host",
+			folders:      []string{"github.com/lib/a", "gitlab.com/team/b"},
+			dependencies: []string{"github.com/lib/a"},
+			expected:     []string{"gitlab.com"},
+		},
+		{
+			name:         "nested vendor folders",
+			folders:      []string{"github.com/lib/a/vendor/github.com/lib/c", "github.com/lib/a/sub"},
+			dependencies: []string{"github.com/lib/a"},
+			expected:     []string{},
+		},
+		{
+			name:         "escaped path",
+			folders:      []string{"github.com/!fold/lib", "github.com/Fold/lib"},
+			dependencies: []string{"github.com/Fold/lib"},
+			expected:     []string{"github.com/Fold"},
+		},
+		{
+			name:         "no vendor folder",
+			dependencies: []string{"github.com/lib/a"},
+			expected:     []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bpm-orphans-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for _, folder := range test.folders {
+				if err := os.MkdirAll(filepath.Join(dir, vendorFolderName, filepath.FromSlash(folder)), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dependencies := make(map[string]*bpmEntry)
+			for _, pkg := range test.dependencies {
+				dependencies[pkg] = &bpmEntry{Commit: "c1"}
+			}
+			caseEscapedMu.Lock()
+			for _, pkg := range test.dependencies {
+				if strings.ToLower(pkg) != pkg {
+					caseEscaped[pkg] = true
+				}
+			}
+			caseEscapedMu.Unlock()
+			defer func() {
+				caseEscapedMu.Lock()
+				caseEscaped = make(map[string]bool)
+				caseEscapedMu.Unlock()
+			}()
+
+			orphans := findOrphans(dir, dependencies)
+			if strings.Join(orphans, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("orphans are %v, expected %v", orphans, test.expected)
+			}
+		})
+	}
+}
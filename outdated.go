@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type outdatedEntry struct {
+	Package    string `json:"package"`
+	Branch     string `json:"branch"`
+	Commit     string `json:"commit"`
+	Latest     string `json:"latest"`
+	Upstream   string `json:"upstream,omitempty"`
+	Behind     int    `json:"behind,omitempty"`
+	CurrentTag string `json:"currentTag,omitempty"`
+	LatestTag  string `json:"latestTag,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+}
+
+func getEntryURL(pkg string, entry *bpmEntry) string {
+	if entry.URL != "" {
+		return entry.URL
+	}
+	if url, ok := replacements[pkg]; ok {
+		return url
+	}
+	return defaultRepoURL(entryRepo(pkg, entry))
+}
+
+// recordUpstreams remembers the original repository of packages installed
+// from a fork or carrying patches, so they can be compared against it.
+func recordUpstreams(root string, dependencies map[string]*bpmEntry) {
+	for pkg, entry := range dependencies {
+		origin := defaultRepoURL(entryRepo(pkg, entry))
+		if entry.Upstream == "" && (getEntryURL(pkg, entry) != origin || len(getPatches(root, pkg)) > 0) {
+			entry.Upstream = origin
+		}
+		recordUpstreams(root, entry.Dependencies)
+	}
+}
+
+// findBehindUpstream reports how many upstream commits the pinned commit is
+// missing. Without a local clone to count in, any differing tip is reported.
+func findBehindUpstream(pkg string, entry *bpmEntry, pkgDir string) (*outdatedEntry, error) {
+	refs, err := lsRemote(entry.Upstream, "HEAD", "refs/heads/"+entry.Branch)
+	if err != nil {
+		return nil, err
+	}
+	branch := entry.Branch
+	tip, ok := refs["refs/heads/"+branch]
+	if !ok {
+		if tip, ok = refs["HEAD"]; !ok {
+			log.Printf("Upstream %s of %s has no branch %s", entry.Upstream, pkg, branch)
+			return nil, nil
+		}
+		branch = "HEAD"
+	}
+	if tip == entry.Commit {
+		return nil, nil
+	}
+	behind := &outdatedEntry{
+		Package:  pkg,
+		Branch:   branch,
+		Commit:   entry.Commit,
+		Latest:   tip,
+		Upstream: entry.Upstream}
+	if !isGitRepo(pkgDir) {
+		return behind, nil
+	}
+
+	fetch := gitCommand(&pkgDir, "fetch", "--quiet", entry.Upstream, tip)
+	if err := fetch.Run(); err != nil {
+		log.Printf("Could not fetch upstream %s of %s: %s", entry.Upstream, pkg, err)
+		return behind, nil
+	}
+	out, err := runCmdOutput(&pkgDir, "git", "rev-list", "--count", entry.Commit+".."+tip)
+	if err != nil {
+		log.Printf("Could not compare %s with upstream %s: %s", pkg, entry.Upstream, err)
+		return behind, nil
+	}
+	if behind.Behind, _ = strconv.Atoi(out); behind.Behind == 0 {
+		return nil, nil
+	}
+	return behind, nil
+}
+
+// findUpdates reports, per pinned package, the newest semver tag classified by
+// severity, falling back to the branch tip for packages without tags, and for
+// forked or patched packages how far behind the upstream branch they are.
+func findUpdates(dir string, dependencies map[string]*bpmEntry) ([]*outdatedEntry, error) {
+	result := make([]*outdatedEntry, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if entry.Commit != "" {
+			update, err := findUpdate(pkg, entry, pkgDir)
+			if err != nil {
+				return nil, err
+			}
+			if update != nil {
+				result = append(result, update)
+			}
+			if entry.Upstream != "" && entry.Upstream != getEntryURL(pkg, entry) && entry.Branch != "" {
+				behind, err := findBehindUpstream(pkg, entry, pkgDir)
+				if err != nil {
+					return nil, err
+				}
+				if behind != nil {
+					behind.Severity = severityCommits
+					result = append(result, behind)
+				}
+			}
+		}
+		nested, err := findUpdates(pkgDir, entry.Dependencies)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+	return result, nil
+}
+
+func findUpdate(pkg string, entry *bpmEntry, pkgDir string) (*outdatedEntry, error) {
+	patterns := []string{"refs/tags/*"}
+	branch, err := trackedBranch(pkg, entry)
+	if err != nil {
+		return nil, err
+	}
+	if branch != "" {
+		patterns = append(patterns, "refs/heads/"+branch)
+	}
+	refs, err := lsRemote(getEntryURL(pkg, entry), patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0)
+	for ref := range refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+	tags = sortSemverTags(tags)
+
+	currentTag := ""
+	for _, tag := range tags {
+		if refs["refs/tags/"+tag] == entry.Commit {
+			currentTag = tag
+		}
+	}
+	if currentTag == "" && isGitRepo(pkgDir) {
+		describe := gitCommand(&pkgDir, "describe", "--tags", "--abbrev=0", entry.Commit)
+		if out, err := describe.Output(); err == nil {
+			currentTag = strings.TrimSpace(string(out))
+		}
+	}
+
+	if current, ok := parseSemver(currentTag); ok {
+		for i := len(tags) - 1; i >= 0; i-- {
+			latest, _ := parseSemver(tags[i])
+			if latest.pre != "" && current.pre == "" && !allowsPrerelease(entry) {
+				continue
+			}
+			if latest.compare(current) <= 0 {
+				break
+			}
+			return &outdatedEntry{
+				Package:    pkg,
+				Branch:     entry.Branch,
+				Commit:     entry.Commit,
+				Latest:     refs["refs/tags/"+tags[i]],
+				CurrentTag: currentTag,
+				LatestTag:  tags[i],
+				Severity:   classifyUpdate(current, latest)}, nil
+		}
+	}
+
+	tip, ok := refs["refs/heads/"+branch]
+	if !ok || tip == entry.Commit {
+		return nil, nil
+	}
+	return &outdatedEntry{
+		Package:    pkg,
+		Branch:     branch,
+		Commit:     entry.Commit,
+		Latest:     tip,
+		CurrentTag: currentTag,
+		Severity:   severityCommits}, nil
+}
+
+// outdatedReport is the result of outdated with -json.
+type outdatedReport struct {
+	Updates  []*outdatedEntry `json:"updates"`
+	Archived []string         `json:"archived,omitempty"`
+}
+
+type outdatedOptions struct {
+	refresh bool
+	maxAge  string
+}
+
+func doOutdated(dir string, minSeverity string, report csvReport, opts outdatedOptions) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	minRank, ok := severityRanks[minSeverity]
+	if minSeverity != "" && !ok {
+		output.Errorf("Unknown severity %q, expected major, minor, patch or commits", minSeverity)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	maxAge, err := parseDurationSetting("-max-age", opts.maxAge, defaultOutdatedMaxAge)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	scan, err := scanOutdated(dir, data.Dependencies, opts.refresh, maxAge)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	owners := resolveOwners(data)
+	updates := make([]*outdatedEntry, 0)
+	for _, update := range scan.Updates {
+		if severityRanks[update.Severity] >= minRank {
+			update.Owner = owners[update.Package]
+			updates = append(updates, update)
+		}
+	}
+	if report.enabled {
+		columns := []string{"Severity", "Package", "Branch", "Commit", "Latest", "CurrentTag", "LatestTag"}
+		if err := report.write(os.Stdout, updates, columns); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		return 0
+	}
+	archived := scan.Archived
+	output.Result(&outdatedReport{Updates: updates, Archived: archived}, func() {
+		printOutdated(updates, archived, owners)
+	})
+	return 0
+}
+
+func printOutdated(updates []*outdatedEntry, archived []string, owners map[string]string) {
+	if len(updates) == 0 && len(archived) == 0 {
+		fmt.Println("All dependencies are up to date")
+		return
+	}
+
+	color := isTerminal(os.Stdout)
+	printUpdate := func(indent string, u *outdatedEntry) {
+		line := fmt.Sprintf("%-8s %s %s", u.Severity, u.Package, describeUpdate(u))
+		if code, ok := severityColors[u.Severity]; ok && color {
+			line = "\x1b[" + code + "m" + line + "\x1b[0m"
+		}
+		fmt.Println(indent + line)
+	}
+	if len(owners) == 0 {
+		for _, u := range updates {
+			printUpdate("", u)
+		}
+	} else {
+		packages := make([]string, len(updates))
+		for i, u := range updates {
+			packages[i] = u.Package
+		}
+		order, groups := groupByOwner(owners, packages)
+		for _, owner := range order {
+			fmt.Printf("%s:\n", owner)
+			for _, i := range groups[owner] {
+				printUpdate("    ", updates[i])
+			}
+		}
+	}
+	for _, pkg := range archived {
+		fmt.Printf("%-8s %s is read-only on its host, no more updates will come\n", "archived", pkg)
+	}
+}
+
+var severityColors = map[string]string{
+	severityMajor: "31",
+	severityMinor: "33",
+	severityPatch: "32",
+}
+
+func describeUpdate(u *outdatedEntry) string {
+	if u.Upstream != "" {
+		return fmt.Sprintf("behind upstream %s (%s): %s", u.Upstream, u.Branch, describeBehind(u))
+	}
+	if u.LatestTag != "" {
+		return fmt.Sprintf("%s -> %s", u.CurrentTag, u.LatestTag)
+	}
+	current := shortHash(u.Commit)
+	if u.CurrentTag != "" {
+		current = u.CurrentTag + "+" + current
+	}
+	return fmt.Sprintf("%s: %s -> %s", u.Branch, current, shortHash(u.Latest))
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
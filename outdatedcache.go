@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultOutdatedMaxAge is how long a scan is reused without -max-age.
+const defaultOutdatedMaxAge = time.Hour
+
+var outdatedScanFile = filepath.Join(".bpm", "outdated.json")
+
+// outdatedScan is the last outdated scan of a project. Pins identifies the
+// pinned commits it was made for, so changed pins invalidate it regardless of
+// its age.
+type outdatedScan struct {
+	Date     time.Time        `json:"date"`
+	Pins     string           `json:"pins"`
+	Updates  []*outdatedEntry `json:"updates"`
+	Archived []string         `json:"archived,omitempty"`
+}
+
+// scanOutdated returns the updates and archived packages of the project,
+// reusing the last scan while it is younger than maxAge and the pins haven't
+// changed, unless refresh is set.
+func scanOutdated(dir string, dependencies map[string]*bpmEntry, refresh bool, maxAge time.Duration) (*outdatedScan, error) {
+	scanFile := filepath.Join(dir, outdatedScanFile)
+	pins := hashPins(dependencies)
+	if !refresh {
+		if scan := readOutdatedScan(scanFile); scan != nil && scan.Pins == pins && time.Since(scan.Date) < maxAge {
+			log.Printf("Using the outdated scan of %s, -refresh scans again", scan.Date.Local().Format(time.RFC3339))
+			return scan, nil
+		}
+	}
+
+	updates, err := findUpdates(dir, dependencies)
+	if err != nil {
+		return nil, err
+	}
+	scan := &outdatedScan{
+		Date:     time.Now().UTC(),
+		Pins:     pins,
+		Updates:  updates,
+		Archived: findArchived(dependencies)}
+	err = createDir(filepath.Dir(scanFile))
+	var bytes []byte
+	if err == nil {
+		bytes, err = jsonEncodeIndented(scan)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(scanFile, bytes, 0644)
+	}
+	if err != nil {
+		log.Printf("Could not save the outdated scan: %s", err)
+	}
+	return scan, nil
+}
+
+func readOutdatedScan(scanFile string) *outdatedScan {
+	bytes, err := ioutil.ReadFile(scanFile)
+	if err != nil {
+		return nil
+	}
+	scan := &outdatedScan{}
+	if err := json.Unmarshal(bytes, scan); err != nil {
+		log.Printf("Ignoring invalid outdated scan %s: %s", scanFile, err)
+		return nil
+	}
+	return scan
+}
+
+// hashPins identifies the pinned commits and origins of a dependency tree.
+func hashPins(dependencies map[string]*bpmEntry) string {
+	flat := make(map[string][]*bpmEntry)
+	flattenDependencies(dependencies, flat)
+	lines := make([]string, 0)
+	for pkg, entries := range flat {
+		for _, entry := range entries {
+			lines = append(lines, pkg+" "+getEntryURL(pkg, entry)+" "+entry.Branch+" "+entry.Commit+"\n")
+		}
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type outputMode int
+
+const (
+	outputPlain outputMode = iota
+	// outputJSON prints results and failures as JSON values, one per line
+	// group, and drops text meant for people.
+	outputJSON
+	// outputQuiet prints failures only.
+	outputQuiet
+)
+
+// outputWriter is what commands print through, so that -json and -quiet
+// apply to every command without it handling them itself. It writes to the
+// current os.Stdout, which the daemon redirects.
+type outputWriter struct {
+	mode outputMode
+}
+
+var output = &outputWriter{}
+
+// setOutputMode applies the -json and -quiet flags, -json winning.
+func setOutputMode(asJSON bool, quiet bool) {
+	switch {
+	case asJSON:
+		output.mode = outputJSON
+	case quiet:
+		output.mode = outputQuiet
+	default:
+		output.mode = outputPlain
+	}
+}
+
+// Printf prints text meant for people, dropped with -json and -quiet.
+func (o *outputWriter) Printf(format string, args ...interface{}) {
+	if o.mode == outputPlain {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Println is Printf with the formatting of fmt.Println.
+func (o *outputWriter) Println(args ...interface{}) {
+	if o.mode == outputPlain {
+		fmt.Println(args...)
+	}
+}
+
+// Result prints the result of a command, v with -json and the output of
+// plain otherwise, or nothing with -quiet.
+func (o *outputWriter) Result(v interface{}, plain func()) {
+	switch o.mode {
+	case outputJSON:
+		o.writeJSON(v)
+	case outputPlain:
+		plain()
+	}
+}
+
+// writeJSON prints v as indented JSON, or logs why it can't be encoded.
+func (o *outputWriter) writeJSON(v interface{}) {
+	bytes, err := jsonEncodeIndented(v)
+	if err != nil {
+		log.Printf("Could not encode the output: %s", err)
+		return
+	}
+	os.Stdout.Write(bytes)
+}
+
+// outputFailure is a failure printed with -json.
+type outputFailure struct {
+	Error    string   `json:"error"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Errorf prints a failure, which -quiet keeps and -json prints as an object
+// with an error field.
+func (o *outputWriter) Errorf(format string, args ...interface{}) {
+	o.Problems(fmt.Sprintf(format, args...), nil)
+}
+
+// Problems prints a failure with the problems behind it, one per line
+// indented below message, also annotated with -annotations.
+func (o *outputWriter) Problems(message string, problems []string) {
+	message = strings.TrimRight(message, "\n")
+	annotate(message, problems)
+	if o.mode == outputJSON {
+		o.writeJSON(&outputFailure{Error: strings.TrimSuffix(message, ":"), Problems: problems})
+		return
+	}
+	fmt.Println(message)
+	for _, problem := range problems {
+		fmt.Printf("    %s\n", problem)
+	}
+}
+
+// noDependencyFile reports the missing manifest of a project.
+func noDependencyFile(depFile string) {
+	output.Errorf("%s does not exist: %s", dependencyFilename, depFile)
+}
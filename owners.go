@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unownedGroup heads the packages without an owner in grouped reports.
+const unownedGroup = "(no owner)"
+
+// ownerRules merges the owners patterns of p and its bases, nearer manifests
+// winning. Patterns are package paths, which also cover the packages below
+// them, or globs like "github.com/aws/*".
+func (p *bpmPackage) ownerRules() map[string]string {
+	rules := make(map[string]string)
+	for _, m := range p.chain() {
+		for pattern, owner := range m.Owners {
+			rules[pattern] = owner
+		}
+	}
+	return rules
+}
+
+// resolveOwners maps every package of the dependency tree to its owner: the
+// owner set on its entry, else that of the longest matching owners pattern,
+// else the owner of the dependency that brought it in.
+func resolveOwners(data *bpmPackage) map[string]string {
+	owners := make(map[string]string)
+	collectOwners(data.Dependencies, data.ownerRules(), "", owners)
+	return owners
+}
+
+func collectOwners(dependencies map[string]*bpmEntry, rules map[string]string, inherited string, owners map[string]string) {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		owner := entry.Owner
+		if owner == "" {
+			owner = matchOwner(rules, pkg)
+		}
+		if owner == "" {
+			owner = inherited
+		}
+		if _, ok := owners[pkg]; !ok && owner != "" {
+			owners[pkg] = owner
+		}
+		collectOwners(entry.Dependencies, rules, owner, owners)
+	}
+}
+
+func matchOwner(rules map[string]string, pkg string) string {
+	best := ""
+	for pattern := range rules {
+		if matchOwnerPattern(pattern, pkg) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+	return rules[best]
+}
+
+func matchOwnerPattern(pattern string, pkg string) bool {
+	parts := strings.Split(pkg, "/")
+	for i := len(parts); i > 0; i-- {
+		if matched, _ := path.Match(pattern, strings.Join(parts[:i], "/")); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// groupByOwner returns the owners of packages in report order, unowned last,
+// with the indexes of the packages of each.
+func groupByOwner(owners map[string]string, packages []string) ([]string, map[string][]int) {
+	groups := make(map[string][]int)
+	for i, pkg := range packages {
+		owner := owners[pkg]
+		if owner == "" {
+			owner = unownedGroup
+		}
+		groups[owner] = append(groups[owner], i)
+	}
+	order := make([]string, 0, len(groups))
+	for owner := range groups {
+		if owner != unownedGroup {
+			order = append(order, owner)
+		}
+	}
+	sort.Strings(order)
+	if _, ok := groups[unownedGroup]; ok {
+		order = append(order, unownedGroup)
+	}
+	return order, groups
+}
+
+// doOwners prints the owner of every dependency, or the distinct owners of
+// the given packages one per line, e.g. to request reviews for a change
+// touching them.
+func doOwners(dir string, packages []string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	owners := resolveOwners(data)
+
+	if len(packages) == 0 {
+		flat := make(map[string][]*bpmEntry)
+		flattenDependencies(data.Dependencies, flat)
+		all := make([]string, 0, len(flat))
+		for pkg := range flat {
+			all = append(all, pkg)
+		}
+		sort.Strings(all)
+		order, groups := groupByOwner(owners, all)
+		byOwner := make(map[string][]string, len(order))
+		for _, owner := range order {
+			for _, i := range groups[owner] {
+				byOwner[owner] = append(byOwner[owner], all[i])
+			}
+		}
+		output.Result(byOwner, func() {
+			for _, owner := range order {
+				fmt.Printf("%s:\n", owner)
+				for _, pkg := range byOwner[owner] {
+					fmt.Printf("    %s\n", pkg)
+				}
+			}
+		})
+		return 0
+	}
+
+	code := 0
+	seen := make(map[string]bool)
+	distinct := make([]string, 0)
+	for _, pkg := range packages {
+		owner, ok := owners[pkg]
+		if entry, _ := findDependency(dir, data.Dependencies, pkg); entry == nil {
+			output.Errorf("%s is not a dependency", pkg)
+			code = 1
+		} else if ok && !seen[owner] {
+			seen[owner] = true
+			distinct = append(distinct, owner)
+		}
+	}
+	output.Result(distinct, func() {
+		for _, owner := range distinct {
+			fmt.Println(owner)
+		}
+	})
+	return code
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+const patchesFolderName = "patches"
+
+// getPatches returns the patch files for a package from
+// $root/patches/<package>/*.patch in the order they are applied.
+func getPatches(root string, pkg string) []string {
+	patches, err := filepath.Glob(filepath.Join(root, patchesFolderName, filepath.FromSlash(pkg), "*.patch"))
+	if err != nil {
+		// Only a malformed pattern fails, which no valid package path makes.
+		log.Printf("Could not list the patches of %s: %s", pkg, err)
+		return nil
+	}
+	sort.Strings(patches)
+	return patches
+}
+
+func applyAllPatches(root string, dir string, dependencies map[string]*bpmEntry) error {
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !isGitRepo(pkgDir) {
+			continue
+		}
+		if err := applyPatches(root, pkg, pkgDir); err != nil {
+			return err
+		}
+		if err := applyAllPatches(root, pkgDir, entry.Dependencies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyPatches(root string, pkg string, pkgDir string) error {
+	for _, patch := range getPatches(root, pkg) {
+		if canApplyPatch(pkgDir, patch, false) {
+			log.Printf("Applying patch %s to %s", patch, pkg)
+			if _, err := runCmd(&pkgDir, false, "git", "apply", patch); err != nil {
+				return err
+			}
+			continue
+		}
+		if canApplyPatch(pkgDir, patch, true) {
+			continue
+		}
+		return fmt.Errorf("patch %s no longer applies to %s in %s", patch, pkg, pkgDir)
+	}
+	return nil
+}
+
+// revertPatches undoes applied patches so the package can be checked out
+// cleanly; they are re-applied after install.
+func revertPatches(root string, pkg string, pkgDir string) error {
+	patches := getPatches(root, pkg)
+	for i := len(patches) - 1; i >= 0; i-- {
+		if canApplyPatch(pkgDir, patches[i], true) {
+			if _, err := runCmd(&pkgDir, false, "git", "apply", "-R", patches[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isPatched reports whether the package has patches and all of them are
+// currently applied.
+func isPatched(root string, pkg string, pkgDir string) bool {
+	patches := getPatches(root, pkg)
+	for i := len(patches) - 1; i >= 0; i-- {
+		if !canApplyPatch(pkgDir, patches[i], true) {
+			return false
+		}
+	}
+	return len(patches) > 0
+}
+
+func canApplyPatch(pkgDir string, patch string, reverse bool) bool {
+	args := []string{"apply", "--check"}
+	if reverse {
+		args = append(args, "-R")
+	}
+	cmd := gitCommand(&pkgDir, append(args, patch)...)
+	return cmd.Run() == nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// maxPathLength is MAX_PATH on Windows, less the terminating NUL.
+	maxPathLength = 259
+	// checkoutPathReserve stands in for the project location on Windows when
+	// checking from other platforms, e.g. C:\Users\name\go\src\github.com\org\.
+	checkoutPathReserve = 60
+)
+
+// checkPathLengths warns about vendored files whose paths exceed MAX_PATH on
+// Windows, where the project is checked out or, on other platforms, would
+// typically be.
+func checkPathLengths(dir string) {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	if !fileExists(vendorDir) {
+		return
+	}
+	reserve := checkoutPathReserve
+	if runtime.GOOS == "windows" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			reserve = len(abs) + 1
+		}
+	}
+
+	count, longest, deepest := 0, "", 0
+	err := filepath.Walk(vendorDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == gitFolderName {
+			return filepath.SkipDir
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if reserve+len(rel) <= maxPathLength {
+			return nil
+		}
+		count++
+		if len(rel) > len(longest) {
+			longest = rel
+		}
+		if depth := strings.Count(filepath.ToSlash(rel), vendorFolderName+"/"); depth > deepest {
+			deepest = depth
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Could not check the path lengths in %s: %s", vendorDir, err)
+		return
+	}
+	if count == 0 {
+		return
+	}
+
+	output.Printf("Warning: %d vendored paths exceed the Windows limit of %d characters, the longest is %d:\n    %s\n",
+		count, maxPathLength, reserve+len(longest), longest)
+	if runtime.GOOS == "windows" {
+		output.Println("    Enable LongPathsEnabled in the registry (HKLM\\SYSTEM\\CurrentControlSet\\Control\\FileSystem); bpm sets core.longpaths on its clones.")
+	}
+	if deepest > 1 {
+		output.Printf("    Vendor folders are nested %d levels deep. The flat layout of bpm rebuild -flat avoids this.\n", deepest)
+	}
+}
+
+// longPathArgs returns the git options that let clones check out long paths
+// on Windows.
+func longPathArgs() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"-c", "core.longpaths=true"}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// platforms is set by -platforms to the GOOS/GOARCH pairs imports are
+// scanned for, like linux/amd64,darwin/arm64. The platforms of bpm.json
+// apply without it. With neither, every file is scanned.
+var platforms = ""
+
+// buildTags are the tags of bpm.json files are built with on every platform.
+var buildTags []string
+
+// platformContexts returns a build context for each target platform, cgo
+// enabled so files only built with cgo count too.
+func platformContexts() ([]*build.Context, error) {
+	if platforms == "" {
+		return nil, nil
+	}
+	contexts := make([]*build.Context, 0)
+	for _, platform := range strings.Split(platforms, ",") {
+		parts := strings.Split(strings.TrimSpace(platform), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q, expected GOOS/GOARCH like linux/amd64", platform)
+		}
+		ctx := build.Default
+		ctx.GOOS, ctx.GOARCH = parts[0], parts[1]
+		ctx.CgoEnabled = true
+		ctx.BuildTags = buildTags
+		contexts = append(contexts, &ctx)
+	}
+	return contexts, nil
+}
+
+// matchesPlatforms is whether any target platform builds the file, going by
+// its name and build constraints.
+func matchesPlatforms(contexts []*build.Context, filename string) bool {
+	if len(contexts) == 0 {
+		return true
+	}
+	for _, ctx := range contexts {
+		ok, err := ctx.MatchFile(filepath.Dir(filename), filepath.Base(filename))
+		if err != nil || ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPlatforms takes the target platforms of a manifest unless -platforms
+// or a manifest read before set them.
+func applyPlatforms(p *bpmPackage) {
+	if platforms == "" {
+		platforms = strings.Join(p.Platforms, ",")
+	}
+	for _, tag := range p.BuildTags {
+		if !containsString(buildTags, tag) {
+			buildTags = append(buildTags, tag)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// policyCandidate is what policy plugins get on stdin for every dependency
+// an install vendors.
+type policyCandidate struct {
+	Package string `json:"package"`
+	URL     string `json:"url"`
+	Kind    string `json:"kind,omitempty"`
+	Version string `json:"version,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	License string `json:"license"`
+	Hash    string `json:"hash,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	// VendoredBy is the dependency vendoring this one, empty at the top.
+	VendoredBy string `json:"vendoredBy,omitempty"`
+}
+
+// checkPolicyPlugins runs the plugins of the policy for every vendored
+// dependency, returning the vetoes. A plugin is a shell command run in the
+// project folder with the candidate as JSON on stdin; exiting non-zero
+// vetoes the dependency, with what the plugin printed as the reason.
+func checkPolicyPlugins(dir string, data *bpmPackage) []string {
+	policy := data.policy()
+	if policy == nil || len(policy.Plugins) == 0 {
+		return nil
+	}
+	candidates := make([]*policyCandidate, 0)
+	collectPolicyCandidates(dir, "", data.Dependencies, &candidates)
+	problems := make([]string, 0)
+	for _, candidate := range candidates {
+		input, err := jsonEncodeIndented(candidate)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", candidate.Package, err))
+			continue
+		}
+		for _, plugin := range policy.Plugins {
+			if reason, ok := runPolicyPlugin(dir, plugin, input); !ok {
+				problems = append(problems, fmt.Sprintf("%s: vetoed by %s: %s", candidate.Package, plugin, reason))
+			}
+		}
+	}
+	return problems
+}
+
+func collectPolicyCandidates(dir string, parent string, dependencies map[string]*bpmEntry, candidates *[]*policyCandidate) {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			continue
+		}
+		*candidates = append(*candidates, &policyCandidate{
+			Package:    pkg,
+			URL:        getEntryURL(pkg, entry),
+			Kind:       entry.Kind,
+			Version:    entry.Version,
+			Tag:        entry.Tag,
+			Branch:     entry.Branch,
+			Commit:     entry.Commit,
+			License:    detectLicense(pkgDir),
+			Hash:       entry.Hash,
+			SHA256:     entry.SHA256,
+			VendoredBy: parent})
+		collectPolicyCandidates(pkgDir, pkg, entry.Dependencies, candidates)
+	}
+}
+
+// runPolicyPlugin returns whether the plugin accepts the candidate, and its
+// reason when it doesn't.
+func runPolicyPlugin(dir string, plugin string, input []byte) (string, bool) {
+	out := &bytes.Buffer{}
+	cmd := shellCommand(dir, plugin, nil)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		reason := strings.TrimSpace(out.String())
+		if reason == "" {
+			reason = err.Error()
+		}
+		return reason, false
+	}
+	return "", true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+var releaseVersionPattern = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+func doPublish(dir string, version string) int {
+	if !releaseVersionPattern.MatchString(version) {
+		output.Errorf("Invalid release version %q, expected vX.Y.Z", version)
+		return 1
+	}
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	changes, err := getLocalChanges(dir)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	problems := make([]string, 0)
+	for _, change := range changes {
+		problems = append(problems, fmt.Sprintf("uncommitted change: %s", change))
+	}
+	problems = append(problems, checkFrozen(data.Dependencies)...)
+	problems = append(problems, verifyVendor(dir, dir, data.Dependencies)...)
+	if len(problems) > 0 {
+		output.Problems(fmt.Sprintf("Cannot publish %s:", version), problems)
+		return 1
+	}
+
+	if err := runHooks(dir, data, prePublishHook); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	if _, err := runCmd(&dir, false, "git", "tag", "-a", version, "-m", "Release "+version); err != nil {
+		output.Errorf("Could not tag %s: %s", version, err)
+		return 1
+	}
+	if _, err := runCmd(&dir, false, "git", "push", "origin", version); err != nil {
+		output.Errorf("Could not push %s: %s", version, err)
+		return 1
+	}
+	output.Printf("Published %s %s\n", data.Package, version)
+	return 0
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Exit codes besides 0 and 1. 3 and 4 are the results of check-updates and
+// exitInterrupted is 130, so every code has one meaning across commands.
+const (
+	// exitPullFailed is returned when some dependencies couldn't be pulled,
+	// the others were pulled completely.
+	exitPullFailed = 5
+	// exitAborted is returned when a command stopped on an unexpected error.
+	exitAborted = 6
+)
+
+// pullError lists the dependencies a pull failed for with their errors.
+type pullError struct {
+	failures map[string]error
+}
+
+func (e *pullError) add(pkg string, err error) {
+	if e.failures == nil {
+		e.failures = make(map[string]error)
+	}
+	e.failures[pkg] = err
+}
+
+func (e *pullError) packages() []string {
+	packages := make([]string, 0, len(e.failures))
+	for pkg := range e.failures {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+func (e *pullError) Error() string {
+	return fmt.Sprintf("could not pull %d dependencies: %v", len(e.failures), e.packages())
+}
+
+// orNil returns nil instead of an empty pullError.
+func (e *pullError) orNil() error {
+	if len(e.failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+// reportPullError prints the failed dependencies of a pull and returns the
+// exit code for it.
+func reportPullError(err error) int {
+	if interrupted() != nil {
+		output.Errorf("Interrupted, incomplete dependencies were removed or marked to be pulled again")
+		return exitInterrupted
+	}
+	if pe, ok := err.(*pullError); ok {
+		problems := make([]string, 0, len(pe.failures))
+		for _, pkg := range pe.packages() {
+			problems = append(problems, fmt.Sprintf("%s: %s", pkg, pe.failures[pkg]))
+		}
+		output.Problems(fmt.Sprintf("Failed to pull %d dependencies:", len(pe.failures)), problems)
+		return exitPullFailed
+	}
+	output.Errorf("%s", err)
+	return exitAborted
+}
+
+// recoverError turns a panic of the running goroutine into an error for err,
+// so a bug stops at the boundary of a dependency instead of killing the whole
+// process. Expected failures are returned as errors, not panics.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(error); ok {
+			*err = e
+		} else {
+			*err = fmt.Errorf("%v", r)
+		}
+	}
+}
+
+// exitOnPanic ends the process with exitAborted on a panic that no command
+// handled, printing its message rather than a stack trace.
+func exitOnPanic() {
+	if r := recover(); r != nil {
+		if interrupted() != nil {
+			log.Printf("Stopped after an interrupt: %v", r)
+			output.Errorf("Interrupted")
+			exit(exitInterrupted)
+		}
+		log.Printf("Aborted: %v", r)
+		output.Errorf("bpm stopped on an error: %v", r)
+		exit(exitAborted)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// readOnlyMarkerFilename marks a vendor folder locked by install
+// -vendor-read-only, until bpm unlock.
+const readOnlyMarkerFilename = ".bpm-read-only"
+
+func isVendorLocked(dir string) bool {
+	return fileExists(filepath.Join(dir, vendorFolderName, readOnlyMarkerFilename))
+}
+
+// checkVendorUnlocked prints how to unlock the vendor folder and returns
+// false when it is read-only.
+func checkVendorUnlocked(dir string) bool {
+	if !isVendorLocked(dir) {
+		return true
+	}
+	output.Errorf("%s is read-only, run bpm unlock before modifying it", filepath.Join(dir, vendorFolderName))
+	return false
+}
+
+// lockVendor removes write permissions from every file and folder in vendor,
+// so build steps can't change dependencies by accident. Windows only honors
+// the read-only attribute of files.
+func lockVendor(dir string) error {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	if !fileExists(vendorDir) {
+		return nil
+	}
+	if err := ioutil.WriteFile(filepath.Join(vendorDir, readOnlyMarkerFilename), []byte{}, 0444); err != nil {
+		return err
+	}
+	if err := setVendorWritable(vendorDir, false); err != nil {
+		return err
+	}
+	log.Printf("Made %s read-only", vendorDir)
+	return nil
+}
+
+func doUnlock(dir string) int {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	if !isVendorLocked(dir) {
+		output.Printf("%s is not read-only\n", vendorDir)
+		return 0
+	}
+	if err := setVendorWritable(vendorDir, true); err != nil {
+		output.Errorf("Could not unlock %s: %s", vendorDir, err)
+		return 1
+	}
+	if err := os.Remove(filepath.Join(vendorDir, readOnlyMarkerFilename)); err != nil {
+		output.Errorf("Could not unlock %s: %s", vendorDir, err)
+		return 1
+	}
+	output.Printf("Unlocked %s\n", vendorDir)
+	return 0
+}
+
+// setVendorWritable adds or removes the owner write permission. Folders are
+// unlocked before their contents are walked and locked after.
+func setVendorWritable(vendorDir string, writable bool) error {
+	var walk func(path string, info os.FileInfo) error
+	walk = func(path string, info os.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		mode := info.Mode().Perm()
+		if writable {
+			mode |= 0200
+		} else {
+			mode &^= 0222
+		}
+		if info.IsDir() && writable {
+			if err := os.Chmod(path, mode); err != nil {
+				return err
+			}
+		}
+		if info.IsDir() {
+			children, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				if err := walk(filepath.Join(path, child.Name()), child); err != nil {
+					return err
+				}
+			}
+		}
+		if !info.IsDir() || !writable {
+			return os.Chmod(path, mode)
+		}
+		return nil
+	}
+	info, err := os.Lstat(vendorDir)
+	if err != nil {
+		return err
+	}
+	return walk(vendorDir, info)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// refTrackingPrefix is where fetched refs are kept in a checkout, so the
+// pinned commit stays reachable: refs/pull/42/head becomes
+// refs/bpm/pull/42/head.
+const refTrackingPrefix = "refs/bpm/"
+
+// findRef returns the commit the ref of an entry points at on its remote.
+// Refs outside branches and tags, like refs/changes/34/1234/2, aren't in the
+// cached remote listings and are asked for one by one.
+func findRef(pkg string, entry *bpmEntry) (string, error) {
+	repoURL := getEntryURL(pkg, entry)
+	refs, ok, err := snapshotRefs(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		throttle := getHostThrottle(repoURL)
+		throttle.acquire()
+		defer throttle.release()
+		out, err := runCmd(nil, true, "git", "ls-remote", mirrorURL(repoURL), entry.Ref)
+		if err != nil {
+			return "", err
+		}
+		refs = parseLsRemote(out)
+	}
+	if err := recordRefs(repoURL, refs); err != nil {
+		return "", err
+	}
+	commit, ok := refs[entry.Ref]
+	if !ok {
+		return "", fmt.Errorf("ref %s of %s does not exist", entry.Ref, pkg)
+	}
+	return commit, nil
+}
+
+// fetchRef fetches a ref into a checkout unless it has the commit already,
+// as clones only get branches and tags.
+func fetchRef(pkgDir string, ref string, commit string) error {
+	if commit != "" && hasCommit(pkgDir, commit) {
+		return nil
+	}
+	args := []string{"fetch", "--quiet"}
+	if isShallowRepo(pkgDir) {
+		args = append(args, "--depth", "1")
+	}
+	tracking := refTrackingPrefix + strings.TrimPrefix(ref, "refs/")
+	if err := runGit(&pkgDir, append(args, "origin", "+"+ref+":"+tracking)...); err != nil {
+		return err
+	}
+	if commit != "" && !hasCommit(pkgDir, commit) {
+		// The ref moved on, as pull request heads do; hosts may still serve
+		// the pinned commit itself.
+		log.Printf("%s no longer points at %s, fetching the commit", ref, shortHash(commit))
+		if err := runGit(&pkgDir, "fetch", "--quiet", "origin", commit); err != nil {
+			return fmt.Errorf("commit %s of %s is not available anymore, pin the entry again: %s", shortHash(commit), ref, err)
+		}
+	}
+	return nil
+}
+
+// fetchRef checks a fetched package out at the ref it was declared with,
+// failing when the ref can't be checked out, as a change under test must
+// not quietly become the head of the repository.
+func (r *resolver) fetchRef(pkg string, pkgDir string, declared *bpmEntry, fetched *bpmEntry) (*bpmEntry, error) {
+	commit, err := findRef(pkg, declared)
+	if err != nil {
+		return nil, err
+	}
+	entry := r.src.checkout(pkg, pkgDir, &bpmEntry{Branch: fetched.Branch, Ref: declared.Ref, Commit: commit})
+	if entry == nil {
+		return nil, fmt.Errorf("could not check %s out at %s", pkg, declared.Ref)
+	}
+	entry.URL = fetched.URL
+	entry.Ref = declared.Ref
+	return entry, nil
+}
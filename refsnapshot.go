@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+var (
+	// refsSnapshotFile is set by -refs-snapshot to a file of remote listings
+	// read instead of the remotes, so re-running a resolution picks the same
+	// tags and branch tips. Remotes missing from it are an error.
+	refsSnapshotFile = ""
+	// recordRefsFile is set by -record-refs to a file every remote listing
+	// is saved to, for -refs-snapshot.
+	recordRefsFile = ""
+)
+
+// refsSnapshot maps remote URLs to their refs, as lsRemote lists them.
+type refsSnapshot struct {
+	Date    string                       `json:"date"`
+	Remotes map[string]map[string]string `json:"remotes"`
+}
+
+var refsSnapshots = struct {
+	sync.Mutex
+	replay   *refsSnapshot
+	recorded *refsSnapshot
+}{}
+
+// snapshotRefs returns the refs of repoURL in the -refs-snapshot file, and
+// whether there is one to replay.
+func snapshotRefs(repoURL string) (map[string]string, bool, error) {
+	if refsSnapshotFile == "" {
+		return nil, false, nil
+	}
+	refsSnapshots.Lock()
+	defer refsSnapshots.Unlock()
+	if refsSnapshots.replay == nil {
+		bytes, err := ioutil.ReadFile(refsSnapshotFile)
+		if err != nil {
+			return nil, true, err
+		}
+		snapshot := &refsSnapshot{}
+		if err := json.Unmarshal(bytes, snapshot); err != nil {
+			return nil, true, fmt.Errorf("invalid refs snapshot %s: %s", refsSnapshotFile, err)
+		}
+		refsSnapshots.replay = snapshot
+	}
+	listing, ok := refsSnapshots.replay.Remotes[repoURL]
+	if !ok {
+		return nil, true, fmt.Errorf("%s isn't in the refs snapshot %s, record it again with -record-refs", repoURL, refsSnapshotFile)
+	}
+	refs := make(map[string]string, len(listing))
+	for ref, hash := range listing {
+		refs[ref] = hash
+	}
+	return refs, true, nil
+}
+
+// recordRefs adds refs of repoURL to the -record-refs file, rewriting it so
+// it is complete whenever the command stops.
+func recordRefs(repoURL string, refs map[string]string) error {
+	if recordRefsFile == "" {
+		return nil
+	}
+	refsSnapshots.Lock()
+	defer refsSnapshots.Unlock()
+	if refsSnapshots.recorded == nil {
+		refsSnapshots.recorded = &refsSnapshot{
+			Date:    time.Now().UTC().Format(time.RFC3339),
+			Remotes: make(map[string]map[string]string)}
+	}
+	listing, ok := refsSnapshots.recorded.Remotes[repoURL]
+	if !ok {
+		listing = make(map[string]string)
+		refsSnapshots.recorded.Remotes[repoURL] = listing
+	}
+	for ref, hash := range refs {
+		listing[ref] = hash
+	}
+	bytes, err := jsonEncodeIndented(refsSnapshots.recorded)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(recordRefsFile, bytes)
+}
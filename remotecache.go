@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultRemoteCacheTTL = 10 * time.Minute
+
+// remoteCacheDisabled bypasses the ls-remote cache, set by -no-remote-cache.
+var remoteCacheDisabled bool
+
+type remoteListing struct {
+	URL       string            `json:"url"`
+	FetchedAt time.Time         `json:"fetchedAt"`
+	Refs      map[string]string `json:"refs"`
+}
+
+// lsRemote lists the remote refs matching the patterns, mapping ref names to
+// commit hashes. Annotated tags are reported by their peeled commit. Patterns
+// match like git's: either the whole ref name as a glob or its trailing
+// components. With -refs-snapshot the refs come from the snapshot instead.
+func lsRemote(repoURL string, patterns ...string) (map[string]string, error) {
+	refs, ok, err := snapshotRefs(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if refs, err = listRemoteRefs(repoURL); err != nil {
+			return nil, err
+		}
+	}
+	if err := recordRefs(repoURL, refs); err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return refs, nil
+	}
+	result := make(map[string]string)
+	for ref, hash := range refs {
+		for _, pattern := range patterns {
+			if matchRef(pattern, ref) {
+				result[ref] = hash
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func matchRef(pattern string, ref string) bool {
+	if ref == pattern || strings.HasSuffix(ref, "/"+pattern) {
+		return true
+	}
+	matched, _ := path.Match(pattern, ref)
+	return matched
+}
+
+// listRemoteRefs returns HEAD, branches and tags of a remote, served from the
+// global cache while younger than the configured remoteCacheTTL. Processes
+// listing the same remote at once wait for the first one to cache it.
+func listRemoteRefs(repoURL string) (map[string]string, error) {
+	// Checked by loadGlobalConfig.
+	ttl, _ := parseDurationSetting("remoteCacheTTL", getGlobalConfig().RemoteCacheTTL, defaultRemoteCacheTTL)
+	sum := sha256.Sum256([]byte(repoURL))
+	cacheFile := filepath.Join(getCacheDir("remotes"), hex.EncodeToString(sum[:])+".json")
+
+	if !remoteCacheDisabled && ttl > 0 {
+		if refs := readRemoteListing(cacheFile, repoURL, ttl); refs != nil {
+			countCacheLookup(true)
+			return refs, nil
+		}
+		unlock, err := lockCache("remote", repoURL)
+		if err != nil {
+			log.Printf("Not caching remote refs of %s: %s", repoURL, err)
+			ttl = 0
+		} else {
+			defer unlock()
+			if refs := readRemoteListing(cacheFile, repoURL, ttl); refs != nil {
+				countCacheLookup(true)
+				return refs, nil
+			}
+		}
+	}
+
+	countCacheLookup(false)
+	throttle := getHostThrottle(repoURL)
+	throttle.acquire()
+	defer throttle.release()
+	out, err := runCmd(nil, true, "git", "ls-remote", mirrorURL(repoURL), "HEAD", "refs/heads/*", "refs/tags/*")
+	if err != nil {
+		return nil, err
+	}
+	listing := &remoteListing{
+		URL:       repoURL,
+		FetchedAt: time.Now().UTC(),
+		Refs:      parseLsRemote(out)}
+	if ttl > 0 {
+		bytes, _ := json.Marshal(listing)
+		tmp := cacheFile + ".tmp"
+		if err := ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+			log.Printf("Could not cache remote refs of %s: %s", repoURL, err)
+		} else if err := os.Rename(tmp, cacheFile); err != nil {
+			log.Printf("Could not cache remote refs of %s: %s", repoURL, err)
+		}
+	}
+	return listing.Refs, nil
+}
+
+func readRemoteListing(cacheFile string, repoURL string, ttl time.Duration) map[string]string {
+	listing := remoteListing{}
+	bytes, err := ioutil.ReadFile(cacheFile)
+	if err != nil || json.Unmarshal(bytes, &listing) != nil {
+		return nil
+	}
+	if listing.URL != repoURL || time.Since(listing.FetchedAt) >= ttl {
+		return nil
+	}
+	return listing.Refs
+}
+
+func parseLsRemote(out []byte) map[string]string {
+	refs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		hash, ref := fields[0], fields[1]
+		if strings.HasSuffix(ref, "^{}") {
+			refs[strings.TrimSuffix(ref, "^{}")] = hash
+			continue
+		}
+		if _, ok := refs[ref]; !ok {
+			refs[ref] = hash
+		}
+	}
+	return refs
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLsRemote(t *testing.T) {
+	out := []byte("a1\tHEAD\n" +
+		"a1\trefs/heads/master\n" +
+		"t1\trefs/tags/v1.0.0\n" +
+		"c1\trefs/tags/v1.0.0^{}\n" +
+		"c2\trefs/tags/v1.1.0^{}\n" +
+		"t2\trefs/tags/v1.1.0\n" +
+		"malformed line here\n" +
+		"\n")
+	expected := map[string]string{
+		"HEAD":              "a1",
+		"refs/heads/master": "a1",
+		"refs/tags/v1.0.0":  "c1",
+		"refs/tags/v1.1.0":  "c2",
+	}
+	refs := parseLsRemote(out)
+	if len(refs) != len(expected) {
+		t.Errorf("parsed refs are %v, expected %v", refs, expected)
+	}
+	for ref, hash := range expected {
+		if refs[ref] != hash {
+			t.Errorf("%s points at %q, expected %q", ref, refs[ref], hash)
+		}
+	}
+}
+
+func TestMatchRef(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ref     string
+		matches bool
+	}{
+		{"HEAD", "HEAD", true},
+		{"refs/heads/master", "refs/heads/master", true},
+		{"master", "refs/heads/master", true},
+		{"master", "refs/heads/old-master", false},
+		{"refs/tags/*", "refs/tags/v1.0.0", true},
+		{"refs/tags/*", "refs/heads/v1.0.0", false},
+		{"refs/tags/v1.*", "refs/tags/v1.2.0", true},
+		{"refs/heads/*", "refs/heads/feature/x", false},
+	}
+	for _, test := range tests {
+		if got := matchRef(test.pattern, test.ref); got != test.matches {
+			t.Errorf("matchRef(%q, %q) is %v, expected %v", test.pattern, test.ref, got, test.matches)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// doRemove drops a direct dependency from the manifest and deletes its vendor
+// folder, with the nested dependencies no remaining package uses. Copies of
+// those that other packages had deduplicated against it are pulled again.
+// In a flat layout, the indirect dependencies nothing imports anymore are
+// removed. A package the project still imports is only removed with -force.
+func doRemove(dir string, pkg string, force bool) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	if pkg == "" {
+		output.Errorf("No package given: bpm remove <pkg>")
+		return 1
+	}
+	if !checkVendorUnlocked(dir) {
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	entry, ok := data.Dependencies[pkg]
+	if !ok {
+		if nested, _ := findDependency(dir, data.Dependencies, pkg); nested != nil {
+			output.Errorf("%s is not a direct dependency, remove the package depending on it instead", pkg)
+		} else {
+			output.Errorf("%s is not a dependency", pkg)
+		}
+		return 1
+	}
+	if !isAssets(entry) && entry.Kind != entryKindTool && !force {
+		imports, err := getImportPaths(dir)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		if isImported(imports, pkg) {
+			output.Errorf("%s is still imported by the project, run remove with -force to remove it anyway", pkg)
+			return 1
+		}
+	}
+
+	if _, err := takeSnapshot(dir, "remove"); err != nil {
+		output.Errorf("Could not take a snapshot: %s", err)
+		return 1
+	}
+	delete(data.Dependencies, pkg)
+	removed := make(map[string]bool)
+	collectPackages(entry.Dependencies, removed)
+
+	pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+	if err := removeDir(pkgDir); err != nil {
+		output.Errorf("Could not remove %s: %s", pkgDir, err)
+		return 1
+	}
+	removeEmptyParents(filepath.Dir(pkgDir), filepath.Join(dir, vendorFolderName))
+	if data.Layout == layoutFlat {
+		flatRemoved, err := syncFlatVendor(dir, data)
+		if err != nil {
+			return reportPullError(err)
+		}
+		for _, name := range flatRemoved {
+			removed[name] = true
+		}
+	}
+	if err := pullPackages(data.Dependencies, dir); err != nil {
+		return reportPullError(err)
+	}
+	if err := postInstall(dir, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if err := writeDataFile(depFile, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	remaining := make(map[string]bool)
+	collectPackages(data.Dependencies, remaining)
+	transitive := make([]string, 0)
+	for name := range removed {
+		if !remaining[name] {
+			transitive = append(transitive, name)
+		}
+	}
+	sort.Strings(transitive)
+	output.Printf("Removed %s\n", pkg)
+	if len(transitive) > 0 {
+		output.Printf("Removed %d dependencies only it used: %s\n", len(transitive), strings.Join(transitive, ", "))
+	}
+	return 0
+}
+
+// removeEmptyParents removes dir and its parents up to root while they are
+// empty, e.g. the host folder of the last package vendored from a host.
+func removeEmptyParents(dir string, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
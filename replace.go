@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ways of handling replace directives in the bpm.json of dependencies, set by
+// transitiveReplaces in the policy. They are ignored by default.
+const (
+	replacesIgnore  = "ignore"
+	replacesHonor   = "honor"
+	replacesConfirm = "confirm"
+)
+
+// transitiveReplace is a replace directive of a dependency that took effect.
+type transitiveReplace struct {
+	pkg        string
+	url        string
+	declaredBy string
+}
+
+// transitiveReplaces is how the policy of p handles replace directives of
+// dependencies.
+func (p *bpmPackage) transitiveReplaces() string {
+	policy := p.policy()
+	if policy == nil || policy.TransitiveReplaces == "" {
+		return replacesIgnore
+	}
+	return policy.TransitiveReplaces
+}
+
+// noteReplaces returns the replace directives in effect for the packages
+// resolved below the dependency pkg, fetched into pkgDir: inherited, the
+// ones in effect for pkg itself, with those of its bpm.json added.
+// Replacements of the project itself and of dependencies nearer the top win.
+func (r *resolver) noteReplaces(pkg string, pkgDir string, inherited map[string]string) map[string]string {
+	manifest, err := ioutil.ReadFile(filepath.Join(pkgDir, dependencyFilename))
+	if err != nil {
+		return inherited
+	}
+	data := bpmPackage{}
+	if err := json.Unmarshal(manifest, &data); err != nil {
+		log.Printf("Could not read replace directives of %s: %s", pkg, err)
+		return inherited
+	}
+	scoped := make(map[string]string, len(inherited)+len(data.Replace))
+	for replaced, url := range inherited {
+		scoped[replaced] = url
+	}
+	for _, replaced := range sortedStringKeys(data.Replace) {
+		replace := transitiveReplace{pkg: replaced, url: data.Replace[replaced], declaredBy: pkg}
+		if _, ok := replacements[replaced]; ok {
+			continue
+		}
+		if _, ok := scoped[replaced]; ok {
+			continue
+		}
+		if !r.honorReplace(replace) {
+			continue
+		}
+		scoped[replaced] = replace.url
+	}
+	return scoped
+}
+
+// honorReplace is whether a replace directive of a dependency takes effect,
+// asking once per directive in confirm mode.
+func (r *resolver) honorReplace(replace transitiveReplace) bool {
+	for _, honored := range r.replaced {
+		if honored == replace {
+			return true
+		}
+	}
+	switch r.replaceMode {
+	case replacesHonor:
+	case replacesConfirm:
+		if !confirm(fmt.Sprintf("%s replaces %s with %s. Honor it?", replace.declaredBy, replace.pkg, replace.url)) {
+			return false
+		}
+	default:
+		log.Printf("Ignoring replace of %s with %s declared by %s", replace.pkg, replace.url, replace.declaredBy)
+		return false
+	}
+	r.replaced = append(r.replaced, replace)
+	return true
+}
+
+// confirm asks a yes or no question on the terminal, answering no when
+// there is none.
+func confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		output.Printf("%s No terminal to confirm on, answering no\n", question)
+		return false
+	}
+	output.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
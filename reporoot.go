@@ -0,0 +1,126 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultRootSegments is the number of path elements of repository roots,
+// counting the host, on hosts that serve no go-import meta tag.
+const defaultRootSegments = 3
+
+// hostRootConfig is how the repository root of an import path is found on a
+// host, set in the hosts of the global config.
+type hostRootConfig struct {
+	// Segments is the number of path elements of a repository root, counting
+	// the host, like 5 for git.example.com/team/sub/group/repo.
+	Segments int `json:"segments,omitempty"`
+	// Pattern matches the repository root at the start of import paths,
+	// taking precedence over Segments.
+	Pattern string `json:"pattern,omitempty"`
+	// GoGet asks the host for go-import meta tags, for hosts with nested
+	// groups like GitLab.
+	GoGet bool `json:"goGet,omitempty"`
+}
+
+// knownHostRoots are the rules of the public code hosts, which are never
+// asked for go-import meta tags unless configured to be.
+var knownHostRoots = map[string]*hostRootConfig{
+	"github.com":    {Segments: 3},
+	"gitlab.com":    {Segments: 3},
+	"bitbucket.org": {Segments: 3},
+	// gopkg.in/yaml.v2 and gopkg.in/user/pkg.v1.
+	"gopkg.in": {Pattern: `^gopkg\.in/(?:[^/.]+/)?[^/.]+\.v\d+`},
+}
+
+// hostRoot returns the rule of host: from the global config, else a built-in
+// one, else the default for hosts configured in hostAPIs. It returns nil for
+// hosts whose repositories are discovered through go-import meta tags.
+func hostRoot(host string) *hostRootConfig {
+	if rule, ok := getGlobalConfig().Hosts[host]; ok {
+		if rule.GoGet {
+			return nil
+		}
+		return rule
+	}
+	if rule, ok := knownHostRoots[host]; ok {
+		return rule
+	}
+	if _, ok := getGlobalConfig().HostAPIs[host]; ok {
+		return &hostRootConfig{Segments: defaultRootSegments}
+	}
+	return nil
+}
+
+// root returns the repository root of importPath by the rule, or "" when the
+// path is too short for it.
+func (rule *hostRootConfig) root(importPath string) string {
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			// Configured patterns are checked by loadGlobalConfig.
+			return ""
+		}
+		if loc := pattern.FindStringIndex(importPath); loc != nil && loc[0] == 0 {
+			return importPath[:loc[1]]
+		}
+		return ""
+	}
+	segments := rule.Segments
+	if segments <= 0 {
+		segments = defaultRootSegments
+	}
+	return segmentsRoot(importPath, segments)
+}
+
+// segmentsRoot returns the first segments elements of importPath, or "" when
+// it has fewer.
+func segmentsRoot(importPath string, segments int) string {
+	elements := strings.Split(importPath, "/")
+	if len(elements) < segments {
+		return ""
+	}
+	for _, element := range elements[:segments] {
+		if element == "" {
+			return ""
+		}
+	}
+	return strings.Join(elements[:segments], "/")
+}
+
+// importHost returns the host of an import path, or "" when its first
+// element has no dot, as in the standard library.
+func importHost(importPath string) string {
+	host := strings.SplitN(importPath, "/", 2)[0]
+	if !strings.Contains(host, ".") {
+		return ""
+	}
+	return host
+}
+
+// staticRepoRoot returns the repository root of importPath by the host rules
+// alone, without asking any host, using defaultRootSegments for hosts
+// without a rule.
+func staticRepoRoot(importPath string) string {
+	host := importHost(importPath)
+	if host == "" {
+		return ""
+	}
+	if rule := hostRoot(host); rule != nil {
+		return rule.root(importPath)
+	}
+	return segmentsRoot(importPath, defaultRootSegments)
+}
+
+// builtinRepoRoot is staticRepoRoot by the built-in host rules only, leaving
+// out the global config, so the result doesn't depend on the machine.
+func builtinRepoRoot(importPath string) string {
+	host := importHost(importPath)
+	if host == "" {
+		return ""
+	}
+	if rule, ok := knownHostRoots[host]; ok {
+		return rule.root(importPath)
+	}
+	return segmentsRoot(importPath, defaultRootSegments)
+}
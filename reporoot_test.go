@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSegmentsRoot(t *testing.T) {
+	tests := []struct {
+		importPath string
+		segments   int
+		expected   string
+	}{
+		{"github.com/user/repo/sub/pkg", 3, "github.com/user/repo"},
+		{"github.com/user/repo", 3, "github.com/user/repo"},
+		{"github.com/user", 3, ""},
+		{"github.com//repo/pkg", 3, ""},
+		{"git.example.com/team/sub/group/repo/pkg", 5, "git.example.com/team/sub/group/repo"},
+	}
+	for _, test := range tests {
+		if got := segmentsRoot(test.importPath, test.segments); got != test.expected {
+			t.Errorf("root of %s by %d segments is %q, expected %q", test.importPath, test.segments, got, test.expected)
+		}
+	}
+}
+
+func TestHostRootConfig(t *testing.T) {
+	tests := []struct {
+		rule       *hostRootConfig
+		importPath string
+		expected   string
+	}{
+		{&hostRootConfig{}, "git.example.com/team/repo/pkg", "git.example.com/team/repo"},
+		{&hostRootConfig{Segments: 4}, "git.example.com/team/group/repo/pkg", "git.example.com/team/group/repo"},
+		{&hostRootConfig{Segments: 4}, "git.example.com/team/repo", ""},
+		{&hostRootConfig{Pattern: `^git\.example\.com/[^/]+/[^/]+\.git`}, "git.example.com/team/repo.git/pkg", "git.example.com/team/repo.git"},
+		{&hostRootConfig{Pattern: `team/repo`}, "git.example.com/team/repo", ""},
+		{&hostRootConfig{Pattern: `(`}, "git.example.com/team/repo", ""},
+	}
+	for _, test := range tests {
+		if got := test.rule.root(test.importPath); got != test.expected {
+			t.Errorf("root of %s by %+v is %q, expected %q", test.importPath, test.rule, got, test.expected)
+		}
+	}
+}
+
+func TestBuiltinRepoRoot(t *testing.T) {
+	tests := []struct {
+		importPath string
+		expected   string
+	}{
+		{"github.com/user/repo/sub", "github.com/user/repo"},
+		{"bitbucket.org/user/repo", "bitbucket.org/user/repo"},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2"},
+		{"gopkg.in/user/pkg.v1/sub", "gopkg.in/user/pkg.v1"},
+		{"gopkg.in/yaml", ""},
+		{"example.org/team/repo/pkg", "example.org/team/repo"},
+		{"fmt", ""},
+		{"net/http", ""},
+	}
+	for _, test := range tests {
+		if got := builtinRepoRoot(test.importPath); got != test.expected {
+			t.Errorf("root of %s is %q, expected %q", test.importPath, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// source provides the packages the resolver works with, either as git clones
+// on disk or from an in-memory fixture.
+type source interface {
+	// imports lists the repository roots imported by the code in dir,
+	// excluding pkg itself, or fails if the code can't be read.
+	imports(dir string, pkg string) ([]string, error)
+	// fetch makes pkg available in pkgDir, from url or its default
+	// repository when url is empty, and returns the entry describing the
+	// fetched revision, or fails if it can't be fetched.
+	fetch(pkg string, url string, pkgDir string) (*bpmEntry, error)
+	// checkout moves a fetched package to a pinned revision and returns the
+	// entry describing it, with the metadata of the pinned tag if any, or nil
+	// if the revision isn't available.
+	checkout(pkg string, pkgDir string, pinned *bpmEntry) *bpmEntry
+	// tags lists the tags of pkg, from url or its default repository when
+	// url is empty, with the commits they point at.
+	tags(pkg string, url string) (map[string]string, error)
+	// escape moves pkg, fetched into vendorDir before it collided with
+	// another package differing only by case, to its escaped folder.
+	escape(vendorDir string, pkg string) error
+}
+
+type resolver struct {
+	src    source
+	cycles [][]string
+	// declared are packages previously declared with a non-git source, as an
+	// alias, as a monorepo subdirectory or with a tag or version, fetched
+	// as declared instead of from the head of their default remote.
+	declared map[string]*bpmEntry
+	// assets are top level repositories without Go code, which no import
+	// leads to and are fetched regardless.
+	assets map[string]*bpmEntry
+	// pins are the revisions packages are checked out at instead of their
+	// branch heads, when rebuilding with -keep-pins.
+	pins map[string]*bpmEntry
+	// seen are all packages found so far, and locations the vendor folders
+	// they were fetched into, so they can be moved when a package differing
+	// only by case turns up.
+	seen      map[string]bool
+	locations map[string][]string
+	// replaceMode is how replace directives in the bpm.json of dependencies
+	// are handled, and replaced the ones that took effect. replaces are the
+	// ones in effect for the packages being fetched: in a nested layout
+	// those of the dependencies above them, in a flat one all of them.
+	replaceMode string
+	replaced    []transitiveReplace
+	replaces    map[string]string
+	// flat resolves into a single vendor folder, and flatPins are the
+	// commits packages pin their own dependencies at, to report conflicts.
+	flat     bool
+	flatPins map[string][]flatPin
+	// conflicts is the strategy settling packages required at different
+	// commits.
+	conflicts string
+	// failed are the packages that couldn't be fetched or checked out as
+	// declared, with their errors.
+	failed pullError
+}
+
+func newGitResolver() *resolver {
+	return &resolver{src: gitSource{}}
+}
+
+// resolve fetches every package imported from dir, recursing into the vendor
+// folder of each fetched package. The packages failing with an error are
+// returned as a *pullError, along with the others.
+func (r *resolver) resolve(dir string, pkg string) (map[string]*bpmEntry, error) {
+	var dependencies map[string]*bpmEntry
+	if r.flat {
+		dependencies = r.resolveFlat(dir, pkg, nil)
+	} else {
+		dependencies = r.resolveNested(dir, pkg, []string{pkg})
+	}
+	missing := make([]string, 0)
+	for asset := range r.assets {
+		if _, ok := dependencies[asset]; !ok {
+			missing = append(missing, asset)
+		}
+	}
+	for asset, entry := range r.fetchAll(missing, dir) {
+		entry.Kind = entryKindAssets
+		dependencies[asset] = entry
+	}
+	r.settleConflicts(dir, pkg, dependencies)
+	r.reportProblems(dependencies)
+	addCaseCollisions(dependencies, &r.failed)
+	return dependencies, r.failed.orNil()
+}
+
+func (r *resolver) resolveNested(dir string, pkg string, chain []string) map[string]*bpmEntry {
+	packages := make([]string, 0)
+	for _, imported := range r.importsOf(dir, pkg) {
+		if cycle := findInChain(chain, imported); cycle != nil {
+			r.cycles = append(r.cycles, append(cycle, imported))
+			continue
+		}
+		packages = append(packages, imported)
+	}
+	packages = r.expandSubdirs(packages)
+	r.noteCollisions(packages)
+	dependencies := r.fetchAll(packages, dir)
+	for pkg := range dependencies {
+		r.locations[pkg] = append(r.locations[pkg], filepath.Join(dir, vendorFolderName))
+	}
+
+	inherited := r.replaces
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		if isAssets(entry) {
+			continue
+		}
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		log.Printf("Subpackage: %s", pkgDir)
+		r.replaces = r.noteReplaces(pkg, pkgDir, inherited)
+		entry.Dependencies = r.resolveNested(pkgDir, entryRepo(pkg, entry), append(chain[:len(chain):len(chain)], pkg))
+	}
+	r.replaces = inherited
+
+	return dependencies
+}
+
+// importsOf lists the imports of the code of pkg in dir, recording pkg in
+// r.failed when the code can't be read.
+func (r *resolver) importsOf(dir string, pkg string) []string {
+	imports, err := r.src.imports(dir, pkg)
+	if err != nil {
+		r.failed.add(pkg, err)
+	}
+	return imports
+}
+
+type fetchResult struct {
+	pkg   string
+	entry *bpmEntry
+	err   error
+}
+
+// fetchAll fetches packages into the vendor folder of dir at once, leaving
+// out the ones that can't be fetched. Packages failing with an error are
+// recorded in r.failed.
+func (r *resolver) fetchAll(packages []string, dir string) map[string]*bpmEntry {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	dependencies := make(map[string]*bpmEntry, len(packages))
+	channelList := []chan fetchResult{}
+
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		c := make(chan fetchResult, 1)
+		pkg := pkg
+		getWorkerPool().submit(func() {
+			result := fetchResult{pkg: pkg}
+			defer func() {
+				c <- result
+			}()
+			defer recoverError(&result.err)
+			result.entry, result.err = r.fetchPackage(pkg, pkgDir)
+		})
+		channelList = append(channelList, c)
+	}
+
+	for _, c := range channelList {
+		result := <-c
+		if result.err != nil {
+			log.Printf("Dependency failed: %s: %s", result.pkg, result.err)
+			r.failed.add(result.pkg, result.err)
+		} else if result.entry != nil {
+			log.Printf("Dependency pulled: %s", result.pkg)
+			dependencies[result.pkg] = result.entry
+		}
+	}
+	return dependencies
+}
+
+// fetchPackage fetches pkg into pkgDir as it was declared or pinned, or from
+// the head of its default remote.
+func (r *resolver) fetchPackage(pkg string, pkgDir string) (*bpmEntry, error) {
+	if declared, ok := r.declared[pkg]; ok && declared.Archive != "" {
+		return fetchArchive(pkg, declared, pkgDir)
+	} else if ok && declared.Subdir != "" {
+		return r.fetchSubdir(pkg, declared, pkgDir)
+	} else if ok && declared.Alias != "" {
+		entry, err := r.src.fetch(declared.Alias, "", pkgDir)
+		if err != nil {
+			return nil, err
+		}
+		entry.Alias = declared.Alias
+		return entry, nil
+	}
+	entry, err := r.src.fetch(pkg, r.replaces[pkg], pkgDir)
+	if err != nil {
+		return nil, err
+	}
+	if pinned, ok := r.pins[pkg]; ok {
+		if kept := r.src.checkout(pkg, pkgDir, pinned); kept != nil {
+			kept.URL = entry.URL
+			kept.Version = pinned.Version
+			entry = kept
+		} else {
+			log.Printf("Pinned commit %s of %s is not available, using %s", pinned.Commit, pkg, entry.Commit)
+		}
+	} else if declared, ok := r.declared[pkg]; ok && declared.Ref != "" {
+		return r.fetchRef(pkg, pkgDir, declared, entry)
+	} else if declared, ok := r.declared[pkg]; ok && (declared.Version != "" || declared.Tag != "") {
+		return r.fetchTagged(pkg, pkgDir, declared, entry)
+	}
+	return entry, nil
+}
+
+func (r *resolver) keepDeclared(dependencies map[string]*bpmEntry) {
+	for pkg, entry := range dependencies {
+		if entry.Archive != "" || entry.Alias != "" || entry.Subdir != "" || entry.Version != "" || entry.Tag != "" || entry.Ref != "" {
+			if r.declared == nil {
+				r.declared = make(map[string]*bpmEntry)
+			}
+			r.declared[pkg] = entry
+		}
+		r.keepDeclared(entry.Dependencies)
+	}
+}
+
+func (r *resolver) keepAssets(dependencies map[string]*bpmEntry) {
+	for pkg, entry := range dependencies {
+		if isAssets(entry) {
+			if r.assets == nil {
+				r.assets = make(map[string]*bpmEntry)
+			}
+			r.assets[pkg] = entry
+		}
+	}
+}
+
+func (r *resolver) noteCollisions(packages []string) {
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+		r.locations = make(map[string][]string)
+	}
+	for _, pkg := range packages {
+		r.seen[pkg] = true
+	}
+	for _, pkg := range escapeCollisions(r.seen) {
+		for _, vendorDir := range r.locations[pkg] {
+			if err := r.src.escape(vendorDir, pkg); err != nil {
+				r.failed.add(pkg, err)
+			}
+		}
+	}
+}
+
+// keepPins remembers the pinned commits of a previous dependency tree. A
+// package pinned at several depths keeps its shallowest pin.
+func (r *resolver) keepPins(dependencies map[string]*bpmEntry) {
+	if r.pins == nil {
+		r.pins = make(map[string]*bpmEntry)
+	}
+	for pkg, entry := range dependencies {
+		if _, ok := r.pins[pkg]; !ok && entry.Commit != "" {
+			r.pins[pkg] = entry
+		}
+	}
+	for _, entry := range dependencies {
+		r.keepPins(entry.Dependencies)
+	}
+}
+
+func fetchArchive(pkg string, declared *bpmEntry, pkgDir string) (*bpmEntry, error) {
+	entry := &bpmEntry{Archive: declared.Archive, Version: declared.Version, SHA256: declared.SHA256}
+	if err := installArchive(pkg, entry, pkgDir); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func findInChain(chain []string, pkg string) []string {
+	for i, p := range chain {
+		if p == pkg {
+			return append([]string{}, chain[i:]...)
+		}
+	}
+	return nil
+}
+
+// findConflicts returns the packages resolved at more than one commit, with
+// the commits found.
+func findConflicts(dependencies map[string]*bpmEntry) map[string][]string {
+	flat := make(map[string][]*bpmEntry)
+	flattenDependencies(dependencies, flat)
+	conflicts := make(map[string][]string)
+	for pkg, entries := range flat {
+		commits := make(map[string]bool)
+		for _, entry := range entries {
+			commits[entry.Commit] = true
+		}
+		if len(commits) > 1 {
+			for commit := range commits {
+				conflicts[pkg] = append(conflicts[pkg], commit)
+			}
+			sort.Strings(conflicts[pkg])
+		}
+	}
+	return conflicts
+}
+
+func (r *resolver) reportProblems(dependencies map[string]*bpmEntry) {
+	reported := make(map[string]bool)
+	for _, cycle := range r.cycles {
+		description := strings.Join(cycle, " -> ")
+		if !reported[description] {
+			reported[description] = true
+			output.Printf("Import cycle, not vendored again: %s\n", description)
+		}
+	}
+	conflicts := findConflicts(dependencies)
+	for _, pkg := range sortedStrings(conflicts) {
+		shortCommits := make([]string, 0, len(conflicts[pkg]))
+		for _, commit := range conflicts[pkg] {
+			shortCommits = append(shortCommits, shortHash(commit))
+		}
+		output.Printf("Conflict: %s resolved at several commits: %s\n", pkg, strings.Join(shortCommits, ", "))
+	}
+	r.reportFlatConflicts(dependencies)
+	for _, replace := range r.replaced {
+		output.Printf("Transitive replace: %s with %s, declared by %s\n", replace.pkg, replace.url, replace.declaredBy)
+	}
+}
+
+func sortedStrings(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// gitSource clones packages from their default remote into the vendor folder
+// and scans the checked out sources for imports.
+type gitSource struct{}
+
+func (gitSource) imports(dir string, pkg string) ([]string, error) {
+	files, err := getAllSourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Found files: %d", len(*files))
+	imports, err := getAllImports(files)
+	if err != nil {
+		return nil, err
+	}
+	return *getImports(imports, pkg), nil
+}
+
+func (gitSource) fetch(pkg string, url string, pkgDir string) (*bpmEntry, error) {
+	if err := createDir(pkgDir); err != nil {
+		return nil, err
+	}
+	cloneURL := url
+	if cloneURL == "" {
+		cloneURL = getEntryURL(pkg, &bpmEntry{})
+	}
+	if err := cloneRepo(cloneURL, pkgDir); err != nil {
+		return nil, err
+	}
+	warnFileCaseCollisions(pkg, pkgDir)
+
+	branch, err := getCurrentBranch(pkgDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the branch of %s: %s", pkg, err)
+	}
+	commit, err := getCurrentCommitHash(pkgDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the commit of %s: %s", pkg, err)
+	}
+	return &bpmEntry{
+		URL:    cloneURL,
+		Branch: branch,
+		Commit: commit}, nil
+}
+
+func (gitSource) checkout(pkg string, pkgDir string, pinned *bpmEntry) *bpmEntry {
+	entry := &bpmEntry{
+		URL:    getEntryURL(pkg, &bpmEntry{}),
+		Branch: pinned.Branch,
+		Ref:    pinned.Ref,
+		Tag:    pinned.Tag,
+		Commit: pinned.Commit}
+	if err := pullRepo(entry, pkgDir); err != nil {
+		log.Printf("Couldn't check out %s at %s due to error: %s", pkg, pinned.Commit, err)
+		return nil
+	}
+	if entry.Tag != "" {
+		entry.TagInfo = readTagInfo(pkgDir, entry.Tag)
+	}
+	return entry
+}
+
+func (gitSource) tags(pkg string, url string) (map[string]string, error) {
+	if url == "" {
+		url = getEntryURL(pkg, &bpmEntry{})
+	}
+	refs, err := lsRemote(url, "refs/tags/*")
+	if err != nil {
+		return nil, err
+	}
+	return tagCommits(refs), nil
+}
+
+func (gitSource) escape(vendorDir string, pkg string) error {
+	return moveEscaped(vendorDir, pkg)
+}
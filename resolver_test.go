@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func resolveFixture(t *testing.T, fixture string) (map[string]*bpmEntry, *resolver, error) {
+	f := &resolverFixture{}
+	if err := json.Unmarshal([]byte(fixture), f); err != nil {
+		t.Fatal(err)
+	}
+	r := fixtureResolver(f)
+	dependencies, err := r.resolve(".", f.Package)
+	return dependencies, r, err
+}
+
+func TestResolveFixture(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		// commits are the expected top level commits, and tags their tags.
+		commits map[string]string
+		tags    map[string]string
+		failed  []string
+	}{
+		{
+			name: "branch heads",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/a", "fmt"], "repos": {
+				"github.com/lib/a": {"branches": {"master": "a2"}, "commits": {"a1": {}, "a2": {}}}}}`,
+			commits: map[string]string{"github.com/lib/a": "a2"},
+		},
+		{
+			name: "pins",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/a"], "pins": {"github.com/lib/a": "a1"}, "repos": {
+				"github.com/lib/a": {"branches": {"master": "a2"}, "commits": {"a1": {}, "a2": {}}}}}`,
+			commits: map[string]string{"github.com/lib/a": "a1"},
+		},
+		{
+			name: "unavailable pin",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/a"], "pins": {"github.com/lib/a": "gone"}, "repos": {
+				"github.com/lib/a": {"branches": {"master": "a2"}, "commits": {"a2": {}}}}}`,
+			commits: map[string]string{"github.com/lib/a": "a2"},
+		},
+		{
+			name: "version constraint",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/c"],
+				"dependencies": {"github.com/lib/c": {"version": "^1.2"}}, "repos": {
+				"github.com/lib/c": {"branches": {"master": "c5"},
+					"tags": {"v1.1.0": "c1", "v1.2.0": "c2", "v1.2.1": "c3", "v1.3.0-beta": "c4", "v2.0.0": "c5"},
+					"commits": {"c1": {}, "c2": {}, "c3": {}, "c4": {}, "c5": {}}}}}`,
+			commits: map[string]string{"github.com/lib/c": "c3"},
+			tags:    map[string]string{"github.com/lib/c": "v1.2.1"},
+		},
+		{
+			name: "pre-release allowed",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/c"],
+				"dependencies": {"github.com/lib/c": {"version": "~1.3.0-0"}}, "repos": {
+				"github.com/lib/c": {"branches": {"master": "c5"},
+					"tags": {"v1.2.1": "c3", "v1.3.0-beta": "c4", "v2.0.0": "c5"},
+					"commits": {"c3": {}, "c4": {}, "c5": {}}}}}`,
+			commits: map[string]string{"github.com/lib/c": "c4"},
+			tags:    map[string]string{"github.com/lib/c": "v1.3.0-beta"},
+		},
+		{
+			name: "tag",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/c"],
+				"dependencies": {"github.com/lib/c": {"tag": "v1.1.0"}}, "repos": {
+				"github.com/lib/c": {"branches": {"master": "c2"}, "tags": {"v1.1.0": "c1"}, "commits": {"c1": {}, "c2": {}}}}}`,
+			commits: map[string]string{"github.com/lib/c": "c1"},
+			tags:    map[string]string{"github.com/lib/c": "v1.1.0"},
+		},
+		{
+			name: "unsatisfiable constraint",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/a", "github.com/lib/c"],
+				"dependencies": {"github.com/lib/c": {"version": ">=3"}}, "repos": {
+				"github.com/lib/a": {"branches": {"master": "a1"}, "commits": {"a1": {}}},
+				"github.com/lib/c": {"branches": {"master": "c1"}, "tags": {"v1.0.0": "c1"}, "commits": {"c1": {}}}}}`,
+			commits: map[string]string{"github.com/lib/a": "a1"},
+			failed:  []string{"github.com/lib/c"},
+		},
+		{
+			name: "missing repository",
+			fixture: `{"package": "github.com/me/app", "imports": ["github.com/lib/a", "github.com/lib/missing"], "repos": {
+				"github.com/lib/a": {"branches": {"master": "a1"}, "commits": {"a1": {}}}}}`,
+			commits: map[string]string{"github.com/lib/a": "a1"},
+			failed:  []string{"github.com/lib/missing"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dependencies, _, err := resolveFixture(t, test.fixture)
+			failed := make([]string, 0)
+			if pe, ok := err.(*pullError); ok {
+				failed = pe.packages()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(failed, ",") != strings.Join(test.failed, ",") {
+				t.Errorf("failed packages are %v, expected %v", failed, test.failed)
+			}
+			if len(dependencies) != len(test.commits) {
+				t.Errorf("resolved %d packages, expected %d", len(dependencies), len(test.commits))
+			}
+			for pkg, commit := range test.commits {
+				entry, ok := dependencies[pkg]
+				if !ok {
+					t.Errorf("%s was not resolved", pkg)
+					continue
+				}
+				if entry.Commit != commit {
+					t.Errorf("%s resolved at %s, expected %s", pkg, entry.Commit, commit)
+				}
+				if entry.Tag != test.tags[pkg] {
+					t.Errorf("%s resolved with tag %q, expected %q", pkg, entry.Tag, test.tags[pkg])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveFixtureCycles(t *testing.T) {
+	dependencies, r, err := resolveFixture(t, `{"package": "github.com/me/app", "imports": ["github.com/lib/a"], "repos": {
+		"github.com/lib/a": {"branches": {"master": "a1"}, "commits": {"a1": {"imports": ["github.com/lib/b/sub"]}}},
+		"github.com/lib/b": {"branches": {"master": "b1"}, "commits": {"b1": {"imports": ["github.com/lib/a", "github.com/me/app/api"]}}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := dependencies["github.com/lib/a"].Dependencies["github.com/lib/b"]
+	if b == nil {
+		t.Fatal("github.com/lib/b was not resolved below github.com/lib/a")
+	}
+	if len(b.Dependencies) != 0 {
+		t.Errorf("packages of the cycle were vendored again: %v", sortedKeys(b.Dependencies))
+	}
+	cycles := make([]string, 0)
+	for _, cycle := range r.cycles {
+		cycles = append(cycles, strings.Join(cycle, " -> "))
+	}
+	expected := []string{
+		"github.com/lib/a -> github.com/lib/b -> github.com/lib/a",
+		"github.com/me/app -> github.com/lib/a -> github.com/lib/b -> github.com/me/app",
+	}
+	if strings.Join(cycles, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("cycles are %q, expected %q", cycles, expected)
+	}
+}
+
+func TestResolveFixtureCaseCollision(t *testing.T) {
+	dependencies, _, err := resolveFixture(t, `{"package": "github.com/me/app", "imports": ["github.com/0/x", "github.com/Fold/lib"], "repos": {
+		"github.com/0/x": {"branches": {"master": "x1"}, "commits": {"x1": {"imports": ["github.com/fold/lib"]}}},
+		"github.com/Fold/lib": {"branches": {"master": "u1"}, "commits": {"u1": {"imports": ["github.com/dep/z"]}}},
+		"github.com/fold/lib": {"branches": {"master": "l1"}, "commits": {"l1": {}}},
+		"github.com/dep/z": {"branches": {"master": "z1"}, "commits": {"z1": {}}}}}`)
+	pe, ok := err.(*pullError)
+	if !ok {
+		t.Fatalf("resolving packages differing by case returned %v, expected a pull error", err)
+	}
+	// Go can't import the escaped folders, so both spellings fail.
+	if failed := strings.Join(pe.packages(), ","); failed != "github.com/Fold/lib,github.com/fold/lib" {
+		t.Errorf("failed packages are %s", failed)
+	}
+	if got := vendorPath("github.com/Fold/lib"); got != filepath.FromSlash("github.com/!fold/lib") {
+		t.Errorf("github.com/Fold/lib is vendored in %s", got)
+	}
+	// The imports of the moved copy are still found.
+	upper := dependencies["github.com/Fold/lib"]
+	if upper == nil || upper.Dependencies["github.com/dep/z"] == nil {
+		t.Errorf("dependencies of the escaped github.com/Fold/lib were not resolved: %+v", upper)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	shared := func(commit string) map[string]*bpmEntry {
+		return map[string]*bpmEntry{"github.com/lib/shared": {Branch: "master", Commit: commit}}
+	}
+	dependencies := map[string]*bpmEntry{
+		"github.com/lib/a": {Commit: "a1", Dependencies: shared("s1")},
+		"github.com/lib/b": {Commit: "b1", Dependencies: shared("s2")},
+		"github.com/lib/c": {Commit: "c1", Dependencies: shared("s1")},
+	}
+
+	conflicts := findConflicts(dependencies)
+	if got := strings.Join(conflicts["github.com/lib/shared"], ","); len(conflicts) != 1 || got != "s1,s2" {
+		t.Errorf("conflicts are %v, expected github.com/lib/shared at s1,s2", conflicts)
+	}
+
+	r := &resolver{src: newMemSource(&resolverFixture{}), conflicts: conflictsFail}
+	diamonds := r.findDiamonds("/app", "github.com/me/app", dependencies)
+	if len(diamonds) != 1 || diamonds[0].pkg != "github.com/lib/shared" {
+		t.Fatalf("diamonds are %+v, expected github.com/lib/shared", diamonds)
+	}
+	if got, expected := diamonds[0].wanted(), "s1 by github.com/lib/a, github.com/lib/c; s2 by github.com/lib/b"; got != expected {
+		t.Errorf("github.com/lib/shared is wanted at %q, expected %q", got, expected)
+	}
+	if len(diamonds[0].copies) != 3 {
+		t.Errorf("found %d copies of github.com/lib/shared, expected 3", len(diamonds[0].copies))
+	}
+
+	for _, strategy := range []string{conflictsFail, conflictsManifestOverride} {
+		r := &resolver{src: newMemSource(&resolverFixture{}), conflicts: strategy}
+		r.settleConflicts("/app", "github.com/me/app", dependencies)
+		err, ok := r.failed.failures["github.com/lib/shared"]
+		if !ok {
+			t.Errorf("%s: the conflict was not recorded as a failure", strategy)
+			continue
+		}
+		if !strings.Contains(err.Error(), "s1 by github.com/lib/a, github.com/lib/c") {
+			t.Errorf("%s: failure %q doesn't say who requires which commit", strategy, err)
+		}
+	}
+}
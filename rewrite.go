@@ -0,0 +1,201 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rewriteMarkerFilename marks a vendor folder whose imports were rewritten,
+// so pulls revert the rewrites before checking packages out again.
+const rewriteMarkerFilename = ".bpm-rewritten"
+
+// bpmRewrite configures rewriting of vendored import paths to a project-local
+// prefix, e.g. "github.com/me/project/vendor/github.com/pkg/errors".
+type bpmRewrite struct {
+	Prefix string `json:"prefix,omitempty"`
+	Own    bool   `json:"own,omitempty"`
+}
+
+func doRewrite(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if data.Rewrite == nil {
+		output.Errorf("No \"rewrite\" section configured in %s", depFile)
+		return 1
+	}
+	if err := rewriteImports(dir, data); err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	return 0
+}
+
+func rewriteImports(dir string, data *bpmPackage) error {
+	prefix := data.Rewrite.Prefix
+	if prefix == "" {
+		prefix = data.Package + "/" + vendorFolderName
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	packages := make(map[string]bool)
+	collectPackages(data.Dependencies, packages)
+
+	files := make([]string, 0)
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	if fileExists(vendorDir) {
+		vendorFiles, err := getAllVendorSourceFiles(vendorDir)
+		if err != nil {
+			return err
+		}
+		files = append(files, vendorFiles...)
+	}
+	if data.Rewrite.Own {
+		ownFiles, err := getAllSourceFiles(dir)
+		if err != nil {
+			return err
+		}
+		files = append(files, *ownFiles...)
+	}
+
+	count := 0
+	for _, fname := range files {
+		rewritten, err := rewriteFileImports(fname, func(path string) (string, bool) {
+			if strings.HasPrefix(path, prefix+"/") || !isVendoredImport(path, packages) {
+				return "", false
+			}
+			return prefix + "/" + path, true
+		})
+		if err != nil {
+			return err
+		}
+		if rewritten {
+			count++
+		}
+	}
+	log.Printf("Rewrote imports in %d files using prefix %s", count, prefix)
+	if fileExists(vendorDir) {
+		return ioutil.WriteFile(filepath.Join(vendorDir, rewriteMarkerFilename), []byte(prefix+"\n"), 0644)
+	}
+	return nil
+}
+
+// hasImportRewrites is whether the imports in the vendor folder of root were
+// rewritten to a prefix.
+func hasImportRewrites(root string) bool {
+	return fileExists(filepath.Join(root, vendorFolderName, rewriteMarkerFilename))
+}
+
+// revertImportRewrites restores the tracked files of a checkout whose imports
+// were rewritten, for an alias or a prefix, before it is checked out again;
+// the rewrites are reapplied after install.
+func revertImportRewrites(pkgDir string) error {
+	_, err := runCmd(&pkgDir, false, "git", "checkout", "--", ".")
+	return err
+}
+
+func collectPackages(dependencies map[string]*bpmEntry, packages map[string]bool) {
+	for pkg, entry := range dependencies {
+		packages[pkg] = true
+		collectPackages(entry.Dependencies, packages)
+	}
+}
+
+func isVendoredImport(path string, packages map[string]bool) bool {
+	for pkg := range packages {
+		if path == pkg || strings.HasPrefix(path, pkg+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func getAllVendorSourceFiles(dir string) ([]string, error) {
+	result := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == gitFolderName {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".go") {
+			result = append(result, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rewriteFileImports replaces the import paths in fname for which rewrite
+// returns a new path and reports whether the file changed.
+func rewriteFileImports(fname string, rewrite func(path string) (string, bool)) (bool, error) {
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return false, err
+	}
+
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, fname, src, parser.ImportsOnly)
+	if err != nil {
+		return false, err
+	}
+
+	type replacement struct {
+		start, end int
+		path       string
+	}
+	replacements := make([]replacement, 0)
+
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		newPath, ok := rewrite(path)
+		if !ok {
+			continue
+		}
+		replacements = append(replacements, replacement{
+			start: fs.Position(spec.Path.Pos()).Offset,
+			end:   fs.Position(spec.Path.End()).Offset,
+			path:  newPath})
+	}
+
+	if len(replacements) == 0 {
+		return false, nil
+	}
+
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start > replacements[j].start
+	})
+	for _, r := range replacements {
+		src = append(src[:r.start], append([]byte(strconv.Quote(r.path)), src[r.end:]...)...)
+	}
+
+	info, err := os.Stat(fname)
+	if err != nil {
+		return false, err
+	}
+	if err = ioutil.WriteFile(fname, src, info.Mode()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// updateVulnerable moves every dependency whose pin is affected by a known
+// vulnerability to the lowest tag containing all the fixes, falling back to
+// its branch tip, and leaves everything else untouched. It returns the moved
+// packages.
+func updateVulnerable(dir string, dependencies map[string]*bpmEntry) ([]string, error) {
+	updated := make([]string, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if entry.Commit != "" && isGitRepo(pkgDir) {
+			vulns, err := queryVulnerabilities(entry.Commit)
+			if err != nil {
+				return nil, fmt.Errorf("vulnerability lookup for %s failed: %s", pkg, err)
+			}
+			if len(vulns) > 0 {
+				moved, err := moveToFixed(pkg, entry, pkgDir, vulns)
+				if err != nil {
+					return nil, fmt.Errorf("could not update %s: %s", pkg, err)
+				}
+				if moved {
+					updated = append(updated, pkg)
+				}
+			}
+		}
+		nested, err := updateVulnerable(pkgDir, entry.Dependencies)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, nested...)
+	}
+	return updated, nil
+}
+
+func moveToFixed(pkg string, entry *bpmEntry, pkgDir string, vulns []*osvVuln) (bool, error) {
+	ids := make([]string, 0, len(vulns))
+	fixedCommits := make([]string, 0)
+	var minVersion *semver
+	for _, vuln := range vulns {
+		ids = append(ids, vuln.ID)
+		for _, affected := range vuln.Affected {
+			for _, r := range affected.Ranges {
+				for _, event := range r.Events {
+					if event.Fixed == "" {
+						continue
+					}
+					if r.Type == "GIT" {
+						fixedCommits = append(fixedCommits, event.Fixed)
+					} else if v, ok := parseSemver(event.Fixed); ok && (minVersion == nil || v.compare(minVersion) > 0) {
+						minVersion = v
+					}
+				}
+			}
+		}
+	}
+	output.Printf("%s %s is affected by %s\n", pkg, shortHash(entry.Commit), strings.Join(ids, ", "))
+
+	if _, err := runCmd(&pkgDir, true, "git", "fetch", "--tags", "--quiet", "origin"); err != nil {
+		return false, err
+	}
+	fromTag, err := getTagAtCommit(pkgDir, entry.Commit)
+	if err != nil {
+		return false, err
+	}
+	tags, err := getTags(pkgDir)
+	if err != nil {
+		return false, err
+	}
+	target, targetTag := "", ""
+	for _, tag := range sortSemverTags(tags) {
+		v, _ := parseSemver(tag)
+		if minVersion != nil && v.compare(minVersion) < 0 {
+			continue
+		}
+		if v.pre != "" && !allowsPrerelease(entry) {
+			continue
+		}
+		commit, err := runCmdOutput(&pkgDir, "git", "rev-parse", tag+"^{commit}")
+		if err != nil {
+			return false, err
+		}
+		if isAncestor(pkgDir, entry.Commit, commit) && containsAll(pkgDir, commit, fixedCommits) && commit != entry.Commit {
+			target, targetTag = commit, tag
+			break
+		}
+	}
+	if target == "" && len(fixedCommits) > 0 {
+		tip, err := runCmdOutput(&pkgDir, "git", "rev-parse", "origin/"+entry.Branch)
+		if err != nil {
+			return false, err
+		}
+		if containsAll(pkgDir, tip, fixedCommits) {
+			target = tip
+		}
+	}
+	if target == "" {
+		output.Printf("    No fixed version of %s found, left at %s\n", pkg, shortHash(entry.Commit))
+		return false, nil
+	}
+
+	if err := checkoutCommit(pkgDir, entry.Branch, target); err != nil {
+		return false, err
+	}
+	entry.Commit = target
+	if targetTag != "" {
+		output.Printf("    Moved to %s (%s)\n\n", targetTag, shortHash(target))
+		printReleaseNotes(collectReleaseNotes(getEntryURL(pkg, entry), getTagsBetween(tags, fromTag, targetTag)))
+	} else {
+		output.Printf("    Moved to the tip of %s (%s)\n", entry.Branch, shortHash(target))
+	}
+	return true, nil
+}
+
+func containsAll(dir string, commit string, ancestors []string) bool {
+	for _, ancestor := range ancestors {
+		if !isAncestor(dir, ancestor, commit) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAncestor(dir string, ancestor string, commit string) bool {
+	check := func() bool {
+		return gitCommand(&dir, "merge-base", "--is-ancestor", ancestor, commit).Run() == nil
+	}
+	if check() {
+		return true
+	}
+	// A shallow clone may just not reach back far enough yet.
+	if !isShallowRepo(dir) || !hasCommit(dir, ancestor) {
+		return false
+	}
+	found, err := deepenUntil(dir, check)
+	if err != nil {
+		log.Printf("Could not deepen %s: %s", dir, err)
+	}
+	return found
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type semver struct {
+	major, minor, patch int
+	pre                 string
+	build               string
+}
+
+// parseSemver parses tags like "v1.2.3", "1.2.3-rc.1" or "v2.0.0+meta".
+func parseSemver(tag string) (*semver, bool) {
+	s := strings.TrimPrefix(tag, "v")
+	v := &semver{}
+	if i := strings.Index(s, "+"); i >= 0 {
+		v.build = s[i+1:]
+		s = s[:i]
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = s[i+1:]
+		s = s[:i]
+		if v.pre == "" {
+			return nil, false
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, true
+}
+
+func (v *semver) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.major, v.minor, v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1 following semver precedence rules.
+func (v *semver) compare(o *semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return comparePrerelease(v.pre, o.pre)
+}
+
+func comparePrerelease(a string, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if len(as) < len(bs) {
+		return -1
+	}
+	return 1
+}
+
+// sortSemverTags keeps only the semver tags from the list and sorts them
+// in ascending order.
+func sortSemverTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	versions := make(map[string]*semver, len(tags))
+	for _, tag := range tags {
+		if v, ok := parseSemver(tag); ok {
+			result = append(result, tag)
+			versions[tag] = v
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return versions[result[i]].compare(versions[result[j]]) < 0
+	})
+	return result
+}
+
+const (
+	severityCommits = "commits"
+	severityPatch   = "patch"
+	severityMinor   = "minor"
+	severityMajor   = "major"
+)
+
+var severityRanks = map[string]int{
+	severityCommits: 0,
+	severityPatch:   1,
+	severityMinor:   2,
+	severityMajor:   3,
+}
+
+// classifyUpdate names the semver component that changes between versions.
+func classifyUpdate(from *semver, to *semver) string {
+	switch {
+	case from.major != to.major:
+		return severityMajor
+	case from.minor != to.minor:
+		return severityMinor
+	default:
+		return severityPatch
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected string
+		ok       bool
+	}{
+		{"v1.2.3", "v1.2.3", true},
+		{"1.2.3", "v1.2.3", true},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1", true},
+		{"v2.0.0+meta", "v2.0.0", true},
+		{"v2.0.0-beta+meta", "v2.0.0-beta", true},
+		{"v0.0.0", "v0.0.0", true},
+		{"v1.2", "", false},
+		{"v1.2.3.4", "", false},
+		{"v1.02.3", "", false},
+		{"v1.2.3-", "", false},
+		{"v1.-2.3", "", false},
+		{"release-1", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		v, ok := parseSemver(test.tag)
+		if ok != test.ok {
+			t.Errorf("parseSemver(%q) ok is %v, expected %v", test.tag, ok, test.ok)
+			continue
+		}
+		if ok && v.String() != test.expected {
+			t.Errorf("parseSemver(%q) is %s, expected %s", test.tag, v, test.expected)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.2.4", -1},
+		{"v1.3.0", "v1.2.9", 1},
+		{"v2.0.0", "v1.9.9", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", -1},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", -1},
+		{"v1.0.0-beta.2", "v1.0.0-beta.11", -1},
+		{"v1.0.0-rc.1", "v1.0.0-beta.11", 1},
+		{"v1.0.0+a", "v1.0.0+b", 0},
+	}
+	for _, test := range tests {
+		a, _ := parseSemver(test.a)
+		b, _ := parseSemver(test.b)
+		if got := a.compare(b); got != test.expected {
+			t.Errorf("%s compared with %s is %d, expected %d", test.a, test.b, got, test.expected)
+		}
+		if got := b.compare(a); got != -test.expected {
+			t.Errorf("%s compared with %s is %d, expected %d", test.b, test.a, got, -test.expected)
+		}
+	}
+}
+
+func TestSortSemverTags(t *testing.T) {
+	tags := []string{"v1.10.0", "latest", "v1.2.0", "v1.2.0-rc.1", "v0.9.0", "v1.2", "v2.0.0-alpha"}
+	expected := "v0.9.0,v1.2.0-rc.1,v1.2.0,v1.10.0,v2.0.0-alpha"
+	if got := strings.Join(sortSemverTags(tags), ","); got != expected {
+		t.Errorf("sorted tags are %s, expected %s", got, expected)
+	}
+}
+
+func TestGetTagsBetween(t *testing.T) {
+	tags := []string{"v1.0.0", "v1.1.0", "v1.2.0-rc.1", "v1.2.0", "v1.3.0", "nightly"}
+	tests := []struct {
+		from, to string
+		expected string
+	}{
+		{"v1.0.0", "v1.2.0", "v1.1.0,v1.2.0-rc.1,v1.2.0"},
+		{"v1.2.0", "v1.2.0", ""},
+		{"", "v1.1.0", "v1.0.0,v1.1.0"},
+		{"master", "v1.0.0", "v1.0.0"},
+		{"v1.0.0", "nightly", ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(getTagsBetween(tags, test.from, test.to), ","); got != test.expected {
+			t.Errorf("tags between %q and %q are %q, expected %q", test.from, test.to, got, test.expected)
+		}
+	}
+}
+
+func TestClassifyUpdate(t *testing.T) {
+	tests := []struct {
+		from, to string
+		expected string
+	}{
+		{"v1.2.3", "v2.0.0", severityMajor},
+		{"v1.2.3", "v1.3.0", severityMinor},
+		{"v1.2.3", "v1.2.4", severityPatch},
+		{"v1.2.3-rc.1", "v1.2.3", severityPatch},
+	}
+	for _, test := range tests {
+		from, _ := parseSemver(test.from)
+		to, _ := parseSemver(test.to)
+		if got := classifyUpdate(from, to); got != test.expected {
+			t.Errorf("update from %s to %s is %s, expected %s", test.from, test.to, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// shallowClone is set by -shallow; "shallow": true in bpm.json enables it
+// for a project.
+var shallowClone = false
+
+// shallowDeepenSteps are the depths a shallow clone is deepened by in turn
+// until a pinned commit turns up, before fetching the whole history.
+var shallowDeepenSteps = []string{"50", "500"}
+
+// shallowCloneArgs are the clone options cloning only the tip of the default
+// branch when shallow clones are enabled.
+func shallowCloneArgs() []string {
+	if !shallowClone {
+		return nil
+	}
+	return []string{"--depth", "1", "--single-branch"}
+}
+
+func isShallowRepo(dir string) bool {
+	return fileExists(filepath.Join(dir, gitFolderName, "shallow"))
+}
+
+func hasCommit(dir string, commit string) bool {
+	return gitCommand(&dir, "cat-file", "-e", commit+"^{commit}").Run() == nil
+}
+
+// fetchShallowBranch adds a branch a single-branch clone doesn't track yet
+// to its remote and fetches its tip.
+func fetchShallowBranch(pkgDir string, branch string) error {
+	if !isShallowRepo(pkgDir) || gitCommand(&pkgDir, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch).Run() == nil {
+		return nil
+	}
+	if _, err := runCmd(&pkgDir, false, "git", "remote", "set-branches", "--add", "origin", branch); err != nil {
+		return err
+	}
+	return runGit(&pkgDir, "fetch", "--quiet", "--depth", "1", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch)
+}
+
+// deepenTo makes a commit available in a shallow clone, fetched by itself
+// where the host allows it, otherwise by deepening the clone.
+func deepenTo(pkgDir string, commit string) error {
+	if !isShallowRepo(pkgDir) || hasCommit(pkgDir, commit) {
+		return nil
+	}
+	log.Printf("Commit %s is not in the shallow clone %s, fetching it", shortHash(commit), pkgDir)
+	if runGit(&pkgDir, "fetch", "--quiet", "--depth", "1", "origin", commit) == nil && hasCommit(pkgDir, commit) {
+		return nil
+	}
+	_, err := deepenUntil(pkgDir, func() bool {
+		return hasCommit(pkgDir, commit)
+	})
+	return err
+}
+
+// deepenUntil deepens a shallow clone step by step until found holds,
+// finally fetching the whole history, and returns whether it does.
+func deepenUntil(pkgDir string, found func() bool) (bool, error) {
+	for _, depth := range shallowDeepenSteps {
+		log.Printf("Deepening %s by %s commits", pkgDir, depth)
+		if err := runGit(&pkgDir, "fetch", "--quiet", "--deepen", depth, "origin"); err != nil {
+			return false, err
+		}
+		if found() {
+			return true, nil
+		}
+	}
+	log.Printf("Fetching the whole history of %s", pkgDir)
+	if err := runGit(&pkgDir, "fetch", "--quiet", "--unshallow", "origin"); err != nil {
+		return false, err
+	}
+	return found(), nil
+}
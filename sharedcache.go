@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// getSharedCache returns the bucket used as a shared cache tier, such as
+// "s3://team-bucket/bpm" or "gs://team-bucket/bpm", from the BPM_SHARED_CACHE
+// environment variable or the sharedCache setting of the global config.
+func getSharedCache() string {
+	if location := os.Getenv("BPM_SHARED_CACHE"); location != "" {
+		return strings.TrimSuffix(location, "/")
+	}
+	return strings.TrimSuffix(getGlobalConfig().SharedCache, "/")
+}
+
+// getBundleLocation returns where the bundle of a repository at a commit is
+// kept in the shared cache.
+func getBundleLocation(cache string, repoURL string, commit string) string {
+	sum := sha256.Sum256([]byte(repoURL))
+	return cache + "/" + hex.EncodeToString(sum[:]) + "/" + commit + ".bundle"
+}
+
+// cloneFromSharedCache clones a package from a git bundle in the shared cache
+// and points its origin at the real remote. It returns false when the cache
+// isn't configured or has no bundle for the commit.
+func cloneFromSharedCache(repoURL string, entry *bpmEntry, pkgDir string) bool {
+	cache := getSharedCache()
+	if cache == "" || entry.Commit == "" {
+		return false
+	}
+	tmp, err := ioutil.TempFile("", "bpm-bundle-")
+	if err != nil {
+		log.Printf("Not using the shared cache: %s", err)
+		return false
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	location := getBundleLocation(cache, repoURL, entry.Commit)
+	if err := copyObject(location, tmp.Name()); err != nil {
+		log.Printf("No bundle for %s at %s in the shared cache", repoURL, shortHash(entry.Commit))
+		return false
+	}
+	log.Printf("Cloning package %s from %s...", repoURL, location)
+	clone := gitCommand(nil, "clone", tmp.Name(), pkgDir)
+	if out, err := clone.CombinedOutput(); err != nil {
+		log.Printf("Could not clone bundle %s: %s %s", location, err, strings.TrimSpace(string(out)))
+		if err := resetDir(pkgDir); err != nil {
+			log.Printf("Could not clear %s: %s", pkgDir, err)
+		}
+		return false
+	}
+	if _, err := runCmd(&pkgDir, false, "git", "remote", "set-url", "origin", repoURL); err != nil {
+		log.Printf("Could not point %s at %s: %s", pkgDir, repoURL, err)
+		if err := resetDir(pkgDir); err != nil {
+			log.Printf("Could not clear %s: %s", pkgDir, err)
+		}
+		return false
+	}
+	return true
+}
+
+// uploadToSharedCache bundles the checked out branch of a freshly cloned
+// package and uploads it, unless the shared cache already has it.
+func uploadToSharedCache(repoURL string, entry *bpmEntry, pkgDir string) {
+	cache := getSharedCache()
+	if cache == "" || entry.Commit == "" {
+		return
+	}
+	location := getBundleLocation(cache, repoURL, entry.Commit)
+	if objectExists(location) {
+		return
+	}
+	tmpDir, err := ioutil.TempDir("", "bpm-bundle-")
+	if err != nil {
+		log.Printf("Could not upload %s to the shared cache: %s", repoURL, err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bundle := filepath.Join(tmpDir, "repo.bundle")
+	create := gitCommand(&pkgDir, "bundle", "create", bundle, "HEAD", entry.Branch)
+	if out, err := create.CombinedOutput(); err != nil {
+		log.Printf("Could not bundle %s: %s %s", repoURL, err, strings.TrimSpace(string(out)))
+		return
+	}
+	if err := copyObject(bundle, location); err != nil {
+		log.Printf("Could not upload %s to the shared cache: %s", repoURL, err)
+		return
+	}
+	log.Printf("Uploaded %s at %s to %s", repoURL, shortHash(entry.Commit), location)
+}
+
+// copyObject copies between a local file and a bucket using the aws or gsutil
+// command line tools, so their configured credentials apply.
+func copyObject(src string, dst string) error {
+	tool, args := objectTool(src + dst)
+	cmd := exec.CommandContext(runContext, tool, append(args, "cp", src, dst)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s cp failed: %s %s", tool, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func objectExists(location string) bool {
+	tool, args := objectTool(location)
+	if tool == "gsutil" {
+		return exec.CommandContext(runContext, tool, append(args, "-q", "stat", location)...).Run() == nil
+	}
+	out, err := exec.CommandContext(runContext, tool, append(args, "ls", location)...).Output()
+	return err == nil && len(strings.TrimSpace(string(out))) > 0
+}
+
+func objectTool(location string) (string, []string) {
+	if strings.Contains(location, "gs://") {
+		return "gsutil", nil
+	}
+	return "aws", []string{"s3"}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// signatureStatuses describes the %G? placeholder of git log.
+var signatureStatuses = map[string]string{
+	"G": "good",
+	"B": "bad",
+	"U": "good, untrusted key",
+	"X": "good, expired",
+	"Y": "good, expired key",
+	"R": "good, revoked key",
+	"E": "unverifiable, missing key",
+	"N": "unsigned",
+}
+
+type signatureInfo struct {
+	pkg    string
+	commit string
+	status string
+	signer string
+	tags   []string
+}
+
+// reportSignatures lists for every vendored git package whether its commit
+// and the tags pointing at it are signed and by whom, without enforcing
+// anything.
+func reportSignatures(dir string, dependencies map[string]*bpmEntry) {
+	infos := make([]*signatureInfo, 0)
+	collectSignatures(filepath.Join(dir, vendorFolderName), dependencies, &infos)
+	signed := 0
+	output.Println("Signatures of vendored commits:")
+	for _, info := range infos {
+		if info.status != signatureStatuses["N"] {
+			signed++
+		}
+		line := fmt.Sprintf("    %s %s: %s", info.pkg, shortHash(info.commit), info.status)
+		if info.signer != "" {
+			line += " by " + info.signer
+		}
+		if len(info.tags) > 0 {
+			line += ", tags " + strings.Join(info.tags, ", ")
+		}
+		output.Println(line)
+	}
+	output.Printf("%d of %d commits signed\n", signed, len(infos))
+}
+
+func collectSignatures(vendorDir string, dependencies map[string]*bpmEntry, infos *[]*signatureInfo) {
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if entry.Commit != "" && isGitRepo(pkgDir) {
+			*infos = append(*infos, getSignatureInfo(pkg, entry.Commit, pkgDir))
+		}
+		collectSignatures(filepath.Join(pkgDir, vendorFolderName), entry.Dependencies, infos)
+	}
+}
+
+func getSignatureInfo(pkg string, commit string, pkgDir string) *signatureInfo {
+	info := &signatureInfo{pkg: pkg, commit: commit, status: "unknown"}
+	out, err := runCmdOutput(&pkgDir, "git", "log", "-1", "--format=%G?%n%GS%n%GK", commit)
+	if err != nil {
+		log.Printf("Could not read the signature of %s: %s", pkg, err)
+		return info
+	}
+	fields := strings.SplitN(out, "\n", 3)
+	if status, ok := signatureStatuses[fields[0]]; ok {
+		info.status = status
+	}
+	if len(fields) > 1 && fields[1] != "" {
+		info.signer = fields[1]
+	} else if len(fields) > 2 && fields[2] != "" {
+		info.signer = "key " + fields[2]
+	}
+
+	out, err = runCmdOutput(&pkgDir, "git", "tag", "--points-at", commit)
+	if err != nil {
+		log.Printf("Could not list the tags of %s: %s", pkg, err)
+		return info
+	}
+	for _, tag := range strings.Fields(out) {
+		signature, err := runCmdOutput(&pkgDir, "git", "tag", "-l", "--format=%(contents:signature)", tag)
+		switch {
+		case err != nil:
+			log.Printf("Could not read the signature of %s: %s", tag, err)
+		case signature == "":
+			info.tags = append(info.tags, tag+" (unsigned)")
+		case gitCommand(&pkgDir, "verify-tag", tag).Run() == nil:
+			info.tags = append(info.tags, tag+" (signed, verified)")
+		default:
+			info.tags = append(info.tags, tag+" (signed, unverified)")
+		}
+	}
+	return info
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	snapshotFilename = "snapshot.json"
+	// maxSnapshots is how many snapshots are kept, oldest removed first.
+	maxSnapshots = 10
+)
+
+var snapshotsFolder = filepath.Join(".bpm", "snapshots")
+
+// bpmSnapshot describes the state of a project before a mutating operation.
+// The manifest and lock file are copied next to it; Vendor maps every
+// vendored folder, relative to the project dir, to its commit or checksum.
+type bpmSnapshot struct {
+	Operation string            `json:"operation"`
+	Date      string            `json:"date"`
+	Vendor    map[string]string `json:"vendor"`
+}
+
+// takeSnapshot saves the manifest, lock file and vendor state of dir before
+// operation changes them, so bpm rollback can go back to it. It returns the
+// snapshot folder.
+func takeSnapshot(dir string, operation string) (string, error) {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		return "", nil
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	snapshotDir := filepath.Join(dir, snapshotsFolder, now.Format("20060102-150405.000"))
+	if err := createDir(snapshotDir); err != nil {
+		return "", err
+	}
+	for _, filename := range []string{dependencyFilename, lockFilename} {
+		source := filepath.Join(dir, filename)
+		if fileExists(source) {
+			if err := copySnapshotFile(source, filepath.Join(snapshotDir, filename)); err != nil {
+				return "", err
+			}
+		}
+	}
+	snapshot := &bpmSnapshot{
+		Operation: operation,
+		Date:      now.Format(time.RFC3339),
+		Vendor:    make(map[string]string)}
+	if err := indexVendor(dir, dir, data.Dependencies, snapshot.Vendor); err != nil {
+		return "", err
+	}
+	bytes, err := jsonEncodeIndented(snapshot)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(snapshotDir, snapshotFilename), bytes, 0644); err != nil {
+		return "", err
+	}
+	log.Printf("Saved snapshot %s", snapshotDir)
+	pruneSnapshots(dir)
+	return snapshotDir, nil
+}
+
+func indexVendor(root string, dir string, dependencies map[string]*bpmEntry, index map[string]string) error {
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			continue
+		}
+		rel, _ := filepath.Rel(root, pkgDir)
+		state := entry.Commit + entry.SHA256
+		if isGitRepo(pkgDir) {
+			var err error
+			if state, err = getCurrentCommitHash(pkgDir); err != nil {
+				return err
+			}
+		}
+		index[filepath.ToSlash(rel)] = state
+		if err := indexVendor(root, pkgDir, entry.Dependencies, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copySnapshotFile(source string, target string) error {
+	bytes, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(target, bytes, 0644)
+}
+
+// listSnapshots returns the snapshot folders of dir, oldest first.
+func listSnapshots(dir string) []string {
+	snapshotsDir := filepath.Join(dir, snapshotsFolder)
+	files, err := ioutil.ReadDir(snapshotsDir)
+	if err != nil {
+		return nil
+	}
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() && fileExists(filepath.Join(snapshotsDir, file.Name(), snapshotFilename)) {
+			result = append(result, filepath.Join(snapshotsDir, file.Name()))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func pruneSnapshots(dir string) {
+	snapshots := listSnapshots(dir)
+	for len(snapshots) > maxSnapshots {
+		if err := removeDir(snapshots[0]); err != nil {
+			log.Printf("Could not remove snapshot %s: %s", snapshots[0], err)
+		}
+		snapshots = snapshots[1:]
+	}
+}
+
+func readSnapshot(snapshotDir string) (*bpmSnapshot, error) {
+	filename := filepath.Join(snapshotDir, snapshotFilename)
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &bpmSnapshot{}
+	if err := json.Unmarshal(bytes, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %s", filename, err)
+	}
+	return snapshot, nil
+}
+
+func doRollback(dir string, action string) int {
+	snapshots := listSnapshots(dir)
+	switch action {
+	case "":
+	case "list":
+		list := make([]*bpmSnapshot, 0, len(snapshots))
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			snapshot, err := readSnapshot(snapshots[i])
+			if err != nil {
+				output.Errorf("%s", err)
+				return 1
+			}
+			list = append(list, snapshot)
+		}
+		output.Result(list, func() {
+			for _, snapshot := range list {
+				fmt.Printf("%s  before %s, %d vendored packages\n", snapshot.Date, snapshot.Operation, len(snapshot.Vendor))
+			}
+		})
+		return 0
+	default:
+		output.Errorf("Unknown rollback action %q, use bpm rollback [list]", action)
+		return 1
+	}
+	if len(snapshots) == 0 {
+		output.Errorf("No snapshots in %s", filepath.Join(dir, snapshotsFolder))
+		return 1
+	}
+	if !checkVendorUnlocked(dir) {
+		return 1
+	}
+	snapshotDir := snapshots[len(snapshots)-1]
+	snapshot, err := readSnapshot(snapshotDir)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	mismatches, err := restoreSnapshot(dir, snapshotDir)
+	if err != nil {
+		output.Errorf("Could not roll back to before %s of %s: %s", snapshot.Operation, snapshot.Date, err)
+		return 1
+	}
+	output.Printf("Rolled back to before %s of %s\n", snapshot.Operation, snapshot.Date)
+	if len(mismatches) > 0 {
+		output.Problems(fmt.Sprintf("%d vendored packages differ from the snapshot:", len(mismatches)), mismatches)
+		return 1
+	}
+	return 0
+}
+
+// restoreSnapshot puts back the manifest, lock file and vendor state of a
+// snapshot, removes the snapshot and returns the vendored packages that
+// could not be restored to their recorded state.
+func restoreSnapshot(dir string, snapshotDir string) ([]string, error) {
+	snapshot, err := readSnapshot(snapshotDir)
+	if err != nil {
+		return nil, err
+	}
+	depFile := filepath.Join(dir, dependencyFilename)
+
+	current := make(map[string]string)
+	if fileExists(depFile) {
+		// A manifest broken since the snapshot leaves only the vendored
+		// folders of the snapshot to restore.
+		if data, err := readDataFile(depFile); err == nil {
+			if err := indexVendor(dir, dir, data.Dependencies, current); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, filename := range []string{dependencyFilename, lockFilename} {
+		source := filepath.Join(snapshotDir, filename)
+		target := filepath.Join(dir, filename)
+		if fileExists(source) {
+			if err := copySnapshotFile(source, target); err != nil {
+				return nil, err
+			}
+		} else if fileExists(target) {
+			if err := os.Remove(target); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, rel := range sortedStringKeys(current) {
+		if _, ok := snapshot.Vendor[rel]; !ok {
+			log.Printf("Removing %s, it was added after the snapshot", rel)
+			if err := removeDir(filepath.Join(dir, filepath.FromSlash(rel))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := readDataFile(depFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := pullPackages(data.Dependencies, dir); err != nil {
+		// The failed packages are reported as mismatches below.
+		log.Print(err)
+	}
+	if err := postInstall(dir, data); err != nil {
+		return nil, err
+	}
+
+	restored := make(map[string]string)
+	if err := indexVendor(dir, dir, data.Dependencies, restored); err != nil {
+		return nil, err
+	}
+	mismatches := make([]string, 0)
+	for _, rel := range sortedStringKeys(snapshot.Vendor) {
+		if state := restored[rel]; state != snapshot.Vendor[rel] {
+			mismatches = append(mismatches, fmt.Sprintf("%s: restored %s, snapshot has %s", rel, shortHash(state), shortHash(snapshot.Vendor[rel])))
+		}
+	}
+	if err := removeDir(snapshotDir); err != nil {
+		log.Printf("Could not remove snapshot %s: %s", snapshotDir, err)
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// sourceRoots are the source roots of every project read, by its absolute
+// folder.
+var sourceRoots = make(map[string][]string)
+
+// setSourceRoots records the source roots a project manifest lists, relative
+// to the project folder dir.
+func setSourceRoots(dir string, roots []string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.Printf("Ignoring the source roots of %s: %s", dir, err)
+		return
+	}
+	if len(roots) == 0 {
+		delete(sourceRoots, abs)
+		return
+	}
+	resolved := make([]string, 0, len(roots))
+	for _, root := range roots {
+		resolved = append(resolved, filepath.Join(abs, filepath.FromSlash(root)))
+	}
+	sourceRoots[abs] = resolved
+}
+
+// projectSourceRoots returns the source roots of the project in dir, if its
+// manifest lists any.
+func projectSourceRoots(dir string) ([]string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, false
+	}
+	roots, ok := sourceRoots[abs]
+	return roots, ok
+}
+
+// getSourceRootFiles returns the source files below every root, once each
+// when roots overlap. Missing roots, like generated trees not generated
+// yet, are skipped.
+func getSourceRootFiles(roots []string) (*[]string, error) {
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		if !fileExists(root) {
+			log.Printf("Skipping missing source root %s", root)
+			continue
+		}
+		files, err := walkSourceFiles(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range *files {
+			if !seen[file] {
+				seen[file] = true
+				result = append(result, file)
+			}
+		}
+	}
+	return &result, nil
+}
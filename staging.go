@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var stagingFolder = filepath.Join(".bpm", "staging")
+
+// bpmPolicy lists the rules new dependencies have to pass in staging.
+type bpmPolicy struct {
+	// Licenses allowed for new dependencies, all when empty.
+	Licenses []string `json:"licenses,omitempty"`
+	// DeniedPackages are package path prefixes that may not be added.
+	DeniedPackages []string `json:"deniedPackages,omitempty"`
+	// TransitiveReplaces is whether replace directives in the bpm.json of
+	// dependencies are honored, ignored or need confirmation.
+	TransitiveReplaces string `json:"transitiveReplaces,omitempty"`
+	// Conflicts is how a package dependencies require at different commits
+	// is settled: newest, manifest-override or fail.
+	Conflicts string `json:"conflicts,omitempty"`
+	// Plugins are commands every dependency an install vendors is passed to,
+	// each able to veto it. See checkPolicyPlugins.
+	Plugins []string `json:"plugins,omitempty"`
+}
+
+// stageNewDependencies installs the dependencies that are neither in the
+// committed bpm.json nor vendored yet into .bpm/staging, checks them and
+// moves them into vendor only when every check passes. It returns false when
+// any of them was rejected.
+func stageNewDependencies(dir string, data *bpmPackage) bool {
+	known := getCommittedPackages(dir)
+	stagingDir := filepath.Join(dir, stagingFolder)
+	staged := make(map[string]*bpmEntry)
+	for pkg, entry := range data.Dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !known[pkg] && !fileExists(pkgDir) {
+			staged[pkg] = entry
+		}
+	}
+	if len(staged) == 0 {
+		return true
+	}
+
+	log.Printf("Staging %d new dependencies in %s", len(staged), stagingDir)
+	if err := pullPackages(staged, stagingDir); err != nil {
+		reportPullError(err)
+		return false
+	}
+
+	accepted := true
+	for _, pkg := range sortedKeys(staged) {
+		stagedDir := filepath.Join(stagingDir, vendorFolderName, vendorPath(pkg))
+		problems := checkStaged(stagedDir, pkg, staged[pkg], data.policy())
+		if len(problems) > 0 {
+			accepted = false
+			output.Problems(fmt.Sprintf("%s was not promoted from staging, it stays in %s:", pkg, stagedDir), problems)
+			continue
+		}
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		err := createDir(filepath.Dir(pkgDir))
+		if err == nil {
+			err = os.Rename(stagedDir, pkgDir)
+		}
+		if err != nil {
+			output.Errorf("Could not promote %s from staging: %s", pkg, err)
+			accepted = false
+			continue
+		}
+		log.Printf("Promoted %s from staging", pkg)
+	}
+	return accepted
+}
+
+// checkStaged runs the license, vulnerability and policy checks on a staged
+// package and everything it vendors.
+func checkStaged(pkgDir string, pkg string, entry *bpmEntry, policy *bpmPolicy) []string {
+	problems := make([]string, 0)
+	if policy != nil {
+		for _, denied := range policy.DeniedPackages {
+			if pkg == denied || strings.HasPrefix(pkg, strings.TrimSuffix(denied, "/")+"/") {
+				problems = append(problems, fmt.Sprintf("%s: denied by policy (%s)", pkg, denied))
+			}
+		}
+		if len(policy.Licenses) > 0 && !isAssets(entry) {
+			license := detectLicense(pkgDir)
+			if !containsString(policy.Licenses, license) {
+				problems = append(problems, fmt.Sprintf("%s: license %s is not allowed by policy", pkg, license))
+			}
+		}
+	}
+	if entry.Commit != "" {
+		vulns, err := queryVulnerabilities(entry.Commit)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: vulnerability lookup failed: %s", pkg, err))
+		}
+		for _, vuln := range vulns {
+			problems = append(problems, fmt.Sprintf("%s: vulnerable, %s %s", pkg, vuln.ID, vuln.Summary))
+		}
+	}
+	for _, nested := range sortedKeys(entry.Dependencies) {
+		nestedDir := filepath.Join(pkgDir, vendorFolderName, vendorPath(nested))
+		problems = append(problems, checkStaged(nestedDir, nested, entry.Dependencies[nested], policy)...)
+	}
+	return problems
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsFilename = "stats.json"
+
+// usageStats is the local usage report written when the stats setting of the
+// global config is on. It is never uploaded anywhere.
+type usageStats struct {
+	Since       time.Time                `json:"since"`
+	Commands    map[string]*commandStats `json:"commands"`
+	CacheHits   int                      `json:"cacheHits"`
+	CacheMisses int                      `json:"cacheMisses"`
+	Clones      int                      `json:"clones"`
+	CloneTime   float64                  `json:"cloneSeconds"`
+}
+
+type commandStats struct {
+	Runs     int       `json:"runs"`
+	Failures int       `json:"failures"`
+	Seconds  float64   `json:"seconds"`
+	LastRun  time.Time `json:"lastRun"`
+}
+
+// session collects the numbers of the running command until it is recorded.
+var session = struct {
+	sync.Mutex
+	command     string
+	start       time.Time
+	cacheHits   int
+	cacheMisses int
+	clones      int
+	cloneTime   time.Duration
+}{}
+
+func startStats(command string) {
+	session.Lock()
+	defer session.Unlock()
+	session.command = command
+	session.start = time.Now()
+}
+
+func countCacheLookup(hit bool) {
+	session.Lock()
+	defer session.Unlock()
+	if hit {
+		session.cacheHits++
+	} else {
+		session.cacheMisses++
+	}
+}
+
+func countClone(d time.Duration) {
+	session.Lock()
+	defer session.Unlock()
+	session.clones++
+	session.cloneTime += d
+}
+
+// exit records the command's statistics before exiting with code.
+func exit(code int) {
+	recordStats(code)
+	os.Exit(code)
+}
+
+func recordStats(code int) {
+	if !getGlobalConfig().Stats {
+		return
+	}
+	session.Lock()
+	defer session.Unlock()
+	if session.command == "" {
+		return
+	}
+
+	unlock, err := lockCache("stats", statsFilename)
+	if err != nil {
+		log.Printf("Statistics not recorded: %s", err)
+		return
+	}
+	defer unlock()
+	filename := filepath.Join(getCacheDir(), statsFilename)
+	stats := readStats(filename)
+	if stats.Since.IsZero() {
+		stats.Since = session.start.UTC()
+	}
+	cmd, ok := stats.Commands[session.command]
+	if !ok {
+		cmd = &commandStats{}
+		stats.Commands[session.command] = cmd
+	}
+	cmd.Runs++
+	if code != 0 {
+		cmd.Failures++
+	}
+	cmd.Seconds += time.Since(session.start).Seconds()
+	cmd.LastRun = session.start.UTC()
+	stats.CacheHits += session.cacheHits
+	stats.CacheMisses += session.cacheMisses
+	stats.Clones += session.clones
+	stats.CloneTime += session.cloneTime.Seconds()
+
+	bytes, err := jsonEncodeIndented(stats)
+	if err == nil {
+		err = ioutil.WriteFile(filename, bytes, 0644)
+	}
+	if err != nil {
+		log.Printf("Could not write %s: %s", filename, err)
+	}
+	session.command = ""
+}
+
+func readStats(filename string) *usageStats {
+	stats := &usageStats{}
+	if bytes, err := ioutil.ReadFile(filename); err == nil {
+		if err = json.Unmarshal(bytes, stats); err != nil {
+			log.Printf("Ignoring invalid %s: %s", filename, err)
+			stats = &usageStats{}
+		}
+	}
+	if stats.Commands == nil {
+		stats.Commands = make(map[string]*commandStats)
+	}
+	return stats
+}
+
+func doStats() int {
+	filename := filepath.Join(getCacheDir(), statsFilename)
+	if !fileExists(filename) {
+		if getGlobalConfig().Stats {
+			output.Println("No statistics recorded yet.")
+		} else {
+			output.Printf("Statistics are off. Set \"stats\": true in %s to record them locally.\n", getConfigFile())
+		}
+		return 0
+	}
+	stats := readStats(filename)
+	output.Result(stats, func() {
+		printStats(filename, stats)
+	})
+	return 0
+}
+
+func printStats(filename string, stats *usageStats) {
+	fmt.Printf("Since %s (%s)\n\n", stats.Since.Local().Format("2006-01-02"), filename)
+	names := make([]string, 0, len(stats.Commands))
+	for name := range stats.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("%-16s %6s %8s %10s\n", "command", "runs", "failures", "avg time")
+	for _, name := range names {
+		cmd := stats.Commands[name]
+		fmt.Printf("%-16s %6d %8d %9.1fs\n", name, cmd.Runs, cmd.Failures, cmd.Seconds/float64(cmd.Runs))
+	}
+
+	fmt.Println()
+	if lookups := stats.CacheHits + stats.CacheMisses; lookups > 0 {
+		rate := float64(stats.CacheHits) / float64(lookups) * 100
+		fmt.Printf("Remote cache: %d lookups, %.0f%% hits\n", lookups, rate)
+		if rate < 50 {
+			fmt.Println("    Few lookups hit the cache, consider a longer remoteCacheTTL.")
+		}
+	}
+	if stats.Clones > 0 {
+		fmt.Printf("Clones: %d, %.1fs on average\n", stats.Clones, stats.CloneTime/float64(stats.Clones))
+		if !getGlobalConfig().PartialClone && stats.CloneTime/float64(stats.Clones) > 10 {
+			fmt.Println("    Clones are slow, consider \"partialClone\": true.")
+		}
+	}
+}
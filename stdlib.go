@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// stdCache is the standard library of a toolchain as go list std lists it,
+// cached as it only changes with the toolchain.
+type stdCache struct {
+	Version  string   `json:"version"`
+	Packages []string `json:"packages"`
+}
+
+var (
+	stdOnce     sync.Once
+	stdPackages map[string]bool
+)
+
+// isStandardPackage is whether importPath is in the standard library of the
+// go toolchain. Without a toolchain, paths whose first element has no dot
+// count as standard, as the go command reserves those.
+func isStandardPackage(importPath string) bool {
+	if importPath == "C" {
+		return true
+	}
+	stdOnce.Do(func() {
+		stdPackages = readStandardPackages()
+	})
+	if stdPackages == nil {
+		return !strings.Contains(strings.SplitN(importPath, "/", 2)[0], ".")
+	}
+	return stdPackages[importPath]
+}
+
+// readStandardPackages runs go list std, unless the cache has the packages of
+// the same toolchain, or returns nil without a toolchain.
+func readStandardPackages() map[string]bool {
+	t := detectToolchain()
+	if t == nil {
+		return nil
+	}
+	cacheFile := filepath.Join(getCacheDir(), "std.json")
+	cache := &stdCache{}
+	if bytes, err := ioutil.ReadFile(cacheFile); err == nil {
+		json.Unmarshal(bytes, cache)
+	}
+	if cache.Version != t.version || len(cache.Packages) == 0 {
+		out, err := exec.CommandContext(runContext, "go", "list", "std").Output()
+		if err != nil {
+			log.Printf("Could not list the standard library: %s", err)
+			return nil
+		}
+		cache = &stdCache{Version: t.version, Packages: strings.Fields(string(out))}
+		bytes, err := jsonEncodeIndented(cache)
+		if err == nil {
+			err = ioutil.WriteFile(cacheFile, bytes, 0644)
+		}
+		if err != nil {
+			log.Printf("Could not cache the standard library: %s", err)
+		}
+	}
+	packages := make(map[string]bool)
+	for _, pkg := range cache.Packages {
+		packages[pkg] = true
+	}
+	return packages
+}
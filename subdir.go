@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// subdirMarkerFilename records the commit a subdirectory of a monorepo was
+// copied from.
+const subdirMarkerFilename = ".bpm-subdir"
+
+// lockMonorepo serializes the work on a cached monorepo clone, as several of
+// its subdirectories are installed in parallel, possibly by several processes.
+func lockMonorepo(url string) (func(), error) {
+	return lockCache("monorepo", url)
+}
+
+// installSubdir vendors one subdirectory of a monorepo, so subdirectories can
+// be pinned at different commits. All of them are checked out as worktrees of
+// a single bare clone in the cache, one per commit, and the subdirectory is
+// copied from there into pkgDir. An unpinned entry takes the commit its
+// branch, or tag, points at.
+func installSubdir(pkg string, entry *bpmEntry, pkgDir string) error {
+	marker := filepath.Join(pkgDir, subdirMarkerFilename)
+	if entry.Commit != "" {
+		if installed, err := ioutil.ReadFile(marker); err == nil && strings.TrimSpace(string(installed)) == entry.Commit {
+			return nil
+		}
+	}
+
+	url := getEntryURL(pkg, entry)
+	unlock, err := lockMonorepo(url)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	clone, err := getMonorepoClone(url)
+	if err != nil {
+		return err
+	}
+	rev := entry.Commit
+	if rev == "" {
+		rev = entry.Branch
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	commit, err := runCmdOutput(&clone, "git", "rev-parse", rev+"^{commit}")
+	if err != nil {
+		return err
+	}
+	worktree := filepath.Join(clone+"-worktrees", commit)
+	if !fileExists(worktree) {
+		log.Printf("Checking out %s of %s in %s", shortHash(commit), url, worktree)
+		if err := runGit(&clone, "worktree", "add", "--detach", worktree, commit); err != nil {
+			return fmt.Errorf("could not check out %s of %s: %s", commit, url, err)
+		}
+	}
+
+	source := filepath.Join(worktree, filepath.FromSlash(entry.Subdir))
+	if !fileExists(source) {
+		return fmt.Errorf("%s has no folder %s at %s", url, entry.Subdir, shortHash(commit))
+	}
+	if err := clearPackageDir(pkgDir); err != nil {
+		return err
+	}
+	if err := copyTree(source, pkgDir); err != nil {
+		return fmt.Errorf("could not copy %s of %s: %s", entry.Subdir, url, err)
+	}
+	if err := ioutil.WriteFile(marker, []byte(commit+"\n"), 0644); err != nil {
+		return err
+	}
+	entry.Commit = commit
+	return nil
+}
+
+// getMonorepoClone returns the bare clone of a monorepo in the cache, cloning
+// it or fetching its latest branches and tags.
+func getMonorepoClone(url string) (string, error) {
+	sum := sha256.Sum256([]byte(url))
+	clone := filepath.Join(getCacheDir("monorepos"), hex.EncodeToString(sum[:8]))
+	if !fileExists(filepath.Join(clone, "HEAD")) {
+		log.Printf("Cloning monorepo %s in %s...", url, clone)
+		if err := removeDir(clone); err != nil {
+			return "", err
+		}
+		if err := runGit(nil, append([]string{"clone", "--bare"}, append(longPathArgs(), mirrorURL(url), clone)...)...); err != nil {
+			return "", fmt.Errorf("could not clone %s, %s", url, err)
+		}
+		return clone, nil
+	}
+	if err := runGit(&clone, "fetch", "--quiet", "--tags", "origin", "+refs/heads/*:refs/heads/*"); err != nil {
+		log.Printf("Could not fetch %s, using the cached clone: %s", url, err)
+	}
+	return clone, nil
+}
+
+// copyTree copies the files below source into target, skipping a nested
+// vendor folder.
+func copyTree(source string, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+		switch {
+		case info.IsDir() && rel == vendorFolderName:
+			return filepath.SkipDir
+		case info.IsDir():
+			return os.MkdirAll(dest, 0755)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// expandSubdirs replaces the repository roots of monorepos found among the
+// imports with the subdirectories declared for them.
+func (r *resolver) expandSubdirs(packages []string) []string {
+	result := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		expanded := false
+		for declared, entry := range r.declared {
+			if entry.Subdir != "" && entryRepo(declared, entry) == pkg {
+				result = append(result, declared)
+				expanded = true
+			}
+		}
+		if !expanded {
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
+
+// fetchSubdir installs a declared subdirectory at the head of its branch or
+// tag, or at its previous commit when kept or when there is neither.
+func (r *resolver) fetchSubdir(pkg string, declared *bpmEntry, pkgDir string) (*bpmEntry, error) {
+	entry := &bpmEntry{URL: declared.URL, Subdir: declared.Subdir, Branch: declared.Branch}
+	if _, kept := r.pins[pkg]; kept || declared.Branch == "" {
+		entry.Commit = declared.Commit
+	}
+	if err := installSubdir(pkg, entry, pkgDir); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// bpmTagInfo is the metadata of an annotated tag a dependency is pinned to,
+// recorded when pinning so the provenance of a release stays with it.
+type bpmTagInfo struct {
+	Tagger    string `json:"tagger,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// pinTag pins an entry to the commit of its tag, or with a version
+// constraint to the highest matching tag, fetching the tag into an existing
+// checkout when fetch is set.
+func pinTag(pkg string, entry *bpmEntry, pkgDir string, fetch bool) error {
+	if entry.Version != "" {
+		tag, commit, err := findVersion(pkg, entry)
+		if err != nil {
+			return err
+		}
+		entry.Tag, entry.Commit = tag, commit
+		log.Printf("Version %s of %s resolved to %s", entry.Version, pkg, entry.Tag)
+	} else {
+		commit, err := findTag(pkg, entry)
+		if err != nil {
+			return err
+		}
+		entry.Commit = commit
+	}
+	if fetch {
+		return runGit(&pkgDir, "fetch", "--quiet", "origin", "+refs/tags/"+entry.Tag+":refs/tags/"+entry.Tag)
+	}
+	return nil
+}
+
+// findTag returns the commit the tag of an entry points at on its remote.
+func findTag(pkg string, entry *bpmEntry) (string, error) {
+	refs, err := lsRemote(getEntryURL(pkg, entry), "refs/tags/"+entry.Tag)
+	if err != nil {
+		return "", err
+	}
+	commit, ok := refs["refs/tags/"+entry.Tag]
+	if !ok {
+		return "", fmt.Errorf("tag %s of %s does not exist", entry.Tag, pkg)
+	}
+	return commit, nil
+}
+
+// fetchTagged checks a fetched package out at the tag it was declared with,
+// or the highest tag matching its declared version constraint, falling back
+// to the fetched head when the tag can't be checked out.
+func (r *resolver) fetchTagged(pkg string, pkgDir string, declared *bpmEntry, fetched *bpmEntry) (*bpmEntry, error) {
+	tags, err := r.src.tags(pkg, fetched.URL)
+	if err != nil {
+		return nil, err
+	}
+	tag, commit := declared.Tag, ""
+	if declared.Version != "" {
+		if tag, commit, err = matchVersion(pkg, declared, tags); err != nil {
+			return nil, err
+		}
+	} else if commit = tags[tag]; commit == "" {
+		return nil, fmt.Errorf("tag %s of %s does not exist", tag, pkg)
+	}
+	entry := r.src.checkout(pkg, pkgDir, &bpmEntry{Branch: fetched.Branch, Tag: tag, Commit: commit})
+	if entry == nil {
+		log.Printf("Tag %s of %s is not available, using %s", tag, pkg, fetched.Commit)
+		return fetched, nil
+	}
+	entry.URL = fetched.URL
+	entry.Version = declared.Version
+	return entry, nil
+}
+
+// readTagInfo reads the metadata of a tag in a checkout. Lightweight tags
+// have none.
+func readTagInfo(pkgDir string, tag string) *bpmTagInfo {
+	format := strings.Join([]string{
+		"%(objecttype)",
+		"%(taggername) %(taggeremail)",
+		"%(taggerdate:iso-strict)",
+		"%(contents:subject)",
+		"%(contents:body)",
+		"%(contents:signature)"}, "%00")
+	out, err := gitCommand(&pkgDir, "for-each-ref", "--format="+format, "refs/tags/"+tag).Output()
+	if err != nil {
+		log.Printf("Could not read tag %s in %s: %s", tag, pkgDir, err)
+		return nil
+	}
+	fields := strings.Split(strings.TrimSuffix(string(out), "\n"), "\x00")
+	if len(fields) < 6 || fields[0] != "tag" {
+		return nil
+	}
+	message := strings.TrimSpace(fields[3] + "\n\n" + fields[4])
+	return &bpmTagInfo{
+		Tagger:    strings.TrimSpace(fields[1]),
+		Date:      fields[2],
+		Message:   message,
+		Signature: strings.TrimSpace(fields[5])}
+}
+
+func (t *bpmTagInfo) String() string {
+	signed := "unsigned"
+	if t.Signature != "" {
+		signed = "signed"
+	}
+	return fmt.Sprintf("tagged by %s on %s, %s", t.Tagger, t.Date, signed)
+}
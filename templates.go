@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// projectTemplates seed the manifest of init -template with settings for a
+// kind of project. An organization defines its own, with its standard
+// dependencies, hooks and policies, in the templates of the global config:
+//
+//	"templates": {
+//	  "service": {
+//	    "requireApproval": true,
+//	    "policy": {"licenses": ["MIT", "Apache-2.0"]},
+//	    "hooks": {"prePublish": ["make test"]},
+//	    "dependencies": {"github.com/corp/telemetry": {"version": "^2.0.0"}}
+//	  }
+//	}
+//
+// Templates of the global config replace the built-in ones of the same name.
+var projectTemplates = map[string]*bpmPackage{
+	"service": {
+		RequireApproval: true,
+		Staging:         true,
+		Policy:          &bpmPolicy{TransitiveReplaces: "confirm"}},
+	"library": {
+		Budget: &bpmBudget{MaxDependencies: 50}},
+	"cli": {
+		Layout: layoutFlat,
+		Budget: &bpmBudget{MaxVendorSize: "100MiB"}},
+}
+
+// getProjectTemplate returns a copy of the named template, or nil when there
+// is none.
+func getProjectTemplate(name string) (*bpmPackage, error) {
+	template, ok := getGlobalConfig().Templates[name]
+	if !ok {
+		if template, ok = projectTemplates[name]; !ok {
+			return nil, nil
+		}
+	}
+	bytes, err := jsonEncodeIndented(template)
+	if err != nil {
+		return nil, err
+	}
+	data := &bpmPackage{}
+	if err := json.Unmarshal(bytes, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func projectTemplateNames() string {
+	names := make([]string, 0, len(projectTemplates))
+	for name := range projectTemplates {
+		names = append(names, name)
+	}
+	for name := range getGlobalConfig().Templates {
+		if _, ok := projectTemplates[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
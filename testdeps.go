@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// markTestDependencies marks the dependencies only the _test.go files of the
+// project in dir need. They are declared in the testDependencies of bpm.json
+// and install -production skips them. In a flat layout, packages only test
+// dependencies import are test dependencies too, while a test dependency
+// another dependency imports is not.
+func markTestDependencies(dir string, pkg string, dependencies map[string]*bpmEntry) error {
+	production, tests, err := projectImports(dir)
+	if err != nil {
+		return err
+	}
+	direct := make(map[string]bool)
+	for name, entry := range dependencies {
+		direct[name] = isImported(tests, name) && !isImported(production, name)
+		entry.Test = direct[name] || len(entry.RequiredBy) > 0
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, entry := range dependencies {
+			if !entry.Test || len(entry.RequiredBy) == 0 {
+				continue
+			}
+			for _, from := range entry.RequiredBy {
+				requirer, ok := dependencies[from]
+				if (from == pkg && !direct[name]) || (from != pkg && (!ok || !requirer.Test)) {
+					entry.Test = false
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// projectImports returns the import paths of the source files in dir,
+// outside of its vendor folder, and those of its _test.go files.
+func projectImports(dir string) ([]string, []string, error) {
+	files, err := getAllSourceFiles(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	imports, err := getAllImports(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	production, tests := make([]string, 0), make([]string, 0)
+	for file, specs := range imports {
+		for _, spec := range specs {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			if strings.HasSuffix(file, "_test.go") {
+				tests = append(tests, path)
+			} else {
+				production = append(production, path)
+			}
+		}
+	}
+	return production, tests, nil
+}
+
+// mergeTestDependencies moves the testDependencies of a manifest in with its
+// other dependencies, marked as test dependencies.
+func (p *bpmPackage) mergeTestDependencies() {
+	if len(p.TestDependencies) > 0 && p.Dependencies == nil {
+		p.Dependencies = make(map[string]*bpmEntry)
+	}
+	for pkg, entry := range p.TestDependencies {
+		entry.Test = true
+		p.Dependencies[pkg] = entry
+	}
+	p.TestDependencies = nil
+}
+
+// splitTestDependencies separates the test dependencies from the others.
+func splitTestDependencies(dependencies map[string]*bpmEntry) (map[string]*bpmEntry, map[string]*bpmEntry) {
+	production, tests := make(map[string]*bpmEntry), make(map[string]*bpmEntry)
+	for pkg, entry := range dependencies {
+		if entry.Test {
+			tests[pkg] = entry
+		} else {
+			production[pkg] = entry
+		}
+	}
+	return production, tests
+}
+
+// removeTestDependencies removes vendored test dependencies, for install
+// -production.
+func removeTestDependencies(dir string, tests map[string]*bpmEntry) error {
+	vendorDir := filepath.Join(dir, vendorFolderName)
+	for _, pkg := range sortedKeys(tests) {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if fileExists(pkgDir) {
+			log.Printf("Removing test dependency %s", pkg)
+			if err := removeDir(pkgDir); err != nil {
+				return err
+			}
+			removeEmptyParents(filepath.Dir(pkgDir), vendorDir)
+		}
+	}
+	return nil
+}
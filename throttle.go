@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobsPerHost   = 4
+	maxThrottledAttempts = 5
+	maxThrottleDelay     = time.Minute
+)
+
+// jobsPerHost is set by -jobs-per-host and overrides the global config.
+var jobsPerHost string
+
+// hostThrottle limits the concurrent git operations against one host and
+// spaces them out after the host signalled rate limiting.
+type hostThrottle struct {
+	host  string
+	slots chan struct{}
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+var (
+	throttlesMu sync.Mutex
+	throttles   = make(map[string]*hostThrottle)
+)
+
+func getHostThrottle(repoURL string) *hostThrottle {
+	host := getURLHost(repoURL)
+	throttlesMu.Lock()
+	defer throttlesMu.Unlock()
+	if t, ok := throttles[host]; ok {
+		return t
+	}
+	t := &hostThrottle{host: host, slots: make(chan struct{}, getJobsPerHost(host))}
+	throttles[host] = t
+	return t
+}
+
+func getJobsPerHost(host string) int {
+	// Checked by checkJobFlags.
+	if jobs, err := strconv.Atoi(jobsPerHost); err == nil && jobs > 0 {
+		return jobs
+	}
+	config := getGlobalConfig()
+	if jobs, ok := config.HostJobs[host]; ok && jobs > 0 {
+		return jobs
+	}
+	if config.JobsPerHost > 0 {
+		return config.JobsPerHost
+	}
+	return defaultJobsPerHost
+}
+
+func (t *hostThrottle) acquire() {
+	t.slots <- struct{}{}
+	t.mu.Lock()
+	delay := t.delay
+	t.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+func (t *hostThrottle) release() {
+	<-t.slots
+}
+
+// slowDown doubles the pause before each operation against the host.
+func (t *hostThrottle) slowDown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.delay == 0 {
+		t.delay = 2 * time.Second
+	} else if t.delay < maxThrottleDelay {
+		t.delay *= 2
+	}
+	log.Printf("%s is rate limiting, waiting %s between requests", t.host, t.delay)
+}
+
+// getURLHost returns the host of an URL or scp-like git address, or "" for
+// local paths.
+func getURLHost(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if i := strings.Index(repoURL, ":"); i > 0 && !strings.Contains(repoURL[:i], "/") {
+		host := repoURL[:i]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+		return host
+	}
+	return ""
+}
+
+// gitError is a failed git command with the error output it printed.
+type gitError struct {
+	args   []string
+	err    error
+	stderr string
+}
+
+func (e *gitError) Error() string {
+	return "git " + strings.Join(e.args, " ") + ": " + e.err.Error() + "\n" + strings.TrimSpace(e.stderr)
+}
+
+// runGit runs a git command like runCmd, but returns failures with the error
+// output instead of panicking.
+func runGit(dir *string, args ...string) error {
+	cmd := gitCommand(dir, args...)
+	log.Printf("Command: git %s", strings.Join(args, " "))
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	if err := cmd.Run(); err != nil {
+		return &gitError{args: args, err: err, stderr: stderr.String()}
+	}
+	return nil
+}
+
+func isThrottled(err error) bool {
+	cause, _ := classifyGitError(err)
+	return cause == "rate limited"
+}
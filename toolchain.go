@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// goToolchain is the go command found on the PATH. Toolchains from before
+// modules only use vendor folders of projects placed at their import path in
+// GOPATH, go1.5 only with GO15VENDOREXPERIMENT=1 and go1.4 not at all, while
+// module mode ignores nested vendor folders.
+type goToolchain struct {
+	version string
+	minor   int
+	gopath  []string
+}
+
+var (
+	toolchainOnce sync.Once
+	toolchain     *goToolchain
+	goVersionRe   = regexp.MustCompile(`go1\.(\d+)`)
+)
+
+// detectToolchain returns the go toolchain, or nil without one.
+func detectToolchain() *goToolchain {
+	toolchainOnce.Do(func() {
+		out, err := exec.CommandContext(runContext, "go", "version").Output()
+		if err != nil {
+			return
+		}
+		t := &goToolchain{version: strings.TrimPrefix(strings.TrimSpace(string(out)), "go version "), minor: 1 << 10}
+		// Development builds have no release number and count as current.
+		if match := goVersionRe.FindStringSubmatch(t.version); match != nil {
+			t.minor, _ = strconv.Atoi(match[1])
+		}
+		gopath := os.Getenv("GOPATH")
+		if out, err := exec.CommandContext(runContext, "go", "env", "GOPATH").Output(); err == nil {
+			gopath = strings.TrimSpace(string(out))
+		}
+		t.gopath = filepath.SplitList(gopath)
+		toolchain = t
+	})
+	return toolchain
+}
+
+// vendorSupport describes whether the toolchain uses vendor folders.
+func (t *goToolchain) vendorSupport() string {
+	experiment := os.Getenv("GO15VENDOREXPERIMENT")
+	switch {
+	case t.minor < 5:
+		return "none"
+	case t.minor == 5 && experiment != "1":
+		return "off"
+	case t.minor == 6 && experiment == "0":
+		return "off"
+	}
+	return "on"
+}
+
+// moduleMode is whether the toolchain builds the project in dir as a module.
+func (t *goToolchain) moduleMode(dir string) bool {
+	if t.minor < 11 {
+		return false
+	}
+	switch os.Getenv("GO111MODULE") {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return t.minor >= 16 || fileExists(filepath.Join(dir, "go.mod"))
+}
+
+// toolchainEnv returns the variables bpm sets for the go commands it runs,
+// turning on vendor folders for go1.5.
+func toolchainEnv() []string {
+	if t := detectToolchain(); t != nil && t.minor == 5 && os.Getenv("GO15VENDOREXPERIMENT") == "" {
+		return []string{"GO15VENDOREXPERIMENT=1"}
+	}
+	return nil
+}
+
+// toolchainProblems explains what the toolchain needs to build the project
+// in dir against its vendor folder.
+func toolchainProblems(t *goToolchain, dir string, data *bpmPackage) []string {
+	problems := make([]string, 0)
+	switch t.vendorSupport() {
+	case "none":
+		problems = append(problems, fmt.Sprintf("%s doesn't support vendor folders, dependencies are only found in GOPATH; go1.6 or later is needed", t.version))
+		return problems
+	case "off":
+		problems = append(problems, "vendor folders are turned off, set GO15VENDOREXPERIMENT=1; bpm sets it for the builds it runs")
+	}
+	if t.moduleMode(dir) {
+		if data.Layout != layoutFlat && hasNestedDependencies(data.Dependencies) {
+			problems = append(problems, "module mode ignores nested vendor folders, use the flat layout of bpm rebuild -flat")
+		}
+		return problems
+	}
+	if !isInGopath(t, dir, data.Package) {
+		expected := filepath.Join("$GOPATH", "src", filepath.FromSlash(data.Package))
+		if len(t.gopath) > 0 {
+			expected = filepath.Join(t.gopath[0], "src", filepath.FromSlash(data.Package))
+		}
+		problems = append(problems, fmt.Sprintf("GOPATH mode only uses the vendor folder of a project at its import path, move the project to %s", expected))
+	}
+	return problems
+}
+
+func isInGopath(t *goToolchain, dir string, pkg string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for _, gopath := range t.gopath {
+		if abs == filepath.Join(gopath, "src", filepath.FromSlash(pkg)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNestedDependencies(dependencies map[string]*bpmEntry) bool {
+	for _, entry := range dependencies {
+		if len(entry.Dependencies) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkToolchain warns after installs when the toolchain won't find the
+// vendored dependencies.
+func checkToolchain(dir string, data *bpmPackage) {
+	t := detectToolchain()
+	if t == nil {
+		return
+	}
+	for _, problem := range toolchainProblems(t, dir, data) {
+		output.Printf("Warning: %s\n", problem)
+	}
+}
+
+// doctorToolchain prints the detected go toolchain, how it finds vendored
+// packages and what it needs to build the project in dir.
+func doctorToolchain(dir string) {
+	t := detectToolchain()
+	if t == nil {
+		output.Printf("go:    not found, bpm doesn't need it but builds do\n")
+		return
+	}
+	mode := "GOPATH mode"
+	if t.moduleMode(dir) {
+		mode = "module mode, only the top level vendor folder is used"
+	}
+	vendor := map[string]string{
+		"none": "no vendor folders",
+		"off":  "vendor folders turned off",
+		"on":   "vendor folders",
+	}[t.vendorSupport()]
+	output.Printf("go:    %s, %s, %s\n", t.version, vendor, mode)
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		return
+	}
+	// An unreadable manifest is reported with the hosts.
+	data, err := readDataFile(depFile)
+	if err != nil {
+		return
+	}
+	for _, problem := range toolchainProblems(t, dir, data) {
+		output.Printf("    %s\n", problem)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultTraceEndpoint = "http://localhost:4318"
+
+// traceSpan is one timed step of a traced command, exported as an
+// OpenTelemetry span.
+type traceSpan struct {
+	id         string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	failure    string
+}
+
+// tracer collects the spans of a command, exported over OTLP/HTTP when it
+// ends. Spans are children of the span owning the closest enclosing folder,
+// so the clone and checkout of a package land below its pull, and the pull of
+// a nested package below the package vendoring it.
+type tracer struct {
+	mu      sync.Mutex
+	traceID string
+	root    *traceSpan
+	byDir   map[string]*traceSpan
+	spans   []*traceSpan
+}
+
+// tracing is nil unless the command runs with -trace.
+var tracing *tracer
+
+// startTracing starts the root span of a command. A W3C TRACEPARENT in the
+// environment, as set by CI systems, makes it part of that trace.
+func startTracing(name string, attributes ...string) *traceSpan {
+	t := &tracer{traceID: newTraceID(16), byDir: make(map[string]*traceSpan)}
+	parentID := ""
+	if parts := strings.Split(os.Getenv("TRACEPARENT"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		t.traceID, parentID = parts[1], parts[2]
+	}
+	tracing = t
+	t.root = t.newSpan(name, parentID, attributes)
+	return t.root
+}
+
+// newTraceID returns a random ID of size bytes, derived from the clock when
+// no random bytes can be read, as tracing must not fail the command.
+func newTraceID(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		log.Printf("Could not read a random trace ID, using the clock: %s", err)
+		binary.BigEndian.PutUint64(id[size-8:], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(id)
+}
+
+func (t *tracer) newSpan(name string, parentID string, attributes []string) *traceSpan {
+	s := &traceSpan{id: newTraceID(8), parentID: parentID, name: name, start: time.Now(), attributes: make(map[string]string)}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		if attributes[i+1] != "" {
+			s.attributes[attributes[i]] = attributes[i+1]
+		}
+	}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return s
+}
+
+// startSpan starts a span below the one owning dir or the closest folder
+// above it, else below the root span, with attributes given as key, value
+// pairs. It returns nil when not tracing; the methods of traceSpan accept nil.
+func startSpan(name string, dir string, attributes ...string) *traceSpan {
+	t := tracing
+	if t == nil {
+		return nil
+	}
+	parent := t.root
+	t.mu.Lock()
+	for d := dir; ; d = filepath.Dir(d) {
+		if s, ok := t.byDir[d]; ok {
+			parent = s
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	t.mu.Unlock()
+	return t.newSpan(name, parent.id, attributes)
+}
+
+// own makes s the parent of the spans started for dir.
+func (s *traceSpan) own(dir string) {
+	if s == nil {
+		return
+	}
+	tracing.mu.Lock()
+	tracing.byDir[dir] = s
+	tracing.mu.Unlock()
+}
+
+func (s *traceSpan) set(key string, value string) {
+	if s == nil || value == "" {
+		return
+	}
+	tracing.mu.Lock()
+	s.attributes[key] = value
+	tracing.mu.Unlock()
+}
+
+// finish ends s, failed when err isn't nil.
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	tracing.mu.Lock()
+	s.end = time.Now()
+	if err != nil {
+		s.failure = err.Error()
+	}
+	tracing.mu.Unlock()
+}
+
+// done finishes s when deferred by the function it times, failed when that
+// panics. The panic goes on.
+func (s *traceSpan) done() {
+	r := recover()
+	if r == nil {
+		s.finish(nil)
+		return
+	}
+	s.finish(fmt.Errorf("%v", r))
+	panic(r)
+}
+
+// finishTracing ends the root span and exports the trace, logging failures
+// as tracing must not fail the command.
+func finishTracing(err error) {
+	t := tracing
+	if t == nil {
+		return
+	}
+	t.root.finish(err)
+	tracing = nil
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = getGlobalConfig().TraceEndpoint
+		}
+		if base == "" {
+			base = defaultTraceEndpoint
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	body, err := jsonEncodeIndented(t.export())
+	if err != nil {
+		log.Printf("Could not export the trace: %s", err)
+		return
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Could not export the trace: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if kv := strings.SplitN(header, "=", 2); len(kv) == 2 {
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("Could not export the trace to %s: %s", endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Could not export the trace to %s: %s", endpoint, resp.Status)
+		return
+	}
+	log.Printf("Exported trace %s with %d spans to %s", t.traceID, len(t.spans), endpoint)
+}
+
+// The OTLP/HTTP JSON encoding of a trace.
+type (
+	otlpTrace struct {
+		ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource      `json:"resource"`
+		ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []*otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []*otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string           `json:"traceId"`
+		SpanID            string           `json:"spanId"`
+		ParentSpanID      string           `json:"parentSpanId,omitempty"`
+		Name              string           `json:"name"`
+		Kind              int              `json:"kind"`
+		StartTimeUnixNano string           `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string           `json:"endTimeUnixNano"`
+		Attributes        []*otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus      `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func otlpAttributes(attributes map[string]string) []*otlpAttribute {
+	result := make([]*otlpAttribute, 0, len(attributes))
+	for _, key := range sortedStringKeys(attributes) {
+		a := &otlpAttribute{Key: key}
+		a.Value.StringValue = attributes[key]
+		result = append(result, a)
+	}
+	return result
+}
+
+func (t *tracer) export() *otlpTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	scope := &otlpScopeSpans{Spans: make([]*otlpSpan, 0, len(t.spans))}
+	scope.Scope.Name = "bpm"
+	for _, s := range t.spans {
+		end := s.end
+		if end.IsZero() {
+			// Left running by a failure that skipped finish.
+			end = t.root.end
+		}
+		span := &otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              1,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attributes)}
+		if s.failure != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.failure}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	hostname, _ := os.Hostname()
+	resource := otlpResource{Attributes: otlpAttributes(map[string]string{
+		"service.name": "bpm",
+		"host.name":    hostname})}
+	return &otlpTrace{ResourceSpans: []*otlpResourceSpans{{Resource: resource, ScopeSpans: []*otlpScopeSpans{scope}}}}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var transactionFolder = filepath.Join(".bpm", "transaction")
+
+// vendorTransaction makes an install all or nothing. Packages not vendored
+// yet are cloned into .bpm/transaction and only moved into vendor once every
+// dependency was pulled. Vendored checkouts note their branch and commit
+// before a pull changes them, and vendored archives and subdirs are copied
+// aside, so a failed install can put them back.
+type vendorTransaction struct {
+	mu        sync.Mutex
+	root      string
+	dir       string
+	staged    map[string]string
+	checkouts map[string]*savedCheckout
+	backups   map[string]string
+	committed bool
+}
+
+// savedCheckout is the state of a vendored checkout before a pull.
+type savedCheckout struct {
+	pkg     string
+	branch  string
+	commit  string
+	patched bool
+}
+
+// transaction is the running install transaction, nil outside of install.
+var transaction *vendorTransaction
+
+func beginTransaction(root string) *vendorTransaction {
+	tx := &vendorTransaction{
+		root:      root,
+		dir:       filepath.Join(root, transactionFolder),
+		staged:    make(map[string]string),
+		checkouts: make(map[string]*savedCheckout),
+		backups:   make(map[string]string)}
+	// Left over by a transaction that was killed.
+	if err := removeDir(tx.dir); err != nil {
+		log.Printf("Could not remove %s: %s", tx.dir, err)
+	}
+	transaction = tx
+	return tx
+}
+
+// stage returns the folder pkgDir is pulled into: a folder in the
+// transaction for a package missing from the vendor folder of the project,
+// else pkgDir itself. Packages nested in a staged one are staged with it.
+func (tx *vendorTransaction) stage(pkgDir string) string {
+	if tx == nil {
+		return pkgDir
+	}
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if staged, ok := tx.staged[pkgDir]; ok {
+		return staged
+	}
+	rel, err := filepath.Rel(filepath.Join(tx.root, vendorFolderName), pkgDir)
+	if err != nil || strings.HasPrefix(rel, "..") || fileExists(pkgDir) {
+		return pkgDir
+	}
+	staged := filepath.Join(tx.dir, vendorFolderName, rel)
+	tx.staged[pkgDir] = staged
+	return staged
+}
+
+// save notes the state of a vendored package before pulling into it.
+func (tx *vendorTransaction) save(pkg string, entry *bpmEntry, pkgDir string) error {
+	if tx == nil || strings.HasPrefix(pkgDir, tx.dir+string(filepath.Separator)) {
+		return nil
+	}
+	if isGitRepo(pkgDir) {
+		branch, err := getCurrentBranch(pkgDir)
+		if err != nil {
+			return fmt.Errorf("could not save the checkout of %s: %s", pkgDir, err)
+		}
+		commit, err := getCurrentCommitHash(pkgDir)
+		if err != nil {
+			return fmt.Errorf("could not save the checkout of %s: %s", pkgDir, err)
+		}
+		saved := &savedCheckout{
+			pkg:     pkg,
+			branch:  branch,
+			commit:  commit,
+			patched: isPatched(tx.root, pkg, pkgDir)}
+		tx.mu.Lock()
+		tx.checkouts[pkgDir] = saved
+		tx.mu.Unlock()
+		return nil
+	}
+	if isInstalled(entry, pkgDir) {
+		return nil
+	}
+	rel, err := filepath.Rel(tx.root, pkgDir)
+	if err != nil {
+		return err
+	}
+	backup := filepath.Join(tx.dir, "backup", rel)
+	if err := copyTree(pkgDir, backup); err != nil {
+		return fmt.Errorf("could not back up %s: %s", pkgDir, err)
+	}
+	tx.mu.Lock()
+	tx.backups[pkgDir] = backup
+	tx.mu.Unlock()
+	return nil
+}
+
+// isInstalled is whether an archive or subdir package is already vendored as
+// its entry pins it, which a pull leaves alone.
+func isInstalled(entry *bpmEntry, pkgDir string) bool {
+	marker, want := filepath.Join(pkgDir, archiveMarkerFilename), entry.SHA256
+	if entry.Archive == "" {
+		marker, want = filepath.Join(pkgDir, subdirMarkerFilename), entry.Commit
+	}
+	installed, err := ioutil.ReadFile(marker)
+	return err == nil && want != "" && strings.TrimSpace(string(installed)) == want
+}
+
+// commit moves the staged packages into vendor, parents first.
+func (tx *vendorTransaction) commit() error {
+	// Set first, so a rollback also removes the packages moved before a
+	// failure.
+	tx.committed = true
+	for _, pkgDir := range tx.stagedDirs() {
+		if err := createDir(filepath.Dir(pkgDir)); err != nil {
+			return err
+		}
+		if err := os.Rename(tx.staged[pkgDir], pkgDir); err != nil {
+			return err
+		}
+		log.Printf("Moved %s into vendor", pkgDir)
+	}
+	return nil
+}
+
+// rollback removes the staged packages, also when already committed, and
+// puts vendored packages back the way they were. It goes on past packages it
+// can't restore, logging them, so as much as possible is put back.
+func (tx *vendorTransaction) rollback() {
+	log.Printf("Rolling back the changes to %s", filepath.Join(tx.root, vendorFolderName))
+	if tx.committed {
+		dirs := tx.stagedDirs()
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := removeDir(dirs[i]); err != nil {
+				log.Printf("Could not remove %s: %s", dirs[i], err)
+			}
+			clearIncomplete(dirs[i])
+			removeEmptyParents(filepath.Dir(dirs[i]), filepath.Join(tx.root, vendorFolderName))
+		}
+	}
+	if interrupted() != nil {
+		// git can't run anymore; the checkouts stay marked incomplete and
+		// are pulled again next time.
+		log.Printf("Leaving %d vendored checkouts to the next install", len(tx.checkouts))
+		tx.checkouts = nil
+	}
+	dirs := make([]string, 0, len(tx.checkouts))
+	for pkgDir := range tx.checkouts {
+		dirs = append(dirs, pkgDir)
+	}
+	sort.Strings(dirs)
+	for _, pkgDir := range dirs {
+		saved := tx.checkouts[pkgDir]
+		if !isGitRepo(pkgDir) {
+			continue
+		}
+		if err := tx.restoreCheckout(saved, pkgDir); err != nil {
+			log.Printf("Could not restore %s: %s", pkgDir, err)
+			continue
+		}
+		clearIncomplete(pkgDir)
+	}
+	for _, pkgDir := range sortedStringKeys(tx.backups) {
+		err := clearPackageDir(pkgDir)
+		if err == nil {
+			err = copyTree(tx.backups[pkgDir], pkgDir)
+		}
+		if err != nil {
+			log.Printf("Could not restore %s: %s", pkgDir, err)
+			continue
+		}
+		clearIncomplete(pkgDir)
+	}
+}
+
+// end finishes the transaction, rolling it back unless ok.
+func (tx *vendorTransaction) end(ok bool) {
+	transaction = nil
+	if !ok {
+		tx.rollback()
+	}
+	if err := removeDir(tx.dir); err != nil {
+		log.Printf("Could not remove %s: %s", tx.dir, err)
+	}
+}
+
+func (tx *vendorTransaction) stagedDirs() []string {
+	dirs := make([]string, 0, len(tx.staged))
+	for pkgDir := range tx.staged {
+		dirs = append(dirs, pkgDir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// restoreCheckout puts a vendored checkout back at the branch and commit it
+// was at, with its patches applied if it had them.
+func (tx *vendorTransaction) restoreCheckout(saved *savedCheckout, pkgDir string) error {
+	branch, err := getCurrentBranch(pkgDir)
+	if err != nil {
+		return err
+	}
+	commit, err := getCurrentCommitHash(pkgDir)
+	if err != nil {
+		return err
+	}
+	if branch != saved.branch || commit != saved.commit {
+		if err := revertPatches(tx.root, saved.pkg, pkgDir); err != nil {
+			return err
+		}
+		if err := checkoutCommit(pkgDir, saved.branch, saved.commit); err != nil {
+			return err
+		}
+	}
+	if saved.patched && !isPatched(tx.root, saved.pkg, pkgDir) {
+		return applyPatches(tx.root, saved.pkg, pkgDir)
+	}
+	return nil
+}
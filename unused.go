@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// doUnused lists the manifest entries that no import of the code depending
+// on them reaches, leaving assets and tools aside, and returns the exit code.
+func doUnused(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	unused, err := findUnused(dir, dir, data.Dependencies)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if len(unused) == 0 {
+		output.Println("Every dependency is imported")
+		return 0
+	}
+	output.Problems(fmt.Sprintf("%d dependencies are not imported:", len(unused)), unused)
+	return 1
+}
+
+func findUnused(root string, dir string, dependencies map[string]*bpmEntry) ([]string, error) {
+	result := make([]string, 0)
+	if len(dependencies) == 0 {
+		return result, nil
+	}
+	imports, err := getImportPaths(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if isAssets(entry) || entry.Kind == entryKindTool || entry.Indirect {
+			continue
+		}
+		if !isImported(imports, pkg) {
+			declaredBy := "the project"
+			if dir != root {
+				rel, _ := filepath.Rel(root, dir)
+				declaredBy = filepath.ToSlash(rel)
+			}
+			result = append(result, fmt.Sprintf("%s: not imported by %s", pkg, declaredBy))
+			continue
+		}
+		nested, err := findUnused(root, pkgDir, entry.Dependencies)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+	return result, nil
+}
+
+// getImportPaths returns every import path of the source files in dir,
+// outside of its vendor folder.
+func getImportPaths(dir string) ([]string, error) {
+	files, err := getAllSourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	all, err := getAllImports(files)
+	if err != nil {
+		return nil, err
+	}
+	imports := make([]string, 0)
+	for _, specs := range all {
+		for _, spec := range specs {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				imports = append(imports, path)
+			}
+		}
+	}
+	return imports, nil
+}
+
+func isImported(imports []string, pkg string) bool {
+	for _, path := range imports {
+		if path == pkg || strings.HasPrefix(path, pkg+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// updateDependencies moves the dependencies to the latest commit of the
+// branch they track, or the highest tag matching their version constraint,
+// every one of them or only pkg and the packages it newly imports, and
+// returns the updated packages. A package whose branch was rewritten so the
+// tip doesn't follow its pinned commit is left alone, unless it switched
+// branches by following a channel or branch pattern. In a flat layout the
+// nested dependencies are left to syncFlatVendor. Packages failing to be
+// resolved again, like those no tag satisfies the constraint of anymore, are
+// added to failed.
+func updateDependencies(dir string, dependencies map[string]*bpmEntry, pkg string, chain []string, flat bool, failed *pullError) []string {
+	updated := make([]string, 0)
+	for _, name := range sortedKeys(dependencies) {
+		entry := dependencies[name]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(name))
+		if pkg == "" || pkg == name {
+			changed, err := updatePackage(name, entry, pkgDir)
+			if err != nil {
+				failed.add(name, err)
+			} else if changed {
+				updated = append(updated, name)
+				if !flat {
+					reresolveNested(name, entry, pkgDir, append(chain[:len(chain):len(chain)], name), failed)
+				}
+			}
+			if pkg == name {
+				continue
+			}
+		}
+		if !isAssets(entry) {
+			updated = append(updated, updateDependencies(pkgDir, entry.Dependencies, pkg, append(chain[:len(chain):len(chain)], name), flat, failed)...)
+		}
+	}
+	return updated
+}
+
+// updatePackage moves pkg to its latest commit, reporting whether it moved.
+func updatePackage(pkg string, entry *bpmEntry, pkgDir string) (bool, error) {
+	if entry.Archive != "" {
+		log.Printf("Skipping %s, archives are pinned by their checksum", pkg)
+		return false, nil
+	}
+	previous, previousTag := entry.Commit, entry.Tag
+	if entry.Subdir != "" {
+		if entry.Branch == "" {
+			return false, nil
+		}
+		entry.Commit = ""
+		if err := installSubdir(pkg, entry, pkgDir); err != nil {
+			return false, err
+		}
+		return reportUpdate(pkg, entry, previous, previousTag), nil
+	}
+	if !isGitRepo(pkgDir) {
+		log.Printf("Skipping %s, it is not installed in %s", pkg, pkgDir)
+		return false, nil
+	}
+
+	if entry.Ref != "" {
+		// Refs of changes under review move as the change is amended.
+		commit, err := findRef(pkg, entry)
+		if err != nil {
+			return false, err
+		}
+		entry.Commit = commit
+		if entry.Commit == previous {
+			return false, nil
+		}
+		if err := fetchRef(pkgDir, entry.Ref, entry.Commit); err != nil {
+			return false, err
+		}
+		if err := checkoutPinned(pkgDir, entry.Commit); err != nil {
+			return false, err
+		}
+		return reportUpdate(pkg, entry, previous, previousTag), nil
+	}
+	if entry.Tag != "" && entry.Version == "" {
+		log.Printf("Skipping %s, it is pinned to tag %s", pkg, entry.Tag)
+		return false, nil
+	}
+	if entry.Version != "" {
+		if err := pinTag(pkg, entry, pkgDir, true); err != nil {
+			return false, err
+		}
+		if entry.Commit == previous {
+			return false, nil
+		}
+		if err := checkoutPinned(pkgDir, entry.Commit); err != nil {
+			return false, err
+		}
+		entry.TagInfo = readTagInfo(pkgDir, entry.Tag)
+		return reportUpdate(pkg, entry, previous, previousTag), nil
+	}
+
+	branch, err := trackedBranch(pkg, entry)
+	if err != nil {
+		return false, err
+	}
+	if err := runGit(&pkgDir, "fetch", "--quiet", "origin", "+refs/heads/"+branch+":refs/remotes/origin/"+branch); err != nil {
+		cause, hint := classifyGitError(err)
+		return false, fmt.Errorf("could not fetch %s, %s: %s", pkg, cause, hint)
+	}
+	tip, err := runCmdOutput(&pkgDir, "git", "rev-parse", "origin/"+branch)
+	if err != nil {
+		return false, err
+	}
+	if tip == entry.Commit {
+		return false, nil
+	}
+	if branch == entry.Branch && entry.Commit != "" && !isAncestor(pkgDir, entry.Commit, tip) {
+		output.Printf("%s: %s can't be fast-forwarded to %s on %s, left as is\n", pkg, shortHash(entry.Commit), shortHash(tip), branch)
+		return false, nil
+	}
+	if err := checkoutCommit(pkgDir, branch, tip); err != nil {
+		return false, err
+	}
+	entry.Branch = branch
+	entry.Commit = tip
+	return reportUpdate(pkg, entry, previous, previousTag), nil
+}
+
+// checkoutPinned checks out commit on the current branch of pkgDir.
+func checkoutPinned(pkgDir string, commit string) error {
+	branch, err := getCurrentBranch(pkgDir)
+	if err != nil {
+		return err
+	}
+	return checkoutCommit(pkgDir, branch, commit)
+}
+
+// reportUpdate prints the move of pkg from previous, with the release notes
+// of the tags it crossed when it moved between tags, and reports whether it
+// moved.
+func reportUpdate(pkg string, entry *bpmEntry, previous string, previousTag string) bool {
+	if entry.Commit == previous {
+		return false
+	}
+	if entry.Ref != "" {
+		output.Printf("Updated %s to %s: %s -> %s\n", pkg, entry.Ref, shortHash(previous), shortHash(entry.Commit))
+		return true
+	}
+	if entry.Tag != "" {
+		output.Printf("Updated %s to %s: %s -> %s\n", pkg, entry.Tag, shortHash(previous), shortHash(entry.Commit))
+		if previousTag != "" && previousTag != entry.Tag {
+			output.Println()
+			printReleaseNotes(releaseNotesBetween(pkg, entry, previousTag, entry.Tag))
+		}
+		return true
+	}
+	output.Printf("Updated %s on %s: %s -> %s\n", pkg, entry.Branch, shortHash(previous), shortHash(entry.Commit))
+	return true
+}
+
+// reresolveNested resolves the dependencies of an updated package again when
+// its imports changed, keeping the pins of packages it still imports.
+// Packages failing to be fetched are added to failed.
+func reresolveNested(pkg string, entry *bpmEntry, pkgDir string, chain []string, failed *pullError) {
+	if isAssets(entry) {
+		return
+	}
+	r := newGitResolver()
+	r.keepDeclared(entry.Dependencies)
+	found, err := r.src.imports(pkgDir, entryRepo(pkg, entry))
+	if err != nil {
+		failed.add(pkg, err)
+		return
+	}
+	imports := make([]string, 0)
+	for _, imported := range r.expandSubdirs(found) {
+		if findInChain(chain, imported) == nil {
+			imports = append(imports, imported)
+		}
+	}
+	sort.Strings(imports)
+	previous := make([]string, 0, len(entry.Dependencies))
+	for name, nested := range entry.Dependencies {
+		if !isAssets(nested) {
+			previous = append(previous, name)
+		}
+	}
+	sort.Strings(previous)
+	if strings.Join(imports, "\n") == strings.Join(previous, "\n") {
+		return
+	}
+
+	log.Printf("Imports of %s changed, resolving its dependencies again", pkg)
+	r.keepPins(entry.Dependencies)
+	if err := os.RemoveAll(filepath.Join(pkgDir, vendorFolderName)); err != nil {
+		failed.add(pkg, err)
+		return
+	}
+	entry.Dependencies = r.resolveNested(pkgDir, entryRepo(pkg, entry), chain)
+	r.reportProblems(entry.Dependencies)
+	for failedPkg, err := range r.failed.failures {
+		failed.add(failedPkg, err)
+	}
+}
+
+// releaseNotesBetween collects the release notes of the tags of pkg in
+// (from, to], none when its tags can't be listed.
+func releaseNotesBetween(pkg string, entry *bpmEntry, from string, to string) []*tagNotes {
+	refs, err := lsRemote(getEntryURL(pkg, entry), "refs/tags/*")
+	if err != nil {
+		log.Printf("Could not list the tags of %s: %s", pkg, err)
+		return nil
+	}
+	return collectReleaseNotes(getEntryURL(pkg, entry), getTagsBetween(sortedStringKeys(tagCommits(refs)), from, to))
+}
+
+// updatePreview is a move update would make, with the release notes of the
+// tags it crosses. It is the result of update -preview with -json.
+type updatePreview struct {
+	Package   string      `json:"package"`
+	Branch    string      `json:"branch,omitempty"`
+	Commit    string      `json:"commit"`
+	Latest    string      `json:"latest"`
+	Tag       string      `json:"tag,omitempty"`
+	LatestTag string      `json:"latestTag,omitempty"`
+	Notes     []*tagNotes `json:"notes,omitempty"`
+}
+
+// doUpdatePreview lists the moves update would make, every package or only
+// pkg, from the remotes alone, without changing the vendor folder or
+// bpm.json. Branch tips that can't be fast-forwarded to are listed too, as
+// telling them apart needs the history update fetches.
+func doUpdatePreview(dir string, data *bpmPackage, pkg string) int {
+	previews := make([]*updatePreview, 0)
+	flat := make(map[string][]*bpmEntry)
+	flattenDependencies(data.Dependencies, flat)
+	if _, ok := flat[pkg]; pkg != "" && !ok {
+		output.Errorf("%s is not a dependency", pkg)
+		return 1
+	}
+	names := make([]string, 0, len(flat))
+	for name := range flat {
+		if pkg == "" || name == pkg {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		seen := make(map[string]bool)
+		for _, entry := range flat[name] {
+			if seen[entry.Commit] {
+				continue
+			}
+			seen[entry.Commit] = true
+			preview, err := previewUpdate(name, entry)
+			if err != nil {
+				output.Errorf("%s: %s", name, err)
+				return 1
+			}
+			if preview != nil {
+				previews = append(previews, preview)
+			}
+		}
+	}
+	output.Result(previews, func() {
+		if len(previews) == 0 {
+			fmt.Println("All dependencies are up to date")
+			return
+		}
+		for _, preview := range previews {
+			switch {
+			case preview.LatestTag != "":
+				fmt.Printf("%s would move to %s: %s -> %s\n", preview.Package, preview.LatestTag, shortHash(preview.Commit), shortHash(preview.Latest))
+			default:
+				fmt.Printf("%s would move on %s: %s -> %s\n", preview.Package, preview.Branch, shortHash(preview.Commit), shortHash(preview.Latest))
+			}
+			if len(preview.Notes) > 0 {
+				fmt.Println()
+				printReleaseNotes(preview.Notes)
+			}
+		}
+	})
+	return 0
+}
+
+// previewUpdate returns the move update would make of an entry, or nil when
+// it would leave it as is.
+func previewUpdate(pkg string, entry *bpmEntry) (*updatePreview, error) {
+	if entry.Archive != "" || entry.Ref != "" || (entry.Tag != "" && entry.Version == "") || (entry.Subdir != "" && entry.Branch == "") {
+		return nil, nil
+	}
+	preview := &updatePreview{Package: pkg, Branch: entry.Branch, Commit: entry.Commit, Tag: entry.Tag}
+	if entry.Version != "" {
+		refs, err := lsRemote(getEntryURL(pkg, entry), "refs/tags/*")
+		if err != nil {
+			return nil, err
+		}
+		tags := tagCommits(refs)
+		if preview.LatestTag, preview.Latest, err = matchVersion(pkg, entry, tags); err != nil {
+			return nil, err
+		}
+		if preview.Latest == entry.Commit {
+			return nil, nil
+		}
+		if entry.Tag != "" && entry.Tag != preview.LatestTag {
+			preview.Notes = collectReleaseNotes(getEntryURL(pkg, entry), getTagsBetween(sortedStringKeys(tags), entry.Tag, preview.LatestTag))
+		}
+		return preview, nil
+	}
+
+	branch, err := trackedBranch(pkg, entry)
+	if err != nil {
+		return nil, err
+	}
+	ref := "refs/heads/" + branch
+	refs, err := lsRemote(getEntryURL(pkg, entry), ref)
+	if err != nil {
+		return nil, err
+	}
+	tip, ok := refs[ref]
+	if !ok {
+		return nil, fmt.Errorf("branch %s does not exist", branch)
+	}
+	if tip == entry.Commit {
+		return nil, nil
+	}
+	preview.Branch, preview.Latest = branch, tip
+	return preview, nil
+}
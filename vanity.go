@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vanityCacheTTL is how long the go-import meta tags of a host are trusted
+// before asking it again.
+const vanityCacheTTL = 24 * time.Hour
+
+// vanityImport is the go-import meta tag served for an import path, like
+// k8s.io/client-go, naming the repository behind it. RepoURL is empty when
+// the host serves no tag, and the path is then used as a repository as is.
+type vanityImport struct {
+	Prefix    string    `json:"prefix"`
+	VCS       string    `json:"vcs,omitempty"`
+	RepoURL   string    `json:"repoURL,omitempty"`
+	FetchedAt time.Time `json:"fetchedAt"`
+}
+
+var vanity = struct {
+	sync.Mutex
+	loaded   bool
+	prefixes map[string]*vanityImport
+	// unreachable are hosts that failed to answer, not asked again.
+	unreachable map[string]bool
+}{unreachable: make(map[string]bool)}
+
+// isVanityPath is whether the repository of importPath has to be discovered
+// from its host: it isn't in the standard library and its host has no rule.
+func isVanityPath(importPath string) bool {
+	host := importHost(importPath)
+	return host != "" && hostRoot(host) == nil
+}
+
+// importRoot returns the repository root of an import path, by the rule of
+// its host or else asking the host for it, or "" for standard library
+// packages.
+func importRoot(importPath string) string {
+	if isStandardPackage(importPath) {
+		return ""
+	}
+	if isVanityPath(importPath) {
+		if v := lookupVanity(importPath); v != nil && v.RepoURL != "" {
+			return v.Prefix
+		}
+	}
+	return staticRepoRoot(importPath)
+}
+
+// defaultRepoURL is the repository a package is cloned from without a url in
+// bpm.json: the one named by the go-import meta tag of a vanity path, else
+// https://<repo>.
+func defaultRepoURL(repo string) string {
+	if isVanityPath(repo) {
+		if v := lookupVanity(repo); v != nil && v.RepoURL != "" && v.Prefix == repo {
+			return v.RepoURL
+		}
+	}
+	return "https://" + repo
+}
+
+// lookupVanity returns the go-import meta tag covering importPath, from the
+// cache while younger than vanityCacheTTL, or nil when the host can't be
+// reached.
+func lookupVanity(importPath string) *vanityImport {
+	vanity.Lock()
+	defer vanity.Unlock()
+	cacheFile := filepath.Join(getCacheDir(), "vanity.json")
+	if !vanity.loaded {
+		vanity.prefixes = readVanityCache(cacheFile)
+		vanity.loaded = true
+	}
+	for prefix, v := range vanity.prefixes {
+		if (importPath == prefix || strings.HasPrefix(importPath, prefix+"/")) && time.Since(v.FetchedAt) < vanityCacheTTL {
+			return v
+		}
+	}
+
+	host := strings.SplitN(importPath, "/", 2)[0]
+	if vanity.unreachable[host] {
+		return nil
+	}
+	v, err := fetchVanity(importPath)
+	if err != nil {
+		log.Printf("Could not discover the repository of %s: %s", importPath, err)
+		vanity.unreachable[host] = true
+		return nil
+	}
+	vanity.prefixes[v.Prefix] = v
+	bytes, err := jsonEncodeIndented(vanity.prefixes)
+	if err == nil {
+		err = ioutil.WriteFile(cacheFile, bytes, 0644)
+	}
+	if err != nil {
+		log.Printf("Could not cache the repository of %s: %s", importPath, err)
+	}
+	return v
+}
+
+func readVanityCache(cacheFile string) map[string]*vanityImport {
+	prefixes := make(map[string]*vanityImport)
+	bytes, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		return prefixes
+	}
+	if err := json.Unmarshal(bytes, &prefixes); err != nil {
+		log.Printf("Ignoring invalid cache %s: %s", cacheFile, err)
+		return make(map[string]*vanityImport)
+	}
+	return prefixes
+}
+
+// fetchVanity asks the host of importPath for its go-import meta tag, the way
+// the go tool does with ?go-get=1.
+func fetchVanity(importPath string) (*vanityImport, error) {
+	log.Printf("Discovering the repository of %s", importPath)
+	resp, err := httpClient.Get("https://" + importPath + "?go-get=1")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Hosts answer 404 for unknown paths yet may still serve the meta tag.
+	v, err := parseGoImport(io.LimitReader(resp.Body, 1<<20), importPath)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		root := segmentsRoot(importPath, defaultRootSegments)
+		if root == "" {
+			root = importPath
+		}
+		log.Printf("No go-import meta tag for %s, cloning https://%s", importPath, root)
+		v = &vanityImport{Prefix: root}
+	} else if v.VCS != "git" {
+		return nil, fmt.Errorf("%s is served from %s, only git is supported", v.Prefix, v.VCS)
+	}
+	v.FetchedAt = time.Now().UTC()
+	return v, nil
+}
+
+var (
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?s)([a-zA-Z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// parseGoImport finds the go-import meta tag whose prefix covers importPath in
+// the head of an HTML page, returning nil without one. Several matching tags
+// are an error, as for the go tool.
+func parseGoImport(r io.Reader, importPath string) (*vanityImport, error) {
+	bytes, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	page := string(bytes)
+	if end := strings.Index(strings.ToLower(page), "</head>"); end >= 0 {
+		page = page[:end]
+	}
+
+	var found *vanityImport
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		attributes := make(map[string]string)
+		for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(match[1])] = match[2] + match[3]
+		}
+		if attributes["name"] != "go-import" {
+			continue
+		}
+		fields := strings.Fields(attributes["content"])
+		if len(fields) != 3 {
+			continue
+		}
+		prefix := fields[0]
+		if importPath != prefix && !strings.HasPrefix(importPath, prefix+"/") {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several go-import meta tags match %s", importPath)
+		}
+		found = &vanityImport{Prefix: prefix, VCS: fields[1], RepoURL: fields[2]}
+	}
+	return found, nil
+}
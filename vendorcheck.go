@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const vendorMarkerFilename = ".bpm-hash"
+
+// hashDir computes a content hash over the files of a vendored package,
+// excluding git metadata, the nested vendor folder and the marker file.
+func hashDir(dir string) (string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() {
+			if rel == gitFolderName || rel == vendorFolderName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == vendorMarkerFilename || !info.Mode().IsRegular() {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	summary := sha256.New()
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), file)
+	}
+	return "sha256:" + hex.EncodeToString(summary.Sum(nil)), nil
+}
+
+func writeVendorMarkers(dir string, dependencies map[string]*bpmEntry) error {
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			continue
+		}
+		hash, err := hashDir(pkgDir)
+		if err != nil {
+			return fmt.Errorf("could not hash %s: %s", pkg, err)
+		}
+		marker := filepath.Join(pkgDir, vendorMarkerFilename)
+		if err := ioutil.WriteFile(marker, []byte(hash+"\n"), 0644); err != nil {
+			return err
+		}
+		if err := writeVendorMarkers(pkgDir, entry.Dependencies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVendorMarkers lists the vendored packages whose content no longer
+// matches the marker written by the last install.
+func checkVendorMarkers(dir string, dependencies map[string]*bpmEntry) []string {
+	problems := make([]string, 0)
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			continue
+		}
+		expected, err := ioutil.ReadFile(filepath.Join(pkgDir, vendorMarkerFilename))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: missing %s, run install", pkg, vendorMarkerFilename))
+		} else if hash, err := hashDir(pkgDir); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: could not hash %s: %s", pkg, pkgDir, err))
+		} else if strings.TrimSpace(string(expected)) != hash {
+			problems = append(problems, fmt.Sprintf("%s: vendored files were modified in %s", pkg, pkgDir))
+		}
+		problems = append(problems, checkVendorMarkers(pkgDir, entry.Dependencies)...)
+	}
+	return problems
+}
+
+// doVendorCheck returns a non-zero exit code when vendored files were edited
+// by hand instead of through patches.
+func doVendorCheck(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	if !data.VendorMarkers {
+		output.Printf("Vendor markers are disabled, set \"vendorMarkers\": true in %s\n", depFile)
+		return 0
+	}
+	problems := checkVendorMarkers(dir, data.Dependencies)
+	if len(problems) == 0 {
+		return 0
+	}
+	output.Problems("Vendor check failed, use patches/ for local changes:", problems)
+	return 1
+}
+
+// recordHashes records the content hash of every vendored package in its
+// entry, after patches and rewrites were applied, for the lock file.
+func recordHashes(dir string, dependencies map[string]*bpmEntry) error {
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if !fileExists(pkgDir) {
+			continue
+		}
+		hash, err := hashDir(pkgDir)
+		if err != nil {
+			return fmt.Errorf("could not hash %s: %s", pkg, err)
+		}
+		entry.Hash = hash
+		if err := recordHashes(pkgDir, entry.Dependencies); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkHashes lists the vendored packages whose content no longer matches
+// the hash in the lock file. With pinnedOnly, packages that are missing, have
+// no hash or are checked out at another commit than locked are skipped, as
+// install replaces them anyway.
+func checkHashes(dir string, dependencies map[string]*bpmEntry, pinnedOnly bool) []string {
+	problems := make([]string, 0)
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		switch {
+		case pinnedOnly && (entry.Hash == "" || !isVendoredAtPin(pkgDir, entry)):
+		case !fileExists(pkgDir):
+			problems = append(problems, fmt.Sprintf("%s: not installed in %s", pkg, pkgDir))
+		case entry.Hash == "":
+			problems = append(problems, fmt.Sprintf("%s: no content hash in %s, run install", pkg, lockFilename))
+		default:
+			if hash, err := hashDir(pkgDir); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: could not hash %s: %s", pkg, pkgDir, err))
+			} else if hash != entry.Hash {
+				problems = append(problems, fmt.Sprintf("%s: content hash is %s, locked %s; files were modified or are missing", pkg, hash, entry.Hash))
+			}
+		}
+		problems = append(problems, checkHashes(pkgDir, entry.Dependencies, pinnedOnly)...)
+	}
+	return problems
+}
+
+// isVendoredAtPin is whether pkgDir holds the commit, or archive, the entry
+// pins.
+func isVendoredAtPin(pkgDir string, entry *bpmEntry) bool {
+	marker, state := "", entry.Commit
+	switch {
+	case entry.Archive != "":
+		marker, state = archiveMarkerFilename, entry.SHA256
+	case entry.Subdir != "":
+		marker = subdirMarkerFilename
+	case isGitRepo(pkgDir):
+		commit, err := getCurrentCommitHash(pkgDir)
+		return err == nil && commit == entry.Commit
+	default:
+		return false
+	}
+	installed, err := ioutil.ReadFile(filepath.Join(pkgDir, marker))
+	return err == nil && strings.TrimSpace(string(installed)) == state
+}
+
+// doVerify compares every vendored package with the content hash recorded in
+// the lock file and returns the exit code.
+func doVerify(dir string) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	problems := checkHashes(dir, data.Dependencies, false)
+	if len(problems) == 0 {
+		return 0
+	}
+	output.Problems("Verify failed:", problems)
+	return 1
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// doDiff compares the vendor tree with bpm.json and returns 1 when they
+// differ. By default it compares the checked out commits; with -vendor it
+// prints a patch of every local modification of the vendored files against
+// their pinned commit, relative to the project dir.
+func doDiff(dir string, vendor bool) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	differs := false
+	if vendor {
+		differs = diffVendorFiles(dir, dir, data.Dependencies)
+	} else {
+		differs = diffVendorCommits(dir, data.Dependencies)
+	}
+	if differs {
+		return 1
+	}
+	return 0
+}
+
+func diffVendorCommits(dir string, dependencies map[string]*bpmEntry) bool {
+	differs := false
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		if isGitRepo(pkgDir) && entry.Commit != "" {
+			if commit, err := getCurrentCommitHash(pkgDir); err != nil {
+				differs = true
+				fmt.Fprintf(os.Stderr, "%s: %s\n", pkg, err)
+			} else if commit != entry.Commit {
+				differs = true
+				fmt.Printf("%s: vendored %s, %s pins %s\n", pkg, shortHash(commit), dependencyFilename, shortHash(entry.Commit))
+			}
+		}
+		if diffVendorCommits(pkgDir, entry.Dependencies) {
+			differs = true
+		}
+	}
+	return differs
+}
+
+func diffVendorFiles(root string, dir string, dependencies map[string]*bpmEntry) bool {
+	differs := false
+	for _, pkg := range sortedKeys(dependencies) {
+		entry := dependencies[pkg]
+		pkgDir := filepath.Join(dir, vendorFolderName, vendorPath(pkg))
+		rel, _ := filepath.Rel(root, pkgDir)
+		prefix := filepath.ToSlash(rel) + "/"
+		var patch string
+		var err error
+		switch {
+		case entry.Subdir != "" && entry.Commit != "":
+			patch, err = diffSubdir(pkg, entry, pkgDir, prefix)
+		case isGitRepo(pkgDir) && entry.Commit != "":
+			patch, err = diffCheckout(entry.Commit, pkgDir, prefix)
+		case fileExists(pkgDir):
+			fmt.Fprintf(os.Stderr, "%s: no pinned commit to diff against\n", pkg)
+		}
+		if err != nil {
+			// A copy that can't be compared isn't known to match either.
+			differs = true
+			fmt.Fprintf(os.Stderr, "%s: %s\n", pkg, err)
+		}
+		if patch != "" {
+			differs = true
+			fmt.Print(patch)
+		}
+		if diffVendorFiles(root, pkgDir, entry.Dependencies) {
+			differs = true
+		}
+	}
+	return differs
+}
+
+// diffCheckout diffs a checkout against commit, untracked files included but
+// the nested vendor folder and bpm's marker files left out.
+func diffCheckout(commit string, pkgDir string, prefix string) (string, error) {
+	excludes := []string{
+		":(exclude)" + vendorFolderName,
+		":(exclude)" + vendorMarkerFilename,
+	}
+	args := append([]string{"diff", "--src-prefix=a/" + prefix, "--dst-prefix=b/" + prefix, commit, "--", "."}, excludes...)
+	out, err := runCmd(&pkgDir, true, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	patch := string(out)
+
+	untracked, err := runCmdOutput(&pkgDir, "git", append([]string{"ls-files", "--others", "--exclude-standard", "--", "."}, excludes...)...)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range strings.Fields(untracked) {
+		patch += diffNoIndex(pkgDir, os.DevNull, file, prefix)
+	}
+	return patch, nil
+}
+
+// diffSubdir diffs a vendored monorepo subdirectory against its worktree in
+// the cache.
+func diffSubdir(pkg string, entry *bpmEntry, pkgDir string, prefix string) (string, error) {
+	url := getEntryURL(pkg, entry)
+	unlock, err := lockMonorepo(url)
+	if err != nil {
+		return "", err
+	}
+	clone, err := getMonorepoClone(url)
+	if err != nil {
+		unlock()
+		return "", err
+	}
+	worktree := filepath.Join(clone+"-worktrees", entry.Commit)
+	if !fileExists(worktree) {
+		if err := runGit(&clone, "worktree", "add", "--detach", worktree, entry.Commit); err != nil {
+			unlock()
+			return "", fmt.Errorf("could not check out %s: %s", entry.Commit, err)
+		}
+	}
+	unlock()
+	source := filepath.Join(worktree, filepath.FromSlash(entry.Subdir))
+	patch := diffNoIndex(pkgDir, source, pkgDir, prefix)
+	lines := strings.SplitAfter(patch, "\n")
+	// --no-index prints the full paths of both sides after the prefix, with
+	// the leading slash dropped, which are cut down to the vendored path.
+	for i, line := range lines {
+		line = strings.Replace(line, strings.TrimPrefix(filepath.ToSlash(source), "/")+"/", "", -1)
+		lines[i] = strings.Replace(line, strings.TrimPrefix(filepath.ToSlash(pkgDir), "/")+"/", "", -1)
+	}
+	return filterMarkerDiffs(strings.Join(lines, ""), prefix), nil
+}
+
+// diffNoIndex diffs two paths outside of any repository. Exit code 1 only
+// means they differ.
+func diffNoIndex(dir string, from string, to string, prefix string) string {
+	cmd := gitCommand(&dir, "diff", "--no-index", "--src-prefix=a/"+prefix, "--dst-prefix=b/"+prefix, from, to)
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); err != nil && !(ok && exitErr.ExitCode() == 1) {
+		fmt.Fprintf(os.Stderr, "Could not diff %s: %s\n", to, err)
+	}
+	return string(out)
+}
+
+// filterMarkerDiffs drops the file diffs of bpm's marker files and nested
+// vendor folders from a patch.
+func filterMarkerDiffs(patch string, prefix string) string {
+	result := strings.Builder{}
+	skip := false
+	for _, line := range strings.SplitAfter(patch, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			skip = strings.Contains(line, "/"+subdirMarkerFilename) ||
+				strings.Contains(line, "/"+vendorMarkerFilename) ||
+				strings.Contains(line, prefix+vendorFolderName+"/")
+		}
+		if !skip {
+			result.WriteString(line)
+		}
+	}
+	return result.String()
+}
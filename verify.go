@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// checkFrozen lists every dependency that isn't pinned to an exact commit.
+func checkFrozen(dependencies map[string]*bpmEntry) []string {
+	problems := make([]string, 0)
+	for pkg, entry := range dependencies {
+		if entry.Archive != "" {
+			if entry.SHA256 == "" {
+				problems = append(problems, fmt.Sprintf("%s: no sha256 recorded for archive", pkg))
+			}
+		} else if entry.Commit == "" {
+			problems = append(problems, fmt.Sprintf("%s: no commit pinned", pkg))
+		}
+		problems = append(problems, checkFrozen(entry.Dependencies)...)
+	}
+	return problems
+}
+
+// verifyVendor checks that every vendored package is checked out at its pinned
+// commit and has no local modifications other than its configured patches.
+func verifyVendor(root string, dir string, dependencies map[string]*bpmEntry) []string {
+	problems := make([]string, 0)
+	vendorDir := filepath.Join(dir, vendorFolderName)
+
+	for pkg, entry := range dependencies {
+		pkgDir := filepath.Join(vendorDir, vendorPath(pkg))
+		if entry.Archive != "" {
+			installed, err := ioutil.ReadFile(filepath.Join(pkgDir, archiveMarkerFilename))
+			if err != nil || strings.TrimSpace(string(installed)) != entry.SHA256 {
+				problems = append(problems, fmt.Sprintf("%s: archive with sha256 %s not installed in %s", pkg, entry.SHA256, pkgDir))
+			}
+			problems = append(problems, verifyVendor(root, pkgDir, entry.Dependencies)...)
+			continue
+		}
+		if entry.Subdir != "" {
+			installed, err := ioutil.ReadFile(filepath.Join(pkgDir, subdirMarkerFilename))
+			if err != nil || strings.TrimSpace(string(installed)) != entry.Commit {
+				problems = append(problems, fmt.Sprintf("%s: %s at %s not installed in %s", pkg, entry.Subdir, entry.Commit, pkgDir))
+			}
+			problems = append(problems, verifyVendor(root, pkgDir, entry.Dependencies)...)
+			continue
+		}
+		if !isGitRepo(pkgDir) {
+			problems = append(problems, fmt.Sprintf("%s: not installed in %s", pkg, pkgDir))
+			continue
+		}
+		commit, err := getCurrentCommitHash(pkgDir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", pkg, err))
+		} else if entry.Commit != "" && commit != entry.Commit {
+			problems = append(problems, fmt.Sprintf("%s: checked out at %s, expected %s", pkg, commit, entry.Commit))
+		}
+		changes, err := getLocalChanges(pkgDir)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", pkg, err))
+		}
+		if entry.Alias != "" || hasImportRewrites(root) {
+			changes = withoutSourceFiles(changes)
+		}
+		if len(changes) > 0 && !isPatched(root, pkg, pkgDir) {
+			problems = append(problems, fmt.Sprintf("%s: local modifications: %s", pkg, strings.Join(changes, ", ")))
+		}
+		problems = append(problems, verifyVendor(root, pkgDir, entry.Dependencies)...)
+	}
+	return problems
+}
+
+// getLocalChanges lists modified or untracked files in a repository, ignoring
+// the nested vendor folder and marker files bpm itself manages.
+func getLocalChanges(dir string) ([]string, error) {
+	out, err := runCmd(&dir, true, "git", "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]string, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		file := strings.TrimSpace(line[3:])
+		if file == vendorMarkerFilename || file == vendorFolderName+"/" || strings.HasPrefix(file, vendorFolderName+"/") {
+			continue
+		}
+		changes = append(changes, file)
+	}
+	return changes, nil
+}
+
+// withoutSourceFiles drops Go files, whose imports are rewritten in aliased
+// copies and with a "rewrite" section.
+func withoutSourceFiles(changes []string) []string {
+	result := make([]string, 0, len(changes))
+	for _, file := range changes {
+		if !strings.HasSuffix(file, ".go") {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// doCheck runs the fast consistency checks and returns the exit code. With
+// deep, every vendored package is also compared with a fresh copy from
+// upstream.
+func doCheck(dir string, deep bool) int {
+	depFile := filepath.Join(dir, dependencyFilename)
+	if !fileExists(depFile) {
+		noDependencyFile(depFile)
+		return 1
+	}
+	data, err := readDataFile(depFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+
+	problems := checkFrozen(data.Dependencies)
+	problems = append(problems, verifyVendor(dir, dir, data.Dependencies)...)
+	problems = append(problems, findMergeConflicts(data.Dependencies)...)
+	if deep {
+		problems = append(problems, deepVerify(dir, dir, data.Dependencies, data.Rewrite != nil)...)
+	}
+	if data.VendorMarkers {
+		problems = append(problems, checkVendorMarkers(dir, data.Dependencies)...)
+	}
+	if data.RequireApproval {
+		for _, pkg := range findUnapproved(dir, data) {
+			problems = append(problems, fmt.Sprintf("%s: new dependency not approved", pkg))
+		}
+	}
+	if len(problems) == 0 {
+		return 0
+	}
+	output.Problems("Check failed:", problems)
+	return 1
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const osvQueryURL = "https://api.osv.dev/v1/query"
+
+type osvVuln struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary"`
+	Aliases  []string `json:"aliases"`
+	Affected []struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Repo   string `json:"repo"`
+			Events []struct {
+				Introduced string `json:"introduced,omitempty"`
+				Fixed      string `json:"fixed,omitempty"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+type vulnerableEntry struct {
+	Package         string     `json:"package"`
+	Commit          string     `json:"commit"`
+	Vulnerabilities []*osvVuln `json:"vulnerabilities"`
+}
+
+// queryVulnerabilities asks OSV for the known vulnerabilities affecting the
+// given commit.
+func queryVulnerabilities(commit string) ([]*osvVuln, error) {
+	var result struct {
+		Vulns []*osvVuln `json:"vulns"`
+	}
+	if err := postJSON(osvQueryURL, map[string]string{"commit": commit}, &result); err != nil {
+		return nil, err
+	}
+	return result.Vulns, nil
+}
+
+func findVulnerable(dependencies map[string]*bpmEntry) ([]*vulnerableEntry, error) {
+	result := make([]*vulnerableEntry, 0)
+	for pkg, entry := range dependencies {
+		if entry.Commit != "" {
+			vulns, err := queryVulnerabilities(entry.Commit)
+			if err != nil {
+				return nil, fmt.Errorf("vulnerability lookup for %s failed: %s", pkg, err)
+			}
+			if len(vulns) > 0 {
+				result = append(result, &vulnerableEntry{
+					Package:         pkg,
+					Commit:          entry.Commit,
+					Vulnerabilities: vulns})
+			}
+		}
+		nested, err := findVulnerable(entry.Dependencies)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, nested...)
+	}
+	return result, nil
+}
+
+func postJSON(apiURL string, body interface{}, v interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(apiURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// bpmVulnIgnore silences a vulnerability, by its ID or an alias, until the
+// end of the Until date, after which it is reported again:
+//
+//	"ignoreVulnerabilities": [{"id": "GO-2024-1234", "until": "2025-01-01", "reason": "not reachable"}]
+type bpmVulnIgnore struct {
+	ID     string `json:"id"`
+	Until  string `json:"until"`
+	Reason string `json:"reason"`
+}
+
+// expiry returns the moment the ignore stops applying, or an error for a
+// missing or invalid date, which silences nothing.
+func (i *bpmVulnIgnore) expiry() (time.Time, error) {
+	until, err := time.ParseInLocation("2006-01-02", i.Until, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid until date %q of ignored %s, expected YYYY-MM-DD", i.Until, i.ID)
+	}
+	return until.AddDate(0, 0, 1), nil
+}
+
+func (i *bpmVulnIgnore) matches(vuln *osvVuln) bool {
+	return vuln.ID == i.ID || containsString(vuln.Aliases, i.ID)
+}
+
+// filterIgnored drops the vulnerabilities silenced by a current ignore and
+// returns the remaining ones with the number dropped. Expired and invalid
+// ignores are logged, so the findings they covered don't resurface silently.
+func filterIgnored(vulnerable []*vulnerableEntry, ignores []*bpmVulnIgnore, now time.Time) ([]*vulnerableEntry, int) {
+	active := make([]*bpmVulnIgnore, 0, len(ignores))
+	for _, ignore := range ignores {
+		expiry, err := ignore.expiry()
+		switch {
+		case err != nil:
+			log.Print(err)
+		case !now.Before(expiry):
+			log.Printf("Ignore of %s expired on %s (%s), reporting it again", ignore.ID, ignore.Until, ignore.Reason)
+		default:
+			active = append(active, ignore)
+		}
+	}
+
+	result := make([]*vulnerableEntry, 0, len(vulnerable))
+	silenced := 0
+	for _, v := range vulnerable {
+		vulns := make([]*osvVuln, 0, len(v.Vulnerabilities))
+		for _, vuln := range v.Vulnerabilities {
+			if isIgnored(vuln, active) {
+				silenced++
+			} else {
+				vulns = append(vulns, vuln)
+			}
+		}
+		if len(vulns) > 0 {
+			result = append(result, &vulnerableEntry{Package: v.Package, Commit: v.Commit, Vulnerabilities: vulns})
+		}
+	}
+	return result, silenced
+}
+
+func isIgnored(vuln *osvVuln, ignores []*bpmVulnIgnore) bool {
+	for _, ignore := range ignores {
+		if ignore.matches(vuln) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// jobs is set by -j and overrides the global config.
+var jobs string
+
+// workerPool runs queued jobs with at most limit of them at once. Workers
+// start as jobs are queued and stop once the queue is empty, so jobs may
+// queue further jobs without waiting for a free worker.
+type workerPool struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queue   []func()
+}
+
+var (
+	workersOnce sync.Once
+	workers     *workerPool
+)
+
+// getWorkerPool returns the pool shared by the clone, pull and resolution
+// phases, so their work together stays within -j.
+func getWorkerPool() *workerPool {
+	workersOnce.Do(func() {
+		workers = &workerPool{limit: getJobs()}
+	})
+	return workers
+}
+
+// checkJobFlags rejects -j and -jobs-per-host values that aren't positive
+// numbers.
+func checkJobFlags() error {
+	for _, flag := range [][2]string{{"-j", jobs}, {"-jobs-per-host", jobsPerHost}} {
+		if flag[1] == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(flag[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid %s %q, expected a positive number", flag[0], flag[1])
+		}
+	}
+	return nil
+}
+
+func getJobs() int {
+	// Checked by checkJobFlags.
+	if n, err := strconv.Atoi(jobs); err == nil && n > 0 {
+		return n
+	}
+	if n := getGlobalConfig().Jobs; n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// submit queues job, run as soon as a worker is free.
+func (p *workerPool) submit(job func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append(p.queue, job)
+	if p.running < p.limit {
+		p.running++
+		go p.work()
+	}
+}
+
+func (p *workerPool) work() {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		job := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		job()
+	}
+}
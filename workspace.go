@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func doWorkspace(dir string, action string) int {
+	switch action {
+	case "", "list":
+		manifestDirs, err := findManifests(dir)
+		if err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+		manifests := make([]string, 0)
+		for _, manifestDir := range manifestDirs {
+			manifests = append(manifests, filepath.Join(manifestDir, dependencyFilename))
+		}
+		output.Result(manifests, func() {
+			for _, manifest := range manifests {
+				fmt.Println(manifest)
+			}
+		})
+	case "lock":
+		if err := lockWorkspace(dir); err != nil {
+			output.Errorf("%s", err)
+			return 1
+		}
+	case "install":
+		return installWorkspace(dir)
+	default:
+		output.Errorf("Unknown workspace action %q, expected list, lock or install", action)
+		return 1
+	}
+	return 0
+}
+
+// findManifests returns every directory below root holding a bpm.json,
+// skipping vendor trees and hidden folders.
+func findManifests(root string) ([]string, error) {
+	result := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != root && (name == vendorFolderName || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == dependencyFilename {
+			result = append(result, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// lockWorkspace resolves a single pin per package across all manifests and
+// writes it to the shared lock file at the root. When manifests disagree, the
+// previously locked pin wins if it is still one of the candidates.
+func lockWorkspace(root string) error {
+	lockFile := filepath.Join(root, lockFilename)
+	prev := &bpmLock{}
+	if fileExists(lockFile) {
+		var err error
+		if prev, err = readLockFile(lockFile); err != nil {
+			return err
+		}
+	}
+
+	lock := &bpmLock{
+		Environment:  captureEnvironment(root, resolutionWorkspace),
+		Dependencies: prev.Dependencies,
+		Workspace:    make(map[string]*bpmEntry)}
+	owners := make(map[string]map[string][]string)
+
+	manifestDirs, err := findManifests(root)
+	if err != nil {
+		return err
+	}
+	for _, manifestDir := range manifestDirs {
+		rel, _ := filepath.Rel(root, manifestDir)
+		data, err := readDataFile(filepath.Join(manifestDir, dependencyFilename))
+		if err != nil {
+			return err
+		}
+		flat := make(map[string][]*bpmEntry)
+		flattenDependencies(data.Dependencies, flat)
+
+		for pkg, entries := range flat {
+			if owners[pkg] == nil {
+				owners[pkg] = make(map[string][]string)
+			}
+			for _, entry := range entries {
+				pin := entry.Commit + entry.SHA256
+				owners[pkg][pin] = append(owners[pkg][pin], rel)
+				if _, ok := lock.Workspace[pkg]; !ok {
+					lock.Workspace[pkg] = &bpmEntry{
+						URL:     entry.URL,
+						Branch:  entry.Branch,
+						Commit:  entry.Commit,
+						Alias:   entry.Alias,
+						Archive: entry.Archive,
+						SHA256:  entry.SHA256}
+				}
+			}
+		}
+	}
+
+	for pkg, commits := range owners {
+		if len(commits) < 2 {
+			continue
+		}
+		if old, ok := prev.Workspace[pkg]; ok {
+			if _, stillUsed := commits[old.Commit+old.SHA256]; stillUsed {
+				lock.Workspace[pkg] = old
+			}
+		}
+		locked := lock.Workspace[pkg]
+		output.Printf("Conflicting pins for %s, locked %s:\n", pkg, shortHash(locked.Commit+locked.SHA256))
+		for commit, manifests := range commits {
+			output.Printf("    %s: %s\n", shortHash(commit), strings.Join(manifests, ", "))
+		}
+	}
+
+	if err := writeLockFile(lockFile, lock); err != nil {
+		return err
+	}
+	output.Printf("Locked %d packages in %s\n", len(lock.Workspace), lockFile)
+	return nil
+}
+
+// installWorkspace installs every manifest's vendor tree using the pins from
+// the shared lock file. A manifest whose dependencies fail to pull is
+// skipped and the others are still installed.
+func installWorkspace(root string) int {
+	lockFile := filepath.Join(root, lockFilename)
+	if !fileExists(lockFile) {
+		output.Errorf("%s does not exist, run workspace lock first: %s", lockFilename, lockFile)
+		return 1
+	}
+	lock, err := readLockFile(lockFile)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	warnEnvironmentChanges(root)
+
+	manifestDirs, err := findManifests(root)
+	if err != nil {
+		output.Errorf("%s", err)
+		return 1
+	}
+	code := 0
+	for _, manifestDir := range manifestDirs {
+		depFile := filepath.Join(manifestDir, dependencyFilename)
+		log.Printf("Installing %s", depFile)
+		data, err := readDataFile(depFile)
+		if err != nil {
+			output.Errorf("%s", err)
+			code = 1
+			continue
+		}
+		for _, pkg := range applyLock(data.Dependencies, lock) {
+			output.Printf("%s: %s is not in %s, run workspace lock\n", depFile, pkg, lockFilename)
+		}
+		if err := pullPackages(data.Dependencies, manifestDir); err != nil {
+			output.Printf("%s was not installed:\n", depFile)
+			code = reportPullError(err)
+			continue
+		}
+		if err := postInstall(manifestDir, data); err != nil {
+			output.Errorf("%s", err)
+			code = 1
+			continue
+		}
+		if err := writeDataFile(depFile, data); err != nil {
+			output.Errorf("%s", err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// applyLock replaces the pins of a dependency tree with the locked ones and
+// returns the packages missing from the lock.
+func applyLock(dependencies map[string]*bpmEntry, lock *bpmLock) []string {
+	missing := make([]string, 0)
+	for pkg, entry := range dependencies {
+		if locked, ok := lock.Workspace[pkg]; ok {
+			entry.URL = locked.URL
+			entry.Branch = locked.Branch
+			entry.Commit = locked.Commit
+			entry.Alias = locked.Alias
+			entry.Archive = locked.Archive
+			entry.SHA256 = locked.SHA256
+		} else {
+			missing = append(missing, pkg)
+		}
+		missing = append(missing, applyLock(entry.Dependencies, lock)...)
+	}
+	return missing
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockWorkspaceKeepsRootPins(t *testing.T) {
+	root, err := ioutil.TempDir("", "bpm-workspace-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	files := map[string]string{
+		dependencyFilename:                       `{"package": "github.com/x/root", "dependencies": {"github.com/x/a": {"commit": "aaa"}}}`,
+		lockFilename:                             `{"dependencies": {"github.com/x/a": {"branch": "master", "commit": "aaa"}}}`,
+		filepath.Join("sub", dependencyFilename): `{"package": "github.com/x/sub", "dependencies": {"github.com/x/b": {"commit": "bbb"}}}`,
+	}
+	for name, content := range files {
+		filename := filepath.Join(root, name)
+		createDir(filepath.Dir(filename))
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lockWorkspace(root); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := readLockFile(filepath.Join(root, lockFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := lock.Dependencies["github.com/x/a"]; !ok || entry.Commit != "aaa" || entry.Branch != "master" {
+		t.Errorf("root pin of github.com/x/a was not kept: %+v", entry)
+	}
+	for pkg, commit := range map[string]string{"github.com/x/a": "aaa", "github.com/x/b": "bbb"} {
+		if entry, ok := lock.Workspace[pkg]; !ok || entry.Commit != commit {
+			t.Errorf("workspace pin of %s is %+v, expected %s", pkg, entry, commit)
+		}
+	}
+}